@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// ListURLs returns the urls of the API key of the request, filtered by the tag and campaign query parameters
+// and paginated with limit and offset
+func (h *handler) ListURLs(response http.ResponseWriter, request *http.Request) {
+	owner, ok := h.catalogOwner(response, request)
+	if ok == false {
+		return
+	}
+	query := request.URL.Query()
+	filter := domain.URLFilter{
+		Tag:      query.Get("tag"),
+		Campaign: query.Get("campaign"),
+	}
+	var err error
+	if filter.Limit, err = intParameter(query, "limit", domain.MaxListLimit); err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	if filter.Offset, err = intParameter(query, "offset", -1); err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	urls, err := h.config.Catalog.List(owner, filter)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	writeJSON(response, http.StatusOK, &urlListJsonResponse{Data: urls})
+}
+
+// CreateCampaign creates a campaign for the API key of the request
+func (h *handler) CreateCampaign(response http.ResponseWriter, request *http.Request) {
+	owner, ok := h.catalogOwner(response, request)
+	if ok == false {
+		return
+	}
+	type createCampaignRequest struct {
+		Name string `json:"name"`
+	}
+	data := &createCampaignRequest{}
+	if err := json.NewDecoder(request.Body).Decode(data); err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	campaign, err := h.config.Catalog.CreateCampaign(owner, data.Name)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	writeJSON(response, http.StatusCreated, &campaignJsonResponse{Data: campaign})
+}
+
+// ListCampaigns returns the campaigns of the API key of the request
+func (h *handler) ListCampaigns(response http.ResponseWriter, request *http.Request) {
+	owner, ok := h.catalogOwner(response, request)
+	if ok == false {
+		return
+	}
+	campaigns, err := h.config.Catalog.Campaigns(owner)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	writeJSON(response, http.StatusOK, &campaignListJsonResponse{Data: campaigns})
+}
+
+// ViewCampaignStats returns the views of every url of a campaign added up
+func (h *handler) ViewCampaignStats(response http.ResponseWriter, request *http.Request) {
+	owner, ok := h.catalogOwner(response, request)
+	if ok == false {
+		return
+	}
+	stats, err := h.config.Catalog.CampaignStats(owner, mux.Vars(request)["campaignID"])
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	writeJSON(response, http.StatusOK, &campaignStatsJsonResponse{Data: stats})
+}
+
+// catalogOwner returns the owner of a request to the catalog endpoints, they are not found
+// when there is no catalog
+func (h *handler) catalogOwner(response http.ResponseWriter, request *http.Request) (string, bool) {
+	return ownerOf(response, request, h.config.Catalog != nil)
+}
+
+// ownerOf returns the owner of a request to endpoints about the urls of an API key, they are not found
+// when they are not enabled and they need an API key, anonymous urls don't belong to anyone.
+// It writes the error response when it returns false.
+func ownerOf(response http.ResponseWriter, request *http.Request, enabled bool) (string, bool) {
+	if enabled == false {
+		chooseErrorResponse(domain.ErrorURLNotFound, response)
+		return "", false
+	}
+	owner := requestOwner(request)
+	if len(owner) == 0 {
+		chooseErrorResponse(errorUnauthorized, response)
+		return "", false
+	}
+	return owner, true
+}
+
+// intParameter parses an optional query parameter that can't be negative nor greater than max,
+// there is no max when it's negative. It's 0 when it's not set
+func intParameter(query url.Values, name string, max int) (int, error) {
+	value := query.Get(name)
+	if len(value) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || (max >= 0 && n > max) {
+		return 0, &parameterError{name: name}
+	}
+	return n, nil
+}
+
+func writeJSON(response http.ResponseWriter, status int, data interface{}) {
+	response.Header().Set("Content-Type", "application/json; charset=utf-8")
+	responseData, err := json.Marshal(data)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	response.WriteHeader(status)
+	response.Write(responseData)
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestCatalogNeedsAnAPIKey(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	if response := getPath(server, "/api/v1/campaigns"); response.Code != http.StatusNotFound {
+		t.Fatal("Catalog endpoints should not be found without catalog", response.Code)
+	}
+	server, _ = newCatalogTestServer()
+	for _, path := range []string{"/api/v1/urls", "/api/v1/campaigns", "/api/v1/campaigns/c1/views"} {
+		if response := getPath(server, path); response.Code != http.StatusUnauthorized {
+			t.Fatal("Catalog endpoints should need an API key", path, response.Code)
+		}
+	}
+}
+
+func TestListURLsByTagAndCampaign(t *testing.T) {
+	server, _ := newCatalogTestServer()
+	response := apiRequest(server, http.MethodPost, "/api/v1/campaigns", `{"name":"Launch"}`, "key")
+	campaign := campaignJsonResponse{}
+	json.NewDecoder(response.Body).Decode(&campaign)
+	if response.Code != http.StatusCreated || campaign.Data.Name != "Launch" {
+		t.Fatal("Failed to create campaign", response.Code, campaign)
+	}
+	if response := apiRequest(server, http.MethodPost, "/api/v1/campaigns", `{"name":"Launch"}`, "key"); response.Code != http.StatusConflict {
+		t.Fatal("Campaign names should be unique", response.Code)
+	}
+	if response := apiRequest(server, http.MethodPost, "/api/v1/campaigns", `{"name":""}`, "key"); response.Code != http.StatusBadRequest {
+		t.Fatal("Campaigns need a name", response.Code)
+	}
+
+	response = apiRequest(server, http.MethodPost, "/api/v1/urls", `{"url":"https://www.example.com/a","tags":["News"],"campaign":"`+campaign.Data.ID+`"}`, "key")
+	tagged := urlCreatedJsonResponse{}
+	json.NewDecoder(response.Body).Decode(&tagged)
+	if response.Code != http.StatusOK || len(tagged.Data.Tags) != 1 || tagged.Data.Tags[0] != "news" || tagged.Data.Campaign != campaign.Data.ID {
+		t.Fatal("Failed to create tagged url", response.Code, tagged)
+	}
+	if response := apiRequest(server, http.MethodPost, "/api/v1/urls", `{"url":"https://www.example.com/b","campaign":"`+campaign.Data.ID+`"}`, "other"); response.Code != http.StatusNotFound {
+		t.Fatal("Urls can't be added to campaigns of other keys", response.Code)
+	}
+	apiRequest(server, http.MethodPost, "/api/v1/urls", `{"url":"https://www.example.com/c"}`, "key")
+	apiRequest(server, http.MethodPost, "/api/v1/urls", `{"url":"https://www.example.com/d","tags":["news"]}`, "other")
+
+	for path, expected := range map[string]int{
+		"/api/v1/urls":                                2,
+		"/api/v1/urls?limit=1":                        1,
+		"/api/v1/urls?offset=1":                       1,
+		"/api/v1/urls?tag=news":                       1,
+		"/api/v1/urls?campaign=" + campaign.Data.ID:   1,
+		"/api/v1/urls?tag=news&campaign=" + "unknown": -1,
+	} {
+		response := apiRequest(server, http.MethodGet, path, "", "key")
+		if expected < 0 {
+			if response.Code != http.StatusNotFound {
+				t.Fatal("Unknown campaigns should not be found", path, response.Code)
+			}
+			continue
+		}
+		list := urlListJsonResponse{}
+		json.NewDecoder(response.Body).Decode(&list)
+		if response.Code != http.StatusOK || len(list.Data) != expected {
+			t.Fatal("Urls of the key should be filtered", path, response.Code, list.Data)
+		}
+	}
+	for _, path := range []string{"/api/v1/urls?limit=-1", "/api/v1/urls?limit=1000", "/api/v1/urls?offset=x"} {
+		if response := apiRequest(server, http.MethodGet, path, "", "key"); response.Code != http.StatusBadRequest {
+			t.Fatal("Invalid pagination should be rejected", path, response.Code)
+		}
+	}
+}
+
+func TestCampaignStatsAddUpItsURLs(t *testing.T) {
+	server, store := newCatalogTestServer()
+	response := apiRequest(server, http.MethodPost, "/api/v1/campaigns", `{"name":"Launch"}`, "key")
+	campaign := campaignJsonResponse{}
+	json.NewDecoder(response.Body).Decode(&campaign)
+	for _, destination := range []string{"https://www.example.com/a", "https://www.example.com/b"} {
+		apiRequest(server, http.MethodPost, "/api/v1/urls", `{"url":"`+destination+`","campaign":"`+campaign.Data.ID+`"}`, "key")
+	}
+	store.CreateURLView(domain.URLView{Hash: "h1", Variant: domain.NoVariant})
+	store.CreateURLView(domain.URLView{Hash: "h2", Variant: domain.NoVariant})
+	store.CreateURLView(domain.URLView{Hash: "h2", Variant: domain.NoVariant})
+
+	response = apiRequest(server, http.MethodGet, "/api/v1/campaigns/"+campaign.Data.ID+"/views", "", "key")
+	body := response.Body.String()
+	stats := campaignStatsJsonResponse{}
+	json.Unmarshal([]byte(body), &stats)
+	if response.Code != http.StatusOK || stats.Data.URLs != 2 || stats.Data.Views.Count != 3 {
+		t.Fatal("Campaign stats should add up the views of its urls", response.Code, stats)
+	}
+	if strings.Contains(body, "unique") {
+		t.Fatal("Visitors of several urls can't be told apart, they shouldn't be added up", body)
+	}
+	if response := apiRequest(server, http.MethodGet, "/api/v1/campaigns/"+campaign.Data.ID+"/views", "", "other"); response.Code != http.StatusNotFound {
+		t.Fatal("Campaigns of other keys should not be found", response.Code)
+	}
+
+	response = apiRequest(server, http.MethodGet, "/api/v1/campaigns", "", "key")
+	list := campaignListJsonResponse{}
+	json.NewDecoder(response.Body).Decode(&list)
+	if response.Code != http.StatusOK || len(list.Data) != 1 || list.Data[0].ID != campaign.Data.ID {
+		t.Fatal("Campaigns of the key should be listed", response.Code, list)
+	}
+}
+
+func newCatalogTestServer() (*Server, *memoryStore) {
+	store := newMemoryStore()
+	service := domain.NewURLShortenerService(store, store, domain.ServiceConfig{})
+	server := NewGorillaHttpServer()
+	server.Route(NewGorillaHTTPHandler(service, HandlerConfig{Catalog: domain.NewURLCatalogService(store, store)}))
+	return &server, store
+}
+
+func apiRequest(server *Server, method string, path string, body string, apiKey string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-API-Key", apiKey)
+	response := httptest.NewRecorder()
+	server.Router.ServeHTTP(response, request)
+	return response
+}
+
+type memoryCampaign struct {
+	domain.Campaign
+	owner string
+}
+
+// List returns the urls of the owner matching the filter, the newest first
+func (s *memoryStore) List(owner string, filter domain.URLFilter) ([]domain.URL, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	urls := []domain.URL{}
+	for hash, url := range s.urls {
+		if s.owners[hash] != owner || (len(filter.Campaign) != 0 && url.Campaign != filter.Campaign) {
+			continue
+		}
+		tagged := len(filter.Tag) == 0
+		for _, tag := range url.Tags {
+			tagged = tagged || tag == filter.Tag
+		}
+		if tagged {
+			urls = append(urls, url)
+		}
+	}
+	// hashes are h1, h2...
+	sort.Slice(urls, func(i, j int) bool {
+		return len(urls[i].Hash) > len(urls[j].Hash) || (len(urls[i].Hash) == len(urls[j].Hash) && urls[i].Hash > urls[j].Hash)
+	})
+	if filter.Offset >= len(urls) {
+		return []domain.URL{}, nil
+	}
+	urls = urls[filter.Offset:]
+	if len(urls) > filter.Limit {
+		urls = urls[:filter.Limit]
+	}
+	return urls, nil
+}
+
+func (s *memoryStore) CreateCampaign(owner string, name string) (domain.Campaign, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, campaign := range s.campaigns {
+		if campaign.owner == owner && campaign.Name == name {
+			return domain.Campaign{}, domain.ErrorCampaignExists
+		}
+	}
+	campaign := domain.Campaign{ID: "c" + strconv.Itoa(len(s.campaigns)+1), Name: name, CreatedAt: time.Now().UTC()}
+	s.campaigns = append(s.campaigns, memoryCampaign{Campaign: campaign, owner: owner})
+	return campaign, nil
+}
+
+func (s *memoryStore) FindCampaign(owner string, campaignID string) (domain.Campaign, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.findCampaign(owner, campaignID)
+}
+
+func (s *memoryStore) findCampaign(owner string, campaignID string) (domain.Campaign, error) {
+	for _, campaign := range s.campaigns {
+		if campaign.owner == owner && campaign.ID == campaignID {
+			return campaign.Campaign, nil
+		}
+	}
+	return domain.Campaign{}, domain.ErrorCampaignNotFound
+}
+
+func (s *memoryStore) CampaignURLs(owner string, campaignID string) ([]string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, err := s.findCampaign(owner, campaignID); err != nil {
+		return nil, err
+	}
+	hashes := []string{}
+	for hash, url := range s.urls {
+		if url.Campaign == campaignID {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+func (s *memoryStore) Campaigns(owner string) ([]domain.Campaign, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	campaigns := []domain.Campaign{}
+	for _, campaign := range s.campaigns {
+		if campaign.owner == owner {
+			campaigns = append(campaigns, campaign.Campaign)
+		}
+	}
+	return campaigns, nil
+}
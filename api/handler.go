@@ -1,6 +1,9 @@
 package api
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -13,29 +16,115 @@ type URLShortnerHttpHandler interface {
 	Redirect(http.ResponseWriter, *http.Request)
 	CreateURL(http.ResponseWriter, *http.Request)
 	ViewUrlStats(http.ResponseWriter, *http.Request)
+	DisableURL(http.ResponseWriter, *http.Request)
+	QRCode(http.ResponseWriter, *http.Request)
+	Preview(http.ResponseWriter, *http.Request)
+	Unlock(http.ResponseWriter, *http.Request)
+	ListURLs(http.ResponseWriter, *http.Request)
+	CreateCampaign(http.ResponseWriter, *http.Request)
+	ListCampaigns(http.ResponseWriter, *http.Request)
+	ViewCampaignStats(http.ResponseWriter, *http.Request)
+	URLHealth(http.ResponseWriter, *http.Request)
+	BrokenURLs(http.ResponseWriter, *http.Request)
+	DeleteURL(http.ResponseWriter, *http.Request)
+	CreateWebhook(http.ResponseWriter, *http.Request)
+	ListWebhooks(http.ResponseWriter, *http.Request)
+	DeleteWebhook(http.ResponseWriter, *http.Request)
+}
+
+var errorUnauthorized = errors.New("Unauthorized")
+
+// HandlerConfig holds the server defaults used when a request doesn't set them
+type HandlerConfig struct {
+	// ReuseExisting is used when a create request doesn't have a "reuse" field
+	ReuseExisting bool
+	// AdminToken is required in the X-Admin-Token header by admin endpoints,
+	// they are disabled when it's empty
+	AdminToken string
+	// Domains resolves the domain of redirects from their Host header and
+	// the domain of API requests from their "domain" query parameter
+	Domains domain.Domains
+	// QRLogo is embedded in QR codes requested with logo=true, they can't have a logo when it's nil
+	QRLogo *QRLogo
+	// CookieSecret signs the cookies of unlocked protected urls and salts the hashes of visitors,
+	// instances behind a load balancer need the same one. A random secret is used when it's empty
+	CookieSecret []byte
+	// PasswordLimiter limits the password attempts of each client for each protected url
+	// to PasswordAttempts, attempts are not limited when it's nil
+	PasswordLimiter  domain.RateLimiter
+	PasswordAttempts domain.RateLimit
+	// CountryHeader is the header with the country code of visitors for routing rules,
+	// like CF-IPCountry behind Cloudflare. Rules with a country never match when it's empty
+	CountryHeader string
+	// Catalog lists the urls and campaigns of API keys, those endpoints are not found when it's nil
+	Catalog domain.URLCatalogService
+	// Health tells how the destinations of urls are doing, those endpoints are not found when it's nil
+	Health domain.URLHealthService
+	// Webhooks lets API keys register endpoints for the events of their urls, those endpoints are not found when it's nil
+	Webhooks domain.WebhookService
+	// TrustForwardedFor takes the client IP from the X-Forwarded-For header, see RateLimitConfig,
+	// and whether the client used HTTPS from the X-Forwarded-Proto header
+	TrustForwardedFor bool
 }
 
 type handler struct {
 	urlService domain.URLShortenerService
+	config     HandlerConfig
+	// rollVariant picks the variant of new visitors of split urls, like rand.Intn
+	rollVariant func(n int) int
 }
 
 // NewGorillaHTTPHandler creates a new http handler that works with Gorilla's router
-func NewGorillaHTTPHandler(service domain.URLShortenerService) URLShortnerHttpHandler {
-	return &handler{urlService: service}
+func NewGorillaHTTPHandler(service domain.URLShortenerService, config HandlerConfig) URLShortnerHttpHandler {
+	if len(config.CookieSecret) == 0 {
+		config.CookieSecret = randomSecret()
+	}
+	return &handler{urlService: service, config: config, rollVariant: newVariantRoller()}
 }
 
-// Redirect URL hash to its full URL
+// Redirect URL hash of the requested host to its full URL
 func (h *handler) Redirect(response http.ResponseWriter, request *http.Request) {
 	urlHash, ok := mux.Vars(request)["urlHash"]
 	if ok == false {
 		chooseErrorResponse(domain.ErrorURLNotFound, response)
 		return
 	}
-	url, err := h.urlService.Find(urlHash, true)
+	// clicks are recorded here, protected urls are only clicked once they are unlocked
+	url, err := h.urlService.Find(h.config.Domains.ForHost(request.Host), urlHash, false)
 	// The switch is here to return 404 when the url has an invalid hash, that's not information the user needs to know.
 	switch err {
 	case nil:
-		http.Redirect(response, request, url.Full, http.StatusMovedPermanently)
+		visitor, err := h.visitor(response, request, url)
+		if err != nil {
+			chooseErrorResponse(err, response)
+			return
+		}
+		if url.Protected() {
+			h.redirectProtected(response, request, url, visitor)
+			return
+		}
+		if err := h.urlService.Click(url, visitor); err != nil {
+			chooseErrorResponse(err, response)
+			return
+		}
+		destination := url.Destination(visitor)
+		if url.Preview {
+			h.renderPreview(response, request, url, destination)
+			return
+		}
+		if url.MaxClicks > 0 || len(url.Variants) != 0 {
+			// browsers would follow a permanent redirect without asking us, skipping the count,
+			// and shared caches would send every visitor of a split url to the same variant
+			response.Header().Set("Cache-Control", "no-store")
+			http.Redirect(response, request, destination, http.StatusFound)
+			return
+		}
+		if len(url.Rules) != 0 {
+			// the same browser can go somewhere else next time, from another country or with other languages
+			http.Redirect(response, request, destination, http.StatusFound)
+			return
+		}
+		http.Redirect(response, request, destination, http.StatusMovedPermanently)
 	case domain.ErrorInvalidURL:
 		chooseErrorResponse(domain.ErrorURLNotFound, response)
 	default:
@@ -43,10 +132,91 @@ func (h *handler) Redirect(response http.ResponseWriter, request *http.Request)
 	}
 }
 
+// Preview shows where a url goes instead of redirecting, for the /{urlHash}+ route.
+// Previews are not counted as views, so click limited urls can't be previewed.
+func (h *handler) Preview(response http.ResponseWriter, request *http.Request) {
+	urlHash, ok := mux.Vars(request)["urlHash"]
+	if ok == false {
+		chooseErrorPage(domain.ErrorURLNotFound, response)
+		return
+	}
+	url, err := h.urlService.Find(h.config.Domains.ForHost(request.Host), urlHash, false)
+	if err != nil {
+		chooseErrorPage(err, response)
+		return
+	}
+	if url.MaxClicks > 0 {
+		renderHTML(response, http.StatusForbidden, errorPage, errorPageData{
+			Title:   "Preview unavailable",
+			Message: "This short link can only be followed a limited number of times and can't be previewed.",
+		})
+		return
+	}
+	if url.Protected() && h.unlocked(request, url) == false {
+		renderPasswordPage(response, http.StatusOK, "")
+		return
+	}
+	visitor, err := h.visitor(response, request, url)
+	if err != nil {
+		chooseErrorPage(err, response)
+		return
+	}
+	h.renderPreview(response, request, url, url.Destination(visitor))
+}
+
+// redirectProtected redirects to protected urls that have been unlocked and asks for the password otherwise.
+// The redirect is temporary so browsers don't skip the password next time.
+func (h *handler) redirectProtected(response http.ResponseWriter, request *http.Request, url domain.URL, visitor domain.Visitor) {
+	if h.unlocked(request, url) == false {
+		renderPasswordPage(response, http.StatusOK, "")
+		return
+	}
+	if err := h.urlService.Click(url, visitor); err != nil {
+		chooseErrorPage(err, response)
+		return
+	}
+	destination := url.Destination(visitor)
+	if url.Preview {
+		h.renderPreview(response, request, url, destination)
+		return
+	}
+	response.Header().Set("Cache-Control", "no-store")
+	http.Redirect(response, request, destination, http.StatusFound)
+}
+
+func (h *handler) renderPreview(response http.ResponseWriter, request *http.Request, url domain.URL, destination string) {
+	stats, err := h.urlService.Stats(url.Hash)
+	if err != nil {
+		chooseErrorPage(err, response)
+		return
+	}
+	short, _ := h.shortURL(request, url)
+	renderPreviewPage(response, previewPageData{
+		ShortURL:    short,
+		URL:         url,
+		Destination: destination,
+		Views:       stats,
+	})
+}
+
 // Create a new URL
 func (h *handler) CreateURL(response http.ResponseWriter, request *http.Request) {
 	type createShortURLRequest struct {
-		URL string `json:"url"`
+		URL       string               `json:"url"`
+		Reuse     *bool                `json:"reuse"`
+		Domain    string               `json:"domain"`
+		Preview   bool                 `json:"preview"`
+		Password  string               `json:"password"`
+		MaxClicks int                  `json:"max_clicks"`
+		Rules     []domain.RoutingRule `json:"rules"`
+		Variants  []domain.Variant     `json:"variants"`
+		QueryMode string               `json:"query_mode"`
+		UTM       domain.UTM           `json:"utm"`
+		Prefix    bool                 `json:"prefix"`
+		Tags      []string             `json:"tags"`
+		Campaign  string               `json:"campaign"`
+		Title     string               `json:"title"`
+		Notes     string               `json:"notes"`
 	}
 	data := &createShortURLRequest{}
 
@@ -54,8 +224,32 @@ func (h *handler) CreateURL(response http.ResponseWriter, request *http.Request)
 		chooseErrorResponse(err, response)
 		return
 	}
+	if data.MaxClicks < 0 {
+		chooseErrorResponse(&parameterError{name: "max_clicks"}, response)
+		return
+	}
+	opts := domain.CreateOptions{
+		Owner:         requestOwner(request),
+		ReuseExisting: h.config.ReuseExisting,
+		Domain:        data.Domain,
+		Preview:       data.Preview,
+		Password:      data.Password,
+		MaxClicks:     data.MaxClicks,
+		Rules:         data.Rules,
+		Variants:      data.Variants,
+		QueryMode:     data.QueryMode,
+		UTM:           data.UTM,
+		Prefix:        data.Prefix,
+		Tags:          data.Tags,
+		Campaign:      data.Campaign,
+		Title:         data.Title,
+		Notes:         data.Notes,
+	}
+	if data.Reuse != nil {
+		opts.ReuseExisting = *data.Reuse
+	}
 
-	url, err := h.urlService.Create(data.URL)
+	url, err := h.urlService.Create(data.URL, opts)
 	if err != nil {
 		chooseErrorResponse(err, response)
 		return
@@ -79,7 +273,12 @@ func (h *handler) ViewUrlStats(response http.ResponseWriter, request *http.Reque
 		chooseErrorResponse(domain.ErrorURLNotFound, response)
 		return
 	}
-	url, err := h.urlService.Find(urlHash, false)
+	urlDomain, err := h.requestDomain(request)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	url, err := h.urlService.Find(urlDomain, urlHash, false)
 	if err != nil {
 		chooseErrorResponse(err, response)
 		return
@@ -90,6 +289,16 @@ func (h *handler) ViewUrlStats(response http.ResponseWriter, request *http.Reque
 		chooseErrorResponse(err, response)
 		return
 	}
+	// stats are public, the destination of protected urls and the notes of owners are not
+	if url.Protected() {
+		url.Full = ""
+		url.Rules = nil
+		url.Variants = nil
+		url.Metadata = nil
+	}
+	url.Notes = ""
+	// views of each variant, including the ones that weren't visited yet
+	stats.Variants = variantViews(url, stats.Variants)
 
 	responseData, err := json.Marshal(&urlStatsJsonResponse{
 		Data: urlStats{
@@ -105,13 +314,114 @@ func (h *handler) ViewUrlStats(response http.ResponseWriter, request *http.Reque
 	response.Write(responseData)
 }
 
+// DisableURL stops a url from being served, it's meant for urls that turned out to be malicious
+func (h *handler) DisableURL(response http.ResponseWriter, request *http.Request) {
+	if h.isAdmin(request) == false {
+		chooseErrorResponse(errorUnauthorized, response)
+		return
+	}
+	urlHash, ok := mux.Vars(request)["urlHash"]
+	if ok == false {
+		chooseErrorResponse(domain.ErrorURLNotFound, response)
+		return
+	}
+	urlDomain, err := h.requestDomain(request)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	switch err := h.urlService.Disable(urlDomain, urlHash); err {
+	case nil:
+		response.WriteHeader(http.StatusNoContent)
+	case domain.ErrorInvalidURL:
+		chooseErrorResponse(domain.ErrorURLNotFound, response)
+	default:
+		chooseErrorResponse(err, response)
+	}
+}
+
+// DeleteURL deletes a url of the API key of the request, urls of other keys are not found
+func (h *handler) DeleteURL(response http.ResponseWriter, request *http.Request) {
+	owner, ok := ownerOf(response, request, true)
+	if ok == false {
+		return
+	}
+	urlHash, ok := mux.Vars(request)["urlHash"]
+	if ok == false {
+		chooseErrorResponse(domain.ErrorURLNotFound, response)
+		return
+	}
+	urlDomain, err := h.requestDomain(request)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	switch err := h.urlService.Delete(urlDomain, urlHash, owner); err {
+	case nil:
+		response.WriteHeader(http.StatusNoContent)
+	case domain.ErrorInvalidURL:
+		chooseErrorResponse(domain.ErrorURLNotFound, response)
+	default:
+		chooseErrorResponse(err, response)
+	}
+}
+
+// requestDomain returns the domain of the url an API request is about, the default one when it's not set
+func (h *handler) requestDomain(request *http.Request) (string, error) {
+	return h.config.Domains.Validate(request.URL.Query().Get("domain"))
+}
+
+func (h *handler) isAdmin(request *http.Request) bool {
+	token := request.Header.Get("X-Admin-Token")
+	if len(h.config.AdminToken) == 0 || len(token) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.config.AdminToken)) == 1
+}
+
+// requestOwner identifies the client by its API key, the key itself is never stored
+func requestOwner(request *http.Request) string {
+	key := request.Header.Get("X-API-Key")
+	if len(key) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
 func chooseErrorResponse(err error, response http.ResponseWriter) {
 	response.Header().Set("Content-Type", "application/json; charset=utf-8")
+	// validation errors tell the client which rule the url failed
+	if validationErr, ok := err.(*domain.URLValidationError); ok {
+		response.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(response).Encode(errorJsonResponse{Message: err.Error(), Rule: validationErr.Rule})
+		return
+	}
+	if _, ok := err.(*parameterError); ok {
+		response.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(response).Encode(errorJsonResponse{Message: err.Error()})
+		return
+	}
+	if rateLimitErr, ok := err.(*rateLimitError); ok {
+		response.Header().Set("Retry-After", retryAfterSeconds(rateLimitErr.retryAfter))
+		response.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(response).Encode(errorJsonResponse{Message: err.Error()})
+		return
+	}
 	switch err {
-	case domain.ErrorInvalidURL:
+	case domain.ErrorInvalidURL, domain.ErrorUnknownDomain, domain.ErrorInvalidPassword, domain.ErrorInvalidQueryMode,
+		domain.ErrorInvalidCampaignName, domain.ErrorInvalidWebhookEvent:
 		response.WriteHeader(http.StatusBadRequest)
-	case domain.ErrorURLNotFound:
+	case domain.ErrorURLNotFound, domain.ErrorCampaignNotFound, domain.ErrorWebhookNotFound:
 		response.WriteHeader(http.StatusNotFound)
+	case domain.ErrorURLBlocked:
+		response.WriteHeader(http.StatusForbidden)
+	case domain.ErrorURLExpired:
+		response.WriteHeader(http.StatusGone)
+	case domain.ErrorCampaignExists, domain.ErrorTooManyWebhooks:
+		response.WriteHeader(http.StatusConflict)
+	case errorUnauthorized:
+		response.WriteHeader(http.StatusUnauthorized)
 	default:
 		response.WriteHeader(http.StatusInternalServerError)
 		err = errors.New("Internal Server Error")
@@ -0,0 +1,566 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestCreateURLRejectsOwnHost(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{OwnHosts: []string{"sho.rt"}}, HandlerConfig{})
+	created := createTestURL(t, server, "https://www.example.com")
+
+	response := postJSON(server, "/api/v1/urls", `{"url":"https://sho.rt/`+created.Hash+`"}`)
+	if response.Code != http.StatusBadRequest {
+		t.Fatal("Urls to our own host should be rejected", response.Code, response.Body.String())
+	}
+	data := errorJsonResponse{}
+	json.NewDecoder(response.Body).Decode(&data)
+	if data.Rule != domain.RuleSelfReference {
+		t.Fatal("Response should tell which rule failed", data)
+	}
+}
+
+func TestCreateURLFlattensOwnHost(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{OwnHosts: []string{"sho.rt"}, FlattenOwnURLs: true}, HandlerConfig{})
+	created := createTestURL(t, server, "https://www.example.com/page")
+
+	flattened := createTestURL(t, server, "https://sho.rt/"+created.Hash)
+	if flattened.Full != created.Full || flattened.Hash == created.Hash {
+		t.Fatal("Url should have been flattened to the destination", flattened, created)
+	}
+}
+
+func TestCreateURLDetectsLoopsThroughOtherShorteners(t *testing.T) {
+	// another shortener with a link back to us and a link to somewhere else
+	otherShortener := http.NewServeMux()
+	target := ""
+	otherShortener.HandleFunc("/toUs", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://sho.rt/"+target, http.StatusMovedPermanently)
+	})
+	otherShortener.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://www.example.org/", http.StatusMovedPermanently)
+	})
+	config := domain.ServiceConfig{
+		OwnHosts:       []string{"sho.rt"},
+		ShortenerHosts: []string{"bit.ly"},
+		Resolver:       &handlerResolver{handler: otherShortener},
+	}
+	server := newTestServer(config, HandlerConfig{})
+	target = createTestURL(t, server, "https://www.example.com").Hash
+
+	response := postJSON(server, "/api/v1/urls", `{"url":"https://bit.ly/toUs"}`)
+	if response.Code != http.StatusBadRequest {
+		t.Fatal("Short links leading to us should be rejected", response.Code, response.Body.String())
+	}
+	if away := createTestURL(t, server, "https://bit.ly/away"); away.Full != "https://bit.ly/away" {
+		t.Fatal("Short links leading somewhere else should be kept", away)
+	}
+
+	config.FlattenOwnURLs = true
+	server = newTestServer(config, HandlerConfig{})
+	target = createTestURL(t, server, "https://www.example.com").Hash
+	if flattened := createTestURL(t, server, "https://bit.ly/toUs"); flattened.Full != "https://www.example.com" {
+		t.Fatal("Short links leading to us should be flattened", flattened)
+	}
+}
+
+func TestURLsBelongToDomains(t *testing.T) {
+	domains := domain.Domains{Default: "sho.rt", Registered: []string{"brand.co"}}
+	server := newTestServer(domain.ServiceConfig{Domains: domains}, HandlerConfig{Domains: domains})
+
+	response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","domain":"brand.co"}`)
+	created := urlCreatedJsonResponse{}
+	json.NewDecoder(response.Body).Decode(&created)
+	if response.Code != http.StatusOK || created.Data.Domain != "brand.co" {
+		t.Fatal("Url should have been created on the requested domain", response.Code, created)
+	}
+	if defaulted := createTestURL(t, server, "https://www.example.com"); defaulted.Domain != "sho.rt" {
+		t.Fatal("Urls without domain should get the default one", defaulted)
+	}
+	if response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","domain":"other.com"}`); response.Code != http.StatusBadRequest {
+		t.Fatal("Unknown domains should be rejected", response.Code, response.Body.String())
+	}
+
+	for host, expected := range map[string]int{
+		"brand.co":      http.StatusMovedPermanently,
+		"BRAND.CO:8080": http.StatusMovedPermanently,
+		"sho.rt":        http.StatusNotFound,
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/"+created.Data.Hash, nil)
+		request.Host = host
+		response := httptest.NewRecorder()
+		server.Router.ServeHTTP(response, request)
+		if response.Code != expected {
+			t.Fatal("Redirects should find urls of the requested host", host, response.Code, expected)
+		}
+	}
+
+	for path, expected := range map[string]int{
+		"/api/v1/urls/" + created.Data.Hash + "/views?domain=brand.co":  http.StatusOK,
+		"/api/v1/urls/" + created.Data.Hash + "/views":                  http.StatusNotFound,
+		"/api/v1/urls/" + created.Data.Hash + "/views?domain=other.com": http.StatusBadRequest,
+	} {
+		response := httptest.NewRecorder()
+		server.Router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		if response.Code != expected {
+			t.Fatal("Stats should find urls of the domain parameter", path, response.Code, expected)
+		}
+	}
+}
+
+func TestStatsHideNotes(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","title":"Example","notes":"Only for us"}`)
+	created := urlCreatedJsonResponse{}
+	json.NewDecoder(response.Body).Decode(&created)
+	if response.Code != http.StatusOK || created.Data.Title != "Example" || created.Data.Notes != "Only for us" {
+		t.Fatal("Failed to create url with title and notes", response.Code, created)
+	}
+	stats := getPath(server, "/api/v1/urls/"+created.Data.Hash+"/views")
+	if stats.Code != http.StatusOK || strings.Contains(stats.Body.String(), `"title":"Example"`) == false ||
+		strings.Contains(stats.Body.String(), "Only for us") {
+		t.Fatal("Public stats should show the title but not the notes", stats.Code, stats.Body.String())
+	}
+}
+
+// handlerResolver resolves short links with an in-process http.Handler
+func TestClickLimitedURLsExpire(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	if response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","max_clicks":-1}`); response.Code != http.StatusBadRequest {
+		t.Fatal("Negative click limits should be rejected", response.Code)
+	}
+	response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","max_clicks":5}`)
+	if response.Code != http.StatusOK || strings.Contains(response.Body.String(), `"max_clicks":5`) == false {
+		t.Fatal("Failed to create click limited url", response.Code, response.Body.String())
+	}
+	if preview := getPath(server, "/h1+"); preview.Code != http.StatusForbidden {
+		t.Fatal("Click limited urls should not be previewed", preview.Code)
+	}
+
+	// more clicks than the limit at once, exactly max_clicks of them go through
+	var wg sync.WaitGroup
+	codes := make(chan int, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response := getPath(server, "/h1")
+			if response.Code == http.StatusFound && response.Header().Get("Cache-Control") != "no-store" {
+				t.Error("Click limited redirects should not be cached")
+			}
+			codes <- response.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	count := map[int]int{}
+	for code := range codes {
+		count[code]++
+	}
+	if count[http.StatusFound] != 5 || count[http.StatusGone] != 15 {
+		t.Fatal("Click limited urls should redirect max_clicks times and then be gone", count)
+	}
+}
+
+func TestRoutingRulesPickTheDestination(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{CountryHeader: "CF-IPCountry"})
+	response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","rules":[
+		{"os":"ios","url":"https://apps.apple.com/app/example"},
+		{"country":"at","url":"https://www.example.com/at"},
+		{"language":"de","url":"https://www.example.com/de"}]}`)
+	if response.Code != http.StatusOK {
+		t.Fatal("Failed to create url with rules", response.Code, response.Body.String())
+	}
+	if response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","rules":[{"os":"beos","url":"https://www.example.com"}]}`); response.Code != http.StatusBadRequest ||
+		strings.Contains(response.Body.String(), domain.RuleRoutingRule) == false {
+		t.Fatal("Invalid rules should be rejected", response.Code, response.Body.String())
+	}
+
+	for expected, headers := range map[string]map[string]string{
+		"https://apps.apple.com/app/example": {"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 14_0 like Mac OS X) Mobile/15E148"},
+		"https://www.example.com/at":         {"CF-IPCountry": "AT", "Accept-Language": "de"},
+		"https://www.example.com/de":         {"Accept-Language": "en;q=0.5, de-CH"},
+		"https://www.example.com":            {"Accept-Language": "en"},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/h1", nil)
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+		response := httptest.NewRecorder()
+		server.Router.ServeHTTP(response, request)
+		if response.Code != http.StatusFound || response.Header().Get("Location") != expected {
+			t.Fatal("Visitor was sent to the wrong destination", headers, response.Code, response.Header().Get("Location"))
+		}
+		if response.Header().Get("Vary") != "User-Agent, Accept-Language, CF-IPCountry" {
+			t.Fatal("Routed redirects should vary on the headers of the rules", response.Header())
+		}
+	}
+}
+
+func TestVariantCookiesAreSecureBehindHTTPSProxies(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{TrustForwardedFor: true})
+	postJSON(server, "/api/v1/urls", `{"variants":[{"url":"https://a.example.com","weight":1},{"url":"https://b.example.com","weight":1}]}`)
+	request := httptest.NewRequest(http.MethodGet, "/h1", nil)
+	request.Header.Set("X-Forwarded-Proto", "https")
+	response := httptest.NewRecorder()
+	server.Router.ServeHTTP(response, request)
+	if cookies := response.Result().Cookies(); len(cookies) != 1 || cookies[0].Secure == false {
+		t.Fatal("Variant cookies of HTTPS clients should be secure", cookies)
+	}
+	if cookies := getPath(server, "/h1").Result().Cookies(); len(cookies) != 1 || cookies[0].Secure {
+		t.Fatal("Variant cookies of HTTP clients can't be secure", cookies)
+	}
+}
+
+func TestSplitURLsKeepVisitorsOnTheirVariant(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	if response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","variants":[{"url":"https://a.example.com","weight":1},{"url":"https://b.example.com","weight":1}]}`); response.Code != http.StatusBadRequest {
+		t.Fatal("Split urls should not have a url", response.Code)
+	}
+	response := postJSON(server, "/api/v1/urls", `{"variants":[{"url":"https://a.example.com","weight":70},{"url":"https://b.example.com","weight":30}]}`)
+	if response.Code != http.StatusOK {
+		t.Fatal("Failed to create split url", response.Code, response.Body.String())
+	}
+
+	visits := map[string]int{}
+	for i := 0; i < 200; i++ {
+		response := getPath(server, "/h1")
+		cookies := response.Result().Cookies()
+		if response.Code != http.StatusFound || len(cookies) != 1 || cookies[0].Name != "variant_h1" {
+			t.Fatal("New visitors should get a variant", response.Code, cookies)
+		}
+		destination := response.Header().Get("Location")
+		visits[destination]++
+		if again := getWithCookie(server, "/h1", cookies[0]); again.Header().Get("Location") != destination || len(again.Result().Cookies()) != 0 {
+			t.Fatal("Visitors should keep their variant", destination, again.Header().Get("Location"))
+		}
+	}
+	if visits["https://a.example.com"] < 100 || visits["https://b.example.com"] < 30 {
+		t.Fatal("Visitors should be split by weight", visits)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	stats := getPath(server, "/api/v1/urls/h1/views")
+	data := &struct {
+		Data struct {
+			Views domain.URLViewStats `json:"views"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(stats.Body.Bytes(), data); err != nil {
+		t.Fatal("Failed to read stats", err, stats.Body.String())
+	}
+	views := data.Data.Views.Variants
+	if len(views) != 2 || views[0] != 2*visits["https://a.example.com"] || views[1] != 2*visits["https://b.example.com"] {
+		t.Fatal("Stats should have the views of each variant", views, visits)
+	}
+}
+
+func TestRedirectCountsUniqueVisitors(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	url := createTestURL(t, server, "https://www.example.com")
+	for _, userAgent := range []string{"Firefox", "Firefox", "Chrome"} {
+		request := httptest.NewRequest(http.MethodGet, "/"+url.Hash, nil)
+		request.Header.Set("User-Agent", userAgent)
+		response := httptest.NewRecorder()
+		server.Router.ServeHTTP(response, request)
+		if response.Code != http.StatusMovedPermanently {
+			t.Fatal("Failed to redirect", response.Code)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	stats := getPath(server, "/api/v1/urls/"+url.Hash+"/views")
+	data := &struct {
+		Data struct {
+			Views domain.URLViewStats `json:"views"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(stats.Body.Bytes(), data); err != nil {
+		t.Fatal("Failed to read stats", err, stats.Body.String())
+	}
+	if views := data.Data.Views; views.Count != 3 || views.UniqueCount != 2 || views.PastDayUniqueCount != 2 {
+		t.Fatal("Visitors should be counted once", views)
+	}
+}
+
+func TestRedirectForwardsQuery(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	if response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","query_mode":"append"}`); response.Code != http.StatusBadRequest {
+		t.Fatal("Unknown query modes should be rejected", response.Code)
+	}
+	createTestURL(t, server, "https://www.example.com/?id=1")
+	response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com/?id=1","query_mode":"override","utm":{"medium":"email"}}`)
+	if response.Code != http.StatusOK || strings.Contains(response.Body.String(), `"query_mode":"override"`) == false {
+		t.Fatal("Failed to create url forwarding its query", response.Code, response.Body.String())
+	}
+
+	for path, expected := range map[string]string{
+		"/h1?utm_source=x":                   "https://www.example.com/?id=1",
+		"/h2?utm_source=x%20y&id=2":          "https://www.example.com/?utm_medium=email&id=2&utm_source=x+y",
+		"/h2?utm_medium=qr&next=%2Fa%3Fb%3D": "https://www.example.com/?id=1&next=%2Fa%3Fb%3D&utm_medium=qr",
+	} {
+		if response := getPath(server, path); response.Header().Get("Location") != expected {
+			t.Fatal("Wrong destination for", path, response.Header().Get("Location"))
+		}
+	}
+}
+
+func TestPrefixURLsForwardTheirPath(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	createTestURL(t, server, "https://www.example.com")
+	response := postJSON(server, "/api/v1/urls", `{"url":"https://docs.example.com/v2/","prefix":true,"query_mode":"merge"}`)
+	if response.Code != http.StatusOK || strings.Contains(response.Body.String(), `"prefix":true`) == false {
+		t.Fatal("Failed to create prefix url", response.Code, response.Body.String())
+	}
+
+	for path, expected := range map[string]string{
+		"/h2":                     "https://docs.example.com/v2/",
+		"/h2/":                    "https://docs.example.com/v2/",
+		"/h2/guide/intro?lang=de": "https://docs.example.com/v2/guide/intro?lang=de",
+		"/h2/a%3Fb/caf%C3%A9/":    "https://docs.example.com/v2/a%3Fb/caf%C3%A9/",
+		"/h1/":                    "https://www.example.com",
+	} {
+		if response := getPath(server, path); response.Header().Get("Location") != expected {
+			t.Fatal("Wrong destination for", path, response.Code, response.Header().Get("Location"))
+		}
+	}
+	if response := getPath(server, "/h1/guide"); response.Code != http.StatusNotFound {
+		t.Fatal("Only prefix urls should accept a path", response.Code, response.Header().Get("Location"))
+	}
+	// the router cleans dot segments before we get them, they must not reach the destination either way
+	if response := getPath(server, "/h2/guide/%2e%2e/%2e%2e/admin"); strings.Contains(response.Header().Get("Location"), "docs.example.com") {
+		t.Fatal("Visitors should not leave the destination path", response.Code, response.Header().Get("Location"))
+	}
+	// destinations that unescape slashes would see segments we didn't check
+	if response := getPath(server, "/h2/a%2Fb"); response.Code != http.StatusNotFound {
+		t.Fatal("Paths with escaped slashes should not be forwarded", response.Code, response.Header().Get("Location"))
+	}
+	if response := getPath(server, "/api/v1/urls/h2/views"); response.Code != http.StatusOK {
+		t.Fatal("API routes should not be taken for prefix paths", response.Code)
+	}
+}
+
+type handlerResolver struct {
+	handler http.Handler
+}
+
+func (r *handlerResolver) Resolve(url string) (string, error) {
+	recorder := httptest.NewRecorder()
+	r.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, url, nil))
+	if location := recorder.Header().Get("Location"); len(location) != 0 {
+		return location, nil
+	}
+	return url, nil
+}
+
+func newTestServer(serviceConfig domain.ServiceConfig, handlerConfig HandlerConfig) *Server {
+	store := newMemoryStore()
+	service := domain.NewURLShortenerService(store, store, serviceConfig)
+	server := NewGorillaHttpServer()
+	server.Route(NewGorillaHTTPHandler(service, handlerConfig))
+	return &server
+}
+
+func createTestURL(t *testing.T, server *Server, url string) domain.URL {
+	response := postJSON(server, "/api/v1/urls", `{"url":"`+url+`"}`)
+	if response.Code != http.StatusOK {
+		t.Fatal("Failed to create url", url, response.Code, response.Body.String())
+	}
+	data := urlCreatedJsonResponse{}
+	if err := json.NewDecoder(response.Body).Decode(&data); err != nil {
+		t.Fatal("Failed to decode response", err)
+	}
+	return data.Data
+}
+
+func postJSON(server *Server, path string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	server.Router.ServeHTTP(response, request)
+	return response
+}
+
+// memoryStore is an in-memory store and analytics repository
+type memoryStore struct {
+	m      sync.Mutex
+	urls   map[string]domain.URL
+	views  map[string][]memoryView
+	clicks map[string]int
+	// owners and campaigns are for the catalog, see catalog_test.go
+	owners    map[string]string
+	campaigns []memoryCampaign
+	// health of the destinations of urls, see health_test.go
+	health map[string]domain.LinkHealth
+	// webhooks and their outbox, see webhook_test.go
+	webhooks   []memoryWebhook
+	deliveries []domain.WebhookDelivery
+	// created counts the created urls, codes of deleted urls are not reused
+	created int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{urls: map[string]domain.URL{}, views: map[string][]memoryView{}, clicks: map[string]int{}, owners: map[string]string{}, health: map[string]domain.LinkHealth{}}
+}
+
+func (s *memoryStore) Find(urlDomain string, urlHash string) (domain.URL, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if strings.HasPrefix(urlHash, "h") == false {
+		return domain.URL{}, domain.ErrorInvalidURL
+	}
+	url, ok := s.urls[urlHash]
+	if ok == false || url.Domain != urlDomain {
+		return domain.URL{}, domain.ErrorURLNotFound
+	}
+	return url, nil
+}
+
+func (s *memoryStore) Create(fullURL string, opts domain.CreateOptions) (domain.URL, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if len(opts.Campaign) != 0 {
+		if _, err := s.findCampaign(opts.Owner, opts.Campaign); err != nil {
+			return domain.URL{}, err
+		}
+	}
+	url := domain.URL{
+		Domain:       opts.Domain,
+		Preview:      opts.Preview,
+		PasswordHash: opts.PasswordHash,
+		MaxClicks:    opts.MaxClicks,
+		Rules:        opts.Rules,
+		Variants:     opts.Variants,
+		QueryMode:    opts.QueryMode,
+		Prefix:       opts.Prefix,
+		Tags:         opts.Tags,
+		Campaign:     opts.Campaign,
+		Title:        opts.Title,
+		Notes:        opts.Notes,
+		Owner:        opts.Owner,
+		Hash:         fmt.Sprintf("h%d", s.created+1),
+		Full:         fullURL,
+		CreatedAt:    time.Now().UTC(),
+	}
+	s.created++
+	s.urls[url.Hash] = url
+	s.owners[url.Hash] = opts.Owner
+	return url, nil
+}
+
+func (s *memoryStore) Disable(urlDomain string, urlHash string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	url, ok := s.urls[urlHash]
+	if ok == false || url.Domain != urlDomain {
+		return domain.ErrorURLNotFound
+	}
+	url.Disabled = true
+	s.urls[urlHash] = url
+	return nil
+}
+
+func (s *memoryStore) Delete(urlDomain string, urlHash string, owner string) (domain.URL, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	url, ok := s.urls[urlHash]
+	if ok == false || url.Domain != urlDomain || s.owners[urlHash] != owner {
+		return domain.URL{}, domain.ErrorURLNotFound
+	}
+	delete(s.urls, urlHash)
+	delete(s.owners, urlHash)
+	delete(s.views, urlHash)
+	delete(s.clicks, urlHash)
+	delete(s.health, urlHash)
+	return url, nil
+}
+
+func (s *memoryStore) CountClick(urlDomain string, urlHash string) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	url, ok := s.urls[urlHash]
+	if ok == false || url.Domain != urlDomain {
+		return 0, domain.ErrorURLNotFound
+	}
+	if url.MaxClicks > 0 && s.clicks[urlHash] >= url.MaxClicks {
+		return 0, domain.ErrorURLExpired
+	}
+	s.clicks[urlHash]++
+	return s.clicks[urlHash], nil
+}
+
+type memoryView struct {
+	at      time.Time
+	variant int
+	visitor string
+}
+
+func (s *memoryStore) CreateURLView(view domain.URLView) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.views[view.Hash] = append(s.views[view.Hash], memoryView{at: time.Now(), variant: view.Variant, visitor: view.Visitor})
+	return nil
+}
+
+func (s *memoryStore) DeleteViews(urlHash string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.views, urlHash)
+	return nil
+}
+
+func (s *memoryStore) Stats(urlHash string) (domain.URLViewStats, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	stats := domain.URLViewStats{}
+	visitors, pastWeekVisitors, pastDayVisitors := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for _, view := range s.views[urlHash] {
+		stats.Count++
+		if len(view.visitor) != 0 {
+			visitors[view.visitor] = true
+		}
+		if time.Since(view.at) < 7*24*time.Hour {
+			stats.PastWeekCount++
+			if len(view.visitor) != 0 {
+				pastWeekVisitors[view.visitor] = true
+			}
+		}
+		if time.Since(view.at) < 24*time.Hour {
+			stats.PastDayCount++
+			if len(view.visitor) != 0 {
+				pastDayVisitors[view.visitor] = true
+			}
+		}
+		if view.variant >= 0 {
+			for len(stats.Variants) <= view.variant {
+				stats.Variants = append(stats.Variants, 0)
+			}
+			stats.Variants[view.variant]++
+		}
+	}
+	stats.UniqueCount, stats.PastWeekUniqueCount, stats.PastDayUniqueCount = len(visitors), len(pastWeekVisitors), len(pastDayVisitors)
+	return stats, nil
+}
+
+func (s *memoryStore) ViewCounts(urlHashes []string) (domain.ViewCounts, error) {
+	counts := domain.ViewCounts{}
+	for _, urlHash := range urlHashes {
+		stats, err := s.Stats(urlHash)
+		if err != nil {
+			return domain.ViewCounts{}, err
+		}
+		counts.Count += stats.Count
+		counts.PastWeekCount += stats.PastWeekCount
+		counts.PastDayCount += stats.PastDayCount
+	}
+	return counts, nil
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// URLHealth returns the last check of the destination of a url, null when it wasn't checked yet
+func (h *handler) URLHealth(response http.ResponseWriter, request *http.Request) {
+	if h.config.Health == nil {
+		chooseErrorResponse(domain.ErrorURLNotFound, response)
+		return
+	}
+	urlHash, ok := mux.Vars(request)["urlHash"]
+	if ok == false {
+		chooseErrorResponse(domain.ErrorURLNotFound, response)
+		return
+	}
+	urlDomain, err := h.requestDomain(request)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	if _, err := h.urlService.Find(urlDomain, urlHash, false); err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	health, err := h.config.Health.Health(urlDomain, urlHash)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	writeJSON(response, http.StatusOK, &urlHealthJsonResponse{Data: health})
+}
+
+// BrokenURLs returns the urls of the API key of the request with broken destinations,
+// paginated with limit and offset
+func (h *handler) BrokenURLs(response http.ResponseWriter, request *http.Request) {
+	owner, ok := ownerOf(response, request, h.config.Health != nil)
+	if ok == false {
+		return
+	}
+	query := request.URL.Query()
+	limit, err := intParameter(query, "limit", domain.MaxListLimit)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	offset, err := intParameter(query, "offset", -1)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	broken, err := h.config.Health.Broken(owner, limit, offset)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	writeJSON(response, http.StatusOK, &brokenURLsJsonResponse{Data: broken})
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+	"github.com/yanisky/url-shortener/pkg/health"
+)
+
+func TestHealthOfDestinations(t *testing.T) {
+	destinations := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer destinations.Close()
+
+	store := newMemoryStore()
+	checker := domain.NewHealthChecker(health.NewHTTPProber(destinations.Client()), store, domain.HealthCheckerConfig{Interval: time.Hour})
+	service := domain.NewURLShortenerService(store, store, domain.ServiceConfig{Policy: domain.URLPolicy{AllowPrivateHosts: true}})
+	server := NewGorillaHttpServer()
+	server.Route(NewGorillaHTTPHandler(service, HandlerConfig{Health: checker}))
+
+	apiRequest(&server, http.MethodPost, "/api/v1/urls", `{"url":"`+destinations.URL+`/ok"}`, "key")
+	apiRequest(&server, http.MethodPost, "/api/v1/urls", `{"url":"`+destinations.URL+`/missing"}`, "key")
+
+	response := getPath(&server, "/api/v1/urls/h1/health")
+	if response.Code != http.StatusOK || response.Body.String() != `{"data":null}` {
+		t.Fatal("Urls that were not checked should not have health", response.Code, response.Body.String())
+	}
+	if checked, err := checker.CheckDue(time.Now()); err != nil || checked != 2 {
+		t.Fatal("Checker should check every url", checked, err)
+	}
+
+	response = getPath(&server, "/api/v1/urls/h1/health")
+	data := urlHealthJsonResponse{}
+	json.NewDecoder(response.Body).Decode(&data)
+	if response.Code != http.StatusOK || data.Data == nil || data.Data.StatusCode != http.StatusOK || data.Data.Broken {
+		t.Fatal("Health of the url should be returned", response.Code, data.Data)
+	}
+	if response := getPath(&server, "/api/v1/urls/h9/health"); response.Code != http.StatusNotFound {
+		t.Fatal("Health of unknown urls should not be found", response.Code)
+	}
+
+	if response := getPath(&server, "/api/v1/urls/broken"); response.Code != http.StatusUnauthorized {
+		t.Fatal("Broken urls should need an API key", response.Code)
+	}
+	response = apiRequest(&server, http.MethodGet, "/api/v1/urls/broken", "", "key")
+	broken := brokenURLsJsonResponse{}
+	json.NewDecoder(response.Body).Decode(&broken)
+	if response.Code != http.StatusOK || len(broken.Data) != 1 || broken.Data[0].URL.Hash != "h2" || broken.Data[0].Health.StatusCode != http.StatusNotFound {
+		t.Fatal("Broken urls of the key should be listed", response.Code, broken.Data)
+	}
+	response = apiRequest(&server, http.MethodGet, "/api/v1/urls/broken", "", "other")
+	if json.NewDecoder(response.Body).Decode(&broken); len(broken.Data) != 0 {
+		t.Fatal("Broken urls of other keys should not be listed", broken.Data)
+	}
+}
+
+func (s *memoryStore) HealthCheckCandidates(checkedBefore time.Time, limit int) ([]domain.URL, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	urls := []domain.URL{}
+	for hash, url := range s.urls {
+		if health, ok := s.health[hash]; url.Disabled == false && (ok == false || health.CheckedAt.Before(checkedBefore)) {
+			urls = append(urls, url)
+		}
+	}
+	sort.Slice(urls, func(i, j int) bool {
+		return urls[i].Hash < urls[j].Hash
+	})
+	if len(urls) > limit {
+		urls = urls[:limit]
+	}
+	return urls, nil
+}
+
+func (s *memoryStore) SaveHealth(urlDomain string, urlHash string, health domain.LinkHealth) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.health[urlHash] = health
+	return nil
+}
+
+func (s *memoryStore) Health(urlDomain string, urlHash string) (*domain.LinkHealth, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	health, ok := s.health[urlHash]
+	if ok == false {
+		return nil, nil
+	}
+	return &health, nil
+}
+
+func (s *memoryStore) BrokenURLs(owner string, limit int, offset int) ([]domain.URLHealth, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	broken := []domain.URLHealth{}
+	for hash, health := range s.health {
+		if health.Broken && s.owners[hash] == owner {
+			broken = append(broken, domain.URLHealth{URL: s.urls[hash], Health: health})
+		}
+	}
+	return broken, nil
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// htmlContentSecurityPolicy only allows the inline styles of our own templates
+const htmlContentSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'"
+
+const htmlLayout = `{{define "layout"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{template "title" .}}</title>
+<style>
+body { font-family: sans-serif; max-width: 40rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+.destination { word-break: break-all; font-size: 1.2rem; }
+.details { color: #666; }
+a.continue { display: inline-block; margin-top: 1rem; padding: .6rem 1.2rem; background: #1a5fb4; color: #fff; text-decoration: none; border-radius: 4px; }
+</style>
+</head>
+<body>
+{{template "content" .}}
+</body>
+</html>{{end}}`
+
+const previewTemplate = `{{define "title"}}Where does {{.ShortURL}} go?{{end}}
+{{define "content"}}<h1>{{.ShortURL}} goes to</h1>
+<p class="destination">{{.Destination}}</p>
+<p class="details">Created on {{.URL.CreatedAt.Format "January 2, 2006"}}, clicked {{.Views.Count}} {{if eq .Views.Count 1}}time{{else}}times{{end}}.</p>
+<a class="continue" href="{{.Destination}}" rel="noopener noreferrer nofollow">Continue to the site</a>{{end}}`
+
+const passwordTemplate = `{{define "title"}}Protected link{{end}}
+{{define "content"}}<h1>This link is protected</h1>
+<p>Enter its password to continue.</p>
+{{if .Message}}<p class="details">{{.Message}}</p>{{end}}
+<form method="post">
+<input type="password" name="password" autofocus required>
+<button type="submit">Continue</button>
+</form>{{end}}`
+
+const errorPageTemplate = `{{define "title"}}{{.Title}}{{end}}
+{{define "content"}}<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>{{end}}`
+
+var (
+	previewPage  = template.Must(template.Must(template.New("preview").Parse(htmlLayout)).Parse(previewTemplate))
+	passwordPage = template.Must(template.Must(template.New("password").Parse(htmlLayout)).Parse(passwordTemplate))
+	errorPage    = template.Must(template.Must(template.New("error").Parse(htmlLayout)).Parse(errorPageTemplate))
+)
+
+type previewPageData struct {
+	ShortURL string
+	URL      domain.URL
+	// Destination is where the visitor goes, see domain.URL.Destination
+	Destination string
+	Views       domain.URLViewStats
+}
+
+type passwordPageData struct {
+	Message string
+}
+
+type errorPageData struct {
+	Title   string
+	Message string
+}
+
+func renderPreviewPage(response http.ResponseWriter, data previewPageData) {
+	// views are counted when preview mode links are visited, so the page can't be cached
+	response.Header().Set("Cache-Control", "no-store")
+	renderHTML(response, http.StatusOK, previewPage, data)
+}
+
+// renderPasswordPage asks for the password of a protected url, the form posts to the same path
+func renderPasswordPage(response http.ResponseWriter, status int, message string) {
+	response.Header().Set("Cache-Control", "no-store")
+	renderHTML(response, status, passwordPage, passwordPageData{Message: message})
+}
+
+// chooseErrorPage is chooseErrorResponse for pages meant for browsers
+func chooseErrorPage(err error, response http.ResponseWriter) {
+	switch err {
+	case domain.ErrorURLNotFound, domain.ErrorInvalidURL:
+		renderHTML(response, http.StatusNotFound, errorPage, errorPageData{
+			Title:   "Link not found",
+			Message: "This short link doesn't exist.",
+		})
+	case domain.ErrorURLBlocked:
+		renderHTML(response, http.StatusForbidden, errorPage, errorPageData{
+			Title:   "Link disabled",
+			Message: "This short link has been disabled because it may be harmful.",
+		})
+	case domain.ErrorURLExpired:
+		renderHTML(response, http.StatusGone, errorPage, errorPageData{
+			Title:   "Link expired",
+			Message: "This short link has been followed as many times as it's allowed to.",
+		})
+	default:
+		renderHTML(response, http.StatusInternalServerError, errorPage, errorPageData{
+			Title:   "Something went wrong",
+			Message: "Please try again later.",
+		})
+	}
+}
+
+func renderHTML(response http.ResponseWriter, status int, page *template.Template, data interface{}) {
+	response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	response.Header().Set("Content-Security-Policy", htmlContentSecurityPolicy)
+	response.Header().Set("X-Frame-Options", "DENY")
+	response.WriteHeader(status)
+	page.ExecuteTemplate(response, "layout", data)
+}
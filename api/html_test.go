@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestPreviewShowsDestination(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	created := createTestURL(t, server, "https://www.example.com/?q=<script>alert(1)</script>")
+
+	response := getPath(server, "/"+created.Hash+"+")
+	body := response.Body.String()
+	if response.Code != http.StatusOK || strings.HasPrefix(response.Header().Get("Content-Type"), "text/html") == false {
+		t.Fatal("Failed to get preview page", response.Code, body)
+	}
+	if strings.Contains(body, "https://www.example.com/?q=") == false || strings.Contains(body, "clicked 0 times") == false {
+		t.Fatal("Preview should show the destination and clicks", body)
+	}
+	if strings.Contains(body, "<script>") {
+		t.Fatal("Destination should be escaped", body)
+	}
+	if redirect := getPath(server, "/"+created.Hash); redirect.Code != http.StatusMovedPermanently {
+		t.Fatal("Urls without preview mode should still redirect", redirect.Code)
+	}
+	if missing := getPath(server, "/h99+"); missing.Code != http.StatusNotFound || strings.Contains(missing.Body.String(), "<html") == false {
+		t.Fatal("Missing urls should get an html error page", missing.Code, missing.Body.String())
+	}
+}
+
+func TestPreviewModeURLs(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","preview":true}`)
+	if response.Code != http.StatusOK || strings.Contains(response.Body.String(), `"preview":true`) == false {
+		t.Fatal("Failed to create preview url", response.Code, response.Body.String())
+	}
+
+	page := getPath(server, "/h1")
+	if page.Code != http.StatusOK || len(page.Header().Get("Location")) != 0 || strings.Contains(page.Body.String(), "Continue to the site") == false {
+		t.Fatal("Preview urls should show the preview page instead of redirecting", page.Code, page.Body.String())
+	}
+	if page.Header().Get("Cache-Control") != "no-store" {
+		t.Fatal("Preview pages should not be cached", page.Header())
+	}
+}
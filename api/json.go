@@ -17,6 +17,39 @@ type urlStatsJsonResponse struct {
 	Data urlStats `json:"data"`
 }
 
+type urlListJsonResponse struct {
+	Data []domain.URL `json:"data"`
+}
+
+type campaignJsonResponse struct {
+	Data domain.Campaign `json:"data"`
+}
+
+type campaignListJsonResponse struct {
+	Data []domain.Campaign `json:"data"`
+}
+
+type campaignStatsJsonResponse struct {
+	Data domain.CampaignStats `json:"data"`
+}
+
+type urlHealthJsonResponse struct {
+	Data *domain.LinkHealth `json:"data"`
+}
+
+type brokenURLsJsonResponse struct {
+	Data []domain.URLHealth `json:"data"`
+}
+
+type webhookJsonResponse struct {
+	Data domain.Webhook `json:"data"`
+}
+
+type webhookListJsonResponse struct {
+	Data []domain.Webhook `json:"data"`
+}
+
 type errorJsonResponse struct {
 	Message string `json:"message"`
+	Rule    string `json:"rule,omitempty"`
 }
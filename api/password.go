@@ -0,0 +1,126 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// unlockCookieTTL is how long a visitor can follow a protected url after entering its password
+const unlockCookieTTL = 15 * time.Minute
+
+// Unlock checks the password of a protected url sent by the password form.
+// On success it sets a signed cookie that unlocks the url for a while and
+// redirects to the destination, or back to the preview page when the form was posted there.
+func (h *handler) Unlock(response http.ResponseWriter, request *http.Request) {
+	urlHash, ok := mux.Vars(request)["urlHash"]
+	if ok == false {
+		chooseErrorPage(domain.ErrorURLNotFound, response)
+		return
+	}
+	url, err := h.urlService.Find(h.config.Domains.ForHost(request.Host), urlHash, false)
+	if err != nil {
+		chooseErrorPage(err, response)
+		return
+	}
+	_, hasPath := mux.Vars(request)["path"]
+	preview := hasPath == false && strings.HasSuffix(request.URL.Path, "+")
+	visitor, err := h.visitor(response, request, url)
+	if err != nil {
+		chooseErrorPage(err, response)
+		return
+	}
+	if url.Protected() == false {
+		http.Redirect(response, request, request.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	if h.config.PasswordLimiter != nil {
+		key := "password:" + url.Domain + ":" + url.Hash + ":" + clientIP(request, h.config.TrustForwardedFor)
+		ok, wait, err := h.config.PasswordLimiter.Allow(key, h.config.PasswordAttempts)
+		if err == nil && ok == false {
+			response.Header().Set("Retry-After", retryAfterSeconds(wait))
+			renderPasswordPage(response, http.StatusTooManyRequests, "Too many attempts, try again later.")
+			return
+		}
+	}
+	if err := url.CheckPassword(request.PostFormValue("password")); err != nil {
+		renderPasswordPage(response, http.StatusUnauthorized, "Wrong password.")
+		return
+	}
+
+	http.SetCookie(response, &http.Cookie{
+		Name:     unlockCookieName(url),
+		Value:    h.signUnlock(url, time.Now().Add(unlockCookieTTL)),
+		Path:     "/",
+		MaxAge:   int(unlockCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.secureRequest(request),
+		SameSite: http.SameSiteLaxMode,
+	})
+	if preview {
+		http.Redirect(response, request, request.URL.Path, http.StatusSeeOther)
+		return
+	}
+	if err := h.urlService.Click(url, visitor); err != nil {
+		chooseErrorPage(err, response)
+		return
+	}
+	http.Redirect(response, request, url.Destination(visitor), http.StatusSeeOther)
+}
+
+// unlocked is true when the request has a valid cookie for the protected url
+func (h *handler) unlocked(request *http.Request, url domain.URL) bool {
+	cookie, err := request.Cookie(unlockCookieName(url))
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 2 {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(cookie.Value), []byte(h.signUnlock(url, time.Unix(expires, 0))))
+}
+
+// signUnlock returns the value of an unlock cookie, signing the password hash
+// too so changing the password invalidates the cookies
+func (h *handler) signUnlock(url domain.URL, expires time.Time) string {
+	expiresUnix := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, h.config.CookieSecret)
+	mac.Write([]byte(url.Domain + "\n" + url.Hash + "\n" + expiresUnix + "\n" + url.PasswordHash))
+	return expiresUnix + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func unlockCookieName(url domain.URL) string {
+	return "unlock_" + url.Hash
+}
+
+// secureRequest is true when the client connected with HTTPS, to us or, with TrustForwardedFor,
+// to the proxy that set X-Forwarded-Proto. Cookies of those clients are only sent over HTTPS.
+func (h *handler) secureRequest(request *http.Request) bool {
+	if request.TLS != nil {
+		return true
+	}
+	return h.config.TrustForwardedFor && strings.EqualFold(request.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// randomSecret is used to sign cookies when the server isn't given a secret
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
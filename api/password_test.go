@@ -0,0 +1,131 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+	"github.com/yanisky/url-shortener/pkg/memory"
+)
+
+func TestProtectedURLsAskForPassword(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{CookieSecret: []byte("secret")})
+	response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","password":"letmein"}`)
+	if response.Code != http.StatusOK || strings.Contains(response.Body.String(), "$2a$") {
+		t.Fatal("Failed to create protected url, or the hash leaked", response.Code, response.Body.String())
+	}
+
+	form := getPath(server, "/h1")
+	if form.Code != http.StatusOK || len(form.Header().Get("Location")) != 0 || strings.Contains(form.Body.String(), `type="password"`) == false {
+		t.Fatal("Protected urls should ask for the password", form.Code, form.Body.String())
+	}
+	if preview := getPath(server, "/h1+"); strings.Contains(preview.Body.String(), "www.example.com") {
+		t.Fatal("Previews of protected urls should ask for the password", preview.Body.String())
+	}
+	if stats := getPath(server, "/api/v1/urls/h1/views"); strings.Contains(stats.Body.String(), "www.example.com") {
+		t.Fatal("Stats should not show where protected urls go", stats.Body.String())
+	}
+
+	if wrong := postForm(server, "/h1", "nope", nil); wrong.Code != http.StatusUnauthorized || len(wrong.Result().Cookies()) != 0 {
+		t.Fatal("Wrong passwords should be rejected", wrong.Code)
+	}
+	right := postForm(server, "/h1", "letmein", nil)
+	if right.Code != http.StatusSeeOther || right.Header().Get("Location") != "https://www.example.com" {
+		t.Fatal("Right passwords should redirect to the destination", right.Code, right.Header())
+	}
+	cookies := right.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].HttpOnly == false {
+		t.Fatal("Right passwords should set an unlock cookie", cookies)
+	}
+
+	unlocked := getWithCookie(server, "/h1", cookies[0])
+	if unlocked.Code != http.StatusFound || unlocked.Header().Get("Location") != "https://www.example.com" {
+		t.Fatal("Unlocked urls should redirect", unlocked.Code)
+	}
+	tampered := *cookies[0]
+	tampered.Value = strings.Replace(tampered.Value, ".", "9.", 1)
+	if forged := getWithCookie(server, "/h1", &tampered); forged.Code != http.StatusOK || len(forged.Header().Get("Location")) != 0 {
+		t.Fatal("Tampered cookies should not unlock urls", forged.Code)
+	}
+	if preview := getWithCookie(server, "/h1+", cookies[0]); strings.Contains(preview.Body.String(), "www.example.com") == false {
+		t.Fatal("Unlocked urls should have previews", preview.Body.String())
+	}
+}
+
+func TestPasswordAttemptsAreRateLimited(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{
+		PasswordLimiter:  memory.NewRateLimiter(),
+		PasswordAttempts: domain.RateLimit{Rate: 1.0 / 60, Burst: 2},
+	})
+	postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","password":"letmein"}`)
+	for i := 0; i < 2; i++ {
+		if response := postForm(server, "/h1", "nope", nil); response.Code != http.StatusUnauthorized {
+			t.Fatal("Attempts within the limit should be checked", response.Code)
+		}
+	}
+	limited := postForm(server, "/h1", "letmein", nil)
+	if limited.Code != http.StatusTooManyRequests || len(limited.Header().Get("Retry-After")) == 0 {
+		t.Fatal("Attempts over the limit should be rejected", limited.Code)
+	}
+	other := postForm(server, "/h1", "letmein", func(r *http.Request) { r.RemoteAddr = "192.0.2.2:1234" })
+	if other.Code != http.StatusSeeOther {
+		t.Fatal("Other clients should not be limited", other.Code)
+	}
+}
+
+func TestUnlockCookiesAreSecureBehindHTTPSProxies(t *testing.T) {
+	forwarded := func(r *http.Request) { r.Header.Set("X-Forwarded-Proto", "https") }
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{TrustForwardedFor: true})
+	postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","password":"letmein"}`)
+	if cookies := postForm(server, "/h1", "letmein", forwarded).Result().Cookies(); len(cookies) != 1 || cookies[0].Secure == false {
+		t.Fatal("Cookies of HTTPS clients should be secure", cookies)
+	}
+	if cookies := postForm(server, "/h1", "letmein", nil).Result().Cookies(); len(cookies) != 1 || cookies[0].Secure {
+		t.Fatal("Cookies of HTTP clients can't be secure", cookies)
+	}
+
+	server = newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com","password":"letmein"}`)
+	if cookies := postForm(server, "/h1", "letmein", forwarded).Result().Cookies(); len(cookies) != 1 || cookies[0].Secure {
+		t.Fatal("X-Forwarded-Proto should only be trusted with TrustForwardedFor", cookies)
+	}
+}
+
+func TestUnlockCookiesExpire(t *testing.T) {
+	h := &handler{config: HandlerConfig{CookieSecret: []byte("secret")}}
+	protected := domain.URL{Hash: "h1", PasswordHash: "hash"}
+	request := httptest.NewRequest(http.MethodGet, "/h1", nil)
+	request.AddCookie(&http.Cookie{Name: unlockCookieName(protected), Value: h.signUnlock(protected, time.Now().Add(-time.Second))})
+	if h.unlocked(request, protected) {
+		t.Fatal("Expired cookies should not unlock urls")
+	}
+	protected.PasswordHash = "other"
+	request = httptest.NewRequest(http.MethodGet, "/h1", nil)
+	request.AddCookie(&http.Cookie{Name: unlockCookieName(protected), Value: h.signUnlock(domain.URL{Hash: "h1", PasswordHash: "hash"}, time.Now().Add(time.Minute))})
+	if h.unlocked(request, protected) {
+		t.Fatal("Changing the password should invalidate cookies")
+	}
+}
+
+func postForm(server *Server, path string, password string, modify func(*http.Request)) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(url.Values{"password": {password}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if modify != nil {
+		modify(request)
+	}
+	response := httptest.NewRecorder()
+	server.Router.ServeHTTP(response, request)
+	return response
+}
+
+func getWithCookie(server *Server, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	request.AddCookie(cookie)
+	response := httptest.NewRecorder()
+	server.Router.ServeHTTP(response, request)
+	return response
+}
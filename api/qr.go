@@ -0,0 +1,336 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"  // logo formats
+	_ "image/jpeg" // logo formats
+	"image/png"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/skip2/go-qrcode"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+const (
+	qrDefaultSize   = 256
+	qrMinSize       = 64
+	qrMaxSize       = 2048
+	qrDefaultMargin = 4
+	qrMaxMargin     = 16
+	// qrLogoRatio is the width of the logo relative to the code,
+	// small enough for the high error correction level to recover the covered modules
+	qrLogoRatio = 0.2
+	// qrCacheMaxAge is how long clients and CDNs can keep a code, it only changes with the short url itself
+	qrCacheMaxAge = 24 * 60 * 60
+)
+
+var qrRecoveryLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// parameterError is returned when a query parameter has an invalid value
+type parameterError struct {
+	name string
+}
+
+func (e *parameterError) Error() string {
+	return "Invalid parameter: " + e.name
+}
+
+// QRLogo is an image embedded in the center of QR codes that ask for it
+type QRLogo struct {
+	image image.Image
+	png   []byte
+}
+
+// LoadQRLogo loads a PNG, JPEG or GIF logo for QR codes
+func LoadQRLogo(path string) (*QRLogo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	buffer := &bytes.Buffer{}
+	if err := png.Encode(buffer, img); err != nil {
+		return nil, err
+	}
+	return &QRLogo{image: img, png: buffer.Bytes()}, nil
+}
+
+// qrOptions are the query parameters of a QR code request
+type qrOptions struct {
+	format string
+	size   int
+	margin int
+	ecc    string
+	logo   bool
+}
+
+func parseQROptions(request *http.Request, logo *QRLogo) (qrOptions, error) {
+	query := request.URL.Query()
+	opts := qrOptions{format: "png", size: qrDefaultSize, margin: qrDefaultMargin, ecc: "M"}
+	if format := query.Get("format"); len(format) != 0 {
+		if format != "png" && format != "svg" {
+			return opts, &parameterError{name: "format"}
+		}
+		opts.format = format
+	}
+	if size := query.Get("size"); len(size) != 0 {
+		value, err := strconv.Atoi(size)
+		if err != nil || value < qrMinSize || value > qrMaxSize {
+			return opts, &parameterError{name: "size"}
+		}
+		opts.size = value
+	}
+	if margin := query.Get("margin"); len(margin) != 0 {
+		value, err := strconv.Atoi(margin)
+		if err != nil || value < 0 || value > qrMaxMargin {
+			return opts, &parameterError{name: "margin"}
+		}
+		opts.margin = value
+	}
+	ecc := strings.ToUpper(query.Get("ecc"))
+	if len(ecc) != 0 {
+		if _, ok := qrRecoveryLevels[ecc]; ok == false {
+			return opts, &parameterError{name: "ecc"}
+		}
+		opts.ecc = ecc
+	}
+	if logoParam := query.Get("logo"); len(logoParam) != 0 {
+		value, err := strconv.ParseBool(logoParam)
+		if err != nil || (value && logo == nil) {
+			return opts, &parameterError{name: "logo"}
+		}
+		opts.logo = value
+	}
+	// the logo covers modules, only the highest level recovers them
+	if opts.logo {
+		if len(ecc) != 0 && ecc != "H" {
+			return opts, &parameterError{name: "ecc"}
+		}
+		opts.ecc = "H"
+	}
+	return opts, nil
+}
+
+// QRCode renders the short url as a QR code image
+func (h *handler) QRCode(response http.ResponseWriter, request *http.Request) {
+	urlHash, ok := mux.Vars(request)["urlHash"]
+	if ok == false {
+		chooseErrorResponse(domain.ErrorURLNotFound, response)
+		return
+	}
+	opts, err := parseQROptions(request, h.config.QRLogo)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	urlDomain, err := h.requestDomain(request)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	url, err := h.urlService.Find(urlDomain, urlHash, false)
+	switch err {
+	case nil:
+	case domain.ErrorInvalidURL:
+		chooseErrorResponse(domain.ErrorURLNotFound, response)
+		return
+	default:
+		chooseErrorResponse(err, response)
+		return
+	}
+
+	content, requested := h.shortURL(request, url)
+	etag := qrETag(content, opts)
+	// shared caches can't keep a code of the host a client asked for
+	cacheControl := "public, max-age="
+	if requested {
+		cacheControl = "private, max-age="
+	}
+	response.Header().Set("Cache-Control", cacheControl+strconv.Itoa(qrCacheMaxAge))
+	response.Header().Set("ETag", etag)
+	if request.Header.Get("If-None-Match") == etag {
+		response.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	code, err := qrcode.New(content, qrRecoveryLevels[opts.ecc])
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	code.DisableBorder = true
+	var logo *QRLogo
+	if opts.logo {
+		logo = h.config.QRLogo
+	}
+	layout, err := newQRLayout(code.Bitmap(), opts)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+
+	if opts.format == "svg" {
+		response.Header().Set("Content-Type", "image/svg+xml")
+		response.Write(layout.svg(logo))
+		return
+	}
+	data, err := layout.png(logo)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	response.Header().Set("Content-Type", "image/png")
+	response.Write(data)
+}
+
+// shortURL is the public url of a short url, on its domain or the default one. Without domains
+// configured it's on the requested host, requested tells the url depends on headers of the client then
+func (h *handler) shortURL(request *http.Request, url domain.URL) (shortURL string, requested bool) {
+	host := url.Domain
+	if len(host) == 0 {
+		host, _ = h.config.Domains.Validate("")
+	}
+	if len(host) == 0 {
+		host = request.Host
+		requested = true
+	}
+	scheme := "http"
+	if h.secureRequest(request) {
+		scheme = "https"
+	}
+	return scheme + "://" + host + "/" + url.Hash, requested
+}
+
+func qrETag(content string, opts qrOptions) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%d|%s|%t", content, opts.format, opts.size, opts.margin, opts.ecc, opts.logo)))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// qrLayout places the modules of a code, with its margin, in a square of size pixels.
+// Modules are whole pixels, the pixels left over are added to the margin.
+type qrLayout struct {
+	modules [][]bool
+	size    int
+	scale   int
+	offset  int
+}
+
+func newQRLayout(modules [][]bool, opts qrOptions) (qrLayout, error) {
+	scale := opts.size / (len(modules) + 2*opts.margin)
+	if scale == 0 {
+		return qrLayout{}, &parameterError{name: "size"}
+	}
+	offset := (opts.size - scale*len(modules)) / 2
+	return qrLayout{modules: modules, size: opts.size, scale: scale, offset: offset}, nil
+}
+
+// logoBox returns the square covered by a logo, in pixels
+func (l qrLayout) logoBox() image.Rectangle {
+	width := int(float64(len(l.modules)*l.scale) * qrLogoRatio)
+	min := (l.size - width) / 2
+	return image.Rect(min, min, min+width, min+width)
+}
+
+func (l qrLayout) png(logo *QRLogo) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, l.size, l.size))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for y, row := range l.modules {
+		for x, dark := range row {
+			if dark {
+				module := image.Rect(0, 0, l.scale, l.scale).Add(image.Pt(l.offset+x*l.scale, l.offset+y*l.scale))
+				draw.Draw(img, module, image.Black, image.Point{}, draw.Src)
+			}
+		}
+	}
+	if logo != nil {
+		box := l.logoBox()
+		// a white frame keeps the logo apart from the modules around it
+		draw.Draw(img, box.Inset(-l.scale), image.White, image.Point{}, draw.Src)
+		drawScaled(img, box, logo.image)
+	}
+	buffer := &bytes.Buffer{}
+	if err := png.Encode(buffer, img); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (l qrLayout) svg(logo *QRLogo) []byte {
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, l.size, l.size, l.size, l.size)
+	fmt.Fprintf(buffer, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, l.size, l.size)
+	for y, row := range l.modules {
+		for x := 0; x < len(row); x++ {
+			if row[x] == false {
+				continue
+			}
+			// one rectangle per run of dark modules
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(buffer, "M%d %dh%dv%dh-%dz", l.offset+x*l.scale, l.offset+y*l.scale, run*l.scale, l.scale, run*l.scale)
+			x += run - 1
+		}
+	}
+	buffer.WriteString(`"/>`)
+	if logo != nil {
+		box := l.logoBox()
+		frame := box.Inset(-l.scale)
+		fmt.Fprintf(buffer, `<rect x="%d" y="%d" width="%d" height="%d" fill="#fff"/>`, frame.Min.X, frame.Min.Y, frame.Dx(), frame.Dy())
+		fmt.Fprintf(buffer, `<image x="%d" y="%d" width="%d" height="%d" href="data:image/png;base64,%s"/>`,
+			box.Min.X, box.Min.Y, box.Dx(), box.Dy(), base64.StdEncoding.EncodeToString(logo.png))
+	}
+	buffer.WriteString(`</svg>`)
+	return buffer.Bytes()
+}
+
+// drawScaled draws src into box with nearest neighbour scaling, keeping the aspect ratio
+func drawScaled(dst draw.Image, box image.Rectangle, src image.Image) {
+	bounds := src.Bounds()
+	if bounds.Empty() {
+		return
+	}
+	width, height := box.Dx(), box.Dy()
+	if bounds.Dx() > bounds.Dy() {
+		height = width * bounds.Dy() / bounds.Dx()
+	} else {
+		width = height * bounds.Dx() / bounds.Dy()
+	}
+	origin := image.Pt(box.Min.X+(box.Dx()-width)/2, box.Min.Y+(box.Dy()-height)/2)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := src.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height)
+			// blend transparent logos over the white frame
+			r, g, b, a := c.RGBA()
+			if a == 0 {
+				continue
+			}
+			if a < 0xffff {
+				white := 0xffff - a
+				c = color.RGBA64{R: uint16(r + white), G: uint16(g + white), B: uint16(b + white), A: 0xffff}
+			}
+			dst.Set(origin.X+x, origin.Y+y, c)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/skip2/go-qrcode"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestQRCodeEncodesShortURL(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	created := createTestURL(t, server, "https://www.example.com")
+
+	response := getPath(server, "/api/v1/urls/"+created.Hash+"/qr?size=300&margin=2&ecc=q")
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "image/png" {
+		t.Fatal("Failed to get QR code", response.Code, response.Body.String())
+	}
+	img, err := png.Decode(response.Body)
+	if err != nil {
+		t.Fatal("QR code is not a valid png", err)
+	}
+	if img.Bounds().Dx() != 300 || img.Bounds().Dy() != 300 {
+		t.Fatal("QR code doesn't have the requested size", img.Bounds())
+	}
+
+	expected, _ := qrcode.New("http://example.com/"+created.Hash, qrcode.High)
+	expected.DisableBorder = true
+	layout, err := newQRLayout(expected.Bitmap(), qrOptions{size: 300, margin: 2})
+	if err != nil {
+		t.Fatal("Failed to lay out expected code", err)
+	}
+	if layout.offset < 2*layout.scale {
+		t.Fatal("QR code should keep the requested margin", layout)
+	}
+	for y, row := range layout.modules {
+		for x, dark := range row {
+			center := layout.offset + layout.scale/2
+			r, _, _, _ := img.At(center+x*layout.scale, center+y*layout.scale).RGBA()
+			if (r == 0) != dark {
+				t.Fatal("QR code module doesn't match the short url", x, y)
+			}
+		}
+	}
+}
+
+func TestQRCodeFormatsAndCaching(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	created := createTestURL(t, server, "https://www.example.com")
+	path := "/api/v1/urls/" + created.Hash + "/qr?format=svg"
+
+	response := getPath(server, path)
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "image/svg+xml" ||
+		strings.HasPrefix(response.Body.String(), "<svg") == false {
+		t.Fatal("Failed to get svg QR code", response.Code, response.Body.String())
+	}
+	etag := response.Header().Get("ETag")
+	if len(etag) == 0 || strings.Contains(response.Header().Get("Cache-Control"), "max-age") == false {
+		t.Fatal("QR codes should have caching headers", response.Header())
+	}
+
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	request.Header.Set("If-None-Match", etag)
+	cached := httptest.NewRecorder()
+	server.Router.ServeHTTP(cached, request)
+	if cached.Code != http.StatusNotModified || cached.Body.Len() != 0 {
+		t.Fatal("Unchanged QR codes should not be sent again", cached.Code)
+	}
+	if other := getPath(server, path+"&size=512"); other.Header().Get("ETag") == etag {
+		t.Fatal("Different options should have a different ETag")
+	}
+}
+
+func TestQRCodeIgnoresHeadersOfClients(t *testing.T) {
+	h := &handler{config: HandlerConfig{Domains: domain.Domains{Default: "sho.rt"}}}
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/urls/abc/qr", nil)
+	request.Host = "evil.example.com"
+	request.Header.Set("X-Forwarded-Proto", "https")
+	if short, requested := h.shortURL(request, domain.URL{Hash: "abc"}); short != "http://sho.rt/abc" || requested {
+		t.Fatal("Short urls should be on the default domain", short, requested)
+	}
+	if short, _ := h.shortURL(request, domain.URL{Domain: "brand.co", Hash: "abc"}); short != "http://brand.co/abc" {
+		t.Fatal("Short urls should be on their domain", short)
+	}
+	h.config.TrustForwardedFor = true
+	if short, _ := h.shortURL(request, domain.URL{Hash: "abc"}); short != "https://sho.rt/abc" {
+		t.Fatal("Short urls should be https behind trusted HTTPS proxies", short)
+	}
+
+	// without domains only the client knows the host
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	created := createTestURL(t, server, "https://www.example.com")
+	if response := getPath(server, "/api/v1/urls/"+created.Hash+"/qr"); strings.HasPrefix(response.Header().Get("Cache-Control"), "private") == false {
+		t.Fatal("QR codes of the requested host should not be kept by shared caches", response.Header())
+	}
+}
+
+func TestQRCodeRejectsInvalidRequests(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	created := createTestURL(t, server, "https://www.example.com")
+	for query, expected := range map[string]int{
+		"format=gif":   http.StatusBadRequest,
+		"size=10":      http.StatusBadRequest,
+		"size=big":     http.StatusBadRequest,
+		"margin=-1":    http.StatusBadRequest,
+		"ecc=X":        http.StatusBadRequest,
+		"logo=true":    http.StatusBadRequest,
+		"domain=other": http.StatusBadRequest,
+	} {
+		if response := getPath(server, "/api/v1/urls/"+created.Hash+"/qr?"+query); response.Code != expected {
+			t.Fatal("Wrong status for", query, response.Code, expected)
+		}
+	}
+	if response := getPath(server, "/api/v1/urls/h99/qr"); response.Code != http.StatusNotFound {
+		t.Fatal("Missing urls should not have QR codes", response.Code)
+	}
+}
+
+func TestQRCodeEmbedsLogo(t *testing.T) {
+	red := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for i := range red.Pix {
+		red.Pix[i] = []uint8{255, 0, 0, 255}[i%4]
+	}
+	buffer := &bytes.Buffer{}
+	png.Encode(buffer, red)
+	logo := &QRLogo{image: red, png: buffer.Bytes()}
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{QRLogo: logo})
+	created := createTestURL(t, server, "https://www.example.com")
+
+	response := getPath(server, "/api/v1/urls/"+created.Hash+"/qr?logo=true")
+	img, err := png.Decode(response.Body)
+	if err != nil {
+		t.Fatal("QR code is not a valid png", response.Code, err)
+	}
+	center := qrDefaultSize / 2
+	if r, g, b, _ := img.At(center, center).RGBA(); r != 0xffff || g != 0 || b != 0 {
+		t.Fatal("Logo should be drawn in the center", img.At(center, center))
+	}
+	for _, query := range []string{"ecc=l&logo=true", "logo=true&ecc=Q"} {
+		if response := getPath(server, "/api/v1/urls/"+created.Hash+"/qr?"+query); response.Code != http.StatusBadRequest {
+			t.Fatal("Logos should require the highest error correction level", query, response.Code)
+		}
+	}
+	if response := getPath(server, "/api/v1/urls/"+created.Hash+"/qr?logo=true&ecc=h"); response.Code != http.StatusOK {
+		t.Fatal("Logos should be drawn with the highest error correction level", response.Code)
+	}
+	response = getPath(server, "/api/v1/urls/"+created.Hash+"/qr?logo=true&format=svg")
+	if strings.Contains(response.Body.String(), "data:image/png;base64,") == false {
+		t.Fatal("Svg QR codes should embed the logo", response.Body.String())
+	}
+}
+
+func getPath(server *Server, path string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	server.Router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+	return response
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// RateLimitConfig holds the limits of each client, zero limits don't limit anything
+type RateLimitConfig struct {
+	// APIPerKey limits /api/v1 requests with an X-API-Key header, per key
+	APIPerKey domain.RateLimit
+	// APIPerIP limits /api/v1 requests without an API key, per IP
+	APIPerIP domain.RateLimit
+	// Redirect limits redirects per IP
+	Redirect domain.RateLimit
+	// TrustForwardedFor takes the client IP from the X-Forwarded-For header
+	// set by the proxy in front of the server instead of the connection address
+	TrustForwardedFor bool
+}
+
+// rateLimitError is returned when a client runs out of tokens
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return "Too Many Requests"
+}
+
+// RateLimitMiddleware limits requests with token buckets kept by limiter.
+// API requests are limited per API key, or per IP when they don't have one, and redirects per IP.
+// When the limiter fails requests are let through.
+func RateLimitMiddleware(limiter domain.RateLimiter, config RateLimitConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			key, limit := rateLimitBucket(request, config)
+			ok, wait, err := limiter.Allow(key, limit)
+			if err == nil && ok == false {
+				chooseErrorResponse(&rateLimitError{retryAfter: wait}, response)
+				return
+			}
+			next.ServeHTTP(response, request)
+		})
+	}
+}
+
+// rateLimitBucket chooses the bucket and limit of a request
+func rateLimitBucket(request *http.Request, config RateLimitConfig) (string, domain.RateLimit) {
+	if strings.HasPrefix(request.URL.Path, "/api/") {
+		if owner := requestOwner(request); len(owner) != 0 {
+			return "api:key:" + owner, config.APIPerKey
+		}
+		return "api:ip:" + clientIP(request, config.TrustForwardedFor), config.APIPerIP
+	}
+	return "redirect:ip:" + clientIP(request, config.TrustForwardedFor), config.Redirect
+}
+
+// clientIP returns the IP of the client, when trustForwardedFor is set
+// it's the last address of X-Forwarded-For, the one added by our proxy
+func clientIP(request *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		forwarded := strings.Split(request.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); len(ip) != 0 {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// retryAfterSeconds rounds up so clients don't come back too early
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
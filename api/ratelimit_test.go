@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+	"github.com/yanisky/url-shortener/pkg/memory"
+)
+
+func TestRateLimitMiddlewareLimitsAPIPerIP(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	server.Router.Use(RateLimitMiddleware(memory.NewRateLimiter(), RateLimitConfig{
+		APIPerIP: domain.RateLimit{Rate: 2.0 / 60, Burst: 2},
+	}))
+	for i := 0; i < 2; i++ {
+		createTestURL(t, server, "https://www.example.com")
+	}
+	response := postJSON(server, "/api/v1/urls", `{"url":"https://www.example.com"}`)
+	if response.Code != http.StatusTooManyRequests {
+		t.Fatal("Third request should have been limited", response.Code)
+	}
+	if retryAfter := response.Header().Get("Retry-After"); retryAfter != "30" {
+		t.Fatal("Retry-After should tell when the next token is available", retryAfter)
+	}
+}
+
+func TestRateLimitMiddlewareSeparatesBuckets(t *testing.T) {
+	server := newTestServer(domain.ServiceConfig{}, HandlerConfig{})
+	server.Router.Use(RateLimitMiddleware(memory.NewRateLimiter(), RateLimitConfig{
+		APIPerKey: domain.RateLimit{Rate: 1.0 / 60, Burst: 1},
+		APIPerIP:  domain.RateLimit{Rate: 1.0 / 60, Burst: 1},
+		Redirect:  domain.RateLimit{Rate: 1.0 / 60, Burst: 1},
+	}))
+	url := createTestURL(t, server, "https://www.example.com")
+
+	// API keys have their own bucket
+	for _, key := range []string{"key-1", "key-2"} {
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/urls", nil)
+		request.Header.Set("X-API-Key", key)
+		response := httptest.NewRecorder()
+		server.Router.ServeHTTP(response, request)
+		if response.Code == http.StatusTooManyRequests {
+			t.Fatal("Each API key should have its own bucket", key)
+		}
+	}
+	// redirects don't share the API bucket
+	redirect := func(remoteAddr string) int {
+		request := httptest.NewRequest(http.MethodGet, "/"+url.Hash, nil)
+		request.RemoteAddr = remoteAddr
+		response := httptest.NewRecorder()
+		server.Router.ServeHTTP(response, request)
+		return response.Code
+	}
+	if code := redirect("192.0.2.1:1234"); code != http.StatusMovedPermanently {
+		t.Fatal("First redirect should not be limited", code)
+	}
+	if code := redirect("192.0.2.1:5678"); code != http.StatusTooManyRequests {
+		t.Fatal("Second redirect from the same IP should be limited", code)
+	}
+	if code := redirect("192.0.2.2:1234"); code != http.StatusMovedPermanently {
+		t.Fatal("Other IPs should not be limited", code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.3")
+	if ip := clientIP(request, false); ip != "10.0.0.1" {
+		t.Fatal("Forwarded header should be ignored unless trusted", ip)
+	}
+	if ip := clientIP(request, true); ip != "198.51.100.3" {
+		t.Fatal("Client IP should be the address added by our proxy", ip)
+	}
+}
@@ -2,7 +2,26 @@ package api
 
 // Route Attaches handlers to routes
 func (s *Server) Route(handler URLShortnerHttpHandler) {
+	// before /{urlHash}, that would match the + too
+	s.Router.HandleFunc("/{urlHash}+", handler.Preview).Methods("GET")
 	s.Router.HandleFunc("/{urlHash}", handler.Redirect).Methods("GET")
+	s.Router.HandleFunc("/{urlHash}+", handler.Unlock).Methods("POST")
+	s.Router.HandleFunc("/{urlHash}", handler.Unlock).Methods("POST")
 	s.Router.HandleFunc("/api/v1/urls", handler.CreateURL).Methods("POST")
+	s.Router.HandleFunc("/api/v1/urls", handler.ListURLs).Methods("GET")
+	s.Router.HandleFunc("/api/v1/urls/broken", handler.BrokenURLs).Methods("GET")
+	s.Router.HandleFunc("/api/v1/urls/{urlHash}", handler.DeleteURL).Methods("DELETE")
 	s.Router.HandleFunc("/api/v1/urls/{urlHash}/views", handler.ViewUrlStats).Methods("GET")
+	s.Router.HandleFunc("/api/v1/urls/{urlHash}/disable", handler.DisableURL).Methods("POST")
+	s.Router.HandleFunc("/api/v1/urls/{urlHash}/qr", handler.QRCode).Methods("GET")
+	s.Router.HandleFunc("/api/v1/urls/{urlHash}/health", handler.URLHealth).Methods("GET")
+	s.Router.HandleFunc("/api/v1/campaigns", handler.CreateCampaign).Methods("POST")
+	s.Router.HandleFunc("/api/v1/campaigns", handler.ListCampaigns).Methods("GET")
+	s.Router.HandleFunc("/api/v1/campaigns/{campaignID}/views", handler.ViewCampaignStats).Methods("GET")
+	s.Router.HandleFunc("/api/v1/webhooks", handler.CreateWebhook).Methods("POST")
+	s.Router.HandleFunc("/api/v1/webhooks", handler.ListWebhooks).Methods("GET")
+	s.Router.HandleFunc("/api/v1/webhooks/{webhookID}", handler.DeleteWebhook).Methods("DELETE")
+	// last, it matches every path. Only prefix urls accept a path after their code
+	s.Router.HandleFunc("/{urlHash}/{path:.*}", handler.Redirect).Methods("GET")
+	s.Router.HandleFunc("/{urlHash}/{path:.*}", handler.Unlock).Methods("POST")
 }
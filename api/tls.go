@@ -0,0 +1,182 @@
+package api
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certCheckInterval is how often certificate files are checked for changes
+const certCheckInterval = 10 * time.Second
+
+// TLSConfig configures HTTPS for Server.RunTLS.
+// Certificates either come from CertFile and KeyFile or, when ACMEHosts is set, from an ACME CA.
+type TLSConfig struct {
+	// CertFile and KeyFile are PEM files, they are reloaded when they change on disk
+	CertFile string
+	KeyFile  string
+	// ACMEHosts are the hosts to get certificates for, other hosts are refused
+	ACMEHosts []string
+	// ACMEEmail is the contact address of the ACME account
+	ACMEEmail string
+	// ACMEDirectoryURL is the ACME CA, Let's Encrypt when empty
+	ACMEDirectoryURL string
+	// ACMECache stores certificates and the account key, for example autocert.DirCache
+	ACMECache autocert.Cache
+	// RedirectAddr when set listens for http requests and redirects them to https,
+	// in ACME mode it also answers http-01 challenges
+	RedirectAddr string
+	// HSTSMaxAge adds a Strict-Transport-Security header to responses when it's not 0
+	HSTSMaxAge time.Duration
+}
+
+// RunTLS serves the router with HTTPS, see TLSConfig
+func (s *Server) RunTLS(addr string, config TLSConfig) error {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	var challengeHandler func(http.Handler) http.Handler
+	if len(config.ACMEHosts) != 0 {
+		manager, err := config.acmeManager()
+		if err != nil {
+			return err
+		}
+		tlsConfig.GetCertificate = manager.GetCertificate
+		tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+		challengeHandler = manager.HTTPHandler
+	} else {
+		reloader, err := newCertReloader(config.CertFile, config.KeyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.GetCertificate = reloader.GetCertificate
+	}
+
+	var handler http.Handler = s.Router
+	if config.HSTSMaxAge > 0 {
+		handler = hstsHandler(handler, config.HSTSMaxAge)
+	}
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+
+	errChan := make(chan error, 2)
+	if len(config.RedirectAddr) != 0 {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		var redirect http.Handler = httpsRedirectHandler(port)
+		if challengeHandler != nil {
+			redirect = challengeHandler(redirect)
+		}
+		go func() {
+			errChan <- http.ListenAndServe(config.RedirectAddr, redirect)
+		}()
+	}
+	go func() {
+		errChan <- server.ListenAndServeTLS("", "")
+	}()
+	return <-errChan
+}
+
+func (c TLSConfig) acmeManager() (*autocert.Manager, error) {
+	if c.ACMECache == nil {
+		return nil, errors.New("ACME needs a certificate cache")
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      c.ACMECache,
+		HostPolicy: autocert.HostWhitelist(c.ACMEHosts...),
+		Email:      c.ACMEEmail,
+	}
+	if len(c.ACMEDirectoryURL) != 0 {
+		manager.Client = &acme.Client{DirectoryURL: c.ACMEDirectoryURL}
+	}
+	return manager, nil
+}
+
+// httpsRedirectHandler redirects to the same url with https, on port unless it's 443
+func httpsRedirectHandler(port string) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		host, _, err := net.SplitHostPort(request.Host)
+		if err != nil {
+			host = request.Host
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := "https://" + host + request.URL.RequestURI()
+		http.Redirect(response, request, target, http.StatusMovedPermanently)
+	})
+}
+
+func hstsHandler(next http.Handler, maxAge time.Duration) http.Handler {
+	value := "max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(response, request)
+	})
+}
+
+// certReloader keeps a certificate loaded from files and loads it again when the files change.
+// If the new files can't be loaded the previous certificate is kept.
+type certReloader struct {
+	m         sync.Mutex
+	certFile  string
+	keyFile   string
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+	now       func() time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return nil, errors.New("TLS needs a certificate and a key file")
+	}
+	r := &certReloader{certFile: certFile, keyFile: keyFile, now: time.Now}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is meant for tls.Config
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if now := r.now(); now.Sub(r.lastCheck) >= certCheckInterval {
+		r.lastCheck = now
+		if r.latestModTime().After(r.modTime) {
+			r.reload()
+		}
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime := r.latestModTime()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	r.lastCheck = r.now()
+	return nil
+}
+
+func (r *certReloader) latestModTime() time.Time {
+	latest := time.Time{}
+	for _, file := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
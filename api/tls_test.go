@@ -0,0 +1,322 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestCertReloaderReloadsChangedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal("Failed to create temp dir", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "first.example")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal("Failed to load certificate", err)
+	}
+	now := time.Now()
+	reloader.now = func() time.Time { return now }
+	assertCertName(t, reloader, "first.example")
+
+	writeTestCert(t, certFile, keyFile, "second.example")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+	// files are not checked on every handshake
+	assertCertName(t, reloader, "first.example")
+	now = now.Add(certCheckInterval)
+	assertCertName(t, reloader, "second.example")
+
+	// broken files keep the current certificate
+	ioutil.WriteFile(certFile, []byte("broken"), 0600)
+	evenLater := later.Add(time.Minute)
+	os.Chtimes(certFile, evenLater, evenLater)
+	now = now.Add(certCheckInterval)
+	assertCertName(t, reloader, "second.example")
+}
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	cases := map[string]string{
+		"443":  "https://sho.rt/abc?x=1",
+		"8443": "https://sho.rt:8443/abc?x=1",
+	}
+	for port, expected := range cases {
+		response := httptest.NewRecorder()
+		httpsRedirectHandler(port).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "http://sho.rt:8080/abc?x=1", nil))
+		if response.Code != http.StatusMovedPermanently || response.Header().Get("Location") != expected {
+			t.Fatal("Http requests should be redirected to https", response.Code, response.Header().Get("Location"), expected)
+		}
+	}
+}
+
+func TestHSTSHandler(t *testing.T) {
+	response := httptest.NewRecorder()
+	hstsHandler(http.NotFoundHandler(), 365*24*time.Hour).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	if hsts := response.Header().Get("Strict-Transport-Security"); hsts != "max-age=31536000" {
+		t.Fatal("HSTS header not set correctly", hsts)
+	}
+}
+
+func TestACMEIssuesCertificates(t *testing.T) {
+	ca := newACMEStandIn(t)
+	defer ca.server.Close()
+	cache := &memoryCertCache{data: map[string][]byte{}}
+	config := TLSConfig{
+		ACMEHosts:        []string{"sho.rt"},
+		ACMEDirectoryURL: ca.server.URL + "/directory",
+		ACMECache:        cache,
+	}
+	manager, err := config.acmeManager()
+	if err != nil {
+		t.Fatal("Failed to create ACME manager", err)
+	}
+	hello := &tls.ClientHelloInfo{
+		ServerName:       "sho.rt",
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SupportedCurves:  []tls.CurveID{tls.CurveP256},
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+	}
+	cert, err := manager.GetCertificate(hello)
+	if err != nil {
+		t.Fatal("Failed to get certificate from ACME", err)
+	}
+	if cert.Leaf == nil || cert.Leaf.VerifyHostname("sho.rt") != nil {
+		t.Fatal("Certificate was not issued for the host", cert.Leaf)
+	}
+	if _, err := cache.Get(context.Background(), "sho.rt"); err != nil {
+		t.Fatal("Certificate should have been cached", err)
+	}
+	if _, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example"}); err == nil {
+		t.Fatal("Hosts not in ACMEHosts should be refused")
+	}
+	if _, err := (TLSConfig{ACMEHosts: []string{"sho.rt"}}).acmeManager(); err == nil {
+		t.Fatal("ACME without cache should fail")
+	}
+}
+
+func assertCertName(t *testing.T, reloader *certReloader, name string) {
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatal("Failed to get certificate", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil || leaf.Subject.CommonName != name {
+		t.Fatal("Wrong certificate", leaf.Subject.CommonName, name, err)
+	}
+}
+
+func writeTestCert(t *testing.T, certFile string, keyFile string, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate key", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Failed to create certificate", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("Failed to marshal key", err)
+	}
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+}
+
+type memoryCertCache struct {
+	m    sync.Mutex
+	data map[string][]byte
+}
+
+func (c *memoryCertCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	data, ok := c.data[key]
+	if ok == false {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, nil
+}
+
+func (c *memoryCertCache) Put(ctx context.Context, key string, data []byte) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.data[key] = data
+	return nil
+}
+
+func (c *memoryCertCache) Delete(ctx context.Context, key string) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	delete(c.data, key)
+	return nil
+}
+
+// acmeStandIn is a minimal RFC 8555 CA: orders are ready right away so no challenges
+// are needed and request signatures are not verified
+type acmeStandIn struct {
+	server *httptest.Server
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+	m      sync.Mutex
+	nonce  int
+	certs  map[string][]byte
+}
+
+func newACMEStandIn(t *testing.T) *acmeStandIn {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate CA key", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Stand-in CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("Failed to create CA certificate", err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+	ca := &acmeStandIn{caKey: caKey, caCert: caCert, certs: map[string][]byte{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", func(w http.ResponseWriter, r *http.Request) {
+		base := ca.server.URL
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   base + "/nonce",
+			"newAccount": base + "/account",
+			"newOrder":   base + "/order",
+			"revokeCert": base + "/revoke",
+			"keyChange":  base + "/key-change",
+		})
+	})
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {
+		ca.setNonce(w)
+	})
+	mux.HandleFunc("/account", func(w http.ResponseWriter, r *http.Request) {
+		ca.setNonce(w)
+		w.Header().Set("Location", ca.server.URL+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"status": "valid"})
+	})
+	mux.HandleFunc("/order", func(w http.ResponseWriter, r *http.Request) {
+		ca.setNonce(w)
+		w.Header().Set("Location", ca.server.URL+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":         "ready",
+			"authorizations": []string{},
+			"finalize":       ca.server.URL + "/finalize/1",
+		})
+	})
+	mux.HandleFunc("/finalize/1", func(w http.ResponseWriter, r *http.Request) {
+		ca.setNonce(w)
+		payload := struct {
+			CSR string `json:"csr"`
+		}{}
+		if err := readJWSPayload(r, &payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		csrDer, _ := base64.RawURLEncoding.DecodeString(payload.CSR)
+		csr, err := x509.ParseCertificateRequest(csrDer)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ca.issue(csr)
+		w.Header().Set("Location", ca.server.URL+"/order/1")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":      "valid",
+			"certificate": ca.server.URL + "/cert/1",
+		})
+	})
+	mux.HandleFunc("/cert/1", func(w http.ResponseWriter, r *http.Request) {
+		ca.setNonce(w)
+		ca.m.Lock()
+		defer ca.m.Unlock()
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.certs["1"]}))
+		w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw}))
+	})
+	ca.server = httptest.NewServer(mux)
+	return ca
+}
+
+func (ca *acmeStandIn) setNonce(w http.ResponseWriter) {
+	ca.m.Lock()
+	defer ca.m.Unlock()
+	ca.nonce++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", ca.nonce))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (ca *acmeStandIn) issue(csr *x509.CertificateRequest) {
+	names := csr.DNSNames
+	if len(names) == 0 {
+		names = []string{csr.Subject.CommonName}
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, ca.caCert, csr.PublicKey, ca.caKey)
+	ca.m.Lock()
+	defer ca.m.Unlock()
+	ca.certs["1"] = der
+}
+
+// readJWSPayload decodes the payload of a flattened JWS request without verifying it
+func readJWSPayload(r *http.Request, payload interface{}) error {
+	jws := struct {
+		Payload string `json:"payload"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, payload)
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// routingVary are the headers routing rules look at, besides the country header
+const routingVary = "User-Agent, Accept-Language"
+
+// visitorFromRequest finds out what routing rules need to know about a visitor from its headers,
+// and the query it may forward.
+// The country comes from countryHeader, set by a CDN or a proxy with a GeoIP database,
+// visitors don't have a country when it's empty.
+func visitorFromRequest(request *http.Request, countryHeader string) domain.Visitor {
+	userAgent := request.Header.Get("User-Agent")
+	visitor := domain.Visitor{
+		Device:    deviceFromUserAgent(userAgent),
+		OS:        osFromUserAgent(userAgent),
+		Languages: acceptedLanguages(request.Header.Get("Accept-Language")),
+		Query:     request.URL.Query(),
+	}
+	if len(countryHeader) != 0 {
+		visitor.Country = strings.ToUpper(strings.TrimSpace(request.Header.Get(countryHeader)))
+	}
+	return visitor
+}
+
+// variantCookieMaxAge is how long visitors of split urls keep going to the same variant
+const variantCookieMaxAge = 30 * 24 * 60 * 60
+
+// visitor returns the visitor of a request to the url, telling caches what its destination depends on
+// when the url has rules. Its ID is a hash of the client that changes every day, for unique visitor counts.
+// Visitors of split urls keep their variant in a cookie, new visitors get one by weight.
+// Paths after the code are only allowed for prefix urls, ErrorURLNotFound is returned otherwise.
+func (h *handler) visitor(response http.ResponseWriter, request *http.Request, url domain.URL) (domain.Visitor, error) {
+	if len(url.Rules) != 0 {
+		vary := routingVary
+		if len(h.config.CountryHeader) != 0 {
+			vary += ", " + h.config.CountryHeader
+		}
+		response.Header().Add("Vary", vary)
+	}
+	visitor := visitorFromRequest(request, h.config.CountryHeader)
+	visitor.ID = domain.VisitorHash(h.config.CookieSecret, time.Now(), url.Hash, clientIP(request, h.config.TrustForwardedFor), request.UserAgent())
+	if suffix := pathSuffix(request); len(suffix) != 0 {
+		if url.Prefix == false {
+			return visitor, domain.ErrorURLNotFound
+		}
+		path, err := domain.CleanPathSuffix(suffix)
+		if err != nil {
+			return visitor, err
+		}
+		visitor.Path = path
+	}
+	if len(url.Variants) == 0 {
+		return visitor, nil
+	}
+	if cookie, err := request.Cookie(variantCookieName(url)); err == nil {
+		if variant, err := strconv.Atoi(cookie.Value); err == nil && variant >= 0 && variant < len(url.Variants) {
+			visitor.Variant = variant
+			return visitor, nil
+		}
+	}
+	visitor.Variant = url.PickVariant(h.rollVariant)
+	http.SetCookie(response, &http.Cookie{
+		Name:     variantCookieName(url),
+		Value:    strconv.Itoa(visitor.Variant),
+		Path:     "/",
+		MaxAge:   variantCookieMaxAge,
+		HttpOnly: true,
+		Secure:   h.secureRequest(request),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return visitor, nil
+}
+
+// pathSuffix returns the escaped path after the code for the /{urlHash}/{path} routes
+func pathSuffix(request *http.Request) string {
+	if _, ok := mux.Vars(request)["path"]; ok == false {
+		return ""
+	}
+	segments := strings.SplitN(strings.TrimPrefix(request.URL.EscapedPath(), "/"), "/", 2)
+	if len(segments) != 2 {
+		return ""
+	}
+	return segments[1]
+}
+
+func variantCookieName(url domain.URL) string {
+	return "variant_" + url.Hash
+}
+
+// newVariantRoller returns a rand.Intn that can be used concurrently
+func newVariantRoller() func(n int) int {
+	var m sync.Mutex
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return func(n int) int {
+		m.Lock()
+		defer m.Unlock()
+		return random.Intn(n)
+	}
+}
+
+// variantViews returns the views of every variant of a split url, nil for other urls
+func variantViews(url domain.URL, views []int) []int {
+	if len(url.Variants) == 0 {
+		return nil
+	}
+	counts := make([]int, len(url.Variants))
+	copy(counts, views)
+	return counts
+}
+
+func osFromUserAgent(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "iPod"):
+		return "ios"
+	case strings.Contains(userAgent, "Android"):
+		return "android"
+	case strings.Contains(userAgent, "Windows"):
+		return "windows"
+	case strings.Contains(userAgent, "Macintosh"), strings.Contains(userAgent, "Mac OS X"):
+		return "macos"
+	case strings.Contains(userAgent, "Linux"), strings.Contains(userAgent, "X11"):
+		return "linux"
+	}
+	return ""
+}
+
+func deviceFromUserAgent(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "Tablet"):
+		return "tablet"
+	case strings.Contains(userAgent, "Mobi"), strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPod"):
+		return "mobile"
+	// Android phones say "Mobile", Android tablets don't
+	case strings.Contains(userAgent, "Android"):
+		return "tablet"
+	}
+	return "desktop"
+}
+
+// acceptedLanguages returns the languages of an Accept-Language header by preference, without "*"
+func acceptedLanguages(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var languages []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if len(tag) == 0 || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+		if q > 0 {
+			languages = append(languages, weighted{tag: tag, q: q})
+		}
+	}
+	if len(languages) == 0 {
+		return nil
+	}
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].q > languages[j].q
+	})
+	tags := make([]string, len(languages))
+	for i, language := range languages {
+		tags[i] = language.tag
+	}
+	return tags
+}
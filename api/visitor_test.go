@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestVisitorFromRequest(t *testing.T) {
+	for userAgent, expected := range map[string]domain.Visitor{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 14_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148":         {Device: "mobile", OS: "ios"},
+		"Mozilla/5.0 (iPad; CPU OS 14_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148":                  {Device: "tablet", OS: "ios"},
+		"Mozilla/5.0 (Linux; Android 10; Pixel 3) AppleWebKit/537.36 Chrome/86.0 Mobile Safari/537.36":      {Device: "mobile", OS: "android"},
+		"Mozilla/5.0 (Linux; Android 10; SM-T510) AppleWebKit/537.36 Chrome/86.0 Safari/537.36":             {Device: "tablet", OS: "android"},
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/86.0 Safari/537.36":            {Device: "desktop", OS: "windows"},
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 Version/14.0 Safari/605.1.15": {Device: "desktop", OS: "macos"},
+		"Mozilla/5.0 (X11; Linux x86_64; rv:82.0) Gecko/20100101 Firefox/82.0":                              {Device: "desktop", OS: "linux"},
+		"curl/7.68.0": {Device: "desktop"},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/h1?ref=qr", nil)
+		request.Header.Set("User-Agent", userAgent)
+		expected.Query = url.Values{"ref": {"qr"}}
+		if visitor := visitorFromRequest(request, ""); reflect.DeepEqual(visitor, expected) == false {
+			t.Fatal("Wrong visitor for", userAgent, visitor)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/h1", nil)
+	request.Header.Set("Accept-Language", "en;q=0.5, de-AT, *;q=0.1, fr;q=0, pt-BR;q=0.8")
+	request.Header.Set("CF-IPCountry", "at")
+	visitor := visitorFromRequest(request, "CF-IPCountry")
+	if reflect.DeepEqual(visitor.Languages, []string{"de-AT", "pt-BR", "en"}) == false || visitor.Country != "AT" {
+		t.Fatal("Wrong languages or country", visitor)
+	}
+	if visitor := visitorFromRequest(request, ""); len(visitor.Country) != 0 {
+		t.Fatal("The country should only be read from the configured header", visitor)
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateWebhook registers an endpoint for the events of the urls of the API key of the request,
+// the response has the secret the payloads are signed with
+func (h *handler) CreateWebhook(response http.ResponseWriter, request *http.Request) {
+	owner, ok := ownerOf(response, request, h.config.Webhooks != nil)
+	if ok == false {
+		return
+	}
+	type createWebhookRequest struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	data := &createWebhookRequest{}
+	if err := json.NewDecoder(request.Body).Decode(data); err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	webhook, err := h.config.Webhooks.Register(owner, data.URL, data.Events)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	writeJSON(response, http.StatusCreated, &webhookJsonResponse{Data: webhook})
+}
+
+// ListWebhooks returns the webhooks of the API key of the request, without their secret
+func (h *handler) ListWebhooks(response http.ResponseWriter, request *http.Request) {
+	owner, ok := ownerOf(response, request, h.config.Webhooks != nil)
+	if ok == false {
+		return
+	}
+	webhooks, err := h.config.Webhooks.Webhooks(owner)
+	if err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	writeJSON(response, http.StatusOK, &webhookListJsonResponse{Data: webhooks})
+}
+
+// DeleteWebhook removes a webhook of the API key of the request, the events waiting to be sent to it are dropped
+func (h *handler) DeleteWebhook(response http.ResponseWriter, request *http.Request) {
+	owner, ok := ownerOf(response, request, h.config.Webhooks != nil)
+	if ok == false {
+		return
+	}
+	if err := h.config.Webhooks.Remove(owner, mux.Vars(request)["webhookID"]); err != nil {
+		chooseErrorResponse(err, response)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
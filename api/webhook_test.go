@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+	"github.com/yanisky/url-shortener/pkg/webhook"
+)
+
+func TestWebhooksReceiveSignedEvents(t *testing.T) {
+	var m sync.Mutex
+	received := map[string]string{}
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		m.Lock()
+		defer m.Unlock()
+		received[r.Header.Get("X-Webhook-Event")] = r.Header.Get("X-Webhook-Signature") + " " + string(body)
+	}))
+	defer endpoint.Close()
+
+	store := newMemoryStore()
+	dispatcher := domain.NewWebhookDispatcher(store, webhook.NewHTTPSender(endpoint.Client()), domain.WebhookConfig{
+		Policy: domain.URLPolicy{AllowPrivateHosts: true},
+	})
+	events := domain.NewLocalEventBus()
+	events.Subscribe("webhooks", dispatcher.Notify, domain.WebhookEvents...)
+	service := domain.NewURLShortenerService(store, store, domain.ServiceConfig{Events: events})
+	server := NewGorillaHttpServer()
+	server.Route(NewGorillaHTTPHandler(service, HandlerConfig{Webhooks: dispatcher}))
+
+	if response := apiRequest(&server, http.MethodPost, "/api/v1/webhooks", `{"url":"`+endpoint.URL+`"}`, ""); response.Code != http.StatusUnauthorized {
+		t.Fatal("Webhooks should need an API key", response.Code)
+	}
+	response := apiRequest(&server, http.MethodPost, "/api/v1/webhooks", `{"url":"`+endpoint.URL+`","events":["link.created","link.deleted"]}`, "key")
+	created := webhookJsonResponse{}
+	json.NewDecoder(response.Body).Decode(&created)
+	if response.Code != http.StatusCreated || len(created.Data.Secret) == 0 || len(created.Data.Events) != 2 {
+		t.Fatal("Webhooks should be created with their secret", response.Code, created)
+	}
+	if response := apiRequest(&server, http.MethodPost, "/api/v1/webhooks", `{"url":"`+endpoint.URL+`","events":["link.visited"]}`, "key"); response.Code != http.StatusBadRequest {
+		t.Fatal("Unknown events should be rejected", response.Code)
+	}
+	response = apiRequest(&server, http.MethodGet, "/api/v1/webhooks", "", "key")
+	list := webhookListJsonResponse{}
+	json.NewDecoder(response.Body).Decode(&list)
+	if response.Code != http.StatusOK || len(list.Data) != 1 || list.Data[0].ID != created.Data.ID || len(list.Data[0].Secret) != 0 {
+		t.Fatal("Webhooks should be listed without their secret", response.Code, list)
+	}
+
+	response = apiRequest(&server, http.MethodPost, "/api/v1/urls", `{"url":"https://example.com"}`, "key")
+	url := urlCreatedJsonResponse{}
+	json.NewDecoder(response.Body).Decode(&url)
+	if response := apiRequest(&server, http.MethodPost, "/api/v1/urls", `{"url":"https://example.org"}`, "other"); response.Code != http.StatusOK {
+		t.Fatal("Failed to create url", response.Code)
+	}
+	if response := apiRequest(&server, http.MethodDelete, "/api/v1/urls/"+url.Data.Hash, "", "other"); response.Code != http.StatusNotFound {
+		t.Fatal("Urls of other keys should not be deleted", response.Code)
+	}
+	if response := apiRequest(&server, http.MethodDelete, "/api/v1/urls/"+url.Data.Hash, "", "key"); response.Code != http.StatusNoContent {
+		t.Fatal("Urls of the key should be deleted", response.Code)
+	}
+	events.Wait()
+	if sent, err := dispatcher.DeliverDue(time.Now()); err != nil || sent != 2 {
+		t.Fatal("Only the events of the urls of the key should be delivered", sent, err)
+	}
+	for _, event := range []string{domain.EventLinkCreated, domain.EventLinkDeleted} {
+		payload := received[event][len("sha256=")+64+1:]
+		if received[event] != domain.SignWebhookPayload(created.Data.Secret, []byte(payload))+" "+payload {
+			t.Fatal("Events should be signed with the secret of the webhook", event, received)
+		}
+	}
+
+	if response := apiRequest(&server, http.MethodDelete, "/api/v1/webhooks/"+created.Data.ID, "", "other"); response.Code != http.StatusNotFound {
+		t.Fatal("Webhooks of other keys should not be found", response.Code)
+	}
+	if response := apiRequest(&server, http.MethodDelete, "/api/v1/webhooks/"+created.Data.ID, "", "key"); response.Code != http.StatusNoContent {
+		t.Fatal("Webhooks of the key should be deleted", response.Code)
+	}
+}
+
+func TestWebhooksAreNotFoundWithoutDispatcher(t *testing.T) {
+	server, _ := newCatalogTestServer()
+	if response := apiRequest(server, http.MethodGet, "/api/v1/webhooks", "", "key"); response.Code != http.StatusNotFound {
+		t.Fatal("Webhooks should not be found when they are not enabled", response.Code)
+	}
+}
+
+type memoryWebhook struct {
+	domain.Webhook
+	owner string
+}
+
+func (s *memoryStore) CreateWebhook(owner string, webhook domain.Webhook) (domain.Webhook, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	webhook.ID = strconv.Itoa(len(s.webhooks) + 1)
+	webhook.CreatedAt = time.Now().UTC()
+	s.webhooks = append(s.webhooks, memoryWebhook{Webhook: webhook, owner: owner})
+	return webhook, nil
+}
+
+func (s *memoryStore) Webhooks(owner string) ([]domain.Webhook, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	webhooks := []domain.Webhook{}
+	for _, webhook := range s.webhooks {
+		if webhook.owner == owner {
+			webhooks = append(webhooks, webhook.Webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (s *memoryStore) DeleteWebhook(owner string, webhookID string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for i, webhook := range s.webhooks {
+		if webhook.ID == webhookID && webhook.owner == owner {
+			s.webhooks = append(s.webhooks[:i], s.webhooks[i+1:]...)
+			return nil
+		}
+	}
+	return domain.ErrorWebhookNotFound
+}
+
+func (s *memoryStore) EnqueueDeliveries(owner string, event string, payload []byte, at time.Time) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, webhook := range s.webhooks {
+		for _, subscribed := range webhook.Events {
+			if webhook.owner == owner && subscribed == event {
+				s.deliveries = append(s.deliveries, domain.WebhookDelivery{
+					ID:          int64(len(s.deliveries) + 1),
+					Webhook:     webhook.Webhook,
+					Event:       event,
+					Payload:     payload,
+					NextAttempt: at,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DueDeliveries(now time.Time, limit int) ([]domain.WebhookDelivery, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	due := []domain.WebhookDelivery{}
+	for _, delivery := range s.deliveries {
+		if delivery.Done == false && delivery.NextAttempt.After(now) == false && len(due) < limit {
+			due = append(due, delivery)
+		}
+	}
+	return due, nil
+}
+
+func (s *memoryStore) SaveDelivery(delivery domain.WebhookDelivery) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.deliveries[delivery.ID-1] = delivery
+	return nil
+}
+
+func (s *memoryStore) PruneDeliveries(before time.Time) (int64, error) {
+	return 0, nil
+}
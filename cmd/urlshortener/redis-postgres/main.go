@@ -13,9 +13,19 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/speps/go-hashids"
 	api "github.com/yanisky/url-shortener/api"
+	"github.com/yanisky/url-shortener/internal/flags"
 	domain "github.com/yanisky/url-shortener/pkg"
+	"github.com/yanisky/url-shortener/pkg/broker"
+	"github.com/yanisky/url-shortener/pkg/elasticsearch"
+	"github.com/yanisky/url-shortener/pkg/health"
+	"github.com/yanisky/url-shortener/pkg/httpclient"
+	"github.com/yanisky/url-shortener/pkg/metadata"
 	pg "github.com/yanisky/url-shortener/pkg/postgres"
 	redis "github.com/yanisky/url-shortener/pkg/redis"
+	"github.com/yanisky/url-shortener/pkg/resolver"
+	"github.com/yanisky/url-shortener/pkg/screening"
+	"github.com/yanisky/url-shortener/pkg/webhook"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 type Server struct {
@@ -41,18 +51,110 @@ func main() {
 		osHashSalt    = os.Getenv("HASH_SALT")
 		osServerPort  = os.Getenv("PORT")
 		osPostgresURL = os.Getenv("POSTGRES_URL")
+		osReuse       = os.Getenv("REUSE_EXISTING")
+		osAllowed     = os.Getenv("ALLOWED_DOMAINS")
+		osDenied      = os.Getenv("DENIED_DOMAINS")
+		osPrivate     = os.Getenv("ALLOW_PRIVATE_HOSTS")
+		osFragment    = os.Getenv("STRIP_FRAGMENT")
+		osBlocklist   = os.Getenv("BLOCKLIST_FILE")
+		osBlockRules  = os.Getenv("BLOCK_RULES_FILE")
+		osBlockHashes = os.Getenv("BLOCK_HASH_PREFIXES_FILE")
+		osScreenFind  = os.Getenv("SCREEN_ON_FIND")
+		osAdminToken  = os.Getenv("ADMIN_TOKEN")
+		osOwnHosts    = os.Getenv("OWN_HOSTS")
+		osFlatten     = os.Getenv("FLATTEN_OWN_URLS")
+		osShorteners  = os.Getenv("SHORTENER_HOSTS")
+		osKeyLimit    = os.Getenv("API_KEY_LIMIT")
+		osIPLimit     = os.Getenv("API_IP_LIMIT")
+		osRedirLimit  = os.Getenv("REDIRECT_LIMIT")
+		osForwarded   = os.Getenv("TRUST_FORWARDED_FOR")
+		osTLSCert     = os.Getenv("TLS_CERT")
+		osTLSKey      = os.Getenv("TLS_KEY")
+		osACMEHosts   = os.Getenv("ACME_HOSTS")
+		osACMEEmail   = os.Getenv("ACME_EMAIL")
+		osACMEDir     = os.Getenv("ACME_DIRECTORY")
+		osACMECache   = os.Getenv("ACME_CACHE_DIR")
+		osRedirAddr   = os.Getenv("HTTP_REDIRECT_ADDR")
+		osHSTS        = os.Getenv("HSTS_MAX_AGE")
+		osDefDomain   = os.Getenv("DEFAULT_DOMAIN")
+		osDomains     = os.Getenv("DOMAINS")
+		osQRLogo      = os.Getenv("QR_LOGO_FILE")
+		osCookieKey   = os.Getenv("COOKIE_SECRET")
+		osPwLimit     = os.Getenv("PASSWORD_ATTEMPT_LIMIT")
+		osCountryHdr  = os.Getenv("COUNTRY_HEADER")
+		osEventBus    = os.Getenv("EVENT_BUS")
+		osMetadata    = os.Getenv("FETCH_METADATA")
+		osHealthEvery = os.Getenv("HEALTH_CHECK_INTERVAL")
+		osWebhooks    = os.Getenv("WEBHOOKS")
+		osRollupEvery = os.Getenv("VIEW_ROLLUP_INTERVAL")
+		osRetention   = os.Getenv("VIEW_RETENTION")
+		osESURL       = os.Getenv("ELASTICSEARCH_URL")
+		osESIndex     = os.Getenv("ELASTICSEARCH_INDEX")
 		osRedisURL    = os.Getenv("REDIS_URL")
+		osRedisStats  = os.Getenv("REDIS_ANALYTICS")
 
 		hashSalt    = flag.String("hash_salt", osHashSalt, "Used to salt our id codes")
 		serverPort  = flag.String("port", osServerPort, "Http server listening port")
 		postgresURL = flag.String("postgres_url", osPostgresURL, "PostgreSQL database url")
+		reuse       = flag.Bool("reuse_existing", osReuse == "true", "Default for returning the existing short url when an owner shortens the same url again")
+		allowed     = flag.String("allowed_domains", osAllowed, "Comma separated domains that can be shortened, all when empty")
+		denied      = flag.String("denied_domains", osDenied, "Comma separated domains that can't be shortened")
+		private     = flag.Bool("allow_private_hosts", osPrivate == "true", "Allow shortening urls to localhost and private IPs")
+		fragment    = flag.Bool("strip_fragment", osFragment == "true", "Remove the #fragment from urls")
+		blocklist   = flag.String("blocklist_file", osBlocklist, "File with one blocked domain per line")
+		blockRules  = flag.String("block_rules_file", osBlockRules, "File with one regular expression per line, matching urls are blocked")
+		blockHashes = flag.String("block_hash_prefixes_file", osBlockHashes, "Safe Browsing hash prefix list (threatListUpdates response with RAW hashes)")
+		screenFind  = flag.Bool("screen_on_find", osScreenFind == "true", "Screen urls on every redirect too, not only when they are created")
+		adminToken  = flag.String("admin_token", osAdminToken, "Token required by admin endpoints, they are disabled when empty")
+		ownHosts    = flag.String("own_hosts", osOwnHosts, "Comma separated hosts this shortener is served from, urls to them are rejected")
+		flatten     = flag.Bool("flatten_own_urls", osFlatten == "true", "Replace urls to our own short urls and to other shorteners with their destination instead of rejecting them")
+		shorteners  = flag.String("shortener_hosts", osShorteners, "Comma separated hosts of other url shorteners, their links are followed to detect redirect loops")
+		keyLimit    = flag.String("api_key_limit", osKeyLimit, "Rate limit of API requests per API key, like 600/m. No limit when empty")
+		ipLimit     = flag.String("api_ip_limit", osIPLimit, "Rate limit of API requests without API key per IP, like 60/m. No limit when empty")
+		redirLimit  = flag.String("redirect_limit", osRedirLimit, "Rate limit of redirects per IP, like 300/m. No limit when empty")
+		forwarded   = flag.Bool("trust_forwarded_for", osForwarded == "true", "Take client IPs from the X-Forwarded-For header and the scheme from the X-Forwarded-Proto header set by a proxy")
+		tlsCert     = flag.String("tls_cert", osTLSCert, "PEM certificate file, serves HTTPS when set. Reloaded when it changes")
+		tlsKey      = flag.String("tls_key", osTLSKey, "PEM private key file of tls_cert")
+		acmeHosts   = flag.String("acme_hosts", osACMEHosts, "Comma separated hosts to get certificates for with ACME (Let's Encrypt), serves HTTPS when set")
+		acmeEmail   = flag.String("acme_email", osACMEEmail, "Contact email of the ACME account")
+		acmeDir     = flag.String("acme_directory", osACMEDir, "ACME directory url, Let's Encrypt production when empty")
+		acmeCache   = flag.String("acme_cache_dir", osACMECache, "Directory to store ACME certificates in, they are stored in PostgreSQL when empty")
+		redirAddr   = flag.String("http_redirect_addr", osRedirAddr, "Address of an http listener redirecting to https and answering ACME challenges, like :80")
+		hsts        = flag.String("hsts_max_age", osHSTS, "Strict-Transport-Security max age for HTTPS responses, like 8760h. Not sent when empty")
+		defDomain   = flag.String("default_domain", osDefDomain, "Short domain of urls created without one and of redirects to unknown hosts")
+		domains     = flag.String("domains", osDomains, "Comma separated short domains urls can be created on besides default_domain")
+		qrLogo      = flag.String("qr_logo_file", osQRLogo, "PNG, JPEG or GIF logo embedded in QR codes requested with logo=true")
+		cookieKey   = flag.String("cookie_secret", osCookieKey, "Secret that signs the cookies of unlocked password protected urls, the same for every instance. Random when empty")
+		pwLimit     = flag.String("password_attempt_limit", flags.EnvOr(osPwLimit, "5/m"), "Rate limit of password attempts per IP for each protected url")
+		countryHdr  = flag.String("country_header", osCountryHdr, "Header with the country code of visitors for routing rules, like CF-IPCountry. Country rules never match when empty")
+		eventBus    = flag.String("event_bus", flags.EnvOr(osEventBus, "local"), "Event bus of the side effects of the service, local or memory for the in-process broker")
+		fetchMeta   = flag.Bool("fetch_metadata", osMetadata == "true", "Fetch the title and Open Graph tags of the destination of new urls in the background")
+		healthEvery = flag.String("health_check_interval", osHealthEvery, "How often the destinations of urls are checked, like 6h. They are not checked when empty")
+		useWebhooks = flag.Bool("webhooks", osWebhooks == "true", "Let API keys register webhooks that receive the events of their urls")
+		rollupEvery = flag.String("view_rollup_interval", osRollupEvery, "How often views are rolled up so stats don't count every view, like 10m. They are not rolled up when empty")
+		retention   = flag.String("view_retention", osRetention, "How long views are kept once they are rolled up, like 720h. They are kept forever when empty")
+		esURL       = flag.String("elasticsearch_url", osESURL, "Elasticsearch url, views are indexed in Elasticsearch instead of PostgreSQL when it's set")
+		esIndex     = flag.String("elasticsearch_index", flags.EnvOr(osESIndex, "url_views"), "Elasticsearch index of views, created when it doesn't exist")
 		redisURL    = flag.String("redis_url", osRedisURL, "Redis url")
+		redisStats  = flag.Bool("redis_analytics", osRedisStats == "true", "Count views in Redis instead of storing every view in PostgreSQL, views stored before are not counted")
 	)
 	flag.Parse()
+	tlsConfig := api.TLSConfig{
+		CertFile:         *tlsCert,
+		KeyFile:          *tlsKey,
+		ACMEHosts:        flags.CommaList(*acmeHosts),
+		ACMEEmail:        *acmeEmail,
+		ACMEDirectoryURL: *acmeDir,
+		RedirectAddr:     *redirAddr,
+	}
+	useTLS := len(tlsConfig.CertFile) != 0 || len(tlsConfig.ACMEHosts) != 0
 	// default port
 	addr := ":" + *serverPort
 	if len(addr) == 1 {
 		addr = ":80"
+		if useTLS {
+			addr = ":443"
+		}
 	}
 	if len(*hashSalt) == 0 {
 		panic("No hash salt provided")
@@ -72,25 +174,196 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	// placeholder codes left by older versions, see 001_unique_short.sql
+	if _, err := postgresRepo.RepairShortCodes(); err != nil {
+		panic(err)
+	}
 
 	redisCache, err := redis.NewRedisRepository(*redisURL, 60*time.Second, hasher)
 	if err != nil {
 		panic(err)
 	}
 
+	var analytics domain.URLAnalyticsRepository = postgresRepo
+	if *redisStats && len(*esURL) != 0 {
+		panic("Views can be counted in Redis or in Elasticsearch, not both")
+	}
+	if *redisStats {
+		if analytics, err = redis.NewRedisAnalyticsRepository(*redisURL, 60*time.Second, hasher); err != nil {
+			panic(err)
+		}
+	}
+	if len(*esURL) != 0 {
+		esRepo, err := elasticsearch.NewElasticsearchRepository(*esURL, *esIndex, 60*time.Second, hasher, log.With(logger, "component", "elasticsearch"))
+		if err != nil {
+			panic(err)
+		}
+		// the queued views are indexed before the server exits
+		stopIndexer := make(chan struct{})
+		defer func() {
+			close(stopIndexer)
+			<-esRepo.Done()
+		}()
+		go esRepo.Run(stopIndexer)
+		analytics = esRepo
+	}
+
 	// service - no cache - postgresRepo implements both URL
-	simpleService := domain.NewURLShortenerService(postgresRepo, postgresRepo)
+	policy := domain.URLPolicy{
+		StripFragment:     *fragment,
+		AllowPrivateHosts: *private,
+		AllowedDomains:    flags.CommaList(*allowed),
+		DeniedDomains:     flags.CommaList(*denied),
+	}
+	screener, err := screening.FromFiles(*blocklist, *blockRules, *blockHashes)
+	if err != nil {
+		panic(err)
+	}
+	shortDomains := domain.Domains{Default: *defDomain, Registered: flags.CommaList(*domains)}
+	serviceConfig := domain.ServiceConfig{
+		Domains:        shortDomains,
+		Policy:         policy,
+		Screener:       screener,
+		ScreenOnFind:   *screenFind,
+		OwnHosts:       flags.CommaList(*ownHosts),
+		FlattenOwnURLs: *flatten,
+		ShortenerHosts: flags.CommaList(*shorteners),
+	}
+	if len(serviceConfig.ShortenerHosts) != 0 {
+		serviceConfig.Resolver = resolver.NewHTTPResolver(5*time.Second, *private)
+	}
+	if *fetchMeta {
+		fetcher := metadata.NewHTTPFetcher(httpclient.New(10*time.Second, *private, 5))
+		enricher := domain.NewMetadataEnricher(fetcher, domain.NewCachedMetadataRepository(postgresRepo, redisCache), 2, 100, log.With(logger, "component", "metadata"))
+		// the queued urls get their metadata before the server exits
+		defer enricher.Close()
+		serviceConfig.Enricher = enricher
+	}
+	// side effects of the service are subscribers of the event bus
+	events, err := broker.Select(*eventBus, "urlshortener.")
+	if err != nil {
+		panic(err)
+	}
+	subscribe := func(name string, handler domain.EventHandler, types ...string) {
+		if err := events.Subscribe(name, handler, types...); err != nil {
+			panic(err)
+		}
+	}
+	subscribe("analytics", domain.AnalyticsSubscriber(analytics), domain.AnalyticsEvents...)
+	subscribe("cache", domain.CacheSubscriber(redisCache), domain.CacheEvents...)
+	serviceConfig.Events = events
+	var webhooks *domain.WebhookDispatcher
+	if *useWebhooks {
+		// endpoints can't redirect the payloads somewhere else
+		sendTimeout := 10 * time.Second
+		sender := webhook.NewHTTPSender(httpclient.New(sendTimeout, *private, 0))
+		webhooks = domain.NewWebhookDispatcher(postgresRepo, sender, domain.WebhookConfig{Policy: policy, PollInterval: 10 * time.Second, BatchSize: 100, SendTimeout: sendTimeout, Logger: log.With(logger, "component", "webhooks")})
+		subscribe("webhooks", webhooks.Notify, domain.WebhookEvents...)
+		serviceConfig.ClickEvents = true
+	}
+	simpleService := domain.NewURLShortenerService(postgresRepo, analytics, serviceConfig)
 	// wrap service with cache
 	cachedService := domain.NewCachedURLShortenerService(simpleService, redisCache)
 
 	server := api.NewGorillaHttpServer()
-	handler := api.NewGorillaHTTPHandler(cachedService)
+	handlerConfig := api.HandlerConfig{
+		ReuseExisting:     *reuse,
+		AdminToken:        *adminToken,
+		Domains:           shortDomains,
+		CookieSecret:      []byte(*cookieKey),
+		CountryHeader:     *countryHdr,
+		Catalog:           domain.NewURLCatalogService(postgresRepo, analytics),
+		TrustForwardedFor: *forwarded,
+	}
+	if len(*qrLogo) != 0 {
+		if handlerConfig.QRLogo, err = api.LoadQRLogo(*qrLogo); err != nil {
+			panic(err)
+		}
+	}
 
+	rateLimits := api.RateLimitConfig{TrustForwardedFor: *forwarded}
+	if rateLimits.APIPerKey, err = domain.ParseRateLimit(*keyLimit); err != nil {
+		panic(err)
+	}
+	if rateLimits.APIPerIP, err = domain.ParseRateLimit(*ipLimit); err != nil {
+		panic(err)
+	}
+	if rateLimits.Redirect, err = domain.ParseRateLimit(*redirLimit); err != nil {
+		panic(err)
+	}
+	limiter, err := redis.NewRedisRateLimiter(*redisURL, 60*time.Second)
+	if err != nil {
+		panic(err)
+	}
+
+	handlerConfig.PasswordLimiter = limiter
+	if handlerConfig.PasswordAttempts, err = domain.ParseRateLimit(*pwLimit); err != nil {
+		panic(err)
+	}
+	healthConfig := domain.HealthCheckerConfig{BatchSize: 100, Concurrency: 4, Logger: log.With(logger, "component", "health")}
+	if len(*healthEvery) != 0 {
+		if healthConfig.Interval, err = time.ParseDuration(*healthEvery); err != nil {
+			panic(err)
+		}
+	}
+	prober := health.NewHTTPProber(httpclient.New(10*time.Second, *private, 10))
+	checker := domain.NewHealthChecker(prober, postgresRepo, healthConfig)
+	handlerConfig.Health = checker
+	if healthConfig.Interval > 0 {
+		stopChecker := make(chan struct{})
+		defer close(stopChecker)
+		go checker.Run(stopChecker)
+	}
+	rollupConfig := domain.ViewRollupConfig{Logger: log.With(logger, "component", "rollups")}
+	if len(*rollupEvery) != 0 {
+		if rollupConfig.Interval, err = time.ParseDuration(*rollupEvery); err != nil {
+			panic(err)
+		}
+	}
+	if len(*retention) != 0 {
+		if rollupConfig.Retention, err = time.ParseDuration(*retention); err != nil {
+			panic(err)
+		}
+	}
+	if rollupConfig.Interval > 0 {
+		aggregator := domain.NewViewAggregator(postgresRepo, rollupConfig)
+		stopAggregator := make(chan struct{})
+		defer close(stopAggregator)
+		go aggregator.Run(stopAggregator)
+	} else if rollupConfig.Retention > 0 {
+		panic("view_retention needs view_rollup_interval, views are only pruned once they are rolled up")
+	}
+	if webhooks != nil {
+		handlerConfig.Webhooks = webhooks
+		stopWebhooks := make(chan struct{})
+		defer close(stopWebhooks)
+		go webhooks.Run(stopWebhooks)
+	}
+	handler := api.NewGorillaHTTPHandler(cachedService, handlerConfig)
 	server.Route(handler)
+	server.Router.Use(api.RateLimitMiddleware(limiter, rateLimits))
+
+	if len(*hsts) != 0 {
+		if tlsConfig.HSTSMaxAge, err = time.ParseDuration(*hsts); err != nil {
+			panic(err)
+		}
+	}
+	if len(tlsConfig.ACMEHosts) != 0 {
+		if len(*acmeCache) != 0 {
+			tlsConfig.ACMECache = autocert.DirCache(*acmeCache)
+		} else if tlsConfig.ACMECache, err = pg.NewPostgreSQLCertCache(*postgresURL, 60*time.Second); err != nil {
+			panic(err)
+		}
+	}
 
 	errChan := make(chan error, 2)
 
 	go func() {
+		if useTLS {
+			logger.Log("transport", "https", "address", addr, "msg", "listening")
+			errChan <- server.RunTLS(addr, tlsConfig)
+			return
+		}
 		logger.Log("transport", "http", "address", addr, "msg", "listening")
 		errChan <- server.Run(addr)
 	}()
@@ -1,11 +1,127 @@
 CREATE TABLE urls(
   id BIGINT PRIMARY KEY GENERATED ALWAYS as IDENTITY,
+  domain TEXT NOT NULL DEFAULT '',
   url TEXT NOT NULL,
   short TEXT NOT NULL,
+  owner TEXT NOT NULL DEFAULT '',
+  url_digest BYTEA,
+  disabled BOOLEAN NOT NULL DEFAULT false,
+  preview BOOLEAN NOT NULL DEFAULT false,
+  password_hash TEXT NOT NULL DEFAULT '',
+  max_clicks INTEGER NOT NULL DEFAULT 0,
+  clicks INTEGER NOT NULL DEFAULT 0,
+  -- routing rules, see domain.RoutingRule
+  rules JSONB NOT NULL DEFAULT '[]',
+  -- weighted destinations of split urls, see domain.Variant
+  variants JSONB NOT NULL DEFAULT '[]',
+  -- '', 'merge' or 'override', see domain.URL.QueryMode
+  query_mode TEXT NOT NULL DEFAULT '',
+  prefix BOOLEAN NOT NULL DEFAULT false,
+  -- see campaigns, NULL for urls without campaign
+  campaign_id BIGINT,
+  title TEXT NOT NULL DEFAULT '',
+  notes TEXT NOT NULL DEFAULT '',
+  -- fetched from the destination, see domain.LinkMetadata. NULL until it's fetched
+  metadata JSONB,
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
+-- codes are unique per domain, the empty domain is used when no domains are configured
+CREATE UNIQUE INDEX url_domain_short on urls (domain, short);
+-- url_digest is only set for urls created in "reuse existing" mode, NULLs never conflict
+CREATE UNIQUE INDEX url_owner_digest on urls (owner, domain, url_digest);
+CREATE INDEX url_owner on urls (owner, id);
+CREATE INDEX url_campaign on urls (campaign_id);
+CREATE TABLE url_tags(
+  url_id BIGINT NOT NULL,
+  tag TEXT NOT NULL,
+  PRIMARY KEY (url_id, tag)
+);
+CREATE INDEX url_tag on url_tags (tag, url_id);
+CREATE TABLE campaigns(
+  id BIGINT PRIMARY KEY GENERATED ALWAYS as IDENTITY,
+  owner TEXT NOT NULL,
+  name TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- names are unique per owner
+CREATE UNIQUE INDEX campaign_owner_name on campaigns (owner, name);
 CREATE TABLE url_views(
   url_id BIGINT NOT NULL,
+  -- index of the variant of split urls, -1 for other views
+  variant SMALLINT NOT NULL DEFAULT -1,
+  -- daily salted hash of the visitor, see domain.VisitorHash. Empty when it's not known
+  visitor TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX url_view_time on url_views (url_id, created_at);
+-- views are rolled up and pruned by time
+CREATE INDEX url_view_created on url_views (created_at);
+-- views rolled up by hour for the past day and week, hours older than 8 days are pruned.
+-- visitors are the ones seen for the first time of their day in the hour, see domain.ViewAggregator
+CREATE TABLE url_view_hours(
+  url_id BIGINT NOT NULL,
+  hour TIMESTAMPTZ NOT NULL,
+  variant SMALLINT NOT NULL,
+  views INTEGER NOT NULL,
+  visitors INTEGER NOT NULL,
+  PRIMARY KEY (url_id, hour, variant)
+);
+CREATE INDEX url_view_hour on url_view_hours (hour);
+-- views rolled up by UTC day, kept forever
+CREATE TABLE url_view_days(
+  url_id BIGINT NOT NULL,
+  day DATE NOT NULL,
+  variant SMALLINT NOT NULL,
+  views INTEGER NOT NULL,
+  visitors INTEGER NOT NULL,
+  PRIMARY KEY (url_id, day, variant)
+);
+-- views recorded before rolled_up_to are in the rollups, it always has one row
+CREATE TABLE view_rollups(
+  rolled_up_to TIMESTAMPTZ NOT NULL
+);
+INSERT INTO view_rollups (rolled_up_to) VALUES ('epoch');
+-- last check of the destination of each url, see domain.HealthChecker
+CREATE TABLE url_health(
+  url_id BIGINT PRIMARY KEY,
+  -- 0 when the destination couldn't be reached
+  status_code INTEGER NOT NULL,
+  latency_ms INTEGER NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  broken BOOLEAN NOT NULL,
+  checked_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX url_health_checked on url_health (checked_at);
+CREATE INDEX url_health_broken on url_health (checked_at) WHERE broken;
+-- webhooks of owners, see domain.Webhook
+CREATE TABLE webhooks(
+  id BIGINT PRIMARY KEY GENERATED ALWAYS as IDENTITY,
+  owner TEXT NOT NULL,
+  url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  events TEXT[] NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX webhook_owner on webhooks (owner, id);
+-- outbox of the events sent to webhooks, see domain.WebhookDispatcher
+CREATE TABLE webhook_deliveries(
+  id BIGINT PRIMARY KEY GENERATED ALWAYS as IDENTITY,
+  webhook_id BIGINT NOT NULL,
+  event TEXT NOT NULL,
+  -- the exact bytes that are signed and sent
+  payload BYTEA NOT NULL,
+  -- failed attempts
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL,
+  -- sent or given up on
+  done BOOLEAN NOT NULL DEFAULT false,
+  last_error TEXT NOT NULL DEFAULT '',
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
-CREATE INDEX url_view_time on url_views (url_id, created_at);
\ No newline at end of file
+CREATE INDEX webhook_delivery_due on webhook_deliveries (next_attempt_at) WHERE NOT done;
+CREATE INDEX webhook_delivery_webhook on webhook_deliveries (webhook_id);
+CREATE TABLE acme_certs(
+  key TEXT PRIMARY KEY,
+  data BYTEA NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
\ No newline at end of file
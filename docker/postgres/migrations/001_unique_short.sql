@@ -0,0 +1,9 @@
+-- Rows created before links were inserted in a single statement could be left
+-- with the time.Now() placeholder in "short" if the follow up UPDATE never ran.
+-- Their id may have been handed out as a code, so they are kept: "short" is set to a unique
+-- marker here and the servers replace it with the code of the id when they start,
+-- see postgreSQLRepository.RepairShortCodes.
+BEGIN;
+UPDATE urls SET short='repair:' || id WHERE short LIKE '% %';
+CREATE UNIQUE INDEX url_short on urls (short);
+COMMIT;
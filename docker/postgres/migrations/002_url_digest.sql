@@ -0,0 +1,6 @@
+-- Adds the columns used to return an existing url instead of creating a duplicate.
+BEGIN;
+ALTER TABLE urls ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN url_digest BYTEA;
+CREATE UNIQUE INDEX url_owner_digest on urls (owner, url_digest);
+COMMIT;
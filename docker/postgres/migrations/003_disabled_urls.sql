@@ -0,0 +1,2 @@
+-- Allows admins to disable urls that turned out to be malicious.
+ALTER TABLE urls ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,6 @@
+-- Certificates and the ACME account key when the certificate cache is the database.
+CREATE TABLE acme_certs(
+  key TEXT PRIMARY KEY,
+  data BYTEA NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,9 @@
+-- Urls belong to a short domain, codes and reused urls are unique per domain.
+-- Existing urls get the empty domain, the default domain serves them once domains are configured.
+BEGIN;
+ALTER TABLE urls ADD COLUMN domain TEXT NOT NULL DEFAULT '';
+DROP INDEX url_short;
+CREATE UNIQUE INDEX url_domain_short on urls (domain, short);
+DROP INDEX url_owner_digest;
+CREATE UNIQUE INDEX url_owner_digest on urls (owner, domain, url_digest);
+COMMIT;
@@ -0,0 +1,2 @@
+-- Urls in preview mode show a page with their destination instead of redirecting.
+ALTER TABLE urls ADD COLUMN preview BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+-- bcrypt hash of the password of protected urls, empty for urls without password.
+ALTER TABLE urls ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
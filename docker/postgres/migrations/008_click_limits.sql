@@ -0,0 +1,5 @@
+-- Click limited urls stop redirecting after max_clicks clicks, 0 is no limit.
+BEGIN;
+ALTER TABLE urls ADD COLUMN max_clicks INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE urls ADD COLUMN clicks INTEGER NOT NULL DEFAULT 0;
+COMMIT;
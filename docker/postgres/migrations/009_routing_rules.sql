@@ -0,0 +1,2 @@
+-- Routing rules send visitors matching a device, os, country or language somewhere else than url.
+ALTER TABLE urls ADD COLUMN rules JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,5 @@
+-- Split urls spread their visitors across weighted variants, views remember the variant.
+BEGIN;
+ALTER TABLE urls ADD COLUMN variants JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE url_views ADD COLUMN variant SMALLINT NOT NULL DEFAULT -1;
+COMMIT;
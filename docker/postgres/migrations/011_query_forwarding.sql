@@ -0,0 +1,2 @@
+-- Urls can forward the query string of visitors to their destination.
+ALTER TABLE urls ADD COLUMN query_mode TEXT NOT NULL DEFAULT '';
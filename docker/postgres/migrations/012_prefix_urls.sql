@@ -0,0 +1,2 @@
+-- Prefix urls forward the path visitors add after their code to their destination.
+ALTER TABLE urls ADD COLUMN prefix BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,17 @@
+-- Urls can be labeled with tags and grouped in campaigns, both are listed per owner.
+ALTER TABLE urls ADD COLUMN campaign_id BIGINT;
+CREATE INDEX url_owner on urls (owner, id);
+CREATE INDEX url_campaign on urls (campaign_id);
+CREATE TABLE url_tags(
+  url_id BIGINT NOT NULL,
+  tag TEXT NOT NULL,
+  PRIMARY KEY (url_id, tag)
+);
+CREATE INDEX url_tag on url_tags (tag, url_id);
+CREATE TABLE campaigns(
+  id BIGINT PRIMARY KEY GENERATED ALWAYS as IDENTITY,
+  owner TEXT NOT NULL,
+  name TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX campaign_owner_name on campaigns (owner, name);
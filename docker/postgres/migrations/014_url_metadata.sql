@@ -0,0 +1,4 @@
+-- Urls can have a title and notes, and the metadata fetched from their destination.
+ALTER TABLE urls ADD COLUMN title TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN notes TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN metadata JSONB;
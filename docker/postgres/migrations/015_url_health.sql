@@ -0,0 +1,11 @@
+-- The destinations of urls are checked periodically, the last check of each one is kept.
+CREATE TABLE url_health(
+  url_id BIGINT PRIMARY KEY,
+  status_code INTEGER NOT NULL,
+  latency_ms INTEGER NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  broken BOOLEAN NOT NULL,
+  checked_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX url_health_checked on url_health (checked_at);
+CREATE INDEX url_health_broken on url_health (checked_at) WHERE broken;
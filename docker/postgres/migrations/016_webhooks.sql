@@ -0,0 +1,26 @@
+-- Owners register webhooks that receive the events of their urls, the events wait in an outbox until they are sent.
+CREATE TABLE webhooks(
+  id BIGINT PRIMARY KEY GENERATED ALWAYS as IDENTITY,
+  owner TEXT NOT NULL,
+  url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  events TEXT[] NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX webhook_owner on webhooks (owner, id);
+CREATE TABLE webhook_deliveries(
+  id BIGINT PRIMARY KEY GENERATED ALWAYS as IDENTITY,
+  webhook_id BIGINT NOT NULL,
+  event TEXT NOT NULL,
+  -- the exact bytes that are signed and sent
+  payload BYTEA NOT NULL,
+  -- failed attempts
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL,
+  -- sent or given up on
+  done BOOLEAN NOT NULL DEFAULT false,
+  last_error TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX webhook_delivery_due on webhook_deliveries (next_attempt_at) WHERE NOT done;
+CREATE INDEX webhook_delivery_webhook on webhook_deliveries (webhook_id);
@@ -0,0 +1,2 @@
+-- Views remember the daily salted hash of their visitor for unique visitor counts.
+ALTER TABLE url_views ADD COLUMN visitor TEXT NOT NULL DEFAULT '';
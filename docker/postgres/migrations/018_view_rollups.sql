@@ -0,0 +1,26 @@
+-- Views are rolled up by hour and by day so stats don't count every view, old views can be pruned.
+-- The first roll up aggregates every view recorded so far, a day at a time.
+BEGIN;
+CREATE INDEX url_view_created on url_views (created_at);
+CREATE TABLE url_view_hours(
+  url_id BIGINT NOT NULL,
+  hour TIMESTAMPTZ NOT NULL,
+  variant SMALLINT NOT NULL,
+  views INTEGER NOT NULL,
+  visitors INTEGER NOT NULL,
+  PRIMARY KEY (url_id, hour, variant)
+);
+CREATE INDEX url_view_hour on url_view_hours (hour);
+CREATE TABLE url_view_days(
+  url_id BIGINT NOT NULL,
+  day DATE NOT NULL,
+  variant SMALLINT NOT NULL,
+  views INTEGER NOT NULL,
+  visitors INTEGER NOT NULL,
+  PRIMARY KEY (url_id, day, variant)
+);
+CREATE TABLE view_rollups(
+  rolled_up_to TIMESTAMPTZ NOT NULL
+);
+INSERT INTO view_rollups (rolled_up_to) VALUES ('epoch');
+COMMIT;
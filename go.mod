@@ -8,5 +8,8 @@ require (
 	github.com/go-redis/redis/v7 v7.2.0
 	github.com/gorilla/mux v1.7.4
 	github.com/jackc/pgx/v4 v4.6.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/speps/go-hashids v2.0.0+incompatible
+	golang.org/x/crypto v0.0.0-20200323165209-0ec3e9974c59
+	golang.org/x/net v0.0.0-20190923162816-aa69164e4478
 )
@@ -0,0 +1,22 @@
+package flags
+
+import "strings"
+
+// EnvOr returns the environment value or def when it's not set
+func EnvOr(value string, def string) string {
+	if len(value) == 0 {
+		return def
+	}
+	return value
+}
+
+// CommaList splits a comma separated flag value ignoring empty items
+func CommaList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) != 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
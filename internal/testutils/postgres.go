@@ -5,33 +5,42 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/speps/go-hashids"
 )
 
 func TruncateAllTables(db *pgxpool.Pool) error {
-	sql := "TRUNCATE TABLE urls, url_views"
+	sql := "TRUNCATE TABLE urls, url_views, url_view_hours, url_view_days, acme_certs, url_tags, campaigns, url_health, webhooks, webhook_deliveries;" +
+		"UPDATE view_rollups SET rolled_up_to='epoch'"
 	if _, err := db.Exec(context.Background(), sql); err != nil {
 		return err
 	}
 	return nil
 }
 func TruncateUrlsTable(db *pgxpool.Pool) error {
-	sql := "TRUNCATE TABLE urls"
+	sql := "TRUNCATE TABLE urls, url_tags, campaigns, url_health, webhooks, webhook_deliveries"
 	if _, err := db.Exec(context.Background(), sql); err != nil {
 		return err
 	}
 	return nil
 }
 func TruncateUrlViewsTable(db *pgxpool.Pool) error {
-	sql := "TRUNCATE TABLE url_views"
+	sql := "TRUNCATE TABLE url_views, url_view_hours, url_view_days; UPDATE view_rollups SET rolled_up_to='epoch'"
 	if _, err := db.Exec(context.Background(), sql); err != nil {
 		return err
 	}
 	return nil
 }
-func InsertUrl(db *pgxpool.Pool, full string) (int64, error) {
-	sql := "INSERT INTO urls (url, short) VALUES ($1, $2) RETURNING id"
+func InsertUrl(db *pgxpool.Pool, hasher *hashids.HashID, full string) (int64, error) {
 	var id int64
-	if err := db.QueryRow(context.Background(), sql, full, time.Now().String()).Scan(&id); err != nil {
+	if err := db.QueryRow(context.Background(), "SELECT nextval(pg_get_serial_sequence('urls', 'id'))").Scan(&id); err != nil {
+		return 0, err
+	}
+	short, err := hasher.EncodeInt64([]int64{id})
+	if err != nil {
+		return 0, err
+	}
+	sql := "INSERT INTO urls (id, url, short) OVERRIDING SYSTEM VALUE VALUES ($1, $2, $3)"
+	if _, err := db.Exec(context.Background(), sql, id, full, short); err != nil {
 		return 0, err
 	}
 	return id, nil
@@ -69,3 +78,10 @@ func InsertUrlView(db *pgxpool.Pool, id int64, createdAt time.Time) error {
 	return nil
 
 }
+func InsertUrlVisitorView(db *pgxpool.Pool, id int64, visitor string, createdAt time.Time) error {
+	sql := "INSERT INTO url_views (url_id, visitor, created_at) VALUES ($1, $2, $3)"
+	if _, err := db.Exec(context.Background(), sql, id, visitor, createdAt); err != nil {
+		return err
+	}
+	return nil
+}
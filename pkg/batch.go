@@ -0,0 +1,37 @@
+package urlshortener
+
+import (
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// runBatches calls batch every interval until stop is closed, batch returns how many items it handled.
+// A full batch is followed by another one right away so a backlog is caught up with, a batch that
+// fails is logged and waits for the next tick like the ones that are not full.
+func runBatches(stop <-chan struct{}, interval time.Duration, batchSize int, logger log.Logger, batch func(now time.Time) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			done, err := batch(time.Now())
+			if err != nil {
+				logger.Log("msg", "batch failed", "done", done, "err", err)
+				break
+			}
+			if done < batchSize {
+				break
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
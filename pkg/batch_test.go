@@ -0,0 +1,35 @@
+package urlshortener
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunBatchesCatchesUpWithFullBatches(t *testing.T) {
+	var m sync.Mutex
+	calls := 0
+	batch := func(now time.Time) (int, error) {
+		m.Lock()
+		defer m.Unlock()
+		calls++
+		if calls <= 3 {
+			return 10, nil
+		}
+		return 2, nil
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		runBatches(stop, time.Hour, 10, &loggerMock{}, batch)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	<-done
+	m.Lock()
+	defer m.Unlock()
+	if calls != 4 {
+		t.Fatal("Full batches should be followed by another one, the others should wait for the next tick", calls)
+	}
+}
@@ -0,0 +1,88 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// Broker is a NATS or Kafka style message broker, clients of those brokers only need a few lines
+// to satisfy it. MemoryBroker stands in for them in tests and single instance deployments.
+type Broker interface {
+	Publish(subject string, data []byte) error
+	// QueueSubscribe calls handler with the messages of the subject,
+	// every message goes to one of the subscribers of each queue, like NATS queue groups or Kafka consumer groups
+	QueueSubscribe(subject string, queue string, handler func(data []byte)) error
+}
+
+type eventBus struct {
+	broker Broker
+	prefix string
+}
+
+// NewEventBus creates an EventBus that sends events through the broker so every instance of the
+// shortener shares them. Events are published to the subject prefix + type, like "urlshortener.link.created",
+// and subscribers are queues named after them so each event is handled once.
+func NewEventBus(broker Broker, prefix string) domain.EventBus {
+	return &eventBus{broker: broker, prefix: prefix}
+}
+
+// message is how events travel, urls keep the fields the API never shows
+// because subscribers like caches need them
+type message struct {
+	domain.LinkEvent
+	Owner        string `json:"owner,omitempty"`
+	PasswordHash string `json:"password_hash,omitempty"`
+	Reused       bool   `json:"reused,omitempty"`
+}
+
+// Publish drops events that can't be sent, like LocalEventBus side effects never fail requests
+func (b *eventBus) Publish(event domain.LinkEvent) {
+	data, err := json.Marshal(message{
+		LinkEvent:    event,
+		Owner:        event.URL.Owner,
+		PasswordHash: event.URL.PasswordHash,
+		Reused:       event.URL.Reused,
+	})
+	if err != nil {
+		return
+	}
+	b.broker.Publish(b.prefix+event.Type, data)
+}
+
+func (b *eventBus) Subscribe(name string, handler domain.EventHandler, types ...string) error {
+	if len(types) == 0 {
+		types = domain.EventTypes
+	}
+	for _, eventType := range types {
+		err := b.broker.QueueSubscribe(b.prefix+eventType, name, func(data []byte) {
+			received := message{}
+			if err := json.Unmarshal(data, &received); err != nil {
+				return
+			}
+			event := received.LinkEvent
+			event.URL.Owner = received.Owner
+			event.URL.PasswordHash = received.PasswordHash
+			event.URL.Reused = received.Reused
+			handler(event)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Select returns the event bus the servers are started with, by name: "local" runs the subscribers in
+// the background of the process, "memory" sends the events through a MemoryBroker, which handles them
+// before Publish returns. Clients of other brokers are added here.
+func Select(name string, prefix string) (domain.EventBus, error) {
+	switch name {
+	case "", "local":
+		return domain.NewLocalEventBus(), nil
+	case "memory":
+		return NewEventBus(NewMemoryBroker(), prefix), nil
+	}
+	return nil, errors.New("unknown event bus " + name)
+}
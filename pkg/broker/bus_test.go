@@ -0,0 +1,59 @@
+package broker
+
+import (
+	"testing"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestEventsKeepTheirUrl(t *testing.T) {
+	bus := NewEventBus(NewMemoryBroker(), "urlshortener.")
+	var received []domain.LinkEvent
+	if err := bus.Subscribe("cache", func(event domain.LinkEvent) { received = append(received, event) }, domain.EventLinkCreated); err != nil {
+		t.Fatal("Failed to subscribe", err)
+	}
+
+	bus.Publish(domain.LinkEvent{Type: domain.EventLinkVisited, URL: domain.URL{Hash: "hash"}})
+	bus.Publish(domain.LinkEvent{
+		Type: domain.EventLinkCreated,
+		URL:  domain.URL{Hash: "hash", Full: "https://example.com", PasswordHash: "bcrypt", Owner: "owner"},
+	})
+	if len(received) != 1 {
+		t.Fatal("Subscribers should only get the events they subscribed to", received)
+	}
+	if url := received[0].URL; url.Hash != "hash" || url.Full != "https://example.com" || url.PasswordHash != "bcrypt" || url.Owner != "owner" {
+		t.Fatal("Urls should keep the fields the API doesn't show, caches need them", url)
+	}
+}
+
+func TestEventsAreHandledOncePerSubscriber(t *testing.T) {
+	broker := NewMemoryBroker()
+	// two instances of the shortener with the same subscribers
+	first, second := NewEventBus(broker, "urlshortener."), NewEventBus(broker, "urlshortener.")
+	views, webhooks := 0, 0
+	for _, bus := range []domain.EventBus{first, second} {
+		bus.Subscribe("analytics", func(event domain.LinkEvent) { views++ }, domain.EventLinkVisited)
+		bus.Subscribe("webhooks", func(event domain.LinkEvent) { webhooks++ })
+	}
+
+	for i := 0; i < 4; i++ {
+		first.Publish(domain.LinkEvent{Type: domain.EventLinkVisited})
+	}
+	second.Publish(domain.LinkEvent{Type: domain.EventLinkDeleted})
+	if views != 4 || webhooks != 5 {
+		t.Fatal("Every event should be handled by one instance of each subscriber", views, webhooks)
+	}
+}
+
+func TestSelectNamesTheBus(t *testing.T) {
+	if bus, err := Select("", "urlshortener."); err != nil || bus == nil {
+		t.Fatal("The local bus should be the default", err)
+	}
+	bus, err := Select("memory", "urlshortener.")
+	if _, ok := bus.(*eventBus); err != nil || ok == false {
+		t.Fatal("The memory broker should be selectable", bus, err)
+	}
+	if _, err := Select("carrier pigeon", "urlshortener."); err == nil {
+		t.Fatal("Unknown buses should be rejected")
+	}
+}
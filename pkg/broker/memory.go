@@ -0,0 +1,52 @@
+package broker
+
+import "sync"
+
+// MemoryBroker is a Broker inside the process, messages are handled before Publish returns
+type MemoryBroker struct {
+	m sync.Mutex
+	// queues of every subject, with the subscribers of each queue
+	subjects map[string]map[string]*memoryQueue
+}
+
+type memoryQueue struct {
+	handlers []func(data []byte)
+	next     int
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subjects: map[string]map[string]*memoryQueue{}}
+}
+
+// Publish hands the message to the next subscriber of every queue of the subject, in turns
+func (b *MemoryBroker) Publish(subject string, data []byte) error {
+	b.m.Lock()
+	var handlers []func(data []byte)
+	for _, queue := range b.subjects[subject] {
+		handlers = append(handlers, queue.handlers[queue.next])
+		queue.next = (queue.next + 1) % len(queue.handlers)
+	}
+	b.m.Unlock()
+	// handlers can publish too
+	for _, handler := range handlers {
+		handler(data)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) QueueSubscribe(subject string, queue string, handler func(data []byte)) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	queues, ok := b.subjects[subject]
+	if ok == false {
+		queues = map[string]*memoryQueue{}
+		b.subjects[subject] = queues
+	}
+	q, ok := queues[queue]
+	if ok == false {
+		q = &memoryQueue{}
+		queues[queue] = q
+	}
+	q.handlers = append(q.handlers, handler)
+	return nil
+}
@@ -1,5 +1,7 @@
 package urlshortener
 
+// cachedURLShortenerService reads urls from cache first and caches the urls it creates or finds.
+// A CacheSubscriber of the EventBus of the service removes the urls that change from cache
 type cachedURLShortenerService struct {
 	cache   URLCacheRepository
 	service URLShortenerService
@@ -7,32 +9,35 @@ type cachedURLShortenerService struct {
 
 // Find will try to find url from cache first than from service
 // if a url was found it will cache it automatically
-func (s *cachedURLShortenerService) Find(urlHash string, shouldTrack bool) (URL, error) {
-	url, err := s.cache.Find(urlHash)
+func (s *cachedURLShortenerService) Find(domain string, urlHash string, shouldTrack bool) (URL, error) {
+	url, err := s.cache.Find(domain, urlHash)
 	if err != nil { // cache miss
-		url, err = s.service.Find(urlHash, shouldTrack) // get from url service
+		url, err = s.service.Find(domain, urlHash, shouldTrack) // get from url service
 		if err != nil {
 			return URL{}, err
 		}
 		// save in cache
 		go func(toCache URL) {
-			s.cache.Cache(url)
+			s.cache.Cache(toCache)
 		}(url)
 		return url, nil
 	}
 
+	// the cached url might have been blocked after it was cached
+	if err := s.service.Screen(url); err != nil {
+		return URL{}, err
+	}
+
 	if shouldTrack == true {
-		go func(hash string) {
-			s.service.RecordURLView(hash)
-		}(urlHash)
+		s.service.RecordURLView(urlHash)
 	}
 
 	return url, nil
 }
 
 // Create creates a short url and caches the value
-func (s *cachedURLShortenerService) Create(fullUrl string) (URL, error) {
-	url, err := s.service.Create(fullUrl)
+func (s *cachedURLShortenerService) Create(fullUrl string, opts CreateOptions) (URL, error) {
+	url, err := s.service.Create(fullUrl, opts)
 	if err != nil {
 		return URL{}, err
 	}
@@ -43,6 +48,7 @@ func (s *cachedURLShortenerService) Create(fullUrl string) (URL, error) {
 
 	return url, nil
 }
+
 func (s *cachedURLShortenerService) RecordURLView(urlHash string) error {
 	return s.service.RecordURLView(urlHash)
 }
@@ -51,9 +57,55 @@ func (s *cachedURLShortenerService) Stats(urlHash string) (URLViewStats, error)
 	return s.service.Stats(urlHash)
 }
 
+func (s *cachedURLShortenerService) Screen(url URL) error {
+	return s.service.Screen(url)
+}
+
+// Click counts the click with the service, the cache only keeps the limit
+func (s *cachedURLShortenerService) Click(url URL, visitor Visitor) error {
+	return s.service.Click(url, visitor)
+}
+
+// Disable disables the url and removes it from cache so it stops being served right away
+func (s *cachedURLShortenerService) Disable(domain string, urlHash string) error {
+	if err := s.service.Disable(domain, urlHash); err != nil {
+		return err
+	}
+	return s.cache.Remove(domain, urlHash)
+}
+
+// Delete deletes the url and removes it from cache so it stops being served right away
+func (s *cachedURLShortenerService) Delete(domain string, urlHash string, owner string) error {
+	if err := s.service.Delete(domain, urlHash, owner); err != nil {
+		return err
+	}
+	return s.cache.Remove(domain, urlHash)
+}
+
 func NewCachedURLShortenerService(service URLShortenerService, cacheRepo URLCacheRepository) URLShortenerService {
 	return &cachedURLShortenerService{
 		service: service,
 		cache:   cacheRepo,
 	}
 }
+
+type cachedMetadataRepository struct {
+	store URLMetadataRepository
+	cache URLCacheRepository
+}
+
+// SaveMetadata saves the metadata and removes the url from cache, it's cached again with it on the next find
+func (r *cachedMetadataRepository) SaveMetadata(domain string, urlHash string, metadata LinkMetadata) error {
+	if err := r.store.SaveMetadata(domain, urlHash, metadata); err != nil {
+		return err
+	}
+	return r.cache.Remove(domain, urlHash)
+}
+
+// NewCachedMetadataRepository keeps the cache in sync with the metadata saved by a MetadataEnricher
+func NewCachedMetadataRepository(store URLMetadataRepository, cacheRepo URLCacheRepository) URLMetadataRepository {
+	return &cachedMetadataRepository{
+		store: store,
+		cache: cacheRepo,
+	}
+}
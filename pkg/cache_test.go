@@ -13,11 +13,11 @@ func TestFindUsesCache(t *testing.T) {
 	cacheRepo := &urlCacheRepoMock{url: url}
 	cachedService := NewCachedURLShortenerService(service, cacheRepo)
 	expectedHash := "hash"
-	cache, err := cachedService.Find(expectedHash, false)
+	cache, err := cachedService.Find("brand.co", expectedHash, false)
 	if err != nil {
 		t.Fatal("Failed to get from cache:", err)
 	}
-	if cacheRepo.findCalled == false || cacheRepo.hash != expectedHash || cache.Full != url.Full {
+	if cacheRepo.findCalled == false || cacheRepo.domain != "brand.co" || cacheRepo.hash != expectedHash || cache.Full != url.Full {
 		t.Fatal("Cache repo was not used correctly", cacheRepo)
 	}
 	if service.findCalled == true {
@@ -30,7 +30,7 @@ func TestFindFallsbackToServiceWhenCacheFails(t *testing.T) {
 	cacheRepo := &urlCacheRepoMock{err: errors.New("cache error")}
 	cachedService := NewCachedURLShortenerService(service, cacheRepo)
 	expectedHash := "hash"
-	cache, err := cachedService.Find(expectedHash, true)
+	cache, err := cachedService.Find("", expectedHash, true)
 	if err != nil {
 		t.Fatal("Failed to get from cache service:", err)
 	}
@@ -50,7 +50,7 @@ func TestCachedFindRecordsUrlsViewsOnce(t *testing.T) {
 	cacheRepo := &urlCacheRepoMock{}
 	cachedService := NewCachedURLShortenerService(service, cacheRepo)
 	expectedHash := "hash"
-	_, err := cachedService.Find(expectedHash, true)
+	_, err := cachedService.Find("", expectedHash, true)
 	if err != nil {
 		t.Fatal("Failed to get from cache service:", err)
 	}
@@ -63,7 +63,7 @@ func TestCachedFindRecordsUrlsViewsOnce(t *testing.T) {
 	cacheRepo = &urlCacheRepoMock{err: errors.New("cache error")}
 	cachedService = NewCachedURLShortenerService(service, cacheRepo)
 
-	_, err = cachedService.Find(expectedHash, true)
+	_, err = cachedService.Find("", expectedHash, true)
 	if err != nil {
 		t.Fatal("Failed to get from cache service:", err)
 	}
@@ -79,7 +79,7 @@ func TestCreateCachesResult(t *testing.T) {
 	cacheRepo := &urlCacheRepoMock{}
 	cachedService := NewCachedURLShortenerService(service, cacheRepo)
 	expectedURL := "www.example.com"
-	cache, err := cachedService.Create(expectedURL)
+	cache, err := cachedService.Create(expectedURL, CreateOptions{})
 	if err != nil {
 		t.Fatal("Failed to create from cached service:", err)
 	}
@@ -88,13 +88,14 @@ func TestCreateCachesResult(t *testing.T) {
 		t.Fatal("Failed to create and cache:", service, cacheRepo)
 	}
 }
+
 func TestCreateDoesntCacheIfError(t *testing.T) {
 	t.Parallel()
 	service := &urlshortenerServiceMock{err: errors.New("service error")}
 	cacheRepo := &urlCacheRepoMock{}
 	cachedService := NewCachedURLShortenerService(service, cacheRepo)
 	expectedURL := "www.example.com"
-	_, err := cachedService.Create(expectedURL)
+	_, err := cachedService.Create(expectedURL, CreateOptions{})
 	if err != service.err {
 		t.Fatal("Cached service should have returned an error", err)
 	}
@@ -104,27 +105,77 @@ func TestCreateDoesntCacheIfError(t *testing.T) {
 	}
 }
 
+func TestCachedFindScreensCachedUrls(t *testing.T) {
+	t.Parallel()
+	service := &urlshortenerServiceMock{screenErr: ErrorURLBlocked}
+	cacheRepo := &urlCacheRepoMock{url: URL{Full: "Full URL"}}
+	cachedService := NewCachedURLShortenerService(service, cacheRepo)
+	_, err := cachedService.Find("", "hash", true)
+	if err != ErrorURLBlocked || service.screenCalled == false {
+		t.Fatal("Cached urls should be screened by the service", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if service.recordCalled != 0 {
+		t.Fatal("Views of blocked urls should not be recorded", service)
+	}
+}
+
+func TestDisableRemovesFromCache(t *testing.T) {
+	service := &urlshortenerServiceMock{}
+	cacheRepo := &urlCacheRepoMock{}
+	cachedService := NewCachedURLShortenerService(service, cacheRepo)
+	if err := cachedService.Disable("", "hash"); err != nil {
+		t.Fatal("Failed to disable url", err)
+	}
+	if service.disableCalled == false || cacheRepo.removeCalled == false || cacheRepo.hash != "hash" {
+		t.Fatal("Disabled urls should be removed from cache", service, cacheRepo)
+	}
+	service = &urlshortenerServiceMock{err: ErrorURLNotFound}
+	cacheRepo = &urlCacheRepoMock{}
+	cachedService = NewCachedURLShortenerService(service, cacheRepo)
+	if err := cachedService.Disable("", "hash"); err != ErrorURLNotFound || cacheRepo.removeCalled == true {
+		t.Fatal("Cache should not be touched when disable fails", err)
+	}
+}
+
+func TestDeleteRemovesFromCache(t *testing.T) {
+	service := &urlshortenerServiceMock{}
+	cacheRepo := &urlCacheRepoMock{}
+	cachedService := NewCachedURLShortenerService(service, cacheRepo)
+	if err := cachedService.Delete("", "hash", "owner"); err != nil {
+		t.Fatal("Failed to delete url", err)
+	}
+	if service.deleteCalled == false || cacheRepo.removeCalled == false || cacheRepo.hash != "hash" {
+		t.Fatal("Deleted urls should be removed from cache", service, cacheRepo)
+	}
+}
+
 type urlshortenerServiceMock struct {
-	m            sync.Mutex
-	findCalled   bool
-	createCalled bool
-	recordCalled int
-	statsCalled  bool
-	shouldTrack  bool
-	val          string
-	err          error
-	stats        URLViewStats
-	url          URL
+	m             sync.Mutex
+	findCalled    bool
+	createCalled  bool
+	recordCalled  int
+	statsCalled   bool
+	screenCalled  bool
+	disableCalled bool
+	deleteCalled  bool
+	clickCalled   bool
+	shouldTrack   bool
+	val           string
+	err           error
+	screenErr     error
+	stats         URLViewStats
+	url           URL
 }
 
-func (s *urlshortenerServiceMock) Find(urlHash string, shouldTrack bool) (URL, error) {
+func (s *urlshortenerServiceMock) Find(domain string, urlHash string, shouldTrack bool) (URL, error) {
 	s.findCalled = true
 	s.shouldTrack = shouldTrack
 	s.val = urlHash
 
 	return s.url, s.err
 }
-func (s *urlshortenerServiceMock) Create(url string) (URL, error) {
+func (s *urlshortenerServiceMock) Create(url string, opts CreateOptions) (URL, error) {
 	s.createCalled = true
 	s.val = url
 	return s.url, s.err
@@ -142,16 +193,38 @@ func (s *urlshortenerServiceMock) Stats(urlHash string) (URLViewStats, error) {
 	return s.stats, s.err
 }
 
+func (s *urlshortenerServiceMock) Screen(url URL) error {
+	s.screenCalled = true
+	return s.screenErr
+}
+func (s *urlshortenerServiceMock) Click(url URL, visitor Visitor) error {
+	s.clickCalled = true
+	return s.err
+}
+func (s *urlshortenerServiceMock) Disable(domain string, urlHash string) error {
+	s.disableCalled = true
+	s.val = urlHash
+	return s.err
+}
+func (s *urlshortenerServiceMock) Delete(domain string, urlHash string, owner string) error {
+	s.deleteCalled = true
+	s.val = urlHash
+	return s.err
+}
+
 type urlCacheRepoMock struct {
-	findCalled  bool
-	cacheCalled bool
-	url         URL
-	hash        string
-	err         error
+	findCalled   bool
+	cacheCalled  bool
+	removeCalled bool
+	url          URL
+	domain       string
+	hash         string
+	err          error
 }
 
-func (r *urlCacheRepoMock) Find(urlHash string) (URL, error) {
+func (r *urlCacheRepoMock) Find(domain string, urlHash string) (URL, error) {
 	r.findCalled = true
+	r.domain = domain
 	r.hash = urlHash
 	return r.url, r.err
 }
@@ -160,3 +233,29 @@ func (r *urlCacheRepoMock) Cache(url URL) error {
 	r.url = url
 	return r.err
 }
+func (r *urlCacheRepoMock) Remove(domain string, urlHash string) error {
+	r.removeCalled = true
+	r.domain = domain
+	r.hash = urlHash
+	return nil
+}
+
+func TestCachedClickDelegatesToService(t *testing.T) {
+	service := &urlshortenerServiceMock{err: ErrorURLExpired}
+	cachedService := NewCachedURLShortenerService(service, &urlCacheRepoMock{})
+	if err := cachedService.Click(URL{Hash: "hash", MaxClicks: 1}, Visitor{}); err != ErrorURLExpired || service.clickCalled == false {
+		t.Fatal("Clicks should be counted by the service", err)
+	}
+}
+
+func TestSaveMetadataRemovesFromCache(t *testing.T) {
+	store := &metadataStoreMock{saved: map[string]LinkMetadata{}}
+	cache := &urlCacheRepoMock{}
+	repo := NewCachedMetadataRepository(store, cache)
+	if err := repo.SaveMetadata("sho.rt", "hash", LinkMetadata{Title: "Example"}); err != nil {
+		t.Fatal("Saving metadata shouldn't fail", err)
+	}
+	if store.saved["hash"].Title != "Example" || cache.removeCalled == false || cache.domain != "sho.rt" || cache.hash != "hash" {
+		t.Fatal("Metadata should be saved and the url removed from cache", store.saved, cache)
+	}
+}
@@ -0,0 +1,168 @@
+package urlshortener
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxTags is how many tags a url can have
+	maxTags = 10
+	// maxTagLength keeps tags short enough to be typed in a filter
+	maxTagLength = 32
+	// maxCampaignNameLength is the longest name a campaign can have
+	maxCampaignNameLength = 100
+	// DefaultListLimit is how many urls List returns when the filter doesn't set a limit
+	DefaultListLimit = 50
+	// MaxListLimit is the most urls List returns at once
+	MaxListLimit = 100
+)
+
+// Campaign groups urls of the same owner so their stats can be added up, see CampaignStats
+type Campaign struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CampaignStats are the views of every url of a campaign added up
+type CampaignStats struct {
+	Campaign Campaign `json:"campaign"`
+	// URLs is how many urls the campaign has
+	URLs  int        `json:"urls"`
+	Views ViewCounts `json:"views"`
+}
+
+// ViewCounts are the views of several urls added up. They don't have unique counts,
+// the hash of a visitor is different for every url so visitors of several urls can't be told apart
+type ViewCounts struct {
+	PastDayCount  int `json:"past_day_count"`
+	PastWeekCount int `json:"past_week_count"`
+	Count         int `json:"count"`
+}
+
+// URLFilter selects the urls of an owner, empty fields match every url.
+// Urls are listed from the newest to the oldest.
+type URLFilter struct {
+	Tag string
+	// Campaign is the ID of a campaign
+	Campaign string
+	Limit    int
+	Offset   int
+}
+
+// URLCatalogService organizes the urls of an owner with tags and campaigns.
+// Every method is scoped to the owner, it has to be set.
+type URLCatalogService interface {
+	List(owner string, filter URLFilter) ([]URL, error)
+	CreateCampaign(owner string, name string) (Campaign, error)
+	Campaigns(owner string) ([]Campaign, error)
+	CampaignStats(owner string, campaignID string) (CampaignStats, error)
+}
+
+type urlCatalogService struct {
+	catalog   URLCatalogRepository
+	analytics URLAnalyticsRepository
+}
+
+// List returns the urls of the owner matching the filter, ErrorCampaignNotFound when
+// the filter has a campaign the owner doesn't have
+func (s *urlCatalogService) List(owner string, filter URLFilter) ([]URL, error) {
+	if len(filter.Tag) != 0 {
+		tag, err := normalizeTag(filter.Tag)
+		if err != nil {
+			return nil, err
+		}
+		filter.Tag = tag
+	}
+	if len(filter.Campaign) != 0 {
+		if _, err := s.catalog.FindCampaign(owner, filter.Campaign); err != nil {
+			return nil, err
+		}
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultListLimit
+	}
+	if filter.Limit > MaxListLimit {
+		filter.Limit = MaxListLimit
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	return s.catalog.List(owner, filter)
+}
+
+// CreateCampaign creates a campaign urls can be added to when they are created, see CreateOptions.Campaign.
+// Names are unique per owner, ErrorCampaignExists is returned when the owner already has one with that name.
+func (s *urlCatalogService) CreateCampaign(owner string, name string) (Campaign, error) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 || len(name) > maxCampaignNameLength {
+		return Campaign{}, ErrorInvalidCampaignName
+	}
+	return s.catalog.CreateCampaign(owner, name)
+}
+
+// Campaigns returns the campaigns of the owner, the oldest first
+func (s *urlCatalogService) Campaigns(owner string) ([]Campaign, error) {
+	return s.catalog.Campaigns(owner)
+}
+
+// CampaignStats adds up the views of every url of the campaign, the analytics repository counts them at once.
+// Views of split urls are not broken down by variant, their variants differ from url to url.
+func (s *urlCatalogService) CampaignStats(owner string, campaignID string) (CampaignStats, error) {
+	campaign, err := s.catalog.FindCampaign(owner, campaignID)
+	if err != nil {
+		return CampaignStats{}, err
+	}
+	hashes, err := s.catalog.CampaignURLs(owner, campaign.ID)
+	if err != nil {
+		return CampaignStats{}, err
+	}
+	views, err := s.analytics.ViewCounts(hashes)
+	if err != nil {
+		return CampaignStats{}, err
+	}
+	return CampaignStats{Campaign: campaign, URLs: len(hashes), Views: views}, nil
+}
+
+// normalizeTags lowercases the tags of a new url, drops repeated ones and sorts them
+func normalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	for _, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if contains(normalized, tag) == false {
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTags {
+		return nil, newURLValidationError(RuleTags, "urls can't have more than "+strconv.Itoa(maxTags)+" tags")
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// normalizeTag lowercases a tag, tags can have letters, digits, "-" and "_"
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if len(tag) == 0 || len(tag) > maxTagLength {
+		return "", newURLValidationError(RuleTags, "tags must have between 1 and "+strconv.Itoa(maxTagLength)+" characters")
+	}
+	for _, c := range tag {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return "", newURLValidationError(RuleTags, "tags can only have letters, digits, - and _")
+		}
+	}
+	return tag, nil
+}
+
+func NewURLCatalogService(catalog URLCatalogRepository, analytics URLAnalyticsRepository) URLCatalogService {
+	return &urlCatalogService{
+		catalog:   catalog,
+		analytics: analytics,
+	}
+}
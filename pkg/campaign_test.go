@@ -0,0 +1,141 @@
+package urlshortener
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{" Social ", "news", "social", "launch_2020"})
+	if err != nil || reflect.DeepEqual(tags, []string{"launch_2020", "news", "social"}) == false {
+		t.Fatal("Tags should be lowercased, deduplicated and sorted", tags, err)
+	}
+	if tags, err := normalizeTags(nil); err != nil || tags != nil {
+		t.Fatal("Urls without tags should stay without tags", tags, err)
+	}
+	for _, invalid := range [][]string{
+		{""},
+		{"with space"},
+		{"comma,separated"},
+		{strings.Repeat("a", maxTagLength+1)},
+		{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"},
+	} {
+		if _, err := normalizeTags(invalid); err == nil || err.(*URLValidationError).Rule != RuleTags {
+			t.Fatal("Invalid tags should be rejected", invalid, err)
+		}
+	}
+}
+
+func TestCatalogListChecksTheFilter(t *testing.T) {
+	catalog := &catalogRepoMock{campaigns: map[string]Campaign{"c1": {ID: "c1"}}}
+	service := NewURLCatalogService(catalog, &urlShortenerRepoMock{})
+
+	if _, err := service.List("owner", URLFilter{Tag: "News", Campaign: "c1", Limit: 1000, Offset: -1}); err != nil {
+		t.Fatal("Service shouldn't fail to list urls", err)
+	}
+	if catalog.filter != (URLFilter{Tag: "news", Campaign: "c1", Limit: MaxListLimit}) {
+		t.Fatal("Service should normalize the filter", catalog.filter)
+	}
+	if _, err := service.List("owner", URLFilter{}); err != nil || catalog.filter.Limit != DefaultListLimit {
+		t.Fatal("Service should use the default limit", catalog.filter, err)
+	}
+	if _, err := service.List("owner", URLFilter{Campaign: "other"}); err != ErrorCampaignNotFound {
+		t.Fatal("Service should reject campaigns the owner doesn't have", err)
+	}
+	if _, err := service.List("owner", URLFilter{Tag: "not a tag"}); err == nil {
+		t.Fatal("Service should reject invalid tags", err)
+	}
+}
+
+func TestCatalogCreateCampaignChecksTheName(t *testing.T) {
+	catalog := &catalogRepoMock{campaigns: map[string]Campaign{}}
+	service := NewURLCatalogService(catalog, &urlShortenerRepoMock{})
+	for _, name := range []string{"", "  ", strings.Repeat("a", maxCampaignNameLength+1)} {
+		if _, err := service.CreateCampaign("owner", name); err != ErrorInvalidCampaignName {
+			t.Fatal("Service should reject invalid names", name, err)
+		}
+	}
+	if campaign, err := service.CreateCampaign("owner", " Launch "); err != nil || campaign.Name != "Launch" {
+		t.Fatal("Service shouldn't fail to create campaign", campaign, err)
+	}
+}
+
+func TestCampaignStatsAddsUpEveryURL(t *testing.T) {
+	catalog := &catalogRepoMock{campaigns: map[string]Campaign{"c1": {ID: "c1", Name: "Launch"}}}
+	for i := 0; i < MaxListLimit+5; i++ {
+		catalog.urls = append(catalog.urls, URL{Hash: "h" + strconv.Itoa(i)})
+	}
+	analytics := &urlShortenerRepoMock{stats: &URLViewStats{Count: 3, PastWeekCount: 2, PastDayCount: 1}}
+	service := NewURLCatalogService(catalog, analytics)
+
+	stats, err := service.CampaignStats("owner", "c1")
+	if err != nil {
+		t.Fatal("Service shouldn't fail to add up stats", err)
+	}
+	urls := MaxListLimit + 5
+	expected := CampaignStats{
+		Campaign: Campaign{ID: "c1", Name: "Launch"},
+		URLs:     urls,
+		Views:    ViewCounts{Count: 3 * urls, PastWeekCount: 2 * urls, PastDayCount: urls},
+	}
+	if reflect.DeepEqual(stats, expected) == false || analytics.viewCountsCalls != 1 {
+		t.Fatal("Service should add up the views of every url at once", stats, analytics.viewCountsCalls)
+	}
+	if _, err := service.CampaignStats("owner", "other"); err != ErrorCampaignNotFound {
+		t.Fatal("Service should reject campaigns the owner doesn't have", err)
+	}
+}
+
+// catalogRepoMock lists its urls for every filter, paginated, and has all of them in every campaign
+type catalogRepoMock struct {
+	urls      []URL
+	campaigns map[string]Campaign
+	filter    URLFilter
+}
+
+func (r *catalogRepoMock) List(owner string, filter URLFilter) ([]URL, error) {
+	r.filter = filter
+	if filter.Offset >= len(r.urls) {
+		return []URL{}, nil
+	}
+	end := filter.Offset + filter.Limit
+	if end > len(r.urls) {
+		end = len(r.urls)
+	}
+	return r.urls[filter.Offset:end], nil
+}
+
+func (r *catalogRepoMock) CreateCampaign(owner string, name string) (Campaign, error) {
+	campaign := Campaign{ID: "c" + strconv.Itoa(len(r.campaigns)+1), Name: name}
+	r.campaigns[campaign.ID] = campaign
+	return campaign, nil
+}
+
+func (r *catalogRepoMock) FindCampaign(owner string, campaignID string) (Campaign, error) {
+	campaign, ok := r.campaigns[campaignID]
+	if ok == false {
+		return Campaign{}, ErrorCampaignNotFound
+	}
+	return campaign, nil
+}
+
+func (r *catalogRepoMock) Campaigns(owner string) ([]Campaign, error) {
+	campaigns := []Campaign{}
+	for _, campaign := range r.campaigns {
+		campaigns = append(campaigns, campaign)
+	}
+	return campaigns, nil
+}
+
+func (r *catalogRepoMock) CampaignURLs(owner string, campaignID string) ([]string, error) {
+	if _, ok := r.campaigns[campaignID]; ok == false {
+		return nil, ErrorCampaignNotFound
+	}
+	hashes := []string{}
+	for _, url := range r.urls {
+		hashes = append(hashes, url.Hash)
+	}
+	return hashes, nil
+}
@@ -0,0 +1,57 @@
+package urlshortener
+
+import (
+	"net"
+	"strings"
+)
+
+// Domains are the short domains links are served from, a link belongs to one of them.
+// The zero value is a single domain deployment, every link gets the empty domain.
+type Domains struct {
+	// Default is the domain of links created without one and the domain used for requests to unknown hosts
+	Default string
+	// Registered are the other domains links can be created on
+	Registered []string
+}
+
+// All returns the default domain, if any, followed by the registered ones
+func (d Domains) All() []string {
+	all := []string{}
+	if len(d.Default) != 0 {
+		all = append(all, canonicalDomain(d.Default))
+	}
+	for _, registered := range d.Registered {
+		all = append(all, canonicalDomain(registered))
+	}
+	return all
+}
+
+// Validate returns the domain a link asking for name belongs to,
+// the default one when name is empty or ErrorUnknownDomain if it's not configured
+func (d Domains) Validate(name string) (string, error) {
+	name = canonicalDomain(name)
+	if len(name) == 0 {
+		return canonicalDomain(d.Default), nil
+	}
+	if matchesHost(name, d.All()) {
+		return name, nil
+	}
+	return "", ErrorUnknownDomain
+}
+
+// ForHost returns the domain of a request to host, which may include a port.
+// Hosts that are not configured get the default domain.
+func (d Domains) ForHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = canonicalDomain(host)
+	if matchesHost(host, d.All()) {
+		return host
+	}
+	return canonicalDomain(d.Default)
+}
+
+func canonicalDomain(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
@@ -0,0 +1,40 @@
+package urlshortener
+
+import "testing"
+
+func TestDomainsValidate(t *testing.T) {
+	domains := Domains{Default: "sho.rt", Registered: []string{"Brand.co."}}
+	cases := map[string]string{
+		"":          "sho.rt",
+		"sho.rt":    "sho.rt",
+		"brand.co":  "brand.co",
+		"BRAND.CO.": "brand.co",
+	}
+	for name, expected := range cases {
+		if actual, err := domains.Validate(name); err != nil || actual != expected {
+			t.Fatal("Wrong domain for", name, actual, err)
+		}
+	}
+	if _, err := domains.Validate("other.com"); err != ErrorUnknownDomain {
+		t.Fatal("Unknown domains should be rejected", err)
+	}
+	if actual, err := (Domains{}).Validate(""); err != nil || actual != "" {
+		t.Fatal("Single domain deployments should use the empty domain", actual, err)
+	}
+}
+
+func TestDomainsForHost(t *testing.T) {
+	domains := Domains{Default: "sho.rt", Registered: []string{"brand.co"}}
+	cases := map[string]string{
+		"brand.co":      "brand.co",
+		"Brand.co:8080": "brand.co",
+		"sho.rt":        "sho.rt",
+		"10.0.0.1:80":   "sho.rt",
+		"":              "sho.rt",
+	}
+	for host, expected := range cases {
+		if actual := domains.ForHost(host); actual != expected {
+			t.Fatal("Wrong domain for host", host, actual, expected)
+		}
+	}
+}
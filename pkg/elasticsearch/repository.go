@@ -0,0 +1,423 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/speps/go-hashids"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+const (
+	// bulkSize is how many views are indexed with one request at most
+	bulkSize = 500
+	// flushInterval is how long views wait to be indexed at most, Stats doesn't count them before
+	flushInterval = 5 * time.Second
+	// maxPending is how many views are kept while Elasticsearch fails to index them, the oldest are dropped
+	maxPending = 100 * bulkSize
+)
+
+// viewMapping is the mapping of the index of views, visitors are only indexed when they are known
+const viewMapping = `{
+	"mappings": {
+		"properties": {
+			"hash": {"type": "keyword"},
+			"variant": {"type": "integer"},
+			"visitor": {"type": "keyword"},
+			"at": {"type": "date"}
+		}
+	}
+}`
+
+// statsQuery counts the views of a url, %s is its hash as a JSON string.
+// Stats has no series, only the counts of the past day and week, so they are two date_range buckets
+// from now rather than a date_histogram.
+// Visitors have another hash every day, so the cardinality of visitors counts them once a day
+const statsQuery = `{
+	"size": 0,
+	"track_total_hits": true,
+	"query": {"term": {"hash": %s}},
+	"aggs": {
+		"visitors": {"cardinality": {"field": "visitor"}},
+		"recent": {
+			"date_range": {
+				"field": "at",
+				"keyed": true,
+				"ranges": [{"key": "past_week", "from": "now-7d"}, {"key": "past_day", "from": "now-1d"}]
+			},
+			"aggs": {"visitors": {"cardinality": {"field": "visitor"}}}
+		},
+		"variants": {"terms": {"field": "variant", "size": 100}}
+	}
+}`
+
+// viewCountsQuery counts the views of several urls, %s is the JSON array of their hashes
+const viewCountsQuery = `{
+	"size": 0,
+	"track_total_hits": true,
+	"query": {"terms": {"hash": %s}},
+	"aggs": {
+		"recent": {
+			"date_range": {
+				"field": "at",
+				"keyed": true,
+				"ranges": [{"key": "past_week", "from": "now-7d"}, {"key": "past_day", "from": "now-1d"}]
+			}
+		}
+	}
+}`
+
+type viewDocument struct {
+	Hash    string    `json:"hash"`
+	Variant int       `json:"variant"`
+	Visitor string    `json:"visitor,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+type statsResponse struct {
+	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+	} `json:"hits"`
+	Aggregations struct {
+		Visitors cardinality `json:"visitors"`
+		Recent   struct {
+			Buckets map[string]struct {
+				DocCount int         `json:"doc_count"`
+				Visitors cardinality `json:"visitors"`
+			} `json:"buckets"`
+		} `json:"recent"`
+		Variants struct {
+			Buckets []struct {
+				Key      int `json:"key"`
+				DocCount int `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"variants"`
+	} `json:"aggregations"`
+}
+
+type cardinality struct {
+	Value int `json:"value"`
+}
+
+// bulkResponse has an item for every action of a bulk request, in the same order
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []struct {
+		Index struct {
+			Status int `json:"status"`
+		} `json:"index"`
+	} `json:"items"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// elasticsearchRepository keeps a document for every view in an Elasticsearch index and counts them
+// with aggregations. Views are indexed in bulk by Run, every flushInterval or as soon as bulkSize views
+// are queued, unique visitor counts are estimates.
+type elasticsearchRepository struct {
+	client   *http.Client
+	endpoint string
+	index    string
+	hasher   *hashids.HashID
+	now      func() time.Time
+	logger   log.Logger
+	// full wakes Run up when bulkSize views are queued
+	full chan struct{}
+	done chan struct{}
+
+	m       sync.Mutex
+	pending []viewDocument
+}
+
+// CreateURLView queues the view, it's indexed with the next bulk request
+func (r *elasticsearchRepository) CreateURLView(view domain.URLView) error {
+	if err := r.validHash(view.Hash); err != nil {
+		return err
+	}
+	r.m.Lock()
+	r.pending = append(r.pending, viewDocument{Hash: view.Hash, Variant: view.Variant, Visitor: view.Visitor, At: r.now().UTC()})
+	full := len(r.pending) >= bulkSize
+	dropped := r.trimPending()
+	r.m.Unlock()
+	if dropped > 0 {
+		r.logger.Log("msg", "dropped views", "views", dropped)
+	}
+	if full {
+		select {
+		case r.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush indexes the queued views bulkSize at a time. The views Elasticsearch fails to index are
+// queued again for the next flush, up to maxPending views, the others of their bulk are not
+func (r *elasticsearchRepository) Flush() error {
+	for {
+		r.m.Lock()
+		n := len(r.pending)
+		if n > bulkSize {
+			n = bulkSize
+		}
+		views := r.pending[:n:n]
+		r.pending = r.pending[n:]
+		r.m.Unlock()
+		if len(views) == 0 {
+			return nil
+		}
+		if failed, err := r.indexViews(views); err != nil {
+			r.m.Lock()
+			r.pending = append(failed, r.pending...)
+			dropped := r.trimPending()
+			r.m.Unlock()
+			if dropped > 0 {
+				r.logger.Log("msg", "dropped views", "views", dropped)
+			}
+			return err
+		}
+	}
+}
+
+// trimPending drops the oldest views above maxPending and returns how many, r.m must be held
+func (r *elasticsearchRepository) trimPending() int {
+	dropped := len(r.pending) - maxPending
+	if dropped <= 0 {
+		return 0
+	}
+	r.pending = r.pending[dropped:]
+	return dropped
+}
+
+// Run flushes the queued views every flushInterval and when bulkSize views are queued until stop
+// is closed, and once more then. Done is closed after that last flush
+func (r *elasticsearchRepository) Run(stop <-chan struct{}) {
+	defer close(r.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			r.flush()
+			return
+		case <-ticker.C:
+		case <-r.full:
+		}
+		r.flush()
+	}
+}
+
+// Done is closed once Run flushed the queued views for the last time
+func (r *elasticsearchRepository) Done() <-chan struct{} {
+	return r.done
+}
+
+func (r *elasticsearchRepository) flush() {
+	if err := r.Flush(); err != nil {
+		r.logger.Log("msg", "failed to index views", "err", err)
+	}
+}
+
+// indexViews indexes the views with one bulk request and returns the ones that failed with the error,
+// all of them when the request failed
+func (r *elasticsearchRepository) indexViews(views []viewDocument) ([]viewDocument, error) {
+	var body bytes.Buffer
+	for _, view := range views {
+		document, err := json.Marshal(view)
+		if err != nil {
+			return views, err
+		}
+		body.WriteString("{\"index\":{}}\n")
+		body.Write(document)
+		body.WriteString("\n")
+	}
+	response := bulkResponse{}
+	if err := r.do(http.MethodPost, "/"+r.index+"/_bulk", "application/x-ndjson", body.Bytes(), &response); err != nil {
+		return views, err
+	}
+	if response.Errors == false {
+		return nil, nil
+	}
+	if len(response.Items) != len(views) {
+		return views, errors.New("elasticsearch failed to index some views")
+	}
+	var failed []viewDocument
+	for i, item := range response.Items {
+		if item.Index.Status < 200 || item.Index.Status > 299 {
+			failed = append(failed, views[i])
+		}
+	}
+	if len(failed) == 0 {
+		return nil, nil
+	}
+	return failed, fmt.Errorf("elasticsearch failed to index %d views", len(failed))
+}
+
+func (r *elasticsearchRepository) Stats(urlHash string) (domain.URLViewStats, error) {
+	if err := r.validHash(urlHash); err != nil {
+		return domain.URLViewStats{}, err
+	}
+	hash, err := json.Marshal(urlHash)
+	if err != nil {
+		return domain.URLViewStats{}, err
+	}
+	response := statsResponse{}
+	query := fmt.Sprintf(statsQuery, hash)
+	if err := r.do(http.MethodPost, "/"+r.index+"/_search", "application/json", []byte(query), &response); err != nil {
+		return domain.URLViewStats{}, err
+	}
+	recent := response.Aggregations.Recent.Buckets
+	stats := domain.URLViewStats{
+		Count:               response.Hits.Total.Value,
+		PastWeekCount:       recent["past_week"].DocCount,
+		PastDayCount:        recent["past_day"].DocCount,
+		UniqueCount:         response.Aggregations.Visitors.Value,
+		PastWeekUniqueCount: recent["past_week"].Visitors.Value,
+		PastDayUniqueCount:  recent["past_day"].Visitors.Value,
+	}
+	for _, bucket := range response.Aggregations.Variants.Buckets {
+		if bucket.Key < 0 {
+			continue
+		}
+		for len(stats.Variants) <= bucket.Key {
+			stats.Variants = append(stats.Variants, 0)
+		}
+		stats.Variants[bucket.Key] = bucket.DocCount
+	}
+	return stats, nil
+}
+
+// ViewCounts adds up the views of the urls with one search
+func (r *elasticsearchRepository) ViewCounts(urlHashes []string) (domain.ViewCounts, error) {
+	for _, urlHash := range urlHashes {
+		if err := r.validHash(urlHash); err != nil {
+			return domain.ViewCounts{}, err
+		}
+	}
+	if len(urlHashes) == 0 {
+		return domain.ViewCounts{}, nil
+	}
+	hashes, err := json.Marshal(urlHashes)
+	if err != nil {
+		return domain.ViewCounts{}, err
+	}
+	response := statsResponse{}
+	query := fmt.Sprintf(viewCountsQuery, hashes)
+	if err := r.do(http.MethodPost, "/"+r.index+"/_search", "application/json", []byte(query), &response); err != nil {
+		return domain.ViewCounts{}, err
+	}
+	recent := response.Aggregations.Recent.Buckets
+	return domain.ViewCounts{
+		Count:         response.Hits.Total.Value,
+		PastWeekCount: recent["past_week"].DocCount,
+		PastDayCount:  recent["past_day"].DocCount,
+	}, nil
+}
+
+// createIndex creates the index of views with its mapping, unless it exists already
+func (r *elasticsearchRepository) createIndex() error {
+	err := r.do(http.MethodPut, "/"+r.index, "application/json", []byte(viewMapping), nil)
+	if err != nil && strings.Contains(err.Error(), "resource_already_exists_exception") {
+		return nil
+	}
+	return err
+}
+
+// do sends a request to Elasticsearch and decodes its JSON answer into out when it's not nil,
+// answers that are not 2xx are errors with the type of the Elasticsearch error
+func (r *elasticsearchRepository) do(method string, path string, contentType string, body []byte, out interface{}) error {
+	request, err := http.NewRequest(method, r.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	response, err := r.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		failure := errorResponse{}
+		json.NewDecoder(io.LimitReader(response.Body, 64*1024)).Decode(&failure)
+		return errors.New("elasticsearch answered " + response.Status + ": " + failure.Error.Type + " " + failure.Error.Reason)
+	}
+	if out == nil {
+		// drain the body so the connection can be reused
+		io.Copy(ioutil.Discard, response.Body)
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+// DeleteViews drops the queued views of the url and deletes its documents
+func (r *elasticsearchRepository) DeleteViews(urlHash string) error {
+	if err := r.validHash(urlHash); err != nil {
+		return err
+	}
+	r.m.Lock()
+	kept := r.pending[:0:0]
+	for _, view := range r.pending {
+		if view.Hash != urlHash {
+			kept = append(kept, view)
+		}
+	}
+	r.pending = kept
+	r.m.Unlock()
+	hash, err := json.Marshal(urlHash)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`{"query": {"term": {"hash": %s}}}`, hash)
+	return r.do(http.MethodPost, "/"+r.index+"/_delete_by_query?conflicts=proceed", "application/json", []byte(query), nil)
+}
+
+// validHash returns ErrorInvalidURL for hashes that can't be decoded, like the store does
+func (r *elasticsearchRepository) validHash(urlHash string) error {
+	if len(urlHash) == 0 {
+		return domain.ErrorInvalidURL
+	}
+	if _, err := r.hasher.DecodeInt64WithError(urlHash); err != nil {
+		return domain.ErrorInvalidURL
+	}
+	return nil
+}
+
+// NewElasticsearchRepository creates an analytics repository that indexes views in the index of
+// the Elasticsearch at esURL, creating the index when it doesn't exist. Run has to be started so
+// views are indexed when there are not enough of them for a bulk request.
+// Views recorded by other repositories before are not counted
+func NewElasticsearchRepository(esURL string, index string, timeout time.Duration, hasher *hashids.HashID, logger log.Logger) (*elasticsearchRepository, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	repo := &elasticsearchRepository{
+		client:   &http.Client{Timeout: timeout},
+		endpoint: strings.TrimSuffix(esURL, "/"),
+		index:    index,
+		hasher:   hasher,
+		now:      time.Now,
+		logger:   logger,
+		full:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if err := repo.createIndex(); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
@@ -0,0 +1,258 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yanisky/url-shortener/internal/testutils"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// standIn answers like Elasticsearch with canned responses and keeps the requests it got
+type standIn struct {
+	m         sync.Mutex
+	requests  []string
+	responses map[string]string
+	status    int
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path+"\n"+string(body))
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+	}
+	w.Write([]byte(s.responses[r.Method+" "+r.URL.Path]))
+}
+
+func (s *standIn) lastRequest() string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if len(s.requests) == 0 {
+		return ""
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+func newTestRepository(t *testing.T, es *standIn) (*elasticsearchRepository, *httptest.Server) {
+	server := httptest.NewServer(es)
+	repo, err := NewElasticsearchRepository(server.URL+"/", "views", 5*time.Second, testutils.CreateHasherForTesting("testsalt"), nil)
+	if err != nil {
+		server.Close()
+		t.Fatal("Failed to create repository", err)
+	}
+	return repo, server
+}
+
+func TestIndexIsCreatedWithMapping(t *testing.T) {
+	es := &standIn{responses: map[string]string{"PUT /views": `{"acknowledged":true}`}}
+	_, server := newTestRepository(t, es)
+	defer server.Close()
+	if request := es.lastRequest(); strings.HasPrefix(request, "PUT /views\n") == false || strings.Contains(request, `"visitor": {"type": "keyword"}`) == false {
+		t.Fatal("The index should be created with the mapping of views", request)
+	}
+
+	es.status = http.StatusBadRequest
+	es.responses["PUT /views"] = `{"error":{"type":"resource_already_exists_exception","reason":"index [views] already exists"}}`
+	if _, err := NewElasticsearchRepository(server.URL, "views", time.Second, nil, nil); err != nil {
+		t.Fatal("Existing indexes should be used", err)
+	}
+	es.responses["PUT /views"] = `{"error":{"type":"invalid_index_name_exception","reason":"invalid"}}`
+	if _, err := NewElasticsearchRepository(server.URL, "views", time.Second, nil, nil); err == nil || strings.Contains(err.Error(), "invalid_index_name_exception") == false {
+		t.Fatal("Other errors should be returned", err)
+	}
+}
+
+func TestViewsAreIndexedInBulk(t *testing.T) {
+	es := &standIn{responses: map[string]string{"POST /views/_bulk": `{"took":3,"errors":false,"items":[]}`}}
+	repo, server := newTestRepository(t, es)
+	defer server.Close()
+	now := time.Date(2020, 4, 3, 20, 48, 48, 0, time.UTC)
+	repo.now = func() time.Time { return now }
+	hash, _ := repo.hasher.EncodeInt64([]int64{8})
+
+	if err := repo.CreateURLView(domain.URLView{Hash: "invalid hash"}); err != domain.ErrorInvalidURL {
+		t.Fatal("Invalid hashes should be rejected", err)
+	}
+	repo.CreateURLView(domain.URLView{Hash: hash, Variant: domain.NoVariant})
+	repo.CreateURLView(domain.URLView{Hash: hash, Variant: 1, Visitor: "visitor"})
+	if request := es.lastRequest(); strings.HasPrefix(request, "PUT") == false {
+		t.Fatal("Views should wait for the bulk request", request)
+	}
+	if err := repo.Flush(); err != nil {
+		t.Fatal("Failed to flush views", err)
+	}
+	lines := strings.Split(es.lastRequest(), "\n")
+	if len(lines) != 6 || lines[0] != "POST /views/_bulk" || lines[1] != `{"index":{}}` || lines[3] != `{"index":{}}` {
+		t.Fatal("Views should be indexed with one bulk request", lines)
+	}
+	view := viewDocument{}
+	if err := json.Unmarshal([]byte(lines[4]), &view); err != nil || view.Hash != hash || view.Variant != 1 || view.Visitor != "visitor" || view.At.Equal(now) == false {
+		t.Fatal("Views should be indexed with their visitor and time", lines[4], err)
+	}
+	if strings.Contains(lines[2], "visitor") {
+		t.Fatal("Unknown visitors shouldn't be indexed", lines[2])
+	}
+
+	es.m.Lock()
+	es.responses["POST /views/_bulk"] = `{"took":3,"errors":true,"items":[]}`
+	es.m.Unlock()
+	repo.CreateURLView(domain.URLView{Hash: hash, Variant: domain.NoVariant})
+	if err := repo.Flush(); err == nil {
+		t.Fatal("Views Elasticsearch failed to index should be an error")
+	}
+	es.m.Lock()
+	es.responses["POST /views/_bulk"] = `{"took":3,"errors":false,"items":[]}`
+	es.m.Unlock()
+	if err := repo.Flush(); err != nil {
+		t.Fatal("Failed to flush views", err)
+	}
+	if lines := strings.Split(es.lastRequest(), "\n"); len(lines) != 4 || lines[0] != "POST /views/_bulk" {
+		t.Fatal("Views Elasticsearch failed to index should be indexed with the next flush", lines)
+	}
+}
+
+func TestOnlyFailedViewsAreIndexedAgain(t *testing.T) {
+	es := &standIn{responses: map[string]string{
+		"POST /views/_bulk": `{"took":3,"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429}},{"index":{"status":201}}]}`,
+	}}
+	repo, server := newTestRepository(t, es)
+	defer server.Close()
+	hash, _ := repo.hasher.EncodeInt64([]int64{8})
+
+	for variant := 0; variant < 3; variant++ {
+		repo.CreateURLView(domain.URLView{Hash: hash, Variant: variant})
+	}
+	if err := repo.Flush(); err == nil {
+		t.Fatal("Views Elasticsearch failed to index should be an error")
+	}
+	es.m.Lock()
+	es.responses["POST /views/_bulk"] = `{"took":3,"errors":false,"items":[{"index":{"status":201}}]}`
+	es.m.Unlock()
+	if err := repo.Flush(); err != nil {
+		t.Fatal("Failed to flush views", err)
+	}
+	lines := strings.Split(es.lastRequest(), "\n")
+	view := viewDocument{}
+	if len(lines) != 4 || json.Unmarshal([]byte(lines[2]), &view) != nil || view.Variant != 1 {
+		t.Fatal("Only the views Elasticsearch failed to index should be indexed again", lines)
+	}
+}
+
+func TestRunIndexesFullBulksRightAway(t *testing.T) {
+	es := &standIn{responses: map[string]string{"POST /views/_bulk": `{"took":3,"errors":false,"items":[]}`}}
+	repo, server := newTestRepository(t, es)
+	defer server.Close()
+	hash, _ := repo.hasher.EncodeInt64([]int64{8})
+	stop := make(chan struct{})
+	go repo.Run(stop)
+
+	for i := 0; i < bulkSize; i++ {
+		if err := repo.CreateURLView(domain.URLView{Hash: hash, Variant: domain.NoVariant}); err != nil {
+			t.Fatal("Failed to queue view", err)
+		}
+	}
+	// way before flushInterval
+	time.Sleep(100 * time.Millisecond)
+	if lines := strings.Split(es.lastRequest(), "\n"); len(lines) != 2*bulkSize+2 || lines[0] != "POST /views/_bulk" {
+		t.Fatal("Views should be indexed as soon as there are bulkSize of them", len(lines))
+	}
+
+	repo.CreateURLView(domain.URLView{Hash: hash, Variant: domain.NoVariant})
+	close(stop)
+	<-repo.Done()
+	if lines := strings.Split(es.lastRequest(), "\n"); len(lines) != 4 || lines[0] != "POST /views/_bulk" {
+		t.Fatal("Queued views should be indexed when Run stops", lines)
+	}
+}
+
+func TestStatsAreAggregated(t *testing.T) {
+	es := &standIn{responses: map[string]string{"POST /views/_search": `{
+		"took": 5,
+		"hits": {"total": {"value": 42, "relation": "eq"}, "hits": []},
+		"aggregations": {
+			"visitors": {"value": 30},
+			"recent": {"buckets": {
+				"past_week": {"from": 1585341000000, "doc_count": 12, "visitors": {"value": 9}},
+				"past_day": {"from": 1585859400000, "doc_count": 3, "visitors": {"value": 2}}
+			}},
+			"variants": {"doc_count_error_upper_bound": 0, "sum_other_doc_count": 0, "buckets": [
+				{"key": -1, "doc_count": 2},
+				{"key": 1, "doc_count": 25},
+				{"key": 0, "doc_count": 15}
+			]}
+		}
+	}`}}
+	repo, server := newTestRepository(t, es)
+	defer server.Close()
+	hash, _ := repo.hasher.EncodeInt64([]int64{8})
+
+	stats, err := repo.Stats(hash)
+	if err != nil {
+		t.Fatal("Failed to get stats", err)
+	}
+	if stats.Count != 42 || stats.PastWeekCount != 12 || stats.PastDayCount != 3 ||
+		stats.UniqueCount != 30 || stats.PastWeekUniqueCount != 9 || stats.PastDayUniqueCount != 2 {
+		t.Fatal("Stats should be read from the aggregations", stats)
+	}
+	if len(stats.Variants) != 2 || stats.Variants[0] != 15 || stats.Variants[1] != 25 {
+		t.Fatal("Views of variants should be read from their buckets", stats.Variants)
+	}
+	if request := es.lastRequest(); strings.Contains(request, `"term": {"hash": "`+hash+`"}`) == false || strings.Contains(request, `"date_range"`) == false {
+		t.Fatal("Stats should be aggregated from the views of the url", request)
+	}
+
+	es.responses["POST /views/_search"] = `{
+		"took": 5,
+		"hits": {"total": {"value": 50, "relation": "eq"}, "hits": []},
+		"aggregations": {"recent": {"buckets": {
+			"past_week": {"from": 1585341000000, "doc_count": 20},
+			"past_day": {"from": 1585859400000, "doc_count": 4}
+		}}}
+	}`
+	other, _ := repo.hasher.EncodeInt64([]int64{9})
+	counts, err := repo.ViewCounts([]string{hash, other})
+	if err != nil || counts != (domain.ViewCounts{Count: 50, PastWeekCount: 20, PastDayCount: 4}) {
+		t.Fatal("Views of the urls should be read from the aggregations", counts, err)
+	}
+	if request := es.lastRequest(); strings.Contains(request, `"terms": {"hash": ["`+hash+`","`+other+`"]}`) == false {
+		t.Fatal("Views of every url should be counted with one search", request)
+	}
+
+	es.status = http.StatusNotFound
+	es.responses["POST /views/_search"] = `{"error":{"type":"index_not_found_exception","reason":"no such index [views]"}}`
+	if _, err := repo.Stats(hash); err == nil {
+		t.Fatal("Elasticsearch errors should be returned")
+	}
+}
+
+func TestDeleteViewsDeletesDocumentsAndQueuedViews(t *testing.T) {
+	es := &standIn{responses: map[string]string{"POST /views/_delete_by_query": `{"deleted":2}`}}
+	repo, server := newTestRepository(t, es)
+	defer server.Close()
+	hash, _ := repo.hasher.EncodeInt64([]int64{8})
+	other, _ := repo.hasher.EncodeInt64([]int64{9})
+	repo.CreateURLView(domain.URLView{Hash: hash, Variant: domain.NoVariant})
+	repo.CreateURLView(domain.URLView{Hash: other, Variant: domain.NoVariant})
+
+	if err := repo.DeleteViews(hash); err != nil {
+		t.Fatal("Failed to delete views", err)
+	}
+	if request := es.lastRequest(); strings.HasPrefix(request, "POST /views/_delete_by_query\n") == false || strings.Contains(request, `{"term": {"hash": "`+hash+`"}}`) == false {
+		t.Fatal("Documents of the url should be deleted by query", request)
+	}
+	if len(repo.pending) != 1 || repo.pending[0].Hash != other {
+		t.Fatal("Only the queued views of the url should be dropped", repo.pending)
+	}
+	if err := repo.DeleteViews("invalid hash"); err != domain.ErrorInvalidURL {
+		t.Fatal("Invalid hashes should be rejected", err)
+	}
+}
@@ -7,4 +7,67 @@ import (
 var (
 	ErrorURLNotFound = errors.New("URL Not Found")
 	ErrorInvalidURL  = errors.New("Invalid URL")
+	ErrorURLBlocked  = errors.New("URL Blocked")
+	// ErrorURLExpired is returned when a url has been followed as many times as it allows
+	ErrorURLExpired = errors.New("URL Expired")
+	// ErrorUnknownDomain is returned when a link asks for a domain that is not configured, see Domains
+	ErrorUnknownDomain = errors.New("Unknown Domain")
+	// ErrorInvalidPassword is returned when a url is created with a password that can't be used
+	ErrorInvalidPassword = errors.New("Invalid Password")
+	// ErrorInvalidQueryMode is returned when a url is created with an unknown query mode, see URL.QueryMode
+	ErrorInvalidQueryMode = errors.New("Invalid Query Mode")
+	// ErrorWrongPassword is returned when the password of a protected url doesn't match
+	ErrorWrongPassword = errors.New("Wrong Password")
+	// ErrorCampaignNotFound is returned when the owner doesn't have the campaign, see Campaign
+	ErrorCampaignNotFound = errors.New("Campaign Not Found")
+	// ErrorCampaignExists is returned when the owner already has a campaign with the same name
+	ErrorCampaignExists = errors.New("Campaign Already Exists")
+	// ErrorInvalidCampaignName is returned when a campaign is created with an empty or too long name
+	ErrorInvalidCampaignName = errors.New("Invalid Campaign Name")
+	// ErrorWebhookNotFound is returned when the owner doesn't have the webhook, see Webhook
+	ErrorWebhookNotFound = errors.New("Webhook Not Found")
+	// ErrorInvalidWebhookEvent is returned when a webhook subscribes to an unknown event, see WebhookEvents
+	ErrorInvalidWebhookEvent = errors.New("Invalid Webhook Event")
+	// ErrorTooManyWebhooks is returned when the owner already has as many webhooks as it can register
+	ErrorTooManyWebhooks = errors.New("Too Many Webhooks")
 )
+
+// Rules checked when validating a url, see URLValidationError
+const (
+	RuleLength           = "length"
+	RuleMalformed        = "malformed"
+	RuleScheme           = "scheme"
+	RuleUserInfo         = "userinfo"
+	RuleHost             = "host"
+	RulePrivateHost      = "private_host"
+	RuleDeniedDomain     = "denied_domain"
+	RuleDomainNotAllowed = "domain_not_allowed"
+	RuleSelfReference    = "self_reference"
+	RuleRedirectLoop     = "redirect_loop"
+	RuleShortLink        = "short_link"
+	RuleRoutingRule      = "routing_rule"
+	RuleVariants         = "variants"
+	RuleTags             = "tags"
+	RuleTitle            = "title"
+	RuleNotes            = "notes"
+)
+
+// URLValidationError is returned when a url fails validation,
+// Rule tells which rule failed so it can be shown to API clients.
+// errors.Is(err, ErrorInvalidURL) is true for it.
+type URLValidationError struct {
+	Rule   string
+	Reason string
+}
+
+func newURLValidationError(rule string, reason string) *URLValidationError {
+	return &URLValidationError{Rule: rule, Reason: reason}
+}
+
+func (e *URLValidationError) Error() string {
+	return ErrorInvalidURL.Error() + ": " + e.Reason
+}
+
+func (e *URLValidationError) Unwrap() error {
+	return ErrorInvalidURL
+}
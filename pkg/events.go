@@ -0,0 +1,138 @@
+package urlshortener
+
+import (
+	"sync"
+	"time"
+)
+
+// Events published by the service, see EventBus
+const (
+	EventLinkCreated = "link.created"
+	// EventLinkFound is published when a url is read from the store, caches keep it
+	EventLinkFound = "link.found"
+	// EventLinkVisited is published for every view of a url, analytics record it
+	EventLinkVisited = "link.visited"
+	// EventLinkUpdated is published when a url is disabled, the only change urls can have for now
+	EventLinkUpdated = "link.updated"
+	EventLinkDeleted = "link.deleted"
+	// EventClickThreshold is published when the clicks of a url reach one of ClickThresholds
+	EventClickThreshold = "link.click_threshold"
+	// EventLinkExpired is published when a click limited url is followed for the last time
+	EventLinkExpired = "link.expired"
+)
+
+// EventTypes are all the events the service publishes
+var EventTypes = []string{
+	EventLinkCreated, EventLinkFound, EventLinkVisited, EventLinkUpdated, EventLinkDeleted, EventClickThreshold, EventLinkExpired,
+}
+
+// LinkEvent is something that happened to a url
+type LinkEvent struct {
+	Type string    `json:"type"`
+	URL  URL       `json:"url"`
+	At   time.Time `json:"at"`
+	// Clicks is how many times the url was followed, for click events
+	Clicks int `json:"clicks,omitempty"`
+	// Variant is the variant the visitor went to for visits, NoVariant otherwise
+	Variant int `json:"variant"`
+	// Visitor is the Visitor.ID of visits, when it's known
+	Visitor string `json:"visitor,omitempty"`
+}
+
+// EventHandler is called with the events a subscriber subscribed to
+type EventHandler func(event LinkEvent)
+
+// EventBus delivers the events the service publishes to subscribers, handlers must not expect
+// to be called while the service waits: side effects never slow down redirects
+type EventBus interface {
+	Publish(event LinkEvent)
+	// Subscribe calls handler with the events of the types, all of them when there are none.
+	// name identifies the subscriber, buses shared by several instances deliver every event
+	// to one instance of each name
+	Subscribe(name string, handler EventHandler, types ...string) error
+}
+
+type subscription struct {
+	handler EventHandler
+	types   []string
+}
+
+func (s subscription) matches(eventType string) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	for _, t := range s.types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// LocalEventBus delivers events to the subscribers of this instance, every handler is called in its own goroutine
+type LocalEventBus struct {
+	m             sync.RWMutex
+	subscriptions []subscription
+	wg            sync.WaitGroup
+}
+
+func NewLocalEventBus() *LocalEventBus {
+	return &LocalEventBus{}
+}
+
+func (b *LocalEventBus) Publish(event LinkEvent) {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	for _, s := range b.subscriptions {
+		if s.matches(event.Type) {
+			b.wg.Add(1)
+			go func(handler EventHandler) {
+				defer b.wg.Done()
+				handler(event)
+			}(s.handler)
+		}
+	}
+}
+
+// Subscribe never fails, there is only one instance of each subscriber
+func (b *LocalEventBus) Subscribe(name string, handler EventHandler, types ...string) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.subscriptions = append(b.subscriptions, subscription{handler: handler, types: types})
+	return nil
+}
+
+// Wait returns once the handlers of the events published so far are done
+func (b *LocalEventBus) Wait() {
+	b.wg.Wait()
+}
+
+// AnalyticsSubscriber records the views of EventLinkVisited and deletes the views of EventLinkDeleted,
+// so a url that gets the hash again doesn't start with them
+func AnalyticsSubscriber(analytics URLAnalyticsRepository) EventHandler {
+	return func(event LinkEvent) {
+		switch event.Type {
+		case EventLinkVisited:
+			analytics.CreateURLView(URLView{Hash: event.URL.Hash, Variant: event.Variant, Visitor: event.Visitor})
+		case EventLinkDeleted:
+			analytics.DeleteViews(event.URL.Hash)
+		}
+	}
+}
+
+// AnalyticsEvents are the events AnalyticsSubscriber handles
+var AnalyticsEvents = []string{EventLinkVisited, EventLinkDeleted}
+
+// CacheSubscriber removes the urls that change from cache, including the ones changed by other
+// instances when the bus is shared. The cached service caches the urls it creates and finds itself
+func CacheSubscriber(cache URLCacheRepository) EventHandler {
+	return func(event LinkEvent) {
+		switch event.Type {
+		case EventLinkUpdated, EventLinkDeleted:
+			cache.Remove(event.URL.Domain, event.URL.Hash)
+		}
+	}
+}
+
+// CacheEvents are the events CacheSubscriber handles
+var CacheEvents = []string{EventLinkUpdated, EventLinkDeleted}
@@ -0,0 +1,142 @@
+package urlshortener
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocalEventBusDeliversSubscribedEvents(t *testing.T) {
+	bus := NewLocalEventBus()
+	created, all := &eventBusMock{}, &eventBusMock{}
+	bus.Subscribe("created", created.Publish, EventLinkCreated)
+	bus.Subscribe("all", all.Publish)
+
+	bus.Publish(LinkEvent{Type: EventLinkCreated})
+	bus.Publish(LinkEvent{Type: EventLinkVisited})
+	bus.Wait()
+	if types := created.types(); len(types) != 1 || types[0] != EventLinkCreated {
+		t.Fatal("Subscribers should only get the events they subscribed to", types)
+	}
+	if types := all.types(); len(types) != 2 {
+		t.Fatal("Subscribers without types should get every event", types)
+	}
+}
+
+func TestAnalyticsSubscriberRecordsVisitsAndDeletesThem(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{}
+	handler := AnalyticsSubscriber(repoMock)
+	handler(LinkEvent{Type: EventLinkFound, URL: URL{Hash: "hash"}})
+	if repoMock.createURLViewCalled {
+		t.Fatal("Only visits should be recorded")
+	}
+	handler(LinkEvent{Type: EventLinkVisited, URL: URL{Hash: "hash"}, Variant: 1, Visitor: "visitor"})
+	if repoMock.createURLViewCalled == false || repoMock.view.Hash != "hash" || repoMock.view.Variant != 1 || repoMock.view.Visitor != "visitor" {
+		t.Fatal("Visits should be recorded with their variant and visitor", repoMock.view)
+	}
+	handler(LinkEvent{Type: EventLinkDeleted, URL: URL{Hash: "hash"}})
+	if repoMock.deleteViewsCalled == false {
+		t.Fatal("Views of deleted urls should be deleted")
+	}
+}
+
+func TestCacheSubscriberRemovesChangedUrls(t *testing.T) {
+	cacheRepo := &urlCacheRepoMock{}
+	handler := CacheSubscriber(cacheRepo)
+	for _, eventType := range []string{EventLinkVisited, EventLinkCreated, EventLinkFound} {
+		handler(LinkEvent{Type: eventType, URL: URL{Hash: "hash"}})
+	}
+	if cacheRepo.cacheCalled || cacheRepo.removeCalled {
+		t.Fatal("Only changes should touch the cache, the cached service caches urls", cacheRepo)
+	}
+	handler(LinkEvent{Type: EventLinkDeleted, URL: URL{Domain: "brand.co", Hash: "hash"}})
+	if cacheRepo.removeCalled == false || cacheRepo.domain != "brand.co" || cacheRepo.hash != "hash" {
+		t.Fatal("Deleted urls should be removed from cache", cacheRepo)
+	}
+}
+
+func TestServicePublishesEvents(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{Owner: "owner"}}
+	bus := &eventBusMock{}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{Events: bus})
+
+	if _, err := service.Create("example.com", CreateOptions{Owner: "owner"}); err != nil {
+		t.Fatal("Failed to create url", err)
+	}
+	repoMock.url.Reused = true
+	if _, err := service.Create("example.com", CreateOptions{Owner: "owner", ReuseExisting: true}); err != nil {
+		t.Fatal("Failed to create url", err)
+	}
+	repoMock.url.Reused = false
+	if _, err := service.Find("", "hash", true); err != nil {
+		t.Fatal("Failed to find url", err)
+	}
+	if err := service.Disable("", "hash"); err != nil {
+		t.Fatal("Failed to disable url", err)
+	}
+	if err := service.Delete("", "hash", "owner"); err != nil {
+		t.Fatal("Failed to delete url", err)
+	}
+	if err := service.Delete("", "hash", "other"); err != ErrorURLNotFound {
+		t.Fatal("Urls of other owners shouldn't be deleted", err)
+	}
+	expected := []string{EventLinkCreated, EventLinkFound, EventLinkFound, EventLinkVisited, EventLinkUpdated, EventLinkDeleted}
+	if types := bus.types(); len(types) != len(expected) {
+		t.Fatal("Every change of a url should be published once", types)
+	} else {
+		for i := range expected {
+			if types[i] != expected[i] {
+				t.Fatal("Every change of a url should be published once", types)
+			}
+		}
+	}
+	if repoMock.createURLViewCalled {
+		t.Fatal("Views should be recorded by the subscribers of the bus")
+	}
+}
+
+func TestClickPublishesThresholdsAndExpiry(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{clicks: 10}
+	bus := &eventBusMock{}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{Events: bus, ClickEvents: true})
+
+	if err := service.Click(URL{Hash: "anonymous"}, Visitor{}); err != nil {
+		t.Fatal("Failed to click url", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if repoMock.countClickCalled {
+		t.Fatal("Clicks of anonymous urls without a limit shouldn't be counted")
+	}
+	if err := service.Click(URL{Hash: "hash", Owner: "owner", MaxClicks: 10}, Visitor{}); err != nil {
+		t.Fatal("Failed to click url", err)
+	}
+	if types := bus.types(); len(types) != 4 || types[1] != EventClickThreshold || types[2] != EventLinkExpired || types[3] != EventLinkVisited {
+		t.Fatal("The last click of a url should publish the threshold and the expiry", types)
+	}
+}
+
+// eventBusMock keeps the published events, its Publish is an EventHandler too
+type eventBusMock struct {
+	m      sync.Mutex
+	events []LinkEvent
+}
+
+func (b *eventBusMock) Publish(event LinkEvent) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.events = append(b.events, event)
+}
+
+func (b *eventBusMock) Subscribe(name string, handler EventHandler, types ...string) error {
+	return nil
+}
+
+func (b *eventBusMock) types() []string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	var types []string
+	for _, event := range b.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
@@ -0,0 +1,140 @@
+package urlshortener
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// LinkHealth is the result of the last check of the destination of a url, see HealthChecker
+type LinkHealth struct {
+	// StatusCode is the status the destination answered with, 0 when it couldn't be reached
+	StatusCode int `json:"status_code"`
+	// LatencyMS is how long the destination took to answer, in milliseconds
+	LatencyMS int64 `json:"latency_ms"`
+	// Error tells why the destination couldn't be reached
+	Error string `json:"error,omitempty"`
+	// Broken destinations couldn't be reached or answered with an error status
+	Broken    bool      `json:"broken"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// URLHealth is a url with the health of its destination
+type URLHealth struct {
+	URL    URL        `json:"url"`
+	Health LinkHealth `json:"health"`
+}
+
+// HealthProber checks a destination, it never fails, unreachable destinations are broken
+type HealthProber interface {
+	Probe(url string) LinkHealth
+}
+
+// URLHealthService tells how the destinations of urls are doing
+type URLHealthService interface {
+	// Health returns the last check of the url, nil when it wasn't checked yet
+	Health(domain string, urlHash string) (*LinkHealth, error)
+	// Broken returns the urls of the owner with broken destinations, the last checked first
+	Broken(owner string, limit int, offset int) ([]URLHealth, error)
+}
+
+// HealthCheckerConfig holds the settings of a HealthChecker
+type HealthCheckerConfig struct {
+	// Interval is how often urls are checked
+	Interval time.Duration
+	// BatchSize is how many urls are checked at most every time the checker wakes up
+	BatchSize int
+	// Concurrency is how many urls are checked at once
+	Concurrency int
+	// Logger logs the batches Run fails to check, nothing is logged when it's nil
+	Logger log.Logger
+}
+
+// HealthChecker periodically checks the destinations of urls that are served, the ones that
+// were not checked for the longest first
+type HealthChecker struct {
+	prober HealthProber
+	repo   URLHealthRepository
+	config HealthCheckerConfig
+}
+
+// CheckDue checks the urls that were not checked in the last Interval, up to BatchSize of them.
+// It returns how many checks were saved, and the first error saving them.
+func (c *HealthChecker) CheckDue(now time.Time) (int, error) {
+	urls, err := c.repo.HealthCheckCandidates(now.Add(-c.config.Interval), c.config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	concurrency := c.config.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	saved := 0
+	var saveErr error
+	queue := make(chan URL)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for url := range queue {
+				health := c.prober.Probe(url.Full)
+				if health.CheckedAt.IsZero() {
+					health.CheckedAt = time.Now().UTC()
+				}
+				err := c.repo.SaveHealth(url.Domain, url.Hash, health)
+				m.Lock()
+				if err == nil {
+					saved++
+				} else if saveErr == nil {
+					saveErr = err
+				}
+				m.Unlock()
+			}
+		}()
+	}
+	for _, url := range urls {
+		queue <- url
+	}
+	close(queue)
+	wg.Wait()
+	return saved, saveErr
+}
+
+// Run checks the due urls every Interval until stop is closed, see runBatches
+func (c *HealthChecker) Run(stop <-chan struct{}) {
+	runBatches(stop, c.config.Interval, c.config.BatchSize, c.config.Logger, c.CheckDue)
+}
+
+func (c *HealthChecker) Health(domain string, urlHash string) (*LinkHealth, error) {
+	return c.repo.Health(domain, urlHash)
+}
+
+func (c *HealthChecker) Broken(owner string, limit int, offset int) ([]URLHealth, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return c.repo.BrokenURLs(owner, limit, offset)
+}
+
+func NewHealthChecker(prober HealthProber, repo URLHealthRepository, config HealthCheckerConfig) *HealthChecker {
+	if config.BatchSize < 1 {
+		config.BatchSize = MaxListLimit
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	return &HealthChecker{
+		prober: prober,
+		repo:   repo,
+		config: config,
+	}
+}
@@ -0,0 +1,56 @@
+package health
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+type httpProber struct {
+	client *http.Client
+}
+
+// NewHTTPProber creates a prober that asks destinations for their headers with a client like httpclient.New,
+// redirects are followed and the status of the last response is kept
+func NewHTTPProber(client *http.Client) domain.HealthProber {
+	return &httpProber{client: client}
+}
+
+// Probe sends a HEAD request and a GET request when that fails, some servers don't answer HEAD requests
+// or answer them differently. Statuses of 400 and above are broken.
+func (p *httpProber) Probe(url string) domain.LinkHealth {
+	health := p.request(http.MethodHead, url)
+	if health.Broken {
+		health = p.request(http.MethodGet, url)
+	}
+	return health
+}
+
+func (p *httpProber) request(method string, url string) domain.LinkHealth {
+	start := time.Now()
+	health := domain.LinkHealth{}
+	request, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		health.Error = err.Error()
+		health.Broken = true
+		health.CheckedAt = time.Now().UTC()
+		return health
+	}
+	response, err := p.client.Do(request)
+	health.LatencyMS = time.Since(start).Milliseconds()
+	health.CheckedAt = time.Now().UTC()
+	if err != nil {
+		health.Error = err.Error()
+		health.Broken = true
+		return health
+	}
+	// the body isn't needed, a little of it is read so the connection can be reused
+	io.CopyN(ioutil.Discard, response.Body, 4096)
+	response.Body.Close()
+	health.StatusCode = response.StatusCode
+	health.Broken = response.StatusCode >= 400
+	return health
+}
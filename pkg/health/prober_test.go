@@ -0,0 +1,54 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProbeRecordsTheStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/moved":
+			http.Redirect(w, r, "/gone", http.StatusMovedPermanently)
+		case "/get-only":
+			if r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	prober := NewHTTPProber(server.Client())
+
+	for path, expected := range map[string]int{
+		"/ok":       http.StatusOK,
+		"/get-only": http.StatusOK,
+		"/moved":    http.StatusGone,
+		"/missing":  http.StatusNotFound,
+	} {
+		health := prober.Probe(server.URL + path)
+		if health.StatusCode != expected || health.Broken != (expected >= 400) || health.CheckedAt.IsZero() {
+			t.Fatal("Probe should record the status of the destination", path, health)
+		}
+	}
+}
+
+func TestProbeMarksUnreachableDestinationsBroken(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	health := NewHTTPProber(&http.Client{Timeout: time.Second}).Probe(url)
+	if health.Broken == false || health.StatusCode != 0 || len(health.Error) == 0 {
+		t.Fatal("Unreachable destinations should be broken", health)
+	}
+}
@@ -0,0 +1,135 @@
+package urlshortener
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckDueSavesTheHealthOfCandidates(t *testing.T) {
+	repo := &healthRepoMock{
+		candidates: []URL{{Hash: "h1", Full: "https://ok.example.com"}, {Hash: "h2", Full: "https://down.example.com"}},
+		saved:      map[string]LinkHealth{},
+	}
+	prober := healthProberMock{"https://ok.example.com": {StatusCode: 200}}
+	checker := NewHealthChecker(prober, repo, HealthCheckerConfig{Interval: time.Hour, BatchSize: 10, Concurrency: 2})
+
+	now := time.Now()
+	checked, err := checker.CheckDue(now)
+	if err != nil || checked != 2 {
+		t.Fatal("Checker should check every candidate", checked, err)
+	}
+	if repo.checkedBefore.Equal(now.Add(-time.Hour)) == false || repo.limit != 10 {
+		t.Fatal("Checker should ask for the urls not checked in the last interval", repo.checkedBefore, repo.limit)
+	}
+	if health := repo.saved["h1"]; health.StatusCode != 200 || health.Broken || health.CheckedAt.IsZero() {
+		t.Fatal("Checker should save the health of reachable destinations", health)
+	}
+	if health := repo.saved["h2"]; health.Broken == false {
+		t.Fatal("Checker should save the health of broken destinations", health)
+	}
+}
+
+func TestCheckDueCountsSavedChecks(t *testing.T) {
+	repo := &healthRepoMock{
+		candidates: []URL{{Hash: "h1", Full: "https://ok.example.com"}, {Hash: "h2", Full: "https://down.example.com"}},
+		saved:      map[string]LinkHealth{},
+		saveErr:    errors.New("database is down"),
+	}
+	logger := &loggerMock{}
+	checker := NewHealthChecker(healthProberMock{}, repo, HealthCheckerConfig{Interval: time.Hour, BatchSize: 2, Logger: logger})
+	if checked, err := checker.CheckDue(time.Now()); err != repo.saveErr || checked != 0 {
+		t.Fatal("Checks that can't be saved should not be counted", checked, err)
+	}
+
+	// the batch is full, it would be checked again right away if it was counted
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		checker.Run(stop)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	<-done
+	if calls := repo.candidateCalls(); calls != 2 {
+		t.Fatal("Failing batches should wait for the next tick", calls)
+	}
+	if logger.logged() != 1 {
+		t.Fatal("Failing batches should be logged", logger.lines)
+	}
+}
+
+func TestBrokenLimitsThePage(t *testing.T) {
+	repo := &healthRepoMock{}
+	checker := NewHealthChecker(healthProberMock{}, repo, HealthCheckerConfig{Interval: time.Hour})
+	checker.Broken("owner", 1000, -1)
+	if repo.limit != MaxListLimit || repo.offset != 0 {
+		t.Fatal("Broken urls should be paginated within limits", repo.limit, repo.offset)
+	}
+	checker.Broken("owner", 0, 5)
+	if repo.limit != DefaultListLimit || repo.offset != 5 {
+		t.Fatal("Broken urls should be paginated within limits", repo.limit, repo.offset)
+	}
+}
+
+type healthProberMock map[string]LinkHealth
+
+func (p healthProberMock) Probe(url string) LinkHealth {
+	health, ok := p[url]
+	if ok == false {
+		return LinkHealth{Error: "unreachable", Broken: true}
+	}
+	return health
+}
+
+type healthRepoMock struct {
+	m             sync.Mutex
+	candidates    []URL
+	saved         map[string]LinkHealth
+	saveErr       error
+	calls         int
+	checkedBefore time.Time
+	limit         int
+	offset        int
+}
+
+func (r *healthRepoMock) HealthCheckCandidates(checkedBefore time.Time, limit int) ([]URL, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.calls++
+	r.checkedBefore = checkedBefore
+	r.limit = limit
+	return r.candidates, nil
+}
+
+func (r *healthRepoMock) SaveHealth(domain string, urlHash string, health LinkHealth) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.saveErr != nil {
+		return r.saveErr
+	}
+	r.saved[urlHash] = health
+	return nil
+}
+
+func (r *healthRepoMock) candidateCalls() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.calls
+}
+
+func (r *healthRepoMock) Health(domain string, urlHash string) (*LinkHealth, error) {
+	health, ok := r.saved[urlHash]
+	if ok == false {
+		return nil, nil
+	}
+	return &health, nil
+}
+
+func (r *healthRepoMock) BrokenURLs(owner string, limit int, offset int) ([]URLHealth, error) {
+	r.limit = limit
+	r.offset = offset
+	return nil, nil
+}
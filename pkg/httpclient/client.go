@@ -0,0 +1,43 @@
+package httpclient
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// ErrPrivateAddress is returned when a request would connect to a private address
+var ErrPrivateAddress = errors.New("destination resolves to a private address")
+
+// New creates a client for requests to destinations of urls, it follows up to maxRedirects redirects.
+// Connections to private addresses are refused unless allowPrivateHosts is set,
+// names of public looking urls can resolve to them.
+func New(timeout time.Duration, allowPrivateHosts bool, maxRedirects int) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if allowPrivateHosts == false {
+		dialer.Control = func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || domain.IsPrivateIP(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,37 @@
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	_, err := New(5*time.Second, false, 5).Get(server.URL)
+	if err == nil || strings.Contains(err.Error(), ErrPrivateAddress.Error()) == false {
+		t.Fatal("Private addresses should not be fetched", err)
+	}
+	response, err := New(5*time.Second, true, 5).Get(server.URL)
+	if err != nil {
+		t.Fatal("Private addresses should be fetched when they are allowed", err)
+	}
+	response.Body.Close()
+}
+
+func TestClientLimitsRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/again", http.StatusFound)
+	}))
+	defer server.Close()
+
+	if _, err := New(5*time.Second, true, 3).Get(server.URL); err == nil {
+		t.Fatal("Endless redirects should fail")
+	}
+}
@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// sweepInterval is how often full buckets are removed
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  domain.RateLimit
+}
+
+// refill adds the tokens earned since the last time the bucket was used
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+		b.last = now
+	}
+}
+
+type rateLimiter struct {
+	m         sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewRateLimiter creates a rate limiter that keeps its buckets in memory,
+// limits are not shared between server instances
+func NewRateLimiter() domain.RateLimiter {
+	return &rateLimiter{
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (r *rateLimiter) Allow(key string, limit domain.RateLimit) (bool, time.Duration, error) {
+	if limit.Unlimited() {
+		return true, 0, nil
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	now := r.now()
+	r.sweep(now)
+
+	b, ok := r.buckets[key]
+	if ok == false {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		r.buckets[key] = b
+	}
+	b.limit = limit
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// sweep removes the buckets that are full again, they are the same as a new bucket
+func (r *rateLimiter) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < sweepInterval {
+		return
+	}
+	r.lastSweep = now
+	for key, b := range r.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(r.buckets, key)
+		}
+	}
+}
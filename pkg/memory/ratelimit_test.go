@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestRateLimiterTakesTokens(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter().(*rateLimiter)
+	limiter.now = func() time.Time { return now }
+	limit := domain.RateLimit{Rate: 1, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := limiter.Allow("key", limit); ok == false {
+			t.Fatal("Bucket should allow a burst of 3", i)
+		}
+	}
+	ok, wait, err := limiter.Allow("key", limit)
+	if ok == true || err != nil || wait != time.Second {
+		t.Fatal("Empty bucket should wait a second for the next token", ok, wait, err)
+	}
+	if ok, _, _ := limiter.Allow("other", limit); ok == false {
+		t.Fatal("Buckets should be independent")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, wait, _ := limiter.Allow("key", limit); ok == true || wait != 500*time.Millisecond {
+		t.Fatal("Half a token should not be enough", ok, wait)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if ok, _, _ := limiter.Allow("key", limit); ok == false {
+		t.Fatal("Bucket should have refilled a token")
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	limiter := NewRateLimiter()
+	for i := 0; i < 100; i++ {
+		if ok, _, _ := limiter.Allow("key", domain.RateLimit{}); ok == false {
+			t.Fatal("Zero limit should not limit")
+		}
+	}
+}
+
+func TestRateLimiterSweepsFullBuckets(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter().(*rateLimiter)
+	limiter.now = func() time.Time { return now }
+	limit := domain.RateLimit{Rate: 1, Burst: 2}
+	limiter.Allow("key", limit)
+	now = now.Add(2 * sweepInterval)
+	limiter.Allow("other", limit)
+	if _, ok := limiter.buckets["key"]; ok == true {
+		t.Fatal("Full buckets should be removed")
+	}
+	if _, ok := limiter.buckets["other"]; ok == false {
+		t.Fatal("Buckets in use should be kept")
+	}
+}
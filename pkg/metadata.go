@@ -0,0 +1,106 @@
+package urlshortener
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+const (
+	// maxTitleLength is the longest title a url can be created with
+	maxTitleLength = 200
+	// maxNotesLength is the longest notes a url can be created with
+	maxNotesLength = 2000
+)
+
+// LinkMetadata is what the destination of a url says about itself in its <title> and Open Graph tags,
+// see MetadataEnricher
+type LinkMetadata struct {
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// MetadataFetcher fetches the metadata of a destination
+type MetadataFetcher interface {
+	Fetch(url string) (LinkMetadata, error)
+}
+
+// MetadataEnricher fetches the metadata of new urls in the background and stores it.
+// Urls are queued by the service when they are created, see ServiceConfig.Enricher.
+type MetadataEnricher struct {
+	fetcher MetadataFetcher
+	store   URLMetadataRepository
+	logger  log.Logger
+	queue   chan URL
+	wg      sync.WaitGroup
+	// m guards closed so urls created while the server stops are skipped
+	m      sync.RWMutex
+	closed bool
+}
+
+// NewMetadataEnricher starts workers that fetch metadata for up to queueSize queued urls,
+// metadata that can't be stored is logged with logger when it's not nil
+func NewMetadataEnricher(fetcher MetadataFetcher, store URLMetadataRepository, workers int, queueSize int, logger log.Logger) *MetadataEnricher {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	e := &MetadataEnricher{
+		fetcher: fetcher,
+		store:   store,
+		logger:  logger,
+		queue:   make(chan URL, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		e.wg.Add(1)
+		go e.work()
+	}
+	return e
+}
+
+// Enqueue queues the url without waiting, it returns false when the queue is full and the url is skipped.
+// Metadata is a nice to have, creating urls must not wait for other sites.
+func (e *MetadataEnricher) Enqueue(url URL) bool {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	if e.closed {
+		return false
+	}
+	select {
+	case e.queue <- url:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the workers once the queued urls are done, urls are skipped after that
+func (e *MetadataEnricher) Close() {
+	e.m.Lock()
+	if e.closed == false {
+		e.closed = true
+		close(e.queue)
+	}
+	e.m.Unlock()
+	e.wg.Wait()
+}
+
+func (e *MetadataEnricher) work() {
+	defer e.wg.Done()
+	for url := range e.queue {
+		metadata, err := e.fetcher.Fetch(url.Full)
+		if err != nil {
+			// the destination may be down or not be a page, the url is listed without metadata
+			continue
+		}
+		if metadata.FetchedAt.IsZero() {
+			metadata.FetchedAt = time.Now().UTC()
+		}
+		if err := e.store.SaveMetadata(url.Domain, url.Hash, metadata); err != nil {
+			e.logger.Log("msg", "failed to save metadata", "domain", url.Domain, "hash", url.Hash, "err", err)
+		}
+	}
+}
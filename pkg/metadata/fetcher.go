@@ -0,0 +1,157 @@
+package metadata
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// maxPageSize is how much of a page is read, <head> is at the start
+	maxPageSize = 512 * 1024
+	// maxFieldLength is the longest title, description or site name kept
+	maxFieldLength = 500
+)
+
+var errNotHTML = errors.New("destination is not an html page")
+
+type httpFetcher struct {
+	client *http.Client
+}
+
+// NewHTTPFetcher creates a fetcher that reads the <title> and Open Graph tags of html pages
+// with a client like httpclient.New
+func NewHTTPFetcher(client *http.Client) domain.MetadataFetcher {
+	return &httpFetcher{client: client}
+}
+
+func (f *httpFetcher) Fetch(pageURL string) (domain.LinkMetadata, error) {
+	request, err := http.NewRequest(http.MethodGet, pageURL, nil)
+	if err != nil {
+		return domain.LinkMetadata{}, err
+	}
+	request.Header.Set("Accept", "text/html")
+	response, err := f.client.Do(request)
+	if err != nil {
+		return domain.LinkMetadata{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return domain.LinkMetadata{}, errors.New("destination answered " + response.Status)
+	}
+	contentType := response.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "text/html" {
+		return domain.LinkMetadata{}, errNotHTML
+	}
+	body, err := charset.NewReader(io.LimitReader(response.Body, maxPageSize), contentType)
+	if err != nil {
+		return domain.LinkMetadata{}, err
+	}
+	// images are relative to the page we ended up at
+	metadata := parseHead(body, response.Request.URL)
+	metadata.FetchedAt = time.Now().UTC()
+	return metadata, nil
+}
+
+// parseHead reads the metadata from the <head> of a page, Open Graph tags take precedence over
+// <title> and the description meta tag
+func parseHead(body io.Reader, base *url.URL) domain.LinkMetadata {
+	metadata := domain.LinkMetadata{}
+	var title, description string
+	tokenizer := html.NewTokenizer(body)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return finish(metadata, title, description)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				if tokenizer.Next() == html.TextToken && len(title) == 0 {
+					title = string(tokenizer.Text())
+				}
+			case "meta":
+				content := attribute(token, "content")
+				switch strings.ToLower(attribute(token, "property")) {
+				case "og:title":
+					metadata.Title = content
+				case "og:description":
+					metadata.Description = content
+				case "og:site_name":
+					metadata.SiteName = content
+				case "og:image", "og:image:url", "og:image:secure_url":
+					if len(metadata.Image) == 0 {
+						metadata.Image = imageURL(base, content)
+					}
+				}
+				if strings.EqualFold(attribute(token, "name"), "description") {
+					description = content
+				}
+			case "body":
+				return finish(metadata, title, description)
+			}
+		case html.EndTagToken:
+			if tokenizer.Token().Data == "head" {
+				return finish(metadata, title, description)
+			}
+		}
+	}
+}
+
+// finish falls back to the <title> and description meta tag and trims every field
+func finish(metadata domain.LinkMetadata, title string, description string) domain.LinkMetadata {
+	if len(strings.TrimSpace(metadata.Title)) == 0 {
+		metadata.Title = title
+	}
+	if len(strings.TrimSpace(metadata.Description)) == 0 {
+		metadata.Description = description
+	}
+	metadata.Title = clean(metadata.Title)
+	metadata.Description = clean(metadata.Description)
+	metadata.SiteName = clean(metadata.SiteName)
+	return metadata
+}
+
+// clean collapses white space and cuts the value to maxFieldLength bytes without splitting a character
+func clean(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if len(value) <= maxFieldLength {
+		return value
+	}
+	value = value[:maxFieldLength]
+	for len(value) != 0 && utf8.ValidString(value) == false {
+		value = value[:len(value)-1]
+	}
+	return value
+}
+
+// imageURL resolves the image against the page, only http and https images are kept
+func imageURL(base *url.URL, image string) string {
+	u, err := base.Parse(strings.TrimSpace(image))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	normalized, err := domain.NormalizeURL(u.String())
+	if err != nil {
+		return ""
+	}
+	return normalized
+}
+
+func attribute(token html.Token, name string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package metadata
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yanisky/url-shortener/pkg/httpclient"
+)
+
+func TestFetchReadsOpenGraphTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/og":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<html><head>
+				<title>Page title</title>
+				<meta property="og:title" content="  Open   Graph title ">
+				<meta property="og:description" content="Open Graph description">
+				<meta property="og:image" content="/images/cover.png">
+				<meta property="og:site_name" content="Example">
+				</head><body><meta property="og:title" content="Not in head"></body></html>`))
+		case "/plain":
+			w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+			w.Write([]byte("<title>Caf\xe9 &amp; more</title><meta name=\"description\" content=\"Plain description\">"))
+		case "/redirect":
+			http.Redirect(w, r, "/og", http.StatusFound)
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	fetcher := NewHTTPFetcher(httpclient.New(5*time.Second, true, 5))
+
+	metadata, err := fetcher.Fetch(server.URL + "/redirect")
+	if err != nil {
+		t.Fatal("Failed to fetch metadata", err)
+	}
+	if metadata.Title != "Open Graph title" || metadata.Description != "Open Graph description" ||
+		metadata.Image != server.URL+"/images/cover.png" || metadata.SiteName != "Example" || metadata.FetchedAt.IsZero() {
+		t.Fatal("Open Graph tags of the head should be read", metadata)
+	}
+
+	metadata, err = fetcher.Fetch(server.URL + "/plain")
+	if err != nil || metadata.Title != "Café & more" || metadata.Description != "Plain description" {
+		t.Fatal("The title and description meta tag should be read without Open Graph tags", metadata, err)
+	}
+
+	for _, path := range []string{"/json", "/missing"} {
+		if _, err := fetcher.Fetch(server.URL + path); err == nil {
+			t.Fatal("Only html pages should be fetched", path)
+		}
+	}
+}
+
+func TestCleanCutsLongValues(t *testing.T) {
+	value := clean(strings.Repeat("é", maxFieldLength))
+	if len(value) > maxFieldLength || strings.HasSuffix(value, "é") == false {
+		t.Fatal("Long values should be cut without splitting characters", len(value))
+	}
+}
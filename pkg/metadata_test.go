@@ -0,0 +1,115 @@
+package urlshortener
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEnricherStoresFetchedMetadata(t *testing.T) {
+	fetcher := metadataFetcherMock{"https://www.example.com": {Title: "Example"}}
+	store := &metadataStoreMock{saved: map[string]LinkMetadata{}}
+	enricher := NewMetadataEnricher(fetcher, store, 2, 10, nil)
+	repoMock := &urlShortenerRepoMock{url: &URL{Hash: "h1"}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{Enricher: enricher})
+
+	if _, err := service.Create("https://www.example.com", CreateOptions{}); err != nil {
+		t.Fatal("Service shouldn't have failed:", err)
+	}
+	enricher.Enqueue(URL{Hash: "h2", Full: "https://down.example.com"})
+	enricher.Close()
+
+	if metadata, ok := store.saved["h1"]; ok == false || metadata.Title != "Example" || metadata.FetchedAt.IsZero() {
+		t.Fatal("Metadata of new urls should be stored", store.saved)
+	}
+	if _, ok := store.saved["h2"]; ok {
+		t.Fatal("Nothing should be stored when fetching fails", store.saved)
+	}
+}
+
+func TestEnqueueSkipsURLsWhenTheQueueIsFull(t *testing.T) {
+	enricher := NewMetadataEnricher(metadataFetcherMock{}, &metadataStoreMock{saved: map[string]LinkMetadata{}}, 0, 1, nil)
+	if enricher.Enqueue(URL{Hash: "h1"}) == false || enricher.Enqueue(URL{Hash: "h2"}) {
+		t.Fatal("Urls should only be queued while there is room")
+	}
+}
+
+func TestEnricherLogsMetadataItCantSave(t *testing.T) {
+	fetcher := metadataFetcherMock{"https://www.example.com": {Title: "Example"}}
+	store := &metadataStoreMock{saved: map[string]LinkMetadata{}, err: errors.New("database is down")}
+	logger := &loggerMock{}
+	enricher := NewMetadataEnricher(fetcher, store, 1, 10, logger)
+	enricher.Enqueue(URL{Hash: "h1", Full: "https://www.example.com"})
+	enricher.Close()
+	if logger.logged() != 1 {
+		t.Fatal("Metadata that can't be saved should be logged", logger.lines)
+	}
+	if enricher.Enqueue(URL{Hash: "h2", Full: "https://www.example.com"}) {
+		t.Fatal("Urls should be skipped once the enricher is closed")
+	}
+}
+
+func TestCreateChecksTitleAndNotes(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	if _, err := service.Create("https://www.example.com", CreateOptions{Title: " Docs ", Notes: " For the team ", ReuseExisting: true}); err != nil {
+		t.Fatal("Service shouldn't have failed:", err)
+	}
+	if repoMock.opts.Title != "Docs" || repoMock.opts.Notes != "For the team" || repoMock.opts.ReuseExisting {
+		t.Fatal("Title and notes should be trimmed and not reuse urls", repoMock.opts)
+	}
+	for _, opts := range []CreateOptions{
+		{Title: strings.Repeat("a", maxTitleLength+1)},
+		{Notes: strings.Repeat("a", maxNotesLength+1)},
+	} {
+		if _, err := service.Create("https://www.example.com", opts); errors.Is(err, ErrorInvalidURL) == false {
+			t.Fatal("Long titles and notes should be rejected", err)
+		}
+	}
+}
+
+type metadataFetcherMock map[string]LinkMetadata
+
+func (f metadataFetcherMock) Fetch(url string) (LinkMetadata, error) {
+	metadata, ok := f[url]
+	if ok == false {
+		return LinkMetadata{}, errors.New("unreachable")
+	}
+	return metadata, nil
+}
+
+type metadataStoreMock struct {
+	m     sync.Mutex
+	saved map[string]LinkMetadata
+	err   error
+}
+
+func (s *metadataStoreMock) SaveMetadata(domain string, urlHash string, metadata LinkMetadata) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.saved[urlHash] = metadata
+	return nil
+}
+
+// loggerMock keeps the lines logged by background workers
+type loggerMock struct {
+	m     sync.Mutex
+	lines [][]interface{}
+}
+
+func (l *loggerMock) Log(keyvals ...interface{}) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.lines = append(l.lines, keyvals)
+	return nil
+}
+
+func (l *loggerMock) logged() int {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return len(l.lines)
+}
@@ -0,0 +1,36 @@
+package urlshortener
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxPasswordLength is the most bcrypt can hash, longer passwords would be truncated
+const maxPasswordLength = 72
+
+// Protected is true when visitors need a password to follow the url
+func (u URL) Protected() bool {
+	return len(u.PasswordHash) != 0
+}
+
+// CheckPassword returns ErrorWrongPassword unless password is the password of the url
+func (u URL) CheckPassword(password string) error {
+	if u.Protected() == false {
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return ErrorWrongPassword
+	}
+	return nil
+}
+
+// hashPassword hashes the password of a new protected url
+func hashPassword(password string) (string, error) {
+	if len(password) > maxPasswordLength {
+		return "", ErrorInvalidPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
@@ -0,0 +1,27 @@
+package urlshortener
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCreateHashesPasswords(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	if _, err := service.Create("example.com", CreateOptions{Password: "letmein", ReuseExisting: true}); err != nil {
+		t.Fatal("Service shouldn't have failed:", err)
+	}
+	if len(repoMock.opts.Password) != 0 || repoMock.opts.ReuseExisting {
+		t.Fatal("The store should get the hash only and protected urls should not be reused", repoMock.opts)
+	}
+	url := URL{PasswordHash: repoMock.opts.PasswordHash}
+	if url.Protected() == false || url.CheckPassword("letmein") != nil || url.CheckPassword("nope") != ErrorWrongPassword {
+		t.Fatal("Password should have been hashed", repoMock.opts.PasswordHash)
+	}
+	if _, err := service.Create("example.com", CreateOptions{Password: strings.Repeat("a", 73)}); err != ErrorInvalidPassword {
+		t.Fatal("Passwords bcrypt can't hash should be rejected", err)
+	}
+	if (URL{}).CheckPassword("") != nil {
+		t.Fatal("Urls without password don't need one")
+	}
+}
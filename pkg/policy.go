@@ -0,0 +1,110 @@
+package urlshortener
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// URLPolicy holds the rules a url has to follow to be shortened on top of NormalizeURL.
+// The zero value rejects private hosts, keeps fragments and allows every other domain.
+type URLPolicy struct {
+	// StripFragment removes the #fragment from urls
+	StripFragment bool
+	// AllowPrivateHosts allows loopback, private and link-local IPs and single label hosts like localhost
+	AllowPrivateHosts bool
+	// AllowedDomains when not empty only these domains and their subdomains can be shortened
+	AllowedDomains []string
+	// DeniedDomains can't be shortened, neither their subdomains. It takes precedence over AllowedDomains
+	DeniedDomains []string
+}
+
+// Normalize validates the url with NormalizeURL and then applies the policy rules.
+// The returned error is a *URLValidationError telling which rule failed.
+func (p URLPolicy) Normalize(rawURL string) (string, error) {
+	normalized, err := NormalizeURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(normalized)
+	if err != nil {
+		return "", newURLValidationError(RuleMalformed, "url is malformed")
+	}
+	host := u.Hostname()
+
+	if p.AllowPrivateHosts == false && isPrivateHost(host) {
+		return "", newURLValidationError(RulePrivateHost, "urls to private or local hosts are not allowed")
+	}
+	if matchesDomain(host, p.DeniedDomains) {
+		return "", newURLValidationError(RuleDeniedDomain, "urls to "+host+" are not allowed")
+	}
+	if len(p.AllowedDomains) != 0 && matchesDomain(host, p.AllowedDomains) == false {
+		return "", newURLValidationError(RuleDomainNotAllowed, "urls to "+host+" are not allowed")
+	}
+
+	if p.StripFragment {
+		u.Fragment = ""
+		normalized = u.String()
+	}
+	return normalized, nil
+}
+
+// isPrivateHost only looks at the host as written in the url, names are not resolved
+func isPrivateHost(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return strings.Contains(host, ".") == false || host == "localhost" || strings.HasSuffix(host, ".localhost")
+	}
+	return IsPrivateIP(ip)
+}
+
+// IsPrivateIP is true for loopback, private, link-local and unspecified addresses.
+// Clients that fetch urls check the addresses they connect to with it, names can resolve to private IPs.
+func IsPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, block := range privateIPBlocks {
+		if block.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var privateIPBlocks = parseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"0.0.0.0/8",     // this network
+	"100.64.0.0/10", // carrier-grade NAT
+	"fc00::/7",      // unique local addresses
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	blocks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, block, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		blocks[i] = block
+	}
+	return blocks
+}
+
+// matchesDomain is true when host is one of the domains or a subdomain of one of them
+func matchesDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+		if err != nil || len(domain) == 0 {
+			continue
+		}
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
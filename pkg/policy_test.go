@@ -0,0 +1,85 @@
+package urlshortener
+
+import (
+	"testing"
+)
+
+func TestPolicyRejectsPrivateHosts(t *testing.T) {
+	cases := []string{
+		"localhost",
+		"http://localhost:8080/admin",
+		"http://app.localhost",
+		"intranet/wiki",
+		"http://127.0.0.1",
+		"http://10.1.2.3",
+		"http://172.16.0.1",
+		"http://192.168.1.1",
+		"http://169.254.169.254/latest/meta-data",
+		"http://0.0.0.0",
+		"http://0.1.2.3",
+		"http://100.64.1.1",
+		"http://100.127.255.254",
+		"http://[::1]/",
+		"http://[fe80::1]/",
+		"http://[fd00::1]/",
+		"http://[::ffff:10.0.0.1]/",
+	}
+	policy := URLPolicy{}
+	for _, url := range cases {
+		_, err := policy.Normalize(url)
+		validationErr, ok := err.(*URLValidationError)
+		if ok == false || validationErr.Rule != RulePrivateHost {
+			t.Fatal("Private host should fail validation:", url, err)
+		}
+	}
+	policy = URLPolicy{AllowPrivateHosts: true}
+	for _, url := range cases {
+		if _, err := policy.Normalize(url); err != nil {
+			t.Fatal("Private host should be allowed by policy:", url, err)
+		}
+	}
+}
+
+func TestPolicyDomainLists(t *testing.T) {
+	policy := URLPolicy{
+		AllowedDomains: []string{"example.com", "Bücher.example"},
+		DeniedDomains:  []string{"private.example.com"},
+	}
+	allowed := []string{
+		"example.com",
+		"https://www.example.com/path",
+		"http://xn--bcher-kva.example",
+		"http://bücher.example",
+	}
+	for _, url := range allowed {
+		if _, err := policy.Normalize(url); err != nil {
+			t.Fatal("Url should be allowed:", url, err)
+		}
+	}
+	rules := map[string]string{
+		"http://notexample.com":             RuleDomainNotAllowed,
+		"http://example.org":                RuleDomainNotAllowed,
+		"http://private.example.com":        RuleDeniedDomain,
+		"http://www.private.example.com/a":  RuleDeniedDomain,
+		"http://www.private.example.com./a": RuleDeniedDomain,
+	}
+	for url, rule := range rules {
+		_, err := policy.Normalize(url)
+		validationErr, ok := err.(*URLValidationError)
+		if ok == false || validationErr.Rule != rule {
+			t.Fatal("Url should have failed with rule:", url, rule, err)
+		}
+	}
+}
+
+func TestPolicyStripsFragment(t *testing.T) {
+	url := "https://www.example.com/?query=30#test"
+	res, err := URLPolicy{}.Normalize(url)
+	if err != nil || res != url {
+		t.Fatal("Fragment should be kept by default", res, err)
+	}
+	res, err = URLPolicy{StripFragment: true}.Normalize(url)
+	if err != nil || res != "https://www.example.com/?query=30" {
+		t.Fatal("Fragment should have been removed", res, err)
+	}
+}
@@ -0,0 +1,171 @@
+package postgresql
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/jackc/pgx/v4"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// List returns the urls of the owner matching the filter, the newest first
+func (r *postgreSQLRepository) List(owner string, filter domain.URLFilter) ([]domain.URL, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	sql := "SELECT " + urlColumns + " FROM urls WHERE owner=$1"
+	args := []interface{}{owner}
+	if len(filter.Tag) != 0 {
+		args = append(args, filter.Tag)
+		sql += " AND id IN (SELECT url_id FROM url_tags WHERE tag=$" + strconv.Itoa(len(args)) + ")"
+	}
+	if len(filter.Campaign) != 0 {
+		campaignID, err := r.campaignID(ctx, owner, filter.Campaign)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, campaignID)
+		sql += " AND campaign_id=$" + strconv.Itoa(len(args))
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	sql += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	rows, err := r.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	urls := []domain.URL{}
+	for rows.Next() {
+		url, err := r.scanURL(rows)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+	return urls, rows.Err()
+}
+
+// CreateCampaign returns ErrorCampaignExists when the owner already has a campaign with the name
+func (r *postgreSQLRepository) CreateCampaign(owner string, name string) (domain.Campaign, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	var id int64
+	campaign := domain.Campaign{Name: name}
+	err := r.conn.QueryRow(
+		ctx,
+		"INSERT INTO campaigns (owner, name) VALUES ($1, $2) ON CONFLICT (owner, name) DO NOTHING RETURNING id, created_at",
+		owner,
+		name,
+	).Scan(&id, &campaign.CreatedAt)
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return domain.Campaign{}, domain.ErrorCampaignExists
+		}
+		return domain.Campaign{}, err
+	}
+	if campaign.ID, err = r.hasher.EncodeInt64([]int64{id}); err != nil {
+		return domain.Campaign{}, err
+	}
+	return campaign, nil
+}
+
+func (r *postgreSQLRepository) FindCampaign(owner string, campaignID string) (domain.Campaign, error) {
+	ids, err := r.hasher.DecodeInt64WithError(campaignID)
+	if err != nil || len(campaignID) == 0 {
+		return domain.Campaign{}, domain.ErrorCampaignNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	campaign := domain.Campaign{ID: campaignID}
+	err = r.conn.QueryRow(ctx, "SELECT name, created_at FROM campaigns WHERE id=$1 AND owner=$2", ids[0], owner).Scan(
+		&campaign.Name,
+		&campaign.CreatedAt,
+	)
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return domain.Campaign{}, domain.ErrorCampaignNotFound
+		}
+		return domain.Campaign{}, err
+	}
+	return campaign, nil
+}
+
+// Campaigns returns the campaigns of the owner, the oldest first
+func (r *postgreSQLRepository) Campaigns(owner string) ([]domain.Campaign, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	rows, err := r.conn.Query(ctx, "SELECT id, name, created_at FROM campaigns WHERE owner=$1 ORDER BY id", owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	campaigns := []domain.Campaign{}
+	for rows.Next() {
+		var id int64
+		campaign := domain.Campaign{}
+		if err := rows.Scan(&id, &campaign.Name, &campaign.CreatedAt); err != nil {
+			return nil, err
+		}
+		if campaign.ID, err = r.hasher.EncodeInt64([]int64{id}); err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, campaign)
+	}
+	return campaigns, rows.Err()
+}
+
+// CampaignURLs returns the hashes of the urls of the campaign of the owner, the newest first
+func (r *postgreSQLRepository) CampaignURLs(owner string, campaignID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	id, err := r.campaignID(ctx, owner, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if id == nil {
+		return nil, domain.ErrorCampaignNotFound
+	}
+	rows, err := r.conn.Query(ctx, "SELECT id FROM urls WHERE campaign_id=$1 ORDER BY id DESC", *id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := []string{}
+	for rows.Next() {
+		var urlID int64
+		if err := rows.Scan(&urlID); err != nil {
+			return nil, err
+		}
+		hash, err := r.hasher.EncodeInt64([]int64{urlID})
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// campaignID returns the id of a campaign of the owner, nil when campaignID is empty
+func (r *postgreSQLRepository) campaignID(ctx context.Context, owner string, campaignID string) (*int64, error) {
+	if len(campaignID) == 0 {
+		return nil, nil
+	}
+	ids, err := r.hasher.DecodeInt64WithError(campaignID)
+	if err != nil {
+		return nil, domain.ErrorCampaignNotFound
+	}
+	var id int64
+	err = r.conn.QueryRow(ctx, "SELECT id FROM campaigns WHERE id=$1 AND owner=$2", ids[0], owner).Scan(&id)
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return nil, domain.ErrorCampaignNotFound
+		}
+		return nil, err
+	}
+	return &id, nil
+}
@@ -0,0 +1,157 @@
+package postgresql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yanisky/url-shortener/internal/testutils"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestFindCampaignShouldReturnCampaignNotFound(t *testing.T) {
+	for _, id := range []string{"", "invalid"} {
+		if _, err := testRepo.FindCampaign("owner", id); err != domain.ErrorCampaignNotFound {
+			t.Fatal("Invalid campaign ids should not be found", id, err)
+		}
+	}
+}
+
+func TestCampaignsBelongToTheirOwner(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	campaign, err := testRepo.CreateCampaign("owner", "Launch")
+	if err != nil || len(campaign.ID) == 0 || campaign.Name != "Launch" {
+		t.Fatal("Repo shouldn't fail to create campaign:", campaign, err)
+	}
+	if _, err := testRepo.CreateCampaign("owner", "Launch"); err != domain.ErrorCampaignExists {
+		t.Fatal("Campaign names should be unique per owner:", err)
+	}
+	if _, err := testRepo.CreateCampaign("other", "Launch"); err != nil {
+		t.Fatal("Other owners should be able to use the same name:", err)
+	}
+	if found, err := testRepo.FindCampaign("owner", campaign.ID); err != nil || found.Name != "Launch" {
+		t.Fatal("Repo should find the campaign of the owner:", found, err)
+	}
+	if _, err := testRepo.FindCampaign("other", campaign.ID); err != domain.ErrorCampaignNotFound {
+		t.Fatal("Repo shouldn't find campaigns of other owners:", err)
+	}
+	if campaigns, err := testRepo.Campaigns("owner"); err != nil || len(campaigns) != 1 || campaigns[0].ID != campaign.ID {
+		t.Fatal("Repo should list the campaigns of the owner:", campaigns, err)
+	}
+	if _, err := testRepo.Create("www.example.com", domain.CreateOptions{Owner: "other", Campaign: campaign.ID}); err != domain.ErrorCampaignNotFound {
+		t.Fatal("Urls can't be added to campaigns of other owners:", err)
+	}
+}
+
+func TestListFiltersByTagAndCampaign(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	campaign, err := testRepo.CreateCampaign("owner", "Launch")
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create campaign:", err)
+	}
+	tagged, err := testRepo.Create("www.example.com/a", domain.CreateOptions{Owner: "owner", Tags: []string{"news", "social"}, Campaign: campaign.ID})
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	if res, err := testRepo.Find("", tagged.Hash); err != nil || reflect.DeepEqual(res.Tags, []string{"news", "social"}) == false || res.Campaign != campaign.ID {
+		t.Fatal("Repo should return the tags and campaign:", res, err)
+	}
+	untagged, err := testRepo.Create("www.example.com/b", domain.CreateOptions{Owner: "owner"})
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	if _, err := testRepo.Create("www.example.com/c", domain.CreateOptions{Owner: "other", Tags: []string{"news"}}); err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+
+	for _, test := range []struct {
+		filter   domain.URLFilter
+		expected []string
+	}{
+		{filter: domain.URLFilter{Limit: 10}, expected: []string{untagged.Hash, tagged.Hash}},
+		{filter: domain.URLFilter{Limit: 1}, expected: []string{untagged.Hash}},
+		{filter: domain.URLFilter{Limit: 10, Offset: 1}, expected: []string{tagged.Hash}},
+		{filter: domain.URLFilter{Tag: "news", Limit: 10}, expected: []string{tagged.Hash}},
+		{filter: domain.URLFilter{Tag: "other", Limit: 10}, expected: []string{}},
+		{filter: domain.URLFilter{Campaign: campaign.ID, Limit: 10}, expected: []string{tagged.Hash}},
+	} {
+		urls, err := testRepo.List("owner", test.filter)
+		if err != nil {
+			t.Fatal("Repo shouldn't fail to list urls:", test.filter, err)
+		}
+		hashes := []string{}
+		for _, url := range urls {
+			hashes = append(hashes, url.Hash)
+		}
+		if reflect.DeepEqual(hashes, test.expected) == false {
+			t.Fatal("Repo should list the urls of the owner matching the filter:", test.filter, hashes, test.expected)
+		}
+	}
+}
+
+func TestCampaignViewsAreAddedUp(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateAllTables(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	campaign, err := testRepo.CreateCampaign("owner", "Launch")
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create campaign:", err)
+	}
+	if _, err := testRepo.CampaignURLs("other", campaign.ID); err != domain.ErrorCampaignNotFound {
+		t.Fatal("Campaigns of other owners should not be found:", err)
+	}
+	var hashes []string
+	for _, full := range []string{"www.example.com/a", "www.example.com/b"} {
+		url, err := testRepo.Create(full, domain.CreateOptions{Owner: "owner", Campaign: campaign.ID})
+		if err != nil {
+			t.Fatal("Repo shouldn't fail to create url:", err)
+		}
+		hashes = append([]string{url.Hash}, hashes...)
+	}
+	if _, err := testRepo.Create("www.example.com/c", domain.CreateOptions{Owner: "owner"}); err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	if urls, err := testRepo.CampaignURLs("owner", campaign.ID); err != nil || reflect.DeepEqual(urls, hashes) == false {
+		t.Fatal("Repo should return the urls of the campaign:", urls, hashes, err)
+	}
+
+	now := time.Now().UTC()
+	for i, at := range []time.Time{now.AddDate(0, 0, -30), now.AddDate(0, 0, -3), now} {
+		ids, _ := testHasher.DecodeInt64WithError(hashes[i%2])
+		if err := testutils.InsertUrlView(testRepo.conn, ids[0], at); err != nil {
+			t.Fatal("Failed to insert with testutils", err)
+		}
+	}
+	expected := domain.ViewCounts{Count: 3, PastWeekCount: 2, PastDayCount: 1}
+	if counts, err := testRepo.ViewCounts(hashes); err != nil || counts != expected {
+		t.Fatal("Views of the urls should be added up", counts, err)
+	}
+	if err := testRepo.RollUpViews(now.Add(-time.Hour).Truncate(time.Hour)); err != nil {
+		t.Fatal("Failed to roll up views", err)
+	}
+	if counts, err := testRepo.ViewCounts(hashes); err != nil || counts != expected {
+		t.Fatal("Rolled up views should be added up too", counts, err)
+	}
+}
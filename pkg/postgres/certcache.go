@@ -0,0 +1,56 @@
+package postgresql
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// postgreSQLCertCache is an autocert.Cache stored in the acme_certs table,
+// so every instance behind a load balancer shares the same certificates
+type postgreSQLCertCache struct {
+	conn    *pgxpool.Pool
+	timeout time.Duration
+}
+
+func NewPostgreSQLCertCache(dbURL string, timeout time.Duration) (*postgreSQLCertCache, error) {
+	conn, err := createConnectionPool(dbURL, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &postgreSQLCertCache{conn: conn, timeout: timeout}, nil
+}
+
+func (c *postgreSQLCertCache) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	var data []byte
+	err := c.conn.QueryRow(ctx, "SELECT data FROM acme_certs WHERE key=$1", key).Scan(&data)
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return nil, autocert.ErrCacheMiss
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (c *postgreSQLCertCache) Put(ctx context.Context, key string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	_, err := c.conn.Exec(ctx,
+		"INSERT INTO acme_certs (key, data) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET data=EXCLUDED.data, updated_at=NOW()",
+		key, data,
+	)
+	return err
+}
+
+func (c *postgreSQLCertCache) Delete(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	_, err := c.conn.Exec(ctx, "DELETE FROM acme_certs WHERE key=$1", key)
+	return err
+}
@@ -0,0 +1,34 @@
+package postgresql
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestCertCacheShouldStoreAndDeleteCertificates(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	cache := &postgreSQLCertCache{conn: testRepo.conn, timeout: testRepo.timeout}
+	ctx := context.Background()
+	if _, err := cache.Get(ctx, "sho.rt"); err != autocert.ErrCacheMiss {
+		t.Fatal("Cache should miss unknown keys but got:", err)
+	}
+	if err := cache.Put(ctx, "sho.rt", []byte("first")); err != nil {
+		t.Fatal("Failed to put certificate", err)
+	}
+	if err := cache.Put(ctx, "sho.rt", []byte("second")); err != nil {
+		t.Fatal("Failed to replace certificate", err)
+	}
+	if data, err := cache.Get(ctx, "sho.rt"); err != nil || string(data) != "second" {
+		t.Fatal("Cache should return the last certificate", string(data), err)
+	}
+	if err := cache.Delete(ctx, "sho.rt"); err != nil {
+		t.Fatal("Failed to delete certificate", err)
+	}
+	if _, err := cache.Get(ctx, "sho.rt"); err != autocert.ErrCacheMiss {
+		t.Fatal("Deleted certificates should miss but got:", err)
+	}
+}
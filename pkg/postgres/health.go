@@ -0,0 +1,125 @@
+package postgresql
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// HealthCheckCandidates returns urls that are not disabled and were not checked since checkedBefore,
+// the ones never checked first and then the ones checked the longest ago
+func (r *postgreSQLRepository) HealthCheckCandidates(checkedBefore time.Time, limit int) ([]domain.URL, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	rows, err := r.conn.Query(
+		ctx,
+		`SELECT `+urlColumns+` FROM urls LEFT JOIN url_health ON url_health.url_id=urls.id
+		WHERE disabled=false AND (checked_at IS NULL OR checked_at < $1)
+		ORDER BY checked_at NULLS FIRST LIMIT $2`,
+		checkedBefore,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	urls := []domain.URL{}
+	for rows.Next() {
+		url, err := r.scanURL(rows)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+	return urls, rows.Err()
+}
+
+// SaveHealth replaces the last check of the url
+func (r *postgreSQLRepository) SaveHealth(urlDomain string, urlHash string, health domain.LinkHealth) error {
+	if len(urlHash) == 0 {
+		return domain.ErrorInvalidURL
+	}
+	ids, err := r.hasher.DecodeInt64WithError(urlHash)
+	if err != nil {
+		return domain.ErrorInvalidURL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	_, err = r.conn.Exec(
+		ctx,
+		`INSERT INTO url_health (url_id, status_code, latency_ms, error, broken, checked_at)
+		SELECT id, $3, $4, $5, $6, $7 FROM urls WHERE id=$1 AND domain=$2
+		ON CONFLICT (url_id) DO UPDATE SET status_code=EXCLUDED.status_code, latency_ms=EXCLUDED.latency_ms,
+		error=EXCLUDED.error, broken=EXCLUDED.broken, checked_at=EXCLUDED.checked_at`,
+		ids[0],
+		urlDomain,
+		health.StatusCode,
+		health.LatencyMS,
+		health.Error,
+		health.Broken,
+		health.CheckedAt,
+	)
+	return err
+}
+
+func (r *postgreSQLRepository) Health(urlDomain string, urlHash string) (*domain.LinkHealth, error) {
+	if len(urlHash) == 0 {
+		return nil, domain.ErrorInvalidURL
+	}
+	ids, err := r.hasher.DecodeInt64WithError(urlHash)
+	if err != nil {
+		return nil, domain.ErrorInvalidURL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	health := &domain.LinkHealth{}
+	err = r.conn.QueryRow(
+		ctx,
+		`SELECT status_code, latency_ms, error, broken, checked_at FROM url_health
+		JOIN urls ON urls.id=url_health.url_id WHERE url_id=$1 AND domain=$2`,
+		ids[0],
+		urlDomain,
+	).Scan(&health.StatusCode, &health.LatencyMS, &health.Error, &health.Broken, &health.CheckedAt)
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return health, nil
+}
+
+// BrokenURLs returns the urls of the owner whose last check was broken, the last checked first
+func (r *postgreSQLRepository) BrokenURLs(owner string, limit int, offset int) ([]domain.URLHealth, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	rows, err := r.conn.Query(
+		ctx,
+		`SELECT `+urlColumns+`, status_code, latency_ms, error, broken, checked_at
+		FROM urls JOIN url_health ON url_health.url_id=urls.id
+		WHERE owner=$1 AND broken ORDER BY checked_at DESC, url_id DESC LIMIT $2 OFFSET $3`,
+		owner,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	broken := []domain.URLHealth{}
+	for rows.Next() {
+		health := domain.LinkHealth{}
+		url, err := r.scanURL(rows, &health.StatusCode, &health.LatencyMS, &health.Error, &health.Broken, &health.CheckedAt)
+		if err != nil {
+			return nil, err
+		}
+		broken = append(broken, domain.URLHealth{URL: url, Health: health})
+	}
+	return broken, rows.Err()
+}
@@ -0,0 +1,68 @@
+package postgresql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yanisky/url-shortener/internal/testutils"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestHealthShouldReturnInvalidUrl(t *testing.T) {
+	if _, err := testRepo.Health("", "invalid"); err != domain.ErrorInvalidURL {
+		t.Fatal("Repo should fail with invalid url", err)
+	}
+	if err := testRepo.SaveHealth("", "", domain.LinkHealth{}); err != domain.ErrorInvalidURL {
+		t.Fatal("Repo should fail with invalid url", err)
+	}
+}
+
+func TestHealthChecksAreStored(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	ok, err := testRepo.Create("www.example.com", domain.CreateOptions{Owner: "owner"})
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	broken, err := testRepo.Create("www.example.org", domain.CreateOptions{Owner: "owner"})
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	if health, err := testRepo.Health("", ok.Hash); err != nil || health != nil {
+		t.Fatal("Urls that were not checked shouldn't have health:", health, err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	if urls, err := testRepo.HealthCheckCandidates(now, 10); err != nil || len(urls) != 2 {
+		t.Fatal("Urls that were never checked should be candidates:", urls, err)
+	}
+
+	if err := testRepo.SaveHealth("", ok.Hash, domain.LinkHealth{StatusCode: 200, LatencyMS: 20, CheckedAt: now}); err != nil {
+		t.Fatal("Repo shouldn't fail to save health:", err)
+	}
+	if err := testRepo.SaveHealth("", broken.Hash, domain.LinkHealth{StatusCode: 404, Broken: true, CheckedAt: now}); err != nil {
+		t.Fatal("Repo shouldn't fail to save health:", err)
+	}
+	if health, err := testRepo.Health("", ok.Hash); err != nil || health == nil || health.StatusCode != 200 || health.CheckedAt.Equal(now) == false {
+		t.Fatal("Repo should return the last check:", health, err)
+	}
+	if urls, err := testRepo.HealthCheckCandidates(now, 10); err != nil || len(urls) != 0 {
+		t.Fatal("Urls checked after the time shouldn't be candidates:", urls, err)
+	}
+	if urls, err := testRepo.HealthCheckCandidates(now.Add(time.Minute), 1); err != nil || len(urls) != 1 {
+		t.Fatal("Urls checked before the time should be candidates up to the limit:", urls, err)
+	}
+	list, err := testRepo.BrokenURLs("owner", 10, 0)
+	if err != nil || len(list) != 1 || list[0].URL.Hash != broken.Hash || list[0].Health.StatusCode != 404 {
+		t.Fatal("Repo should list the broken urls of the owner:", list, err)
+	}
+	if list, err := testRepo.BrokenURLs("other", 10, 0); err != nil || len(list) != 0 {
+		t.Fatal("Repo shouldn't list broken urls of other owners:", list, err)
+	}
+}
@@ -2,6 +2,8 @@ package postgresql
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -31,7 +33,44 @@ func NewPostgreSQLRepository(dbURL string, timeout time.Duration, hasher *hashid
 	return repo, nil
 }
 
-func (r *postgreSQLRepository) Find(urlHash string) (domain.URL, error) {
+// RepairShortCodes sets the short code of the rows marked by the 001_unique_short migration
+// to the code of their id, it returns how many were repaired
+func (r *postgreSQLRepository) RepairShortCodes() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	rows, err := r.conn.Query(ctx, "SELECT id FROM urls WHERE short LIKE 'repair:%'")
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	var repaired int64
+	for _, id := range ids {
+		hash, err := r.hasher.EncodeInt64([]int64{id})
+		if err != nil {
+			return repaired, err
+		}
+		if _, err := r.conn.Exec(ctx, "UPDATE urls SET short=$1 WHERE id=$2", hash, id); err != nil {
+			return repaired, err
+		}
+		repaired++
+	}
+	return repaired, nil
+}
+
+func (r *postgreSQLRepository) Find(urlDomain string, urlHash string) (domain.URL, error) {
 	if len(urlHash) == 0 {
 		return domain.URL{}, domain.ErrorInvalidURL
 	}
@@ -41,28 +80,139 @@ func (r *postgreSQLRepository) Find(urlHash string) (domain.URL, error) {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 	defer cancel()
-	dbUrl := &domain.URL{}
-	err = r.conn.QueryRow(ctx, "SELECT url, short, created_at FROM urls WHERE id=$1", ids[0]).Scan(
-		&dbUrl.Full,
-		&dbUrl.Hash,
-		&dbUrl.CreatedAt,
-	)
+	return r.scanURL(r.conn.QueryRow(ctx, "SELECT "+urlColumns+" FROM urls WHERE id=$1 AND domain=$2", ids[0], urlDomain))
+}
+
+// Cache doesn't do anything because we use table as "cache"
+func (r *postgreSQLRepository) Cache(url domain.URL) error {
+	return nil
+}
+
+// Remove doesn't do anything because we use table as "cache"
+func (r *postgreSQLRepository) Remove(urlDomain string, urlHash string) error {
+	return nil
+}
+
+// Disable marks the url of the domain as disabled, returns ErrorURLNotFound if it doesn't exist
+func (r *postgreSQLRepository) Disable(urlDomain string, urlHash string) error {
+	if len(urlHash) == 0 {
+		return domain.ErrorInvalidURL
+	}
+	ids, err := r.hasher.DecodeInt64WithError(urlHash)
+	if err != nil {
+		return domain.ErrorInvalidURL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	tag, err := r.conn.Exec(ctx, "UPDATE urls SET disabled=true WHERE id=$1 AND domain=$2", ids[0], urlDomain)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrorURLNotFound
+	}
+	return nil
+}
+
+// CountClick adds a click to a url in a single UPDATE so concurrent clicks can't go over the limit of click limited urls
+func (r *postgreSQLRepository) CountClick(urlDomain string, urlHash string) (int, error) {
+	if len(urlHash) == 0 {
+		return 0, domain.ErrorInvalidURL
+	}
+	ids, err := r.hasher.DecodeInt64WithError(urlHash)
+	if err != nil {
+		return 0, domain.ErrorInvalidURL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	var clicks int
+	err = r.conn.QueryRow(
+		ctx,
+		"UPDATE urls SET clicks=clicks+1 WHERE id=$1 AND domain=$2 AND (max_clicks=0 OR clicks < max_clicks) RETURNING clicks",
+		ids[0],
+		urlDomain,
+	).Scan(&clicks)
 	if err != nil {
 		if err.Error() == pgx.ErrNoRows.Error() {
-			return domain.URL{}, domain.ErrorURLNotFound
+			return 0, domain.ErrorURLExpired
 		}
-		return domain.URL{}, err
+		return 0, err
 	}
+	return clicks, nil
+}
 
-	return *dbUrl, nil
+// Delete removes the url of the owner with its tags, views, rolled up views and health
+func (r *postgreSQLRepository) Delete(urlDomain string, urlHash string, owner string) (domain.URL, error) {
+	if len(urlHash) == 0 {
+		return domain.URL{}, domain.ErrorInvalidURL
+	}
+	ids, err := r.hasher.DecodeInt64WithError(urlHash)
+	if err != nil {
+		return domain.URL{}, domain.ErrorInvalidURL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	tx, err := r.conn.Begin(ctx)
+	if err != nil {
+		return domain.URL{}, err
+	}
+	defer tx.Rollback(ctx)
+	url, err := r.scanURL(tx.QueryRow(
+		ctx,
+		"DELETE FROM urls WHERE id=$1 AND domain=$2 AND owner=$3 RETURNING "+urlColumns,
+		ids[0],
+		urlDomain,
+		owner,
+	))
+	if err != nil {
+		return domain.URL{}, err
+	}
+	for _, table := range append([]string{"url_tags", "url_health"}, viewTables...) {
+		if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE url_id=$1", ids[0]); err != nil {
+			return domain.URL{}, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return domain.URL{}, err
+	}
+	return url, nil
 }
 
-//Cache doesn't do anything because we use table as "cache"
-func (r *postgreSQLRepository) Cache(url domain.URL) error {
+// SaveMetadata stores the metadata fetched for a url, replacing the one it had
+func (r *postgreSQLRepository) SaveMetadata(urlDomain string, urlHash string, metadata domain.LinkMetadata) error {
+	if len(urlHash) == 0 {
+		return domain.ErrorInvalidURL
+	}
+	ids, err := r.hasher.DecodeInt64WithError(urlHash)
+	if err != nil {
+		return domain.ErrorInvalidURL
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	tag, err := r.conn.Exec(ctx, "UPDATE urls SET metadata=$3 WHERE id=$1 AND domain=$2", ids[0], urlDomain, encoded)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrorURLNotFound
+	}
 	return nil
 }
 
-func (r *postgreSQLRepository) Create(url string) (domain.URL, error) {
+// Create stores a new url in a single INSERT.
+// The id is reserved from the identity sequence first so the short code can be
+// computed before the row exists, a failure between both steps only leaves a gap in the sequence.
+// When opts.ReuseExisting is set the digest of the canonical url is stored too and
+// the url previously created in that mode by the same owner on the same domain is returned if there is one.
+func (r *postgreSQLRepository) Create(url string, opts domain.CreateOptions) (domain.URL, error) {
 	var id int64
 	returnURL := domain.URL{}
 	fullURL, err := domain.NormalizeURL(url)
@@ -72,12 +222,29 @@ func (r *postgreSQLRepository) Create(url string) (domain.URL, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 	defer cancel()
 
-	err = r.conn.QueryRow(
-		ctx,
-		"INSERT INTO urls (short, url) VALUES ($1, $2) RETURNING id, created_at",
-		time.Now().String(),
-		fullURL,
-	).Scan(&id, &returnURL.CreatedAt)
+	var digest []byte
+	if opts.ReuseExisting {
+		sum := sha256.Sum256([]byte(domain.CanonicalURL(fullURL)))
+		digest = sum[:]
+		existing, err := r.findByDigest(ctx, opts.Owner, opts.Domain, digest)
+		if err != domain.ErrorURLNotFound {
+			return existing, err
+		}
+	}
+
+	rules, err := encodeRules(opts.Rules)
+	if err != nil {
+		return returnURL, err
+	}
+	variants, err := encodeVariants(opts.Variants)
+	if err != nil {
+		return returnURL, err
+	}
+	campaignID, err := r.campaignID(ctx, opts.Owner, opts.Campaign)
+	if err != nil {
+		return returnURL, err
+	}
+	err = r.conn.QueryRow(ctx, "SELECT nextval(pg_get_serial_sequence('urls', 'id'))").Scan(&id)
 	if err != nil {
 		return returnURL, err
 	}
@@ -85,21 +252,189 @@ func (r *postgreSQLRepository) Create(url string) (domain.URL, error) {
 	if err != nil {
 		return returnURL, err
 	}
-	_, err = r.conn.Exec(context.Background(), "UPDATE urls SET short=$1 WHERE id=$2", hash, id)
+	// the url and its tags are created together
+	tx, err := r.conn.Begin(ctx)
+	if err != nil {
+		return returnURL, err
+	}
+	defer tx.Rollback(ctx)
+	// a concurrent request may have created the same url between the lookup and the insert
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO urls (id, domain, short, url, owner, url_digest, preview, password_hash, max_clicks, rules, variants, query_mode, prefix, campaign_id,
+			title, notes)
+		OVERRIDING SYSTEM VALUE VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (owner, domain, url_digest) DO NOTHING RETURNING created_at`,
+		id,
+		opts.Domain,
+		hash,
+		fullURL,
+		opts.Owner,
+		digest,
+		opts.Preview,
+		opts.PasswordHash,
+		opts.MaxClicks,
+		rules,
+		variants,
+		opts.QueryMode,
+		opts.Prefix,
+		campaignID,
+		opts.Title,
+		opts.Notes,
+	).Scan(&returnURL.CreatedAt)
 	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return r.findByDigest(ctx, opts.Owner, opts.Domain, digest)
+		}
+		return returnURL, err
+	}
+	for _, tag := range opts.Tags {
+		if _, err := tx.Exec(ctx, "INSERT INTO url_tags (url_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING", id, tag); err != nil {
+			return returnURL, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
 		return returnURL, err
 	}
+	returnURL.Domain = opts.Domain
 	returnURL.Hash = hash
 	returnURL.Full = fullURL
+	returnURL.Preview = opts.Preview
+	returnURL.PasswordHash = opts.PasswordHash
+	returnURL.MaxClicks = opts.MaxClicks
+	returnURL.Rules = opts.Rules
+	returnURL.Variants = opts.Variants
+	returnURL.QueryMode = opts.QueryMode
+	returnURL.Prefix = opts.Prefix
+	returnURL.Tags = opts.Tags
+	returnURL.Campaign = opts.Campaign
+	returnURL.Title = opts.Title
+	returnURL.Notes = opts.Notes
+	returnURL.Owner = opts.Owner
 
 	return returnURL, nil
 }
 
-func (r *postgreSQLRepository) CreateURLView(urlHash string) error {
+// findByDigest returns the url created in "reuse existing" mode, it's marked as Reused
+func (r *postgreSQLRepository) findByDigest(ctx context.Context, owner string, urlDomain string, digest []byte) (domain.URL, error) {
+	url, err := r.scanURL(r.conn.QueryRow(
+		ctx,
+		"SELECT "+urlColumns+" FROM urls WHERE owner=$1 AND domain=$2 AND url_digest=$3",
+		owner,
+		urlDomain,
+		digest,
+	))
+	url.Reused = err == nil
+	return url, err
+}
+
+// urlColumns are the columns scanURL reads, tags come from url_tags
+const urlColumns = `domain, url, short, created_at, disabled, preview, password_hash, max_clicks, rules, variants, query_mode, prefix,
+	campaign_id, ARRAY(SELECT tag FROM url_tags WHERE url_id=urls.id ORDER BY tag), title, notes, metadata, owner`
+
+// scanURL reads a url selected with urlColumns, followed by the extra columns.
+// It returns ErrorURLNotFound when there is no row
+func (r *postgreSQLRepository) scanURL(row pgx.Row, extra ...interface{}) (domain.URL, error) {
+	dbUrl := &domain.URL{}
+	var rules, variants, metadata []byte
+	var campaignID *int64
+	dest := []interface{}{
+		&dbUrl.Domain,
+		&dbUrl.Full,
+		&dbUrl.Hash,
+		&dbUrl.CreatedAt,
+		&dbUrl.Disabled,
+		&dbUrl.Preview,
+		&dbUrl.PasswordHash,
+		&dbUrl.MaxClicks,
+		&rules,
+		&variants,
+		&dbUrl.QueryMode,
+		&dbUrl.Prefix,
+		&campaignID,
+		&dbUrl.Tags,
+		&dbUrl.Title,
+		&dbUrl.Notes,
+		&metadata,
+		&dbUrl.Owner,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		if err.Error() == pgx.ErrNoRows.Error() {
+			return domain.URL{}, domain.ErrorURLNotFound
+		}
+		return domain.URL{}, err
+	}
+	if dbUrl.Rules, err = decodeRules(rules); err != nil {
+		return domain.URL{}, err
+	}
+	if dbUrl.Variants, err = decodeVariants(variants); err != nil {
+		return domain.URL{}, err
+	}
+	if len(dbUrl.Tags) == 0 {
+		dbUrl.Tags = nil
+	}
+	if metadata != nil {
+		dbUrl.Metadata = &domain.LinkMetadata{}
+		if err := json.Unmarshal(metadata, dbUrl.Metadata); err != nil {
+			return domain.URL{}, err
+		}
+	}
+	if campaignID != nil {
+		if dbUrl.Campaign, err = r.hasher.EncodeInt64([]int64{*campaignID}); err != nil {
+			return domain.URL{}, err
+		}
+	}
+
+	return *dbUrl, nil
+}
+
+// encodeRules returns the JSON stored in the rules column, an empty array when there are none
+func encodeRules(rules []domain.RoutingRule) ([]byte, error) {
+	if len(rules) == 0 {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(rules)
+}
+
+func decodeRules(data []byte) ([]domain.RoutingRule, error) {
+	var rules []domain.RoutingRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	return rules, nil
+}
+
+// encodeVariants returns the JSON stored in the variants column, an empty array when there are none
+func encodeVariants(variants []domain.Variant) ([]byte, error) {
+	if len(variants) == 0 {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(variants)
+}
+
+func decodeVariants(data []byte) ([]domain.Variant, error) {
+	var variants []domain.Variant
+	if err := json.Unmarshal(data, &variants); err != nil {
+		return nil, err
+	}
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	return variants, nil
+}
+
+// viewTables have the views of urls, recorded and rolled up
+var viewTables = []string{"url_views", "url_view_hours", "url_view_days"}
+
+// DeleteViews deletes the views of the url, recorded and rolled up
+func (r *postgreSQLRepository) DeleteViews(urlHash string) error {
 	if len(urlHash) == 0 {
 		return domain.ErrorInvalidURL
 	}
-
 	ids, err := r.hasher.DecodeInt64WithError(urlHash)
 	if err != nil {
 		return domain.ErrorInvalidURL
@@ -107,7 +442,32 @@ func (r *postgreSQLRepository) CreateURLView(urlHash string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 	defer cancel()
 
-	_, err = r.conn.Exec(ctx, "INSERT INTO url_views (url_id) VALUES ($1)", ids[0])
+	tx, err := r.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	for _, table := range viewTables {
+		if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE url_id=$1", ids[0]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *postgreSQLRepository) CreateURLView(view domain.URLView) error {
+	if len(view.Hash) == 0 {
+		return domain.ErrorInvalidURL
+	}
+
+	ids, err := r.hasher.DecodeInt64WithError(view.Hash)
+	if err != nil {
+		return domain.ErrorInvalidURL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	_, err = r.conn.Exec(ctx, "INSERT INTO url_views (url_id, variant, visitor) VALUES ($1, $2, $3)", ids[0], view.Variant, view.Visitor)
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -116,6 +476,25 @@ func (r *postgreSQLRepository) CreateURLView(urlHash string) error {
 	return nil
 }
 
+// tailViewsSQL counts the views of a url that were not rolled up yet, recorded from $3.
+// Views are numbered from the start of the day $2 like when they are rolled up, so visitors
+// that were seen earlier in the day are not counted again
+const tailViewsSQL = `WITH views AS (
+	SELECT created_at,
+		visitor <> '' AND ROW_NUMBER() OVER (PARTITION BY visitor, (created_at AT TIME ZONE 'UTC')::date ORDER BY created_at) = 1 AS new_visitor
+	FROM url_views WHERE url_id=$1 AND created_at >= $2
+)
+SELECT Count(*), Count(*) FILTER (WHERE new_visitor),
+	Count(*) FILTER (WHERE created_at >= $4), Count(*) FILTER (WHERE new_visitor AND created_at >= $4),
+	Count(*) FILTER (WHERE created_at >= $5), Count(*) FILTER (WHERE new_visitor AND created_at >= $5)
+FROM views WHERE created_at >= $3`
+
+// statsTxOptions read the views, rolled up and not, as they were when the first query ran
+var statsTxOptions = pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}
+
+// Stats reads the views rolled up by RollUpViews and counts the ones recorded after them,
+// so it only counts the views of the last hours when views are rolled up regularly.
+// Past day and week counts of rolled up views are as precise as the hour
 func (r *postgreSQLRepository) Stats(urlHash string) (domain.URLViewStats, error) {
 	if len(urlHash) == 0 {
 		return domain.URLViewStats{}, domain.ErrorInvalidURL
@@ -126,38 +505,157 @@ func (r *postgreSQLRepository) Stats(urlHash string) (domain.URLViewStats, error
 		return domain.URLViewStats{}, domain.ErrorInvalidURL
 	}
 
-	var count, pastDayCount, pastWeekCount int
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
 
-	// very inefficient like a vintage car.
-	countSQL := "SELECT Count(*) FROM url_views WHERE url_id=$1"
-	pastWeekSQL := "SELECT Count(*) FROM url_views WHERE url_id=$1 AND created_at >= NOW() - interval '1 week'"
-	pastDaySQL := "SELECT Count(*) FROM url_views WHERE url_id=$1 AND created_at >= NOW() - interval '1 day'"
+	// a roll up committed between the queries would move views from one table to the other
+	tx, err := r.conn.BeginTx(ctx, statsTxOptions)
+	if err != nil {
+		return domain.URLViewStats{}, err
+	}
+	defer tx.Rollback(ctx)
+	now := time.Now().UTC()
+	pastWeek, pastDay := now.AddDate(0, 0, -7), now.AddDate(0, 0, -1)
+	var rolledUpTo time.Time
+	if err := tx.QueryRow(ctx, "SELECT rolled_up_to FROM view_rollups").Scan(&rolledUpTo); err != nil {
+		fmt.Println(err)
+		return domain.URLViewStats{}, err
+	}
 
-	err = r.conn.QueryRow(context.Background(), countSQL, ids[0]).Scan(&count)
+	// the days for the totals, the hours for the past day and week
+	var count, uniqueCount int
+	err = tx.QueryRow(ctx, "SELECT COALESCE(SUM(views), 0), COALESCE(SUM(visitors), 0) FROM url_view_days WHERE url_id=$1", ids[0]).Scan(&count, &uniqueCount)
+	if err != nil {
+		fmt.Println(err)
+		return domain.URLViewStats{}, err
+	}
+	var pastWeekCount, pastWeekUniqueCount, pastDayCount, pastDayUniqueCount int
+	err = tx.QueryRow(
+		ctx,
+		`SELECT COALESCE(SUM(views), 0), COALESCE(SUM(visitors), 0),
+			COALESCE(SUM(views) FILTER (WHERE hour >= $3), 0), COALESCE(SUM(visitors) FILTER (WHERE hour >= $3), 0)
+		FROM url_view_hours WHERE url_id=$1 AND hour >= $2`,
+		ids[0], pastWeek, pastDay,
+	).Scan(&pastWeekCount, &pastWeekUniqueCount, &pastDayCount, &pastDayUniqueCount)
 	if err != nil {
 		fmt.Println(err)
 		return domain.URLViewStats{}, err
 	}
 
-	err = r.conn.QueryRow(context.Background(), pastWeekSQL, ids[0]).Scan(&pastWeekCount)
+	var tail domain.URLViewStats
+	err = tx.QueryRow(ctx, tailViewsSQL, ids[0], dayStart(rolledUpTo), rolledUpTo, pastWeek, pastDay).Scan(
+		&tail.Count, &tail.UniqueCount, &tail.PastWeekCount, &tail.PastWeekUniqueCount, &tail.PastDayCount, &tail.PastDayUniqueCount,
+	)
 	if err != nil {
 		fmt.Println(err)
 		return domain.URLViewStats{}, err
 	}
 
-	err = r.conn.QueryRow(context.Background(), pastDaySQL, ids[0]).Scan(&pastDayCount)
+	variants, err := variantCounts(ctx, tx, ids[0], rolledUpTo)
 	if err != nil {
 		fmt.Println(err)
 		return domain.URLViewStats{}, err
 	}
 
 	return domain.URLViewStats{
-		Count:         count,
-		PastWeekCount: pastWeekCount,
-		PastDayCount:  pastDayCount,
+		Count:               count + tail.Count,
+		PastWeekCount:       pastWeekCount + tail.PastWeekCount,
+		PastDayCount:        pastDayCount + tail.PastDayCount,
+		UniqueCount:         uniqueCount + tail.UniqueCount,
+		PastWeekUniqueCount: pastWeekUniqueCount + tail.PastWeekUniqueCount,
+		PastDayUniqueCount:  pastDayUniqueCount + tail.PastDayUniqueCount,
+		Variants:            variants,
+	}, nil
+}
+
+// ViewCounts adds up the views of the urls like Stats counts them, with one query for
+// the days, one for the hours and one for the views that were not rolled up yet
+func (r *postgreSQLRepository) ViewCounts(urlHashes []string) (domain.ViewCounts, error) {
+	ids := make([]int64, 0, len(urlHashes))
+	for _, urlHash := range urlHashes {
+		decoded, err := r.hasher.DecodeInt64WithError(urlHash)
+		if err != nil || len(urlHash) == 0 {
+			return domain.ViewCounts{}, domain.ErrorInvalidURL
+		}
+		ids = append(ids, decoded[0])
+	}
+	if len(ids) == 0 {
+		return domain.ViewCounts{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	tx, err := r.conn.BeginTx(ctx, statsTxOptions)
+	if err != nil {
+		return domain.ViewCounts{}, err
+	}
+	defer tx.Rollback(ctx)
+	now := time.Now().UTC()
+	pastWeek, pastDay := now.AddDate(0, 0, -7), now.AddDate(0, 0, -1)
+	var rolledUpTo time.Time
+	if err := tx.QueryRow(ctx, "SELECT rolled_up_to FROM view_rollups").Scan(&rolledUpTo); err != nil {
+		return domain.ViewCounts{}, err
+	}
+	var count, pastWeekCount, pastDayCount int
+	if err := tx.QueryRow(ctx, "SELECT COALESCE(SUM(views), 0) FROM url_view_days WHERE url_id = ANY($1)", ids).Scan(&count); err != nil {
+		return domain.ViewCounts{}, err
+	}
+	err = tx.QueryRow(
+		ctx,
+		"SELECT COALESCE(SUM(views), 0), COALESCE(SUM(views) FILTER (WHERE hour >= $3), 0) FROM url_view_hours WHERE url_id = ANY($1) AND hour >= $2",
+		ids, pastWeek, pastDay,
+	).Scan(&pastWeekCount, &pastDayCount)
+	if err != nil {
+		return domain.ViewCounts{}, err
+	}
+	var tail domain.ViewCounts
+	err = tx.QueryRow(
+		ctx,
+		`SELECT Count(*), Count(*) FILTER (WHERE created_at >= $3), Count(*) FILTER (WHERE created_at >= $4)
+		FROM url_views WHERE url_id = ANY($1) AND created_at >= $2`,
+		ids, rolledUpTo, pastWeek, pastDay,
+	).Scan(&tail.Count, &tail.PastWeekCount, &tail.PastDayCount)
+	if err != nil {
+		return domain.ViewCounts{}, err
+	}
+	return domain.ViewCounts{
+		Count:         count + tail.Count,
+		PastWeekCount: pastWeekCount + tail.PastWeekCount,
+		PastDayCount:  pastDayCount + tail.PastDayCount,
 	}, nil
 }
 
+// variantCounts returns the views of each variant of a split url, rolled up and recorded from rolledUpTo.
+// It's nil when it has no views of variants
+func variantCounts(ctx context.Context, tx pgx.Tx, id int64, rolledUpTo time.Time) ([]int, error) {
+	rows, err := tx.Query(
+		ctx,
+		`SELECT variant, SUM(views) FROM (
+			SELECT variant, views FROM url_view_days WHERE url_id=$1 AND variant >= 0
+			UNION ALL
+			SELECT variant, 1 FROM url_views WHERE url_id=$1 AND variant >= 0 AND created_at >= $2
+		) AS variant_views GROUP BY variant`,
+		id, rolledUpTo,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var counts []int
+	for rows.Next() {
+		var variant, count int
+		if err := rows.Scan(&variant, &count); err != nil {
+			return nil, err
+		}
+		for len(counts) <= variant {
+			counts = append(counts, 0)
+		}
+		counts[variant] = count
+	}
+	return counts, rows.Err()
+}
+
 func createConnectionPool(database string, timeout time.Duration) (*pgxpool.Pool, error) {
 	poolConfig, err := pgxpool.ParseConfig(database)
 	if err != nil {
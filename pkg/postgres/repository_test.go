@@ -1,6 +1,7 @@
 package postgresql
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
@@ -51,13 +52,13 @@ func deferableTestMain(m *testing.M) int {
 }
 
 func TestFindShouldReturnInvalidUrlError(t *testing.T) {
-	if _, err := testRepo.Find(""); err != domain.ErrorInvalidURL {
+	if _, err := testRepo.Find("", ""); err != domain.ErrorInvalidURL {
 		t.Fatal("Repo should return an URL invalid error but got:", err)
 	}
-	if _, err := testRepo.Find(" "); err != domain.ErrorInvalidURL {
+	if _, err := testRepo.Find("", " "); err != domain.ErrorInvalidURL {
 		t.Fatal("Repo should return an URL invalid error but got:", err)
 	}
-	if _, err := testRepo.Find("1"); err != domain.ErrorInvalidURL {
+	if _, err := testRepo.Find("", "1"); err != domain.ErrorInvalidURL {
 		t.Fatal("Repo should return an URL invalid error but got:", err)
 	}
 }
@@ -66,7 +67,7 @@ func TestFindShouldReturnUrlNotFound(t *testing.T) {
 		return
 	}
 	hash, _ := testHasher.EncodeInt64([]int64{99})
-	if _, err := testRepo.Find(hash); err != domain.ErrorURLNotFound {
+	if _, err := testRepo.Find("", hash); err != domain.ErrorURLNotFound {
 		t.Fatal("Repo should return an URL not found error but got:", err)
 	}
 }
@@ -82,7 +83,7 @@ func TestFindReturnsUrl(t *testing.T) {
 	}(testRepo)
 
 	expectedURL := "www.example.com"
-	id, err := testutils.InsertUrl(testRepo.conn, expectedURL)
+	id, err := testutils.InsertUrl(testRepo.conn, testHasher, expectedURL)
 	if err != nil {
 		t.Fatal("Failed to seed database", err)
 	}
@@ -91,7 +92,7 @@ func TestFindReturnsUrl(t *testing.T) {
 		t.Fatal("Unable to encode id")
 	}
 
-	res, err := testRepo.Find(hash)
+	res, err := testRepo.Find("", hash)
 	if err != nil {
 		t.Fatal("Repo didn't find url:", err)
 	}
@@ -101,14 +102,43 @@ func TestFindReturnsUrl(t *testing.T) {
 
 }
 
+func TestUrlsWithoutDomainAreServedOnTheDefaultDomain(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	// urls created before domains were configured have the empty domain
+	id, err := testutils.InsertUrl(testRepo.conn, testHasher, "www.example.com")
+	if err != nil {
+		t.Fatal("Failed to seed database", err)
+	}
+	hash, _ := testHasher.EncodeInt64([]int64{id})
+	service := domain.NewURLShortenerService(testRepo, testRepo, domain.ServiceConfig{Domains: domain.Domains{Default: "sho.rt"}})
+	url, err := service.Find(domain.Domains{Default: "sho.rt"}.ForHost("sho.rt:8080"), hash, false)
+	if err != nil || url.Full != "www.example.com" {
+		t.Fatal("Urls without domain should be found on the default domain", url, err)
+	}
+	if err := service.Disable("sho.rt", hash); err != nil {
+		t.Fatal("Urls without domain should be disabled on the default domain", err)
+	}
+	if err := service.Delete("sho.rt", hash, ""); err != nil {
+		t.Fatal("Urls without domain should be deleted on the default domain", err)
+	}
+}
+
 func TestCreateShouldReturnInvalidUrlError(t *testing.T) {
-	if _, err := testRepo.Create(`javascript:alert("Hello World")`); err != domain.ErrorInvalidURL {
+	if _, err := testRepo.Create(`javascript:alert("Hello World")`, domain.CreateOptions{}); err != domain.ErrorInvalidURL {
 		t.Fatal("Repo should return an URL invalid error but got:", err)
 	}
-	if _, err := testRepo.Create(" "); err != domain.ErrorInvalidURL {
+	if _, err := testRepo.Create(" ", domain.CreateOptions{}); err != domain.ErrorInvalidURL {
 		t.Fatal("Repo should return an URL invalid error but got:", err)
 	}
-	if _, err := testRepo.Create(""); err != domain.ErrorInvalidURL {
+	if _, err := testRepo.Create("", domain.CreateOptions{}); err != domain.ErrorInvalidURL {
 		t.Fatal("Repo should return an URL invalid error but got:", err)
 	}
 }
@@ -123,7 +153,7 @@ func TestCreateShouldInsertInDatabase(t *testing.T) {
 		}
 	}(testRepo)
 
-	url, err := testRepo.Create("www.example.com")
+	url, err := testRepo.Create("www.example.com", domain.CreateOptions{})
 	if err != nil {
 		t.Fatal("Repo shouldn't fail to create url:", err)
 	}
@@ -143,14 +173,111 @@ func TestCreateShouldInsertInDatabase(t *testing.T) {
 	}
 }
 
+func TestRepairShortCodesSetsTheCodeOfTheId(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	id, err := testutils.InsertUrl(testRepo.conn, testHasher, "http://www.example.com")
+	if err != nil {
+		t.Fatal("Failed to insert with testutils", err)
+	}
+	if _, err := testRepo.conn.Exec(context.Background(), "UPDATE urls SET short='repair:' || id WHERE id=$1", id); err != nil {
+		t.Fatal("Failed to mark url", err)
+	}
+	if repaired, err := testRepo.RepairShortCodes(); err != nil || repaired != 1 {
+		t.Fatal("Marked urls should be repaired", repaired, err)
+	}
+	hash, _ := testHasher.EncodeInt64([]int64{id})
+	if _, err := testutils.GeUrlId(testRepo.conn, "http://www.example.com", hash); err != nil {
+		t.Fatal("Repaired urls should have the code of their id", err)
+	}
+	if url, err := testRepo.Find("", hash); err != nil || url.Full != "http://www.example.com" {
+		t.Fatal("Repaired urls should still be found by their code", url, err)
+	}
+}
+
+func TestCreateReusesExistingUrl(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	opts := domain.CreateOptions{Owner: "owner", ReuseExisting: true}
+	first, err := testRepo.Create("www.example.com", opts)
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	second, err := testRepo.Create("HTTP://WWW.EXAMPLE.COM:80/", opts)
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	if first.Hash != second.Hash || first.Reused || second.Reused == false || second.Owner != "owner" {
+		t.Fatal("Repo should have returned the existing url", first, second)
+	}
+	other, err := testRepo.Create("www.example.com", domain.CreateOptions{Owner: "other", ReuseExisting: true})
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	if other.Hash == first.Hash {
+		t.Fatal("Urls should not be shared between owners", first, other)
+	}
+	duplicate, err := testRepo.Create("www.example.com", domain.CreateOptions{Owner: "owner"})
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	if duplicate.Hash == first.Hash {
+		t.Fatal("Repo should create a new url when reuse is not set", first, duplicate)
+	}
+}
+
+func TestDisableShouldDisableUrl(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	url, err := testRepo.Create("www.example.com", domain.CreateOptions{})
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	if err := testRepo.Disable("", url.Hash); err != nil {
+		t.Fatal("Repo shouldn't fail to disable url:", err)
+	}
+	res, err := testRepo.Find("", url.Hash)
+	if err != nil {
+		t.Fatal("Repo didn't find url:", err)
+	}
+	if res.Disabled == false {
+		t.Fatal("Url should be disabled", res)
+	}
+	hash, _ := testHasher.EncodeInt64([]int64{99})
+	if err := testRepo.Disable("", hash); err != domain.ErrorURLNotFound {
+		t.Fatal("Repo should return an URL not found error but got:", err)
+	}
+}
+
 func TestCreateURLViewShouldReturnInvalidUrl(t *testing.T) {
-	if err := testRepo.CreateURLView(""); err != domain.ErrorInvalidURL {
+	if err := testRepo.CreateURLView(domain.URLView{Hash: "", Variant: domain.NoVariant}); err != domain.ErrorInvalidURL {
 		t.Fatal("Repo should return an URL invalid error but got:", err)
 	}
-	if err := testRepo.CreateURLView(" "); err != domain.ErrorInvalidURL {
+	if err := testRepo.CreateURLView(domain.URLView{Hash: " ", Variant: domain.NoVariant}); err != domain.ErrorInvalidURL {
 		t.Fatal("Repo should return an URL invalid error but got:", err)
 	}
-	if err := testRepo.CreateURLView("1"); err != domain.ErrorInvalidURL {
+	if err := testRepo.CreateURLView(domain.URLView{Hash: "1", Variant: domain.NoVariant}); err != domain.ErrorInvalidURL {
 		t.Fatal("Repo should return an URL invalid error but got:", err)
 	}
 }
@@ -171,7 +298,7 @@ func TestCreateURLViewShouldInsertInDatabase(t *testing.T) {
 		t.Fatal("Failed to hash id:", err)
 	}
 
-	if err := testRepo.CreateURLView(hash); err != nil {
+	if err := testRepo.CreateURLView(domain.URLView{Hash: hash, Variant: domain.NoVariant}); err != nil {
 		t.Fatal("Failed to add to database", err)
 	}
 	now := time.Now()
@@ -259,3 +386,278 @@ func TestStatsCountsCorrectly(t *testing.T) {
 	}
 
 }
+
+func TestUrlsBelongToTheirDomain(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	opts := domain.CreateOptions{Owner: "owner", Domain: "brand.co", ReuseExisting: true}
+	url, err := testRepo.Create("www.example.com", opts)
+	if err != nil || url.Domain != "brand.co" {
+		t.Fatal("Repo shouldn't fail to create url:", url, err)
+	}
+	if res, err := testRepo.Find("brand.co", url.Hash); err != nil || res.Domain != "brand.co" {
+		t.Fatal("Repo didn't find url in its domain:", res, err)
+	}
+	if _, err := testRepo.Find("sho.rt", url.Hash); err != domain.ErrorURLNotFound {
+		t.Fatal("Repo shouldn't find urls of other domains but got:", err)
+	}
+	if err := testRepo.Disable("sho.rt", url.Hash); err != domain.ErrorURLNotFound {
+		t.Fatal("Repo shouldn't disable urls of other domains but got:", err)
+	}
+	opts.Domain = "sho.rt"
+	other, err := testRepo.Create("www.example.com", opts)
+	if err != nil || other.Hash == url.Hash {
+		t.Fatal("Reused urls should be scoped to the domain", url, other, err)
+	}
+}
+
+func TestCreateShouldStorePreviewMode(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	url, err := testRepo.Create("www.example.com", domain.CreateOptions{Preview: true})
+	if err != nil || url.Preview == false {
+		t.Fatal("Repo shouldn't fail to create url:", url, err)
+	}
+	if res, err := testRepo.Find("", url.Hash); err != nil || res.Preview == false {
+		t.Fatal("Repo should return the preview mode:", res, err)
+	}
+}
+
+func TestCountClickStopsAtMaxClicks(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	url, err := testRepo.Create("www.example.com", domain.CreateOptions{MaxClicks: 2})
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	if res, err := testRepo.Find("", url.Hash); err != nil || res.MaxClicks != 2 {
+		t.Fatal("Repo should return the click limit:", res, err)
+	}
+	for i := 1; i <= 2; i++ {
+		if clicks, err := testRepo.CountClick("", url.Hash); err != nil || clicks != i {
+			t.Fatal("Repo shouldn't fail to count click:", clicks, err)
+		}
+	}
+	if _, err := testRepo.CountClick("", url.Hash); err != domain.ErrorURLExpired {
+		t.Fatal("Repo should return an URL expired error but got:", err)
+	}
+	if _, err := testRepo.CountClick("", "?"); err != domain.ErrorInvalidURL {
+		t.Fatal("Repo should return an invalid URL error but got:", err)
+	}
+}
+
+func TestDeleteOnlyDeletesUrlsOfTheOwner(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateAllTables(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	url, err := testRepo.Create("www.example.com", domain.CreateOptions{Owner: "owner", Tags: []string{"docs"}})
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	ids, err := testHasher.DecodeInt64WithError(url.Hash)
+	if err != nil {
+		t.Fatal("Failed to decode", err)
+	}
+	now := time.Now()
+	if err := testutils.InsertUrlVisitorView(testRepo.conn, ids[0], "a", now.Add(-2*time.Hour)); err != nil {
+		t.Fatal("Failed to insert with testutils", err)
+	}
+	if err := testRepo.RollUpViews(now.Truncate(time.Hour)); err != nil {
+		t.Fatal("Failed to roll up views", err)
+	}
+	if _, err := testRepo.Delete("", url.Hash, "other"); err != domain.ErrorURLNotFound {
+		t.Fatal("Repo should return an URL not found error but got:", err)
+	}
+	deleted, err := testRepo.Delete("", url.Hash, "owner")
+	if err != nil || deleted.Hash != url.Hash || deleted.Owner != "owner" || len(deleted.Tags) != 1 {
+		t.Fatal("Repo should return the deleted url:", deleted, err)
+	}
+	if _, err := testRepo.Find("", url.Hash); err != domain.ErrorURLNotFound {
+		t.Fatal("Deleted urls should not be found but got:", err)
+	}
+	for _, table := range []string{"url_views", "url_view_hours", "url_view_days"} {
+		var left int
+		if err := testRepo.conn.QueryRow(context.Background(), "SELECT Count(*) FROM "+table+" WHERE url_id=$1", ids[0]).Scan(&left); err != nil || left != 0 {
+			t.Fatal("Views of deleted urls should be deleted from", table, left, err)
+		}
+	}
+}
+
+func TestCreateShouldStoreRoutingRules(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	rules := []domain.RoutingRule{
+		{OS: "ios", URL: "https://apps.apple.com/app/example"},
+		{Language: "de", Country: "AT", URL: "https://www.example.com/de"},
+	}
+	url, err := testRepo.Create("www.example.com", domain.CreateOptions{Rules: rules})
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	res, err := testRepo.Find("", url.Hash)
+	if err != nil || len(res.Rules) != 2 || res.Rules[0] != rules[0] || res.Rules[1] != rules[1] {
+		t.Fatal("Repo should return the routing rules in order:", res, err)
+	}
+	other, err := testRepo.Create("www.example.com/other", domain.CreateOptions{})
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	if res, err := testRepo.Find("", other.Hash); err != nil || res.Rules != nil {
+		t.Fatal("Urls without rules should not have any:", res, err)
+	}
+}
+
+func TestStatsCountsVariants(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	variants := []domain.Variant{{URL: "http://a.com", Weight: 70}, {URL: "http://b.com", Weight: 30}}
+	url, err := testRepo.Create("http://a.com", domain.CreateOptions{Variants: variants})
+	if err != nil {
+		t.Fatal("Repo shouldn't fail to create url:", err)
+	}
+	if res, err := testRepo.Find("", url.Hash); err != nil || len(res.Variants) != 2 || res.Variants[1] != variants[1] {
+		t.Fatal("Repo should return the variants:", res, err)
+	}
+	for _, variant := range []int{1, 1, domain.NoVariant} {
+		if err := testRepo.CreateURLView(domain.URLView{Hash: url.Hash, Variant: variant}); err != nil {
+			t.Fatal("Repo shouldn't fail to create view:", err)
+		}
+	}
+	stats, err := testRepo.Stats(url.Hash)
+	if err != nil || stats.Count != 3 || len(stats.Variants) != 2 || stats.Variants[0] != 0 || stats.Variants[1] != 2 {
+		t.Fatal("Repo should count the views of each variant:", stats, err)
+	}
+}
+
+func TestStatsCountsUniqueVisitors(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlViewsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	hash, err := testHasher.EncodeInt64([]int64{8})
+	if err != nil {
+		t.Fatal("Failed to encode", err)
+	}
+	for _, visitor := range []string{"a", "a", "b", ""} {
+		if err := testRepo.CreateURLView(domain.URLView{Hash: hash, Variant: domain.NoVariant, Visitor: visitor}); err != nil {
+			t.Fatal("Repo shouldn't fail to create view:", err)
+		}
+	}
+	stats, err := testRepo.Stats(hash)
+	if err != nil || stats.Count != 4 || stats.UniqueCount != 2 || stats.PastWeekUniqueCount != 2 || stats.PastDayUniqueCount != 2 {
+		t.Fatal("Repo should count the visitors once, views without visitor not at all:", stats, err)
+	}
+}
+
+func TestCreateShouldStoreQueryMode(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	url, err := testRepo.Create("www.example.com", domain.CreateOptions{QueryMode: domain.QueryMerge})
+	if err != nil || url.QueryMode != domain.QueryMerge {
+		t.Fatal("Repo shouldn't fail to create url:", url, err)
+	}
+	if res, err := testRepo.Find("", url.Hash); err != nil || res.QueryMode != domain.QueryMerge {
+		t.Fatal("Repo should return the query mode:", res, err)
+	}
+}
+
+func TestCreateShouldStorePrefix(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	url, err := testRepo.Create("docs.example.com", domain.CreateOptions{Prefix: true})
+	if err != nil || url.Prefix == false {
+		t.Fatal("Repo shouldn't fail to create url:", url, err)
+	}
+	if res, err := testRepo.Find("", url.Hash); err != nil || res.Prefix == false {
+		t.Fatal("Repo should return the prefix setting:", res, err)
+	}
+}
+
+func TestSaveMetadataShouldStoreMetadata(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	url, err := testRepo.Create("www.example.com", domain.CreateOptions{Title: "Example", Notes: "Home page"})
+	if err != nil || url.Title != "Example" || url.Notes != "Home page" {
+		t.Fatal("Repo shouldn't fail to create url:", url, err)
+	}
+	if res, err := testRepo.Find("", url.Hash); err != nil || res.Title != "Example" || res.Metadata != nil {
+		t.Fatal("Repo should return the title and no metadata before it's fetched:", res, err)
+	}
+	metadata := domain.LinkMetadata{Title: "Example Domain", FetchedAt: time.Now().UTC().Truncate(time.Second)}
+	if err := testRepo.SaveMetadata("", url.Hash, metadata); err != nil {
+		t.Fatal("Repo shouldn't fail to save metadata:", err)
+	}
+	if res, err := testRepo.Find("", url.Hash); err != nil || res.Metadata == nil || *res.Metadata != metadata {
+		t.Fatal("Repo should return the saved metadata:", res, err)
+	}
+	if err := testRepo.SaveMetadata("other.com", url.Hash, metadata); err != domain.ErrorURLNotFound {
+		t.Fatal("Repo should only save metadata of urls of the domain:", err)
+	}
+}
@@ -0,0 +1,116 @@
+package postgresql
+
+import (
+	"context"
+	"time"
+)
+
+// hourRollupTTL is how long views rolled up by hour are kept, Stats only reads the past week of them
+const hourRollupTTL = 8 * 24 * time.Hour
+
+// numberedViewsSQL numbers the views of each visitor of a url in their UTC day from $1 to $2,
+// the first one counts the visitor like domain.VisitorHash does
+const numberedViewsSQL = `WITH views AS (
+	SELECT url_id, variant, created_at,
+		visitor <> '' AND ROW_NUMBER() OVER (PARTITION BY url_id, visitor, (created_at AT TIME ZONE 'UTC')::date ORDER BY created_at) = 1 AS new_visitor
+	FROM url_views WHERE created_at >= $1 AND created_at < $2
+)
+`
+
+const rollUpHoursSQL = numberedViewsSQL + `INSERT INTO url_view_hours (url_id, hour, variant, views, visitors)
+SELECT url_id, date_trunc('hour', created_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', variant, Count(*), Count(*) FILTER (WHERE new_visitor)
+FROM views GROUP BY 1, 2, 3
+ON CONFLICT (url_id, hour, variant) DO UPDATE SET views=EXCLUDED.views, visitors=EXCLUDED.visitors`
+
+const rollUpDaysSQL = numberedViewsSQL + `INSERT INTO url_view_days (url_id, day, variant, views, visitors)
+SELECT url_id, (created_at AT TIME ZONE 'UTC')::date, variant, Count(*), Count(*) FILTER (WHERE new_visitor)
+FROM views GROUP BY 1, 2, 3
+ON CONFLICT (url_id, day, variant) DO UPDATE SET views=EXCLUDED.views, visitors=EXCLUDED.visitors`
+
+// rollupTimeout is how long rolling up a day of views can take, a day has many more views
+// than the queries of requests read
+const rollupTimeout = 5 * time.Minute
+
+// RollUpViews aggregates the views recorded before until by hour and by day.
+// The days that are rolled up are aggregated again from their start so their visitors are counted
+// once, aggregating the same views twice doesn't count them twice.
+// Views are rolled up a day at a time, each day in its own transaction, so the first roll up of
+// every view recorded so far doesn't hold a transaction over all of them.
+func (r *postgreSQLRepository) RollUpViews(until time.Time) error {
+	for {
+		done, err := r.rollUpDay(until)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// rollUpDay aggregates the views of the day after the last roll up, skipping the days without views,
+// and reports whether the views are rolled up to until
+func (r *postgreSQLRepository) rollUpDay(until time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rollupTimeout)
+	defer cancel()
+
+	tx, err := r.conn.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+	var rolledUpTo time.Time
+	// instances roll up one at a time
+	if err := tx.QueryRow(ctx, "SELECT rolled_up_to FROM view_rollups FOR UPDATE").Scan(&rolledUpTo); err != nil {
+		return false, err
+	}
+	if until.After(rolledUpTo) == false {
+		return true, nil
+	}
+	from := dayStart(rolledUpTo)
+	var next *time.Time
+	if err := tx.QueryRow(ctx, "SELECT min(created_at) FROM url_views WHERE created_at >= $1 AND created_at < $2", from, until).Scan(&next); err != nil {
+		return false, err
+	}
+	to := until
+	if next != nil {
+		if day := dayStart(*next); day.After(from) {
+			from = day
+		}
+		if end := from.Add(24 * time.Hour); end.Before(until) {
+			to = end
+		}
+		if _, err := tx.Exec(ctx, rollUpHoursSQL, from, to); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(ctx, rollUpDaysSQL, from, to); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM url_view_hours WHERE hour < $1", to.Add(-hourRollupTTL)); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE view_rollups SET rolled_up_to=$1", to); err != nil {
+		return false, err
+	}
+	return to.Equal(until), tx.Commit(ctx)
+}
+
+// PruneViews deletes the views recorded before, the views of the day that is being rolled up are kept
+// so its visitors can be counted
+func (r *postgreSQLRepository) PruneViews(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	tag, err := r.conn.Exec(
+		ctx,
+		`DELETE FROM url_views WHERE created_at < LEAST($1, (SELECT date_trunc('day', rolled_up_to AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' FROM view_rollups))`,
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// dayStart returns the start of the UTC day of t
+func dayStart(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
@@ -0,0 +1,81 @@
+package postgresql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yanisky/url-shortener/internal/testutils"
+)
+
+func TestStatsAreTheSameWithRollups(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlViewsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	var id int64 = 8
+	hash, err := testHasher.EncodeInt64([]int64{id})
+	if err != nil {
+		t.Fatal("Failed to encode", err)
+	}
+	now := time.Now().UTC()
+	today := dayStart(now)
+	views := []struct {
+		visitor string
+		at      time.Time
+	}{
+		{"a", now.AddDate(0, 0, -30)},
+		{"a", now.AddDate(0, 0, -3)},
+		{"b", today},
+		{"b", today.Add(time.Second)},
+		{"c", now},
+	}
+	for _, view := range views {
+		if err := testutils.InsertUrlVisitorView(testRepo.conn, id, view.visitor, view.at); err != nil {
+			t.Fatal("Failed to insert with testutils", err)
+		}
+	}
+	expected, err := testRepo.Stats(hash)
+	if err != nil {
+		t.Fatal("Failed to get stats", err)
+	}
+	if expected.Count != 5 || expected.PastWeekCount != 4 || expected.PastDayCount != 3 ||
+		expected.UniqueCount != 4 || expected.PastWeekUniqueCount != 3 || expected.PastDayUniqueCount != 2 {
+		t.Fatal("Stats count is not correct", expected)
+	}
+
+	// twice, rolling up the same views again shouldn't count them again
+	for i := 0; i < 2; i++ {
+		if err := testRepo.RollUpViews(now.Truncate(time.Hour)); err != nil {
+			t.Fatal("Failed to roll up views", err)
+		}
+		if stats, err := testRepo.Stats(hash); err != nil || stats.Count != expected.Count || stats.PastWeekCount != expected.PastWeekCount ||
+			stats.PastDayCount != expected.PastDayCount || stats.UniqueCount != expected.UniqueCount ||
+			stats.PastWeekUniqueCount != expected.PastWeekUniqueCount || stats.PastDayUniqueCount != expected.PastDayUniqueCount {
+			t.Fatal("Rolled up stats should be the same", stats, expected, err)
+		}
+	}
+	// the days are rolled up one at a time up to the last one
+	var rolledUpTo time.Time
+	if err := testRepo.conn.QueryRow(context.Background(), "SELECT rolled_up_to FROM view_rollups").Scan(&rolledUpTo); err != nil ||
+		rolledUpTo.Equal(now.Truncate(time.Hour)) == false {
+		t.Fatal("Views should be rolled up to the end", rolledUpTo, err)
+	}
+
+	pruned, err := testRepo.PruneViews(now.AddDate(0, 0, -1))
+	if err != nil || pruned != 2 {
+		t.Fatal("Views older than the day that is rolled up should be pruned", pruned, err)
+	}
+	var left int
+	if err := testRepo.conn.QueryRow(context.Background(), "SELECT Count(*) FROM url_views WHERE url_id=$1", id).Scan(&left); err != nil || left != 3 {
+		t.Fatal("Views of today should be kept", left, err)
+	}
+	if stats, err := testRepo.Stats(hash); err != nil || stats.Count != expected.Count || stats.UniqueCount != expected.UniqueCount {
+		t.Fatal("Pruned views should still be counted", stats, err)
+	}
+}
@@ -0,0 +1,178 @@
+package postgresql
+
+import (
+	"context"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func (r *postgreSQLRepository) CreateWebhook(owner string, webhook domain.Webhook) (domain.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	var id int64
+	err := r.conn.QueryRow(
+		ctx,
+		"INSERT INTO webhooks (owner, url, secret, events) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		owner,
+		webhook.URL,
+		webhook.Secret,
+		webhook.Events,
+	).Scan(&id, &webhook.CreatedAt)
+	if err != nil {
+		return domain.Webhook{}, err
+	}
+	if webhook.ID, err = r.hasher.EncodeInt64([]int64{id}); err != nil {
+		return domain.Webhook{}, err
+	}
+	return webhook, nil
+}
+
+// Webhooks returns the webhooks of the owner, the oldest first
+func (r *postgreSQLRepository) Webhooks(owner string) ([]domain.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	rows, err := r.conn.Query(ctx, "SELECT id, url, secret, events, created_at FROM webhooks WHERE owner=$1 ORDER BY id", owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := []domain.Webhook{}
+	for rows.Next() {
+		var id int64
+		webhook := domain.Webhook{}
+		if err := rows.Scan(&id, &webhook.URL, &webhook.Secret, &webhook.Events, &webhook.CreatedAt); err != nil {
+			return nil, err
+		}
+		if webhook.ID, err = r.hasher.EncodeInt64([]int64{id}); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook deletes the webhook with the deliveries it has in the outbox
+func (r *postgreSQLRepository) DeleteWebhook(owner string, webhookID string) error {
+	ids, err := r.hasher.DecodeInt64WithError(webhookID)
+	if err != nil || len(webhookID) == 0 {
+		return domain.ErrorWebhookNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	tx, err := r.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	tag, err := tx.Exec(ctx, "DELETE FROM webhooks WHERE id=$1 AND owner=$2", ids[0], owner)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrorWebhookNotFound
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM webhook_deliveries WHERE webhook_id=$1", ids[0]); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// EnqueueDeliveries adds the deliveries in a single INSERT
+func (r *postgreSQLRepository) EnqueueDeliveries(owner string, event string, payload []byte, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	_, err := r.conn.Exec(
+		ctx,
+		`INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt_at)
+		SELECT id, $2, $3, $4 FROM webhooks WHERE owner=$1 AND $2=ANY(events)`,
+		owner,
+		event,
+		payload,
+		at,
+	)
+	return err
+}
+
+// DueDeliveries claims the due deliveries by moving their next attempt domain.WebhookDeliveryLease ahead,
+// so several dispatchers can share the outbox
+func (r *postgreSQLRepository) DueDeliveries(now time.Time, limit int) ([]domain.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	rows, err := r.conn.Query(
+		ctx,
+		`UPDATE webhook_deliveries SET next_attempt_at=$3 FROM webhooks
+		WHERE webhooks.id=webhook_deliveries.webhook_id AND webhook_deliveries.id IN (
+			SELECT id FROM webhook_deliveries WHERE done=false AND next_attempt_at <= $1
+			ORDER BY next_attempt_at, id LIMIT $2 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING webhook_deliveries.id, event, payload, attempts, next_attempt_at, last_error,
+			webhooks.id, webhooks.url, webhooks.secret, webhooks.events, webhooks.created_at`,
+		now,
+		limit,
+		now.Add(domain.WebhookDeliveryLease),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []domain.WebhookDelivery{}
+	for rows.Next() {
+		var webhookID int64
+		delivery := domain.WebhookDelivery{}
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Attempts,
+			&delivery.NextAttempt,
+			&delivery.LastError,
+			&webhookID,
+			&delivery.Webhook.URL,
+			&delivery.Webhook.Secret,
+			&delivery.Webhook.Events,
+			&delivery.Webhook.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if delivery.Webhook.ID, err = r.hasher.EncodeInt64([]int64{webhookID}); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (r *postgreSQLRepository) SaveDelivery(delivery domain.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	_, err := r.conn.Exec(
+		ctx,
+		"UPDATE webhook_deliveries SET attempts=$2, next_attempt_at=$3, done=$4, last_error=$5 WHERE id=$1",
+		delivery.ID,
+		delivery.Attempts,
+		delivery.NextAttempt,
+		delivery.Done,
+		delivery.LastError,
+	)
+	return err
+}
+
+// PruneDeliveries deletes the deliveries that were sent or given up on and were created before
+func (r *postgreSQLRepository) PruneDeliveries(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	tag, err := r.conn.Exec(ctx, "DELETE FROM webhook_deliveries WHERE done AND created_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,94 @@
+package postgresql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yanisky/url-shortener/internal/testutils"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestDeleteWebhookShouldReturnNotFound(t *testing.T) {
+	if err := testRepo.DeleteWebhook("owner", "invalid"); err != domain.ErrorWebhookNotFound {
+		t.Fatal("Repo should fail with webhook not found", err)
+	}
+}
+
+func TestWebhookDeliveriesAreQueued(t *testing.T) {
+	if *testPostgreSQL == false {
+		return
+	}
+	defer func(repo *postgreSQLRepository) {
+		if err := testutils.TruncateUrlsTable(repo.conn); err != nil {
+			t.Fatal("Failed to clean up after test:", err)
+		}
+	}(testRepo)
+
+	created, err := testRepo.CreateWebhook("owner", domain.Webhook{
+		URL:    "https://hooks.example.com/created",
+		Events: []string{domain.EventLinkCreated},
+		Secret: "secret",
+	})
+	if err != nil || len(created.ID) == 0 || created.CreatedAt.IsZero() {
+		t.Fatal("Repo shouldn't fail to create webhook:", created, err)
+	}
+	if _, err := testRepo.CreateWebhook("owner", domain.Webhook{URL: "https://hooks.example.com/deleted", Events: []string{domain.EventLinkDeleted}}); err != nil {
+		t.Fatal("Repo shouldn't fail to create webhook:", err)
+	}
+	if webhooks, err := testRepo.Webhooks("owner"); err != nil || len(webhooks) != 2 || webhooks[0].ID != created.ID || webhooks[0].Secret != "secret" {
+		t.Fatal("Repo should return the webhooks of the owner:", webhooks, err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := testRepo.EnqueueDeliveries("owner", domain.EventLinkCreated, []byte(`{"event":"link.created"}`), now); err != nil {
+		t.Fatal("Repo shouldn't fail to enqueue deliveries:", err)
+	}
+	if err := testRepo.EnqueueDeliveries("other", domain.EventLinkCreated, []byte(`{}`), now); err != nil {
+		t.Fatal("Repo shouldn't fail to enqueue deliveries:", err)
+	}
+	if deliveries, err := testRepo.DueDeliveries(now.Add(-time.Second), 10); err != nil || len(deliveries) != 0 {
+		t.Fatal("Deliveries shouldn't be due before their next attempt:", deliveries, err)
+	}
+	deliveries, err := testRepo.DueDeliveries(now, 10)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatal("Only the webhooks subscribed to the event should get a delivery:", deliveries, err)
+	}
+	delivery := deliveries[0]
+	if delivery.Webhook.ID != created.ID || delivery.Webhook.Secret != "secret" || string(delivery.Payload) != `{"event":"link.created"}` {
+		t.Fatal("Repo should return the delivery with its webhook:", delivery)
+	}
+	if deliveries, err := testRepo.DueDeliveries(now, 10); err != nil || len(deliveries) != 0 {
+		t.Fatal("Claimed deliveries shouldn't be returned again:", deliveries, err)
+	}
+
+	delivery.Attempts = 1
+	delivery.NextAttempt = now.Add(time.Minute)
+	delivery.LastError = "timeout"
+	if err := testRepo.SaveDelivery(delivery); err != nil {
+		t.Fatal("Repo shouldn't fail to save delivery:", err)
+	}
+	deliveries, err = testRepo.DueDeliveries(now.Add(time.Minute), 10)
+	if err != nil || len(deliveries) != 1 || deliveries[0].Attempts != 1 || deliveries[0].LastError != "timeout" {
+		t.Fatal("Failed deliveries should be retried:", deliveries, err)
+	}
+	deliveries[0].Done = true
+	if err := testRepo.SaveDelivery(deliveries[0]); err != nil {
+		t.Fatal("Repo shouldn't fail to save delivery:", err)
+	}
+	if deliveries, err := testRepo.DueDeliveries(now.Add(time.Hour), 10); err != nil || len(deliveries) != 0 {
+		t.Fatal("Done deliveries shouldn't be retried:", deliveries, err)
+	}
+	if pruned, err := testRepo.PruneDeliveries(now.Add(-time.Hour)); err != nil || pruned != 0 {
+		t.Fatal("Deliveries created after should be kept:", pruned, err)
+	}
+	if pruned, err := testRepo.PruneDeliveries(now.Add(time.Hour)); err != nil || pruned != 1 {
+		t.Fatal("Done deliveries should be pruned:", pruned, err)
+	}
+
+	if err := testRepo.DeleteWebhook("other", created.ID); err != domain.ErrorWebhookNotFound {
+		t.Fatal("Webhooks of other owners shouldn't be deleted:", err)
+	}
+	if err := testRepo.DeleteWebhook("owner", created.ID); err != nil {
+		t.Fatal("Repo shouldn't fail to delete webhook:", err)
+	}
+}
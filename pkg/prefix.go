@@ -0,0 +1,50 @@
+package urlshortener
+
+import (
+	"net/url"
+	"strings"
+)
+
+// CleanPathSuffix checks the escaped path visitors add after the code of a prefix url, see URL.Prefix.
+// Empty and "." segments are dropped and a trailing slash is kept. Suffixes with ".." segments,
+// even escaped ones, return ErrorURLNotFound so visitors can't leave the path of the destination.
+// So do segments with an escaped slash or backslash, destinations that unescape them would see
+// more segments than we checked.
+func CleanPathSuffix(suffix string) (string, error) {
+	var segments []string
+	for _, segment := range strings.Split(suffix, "/") {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil || unescaped == ".." || strings.ContainsAny(unescaped, "/\\") {
+			return "", ErrorURLNotFound
+		}
+		if len(segment) == 0 || unescaped == "." {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	cleaned := strings.Join(segments, "/")
+	if len(cleaned) != 0 && strings.HasSuffix(suffix, "/") {
+		cleaned += "/"
+	}
+	return cleaned, nil
+}
+
+// joinPath appends a suffix cleaned by CleanPathSuffix to the path of destination,
+// keeping its query and fragment
+func joinPath(destination string, suffix string) string {
+	if len(suffix) == 0 {
+		return destination
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+	joined := strings.TrimSuffix(u.EscapedPath(), "/") + "/" + suffix
+	path, err := url.PathUnescape(joined)
+	if err != nil {
+		return destination
+	}
+	u.Path = path
+	u.RawPath = joined
+	return u.String()
+}
@@ -0,0 +1,41 @@
+package urlshortener
+
+import (
+	"testing"
+)
+
+func TestCleanPathSuffix(t *testing.T) {
+	for suffix, expected := range map[string]string{
+		"docs/intro":          "docs/intro",
+		"docs//./intro/":      "docs/intro/",
+		"a%3Fb/caf%C3%A9":     "a%3Fb/caf%C3%A9",
+		"":                    "",
+		"./":                  "",
+		"docs/with%20space/.": "docs/with%20space",
+	} {
+		if actual, err := CleanPathSuffix(suffix); err != nil || actual != expected {
+			t.Fatal("Wrong cleaned path for", suffix, actual, err)
+		}
+	}
+	for _, suffix := range []string{"../admin", "docs/%2e%2E/admin", "docs/%zz", "..%2F..", "docs/a%2F..%2F..", "..%5Cadmin"} {
+		if _, err := CleanPathSuffix(suffix); err != ErrorURLNotFound {
+			t.Fatal("Path should be rejected", suffix, err)
+		}
+	}
+}
+
+func TestDestinationJoinsPrefixPath(t *testing.T) {
+	for full, expected := range map[string]string{
+		"https://docs.example.com":                "https://docs.example.com/guide/a%2Fb",
+		"https://docs.example.com/v2/":            "https://docs.example.com/v2/guide/a%2Fb",
+		"https://docs.example.com/v2?lang=en#top": "https://docs.example.com/v2/guide/a%2Fb?lang=en#top",
+	} {
+		url := URL{Full: full, Prefix: true}
+		if actual := url.Destination(Visitor{Path: "guide/a%2Fb"}); actual != expected {
+			t.Fatal("Wrong destination for", full, actual)
+		}
+	}
+	if actual := (URL{Full: "https://docs.example.com/v2"}).Destination(Visitor{Path: "guide"}); actual != "https://docs.example.com/v2" {
+		t.Fatal("Only prefix urls should forward the path", actual)
+	}
+}
@@ -0,0 +1,105 @@
+package urlshortener
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// How a url forwards the query string of its visitors to its destination, see URL.QueryMode
+const (
+	// QueryDrop ignores the query of visitors
+	QueryDrop = ""
+	// QueryMerge adds the parameters of visitors the destination doesn't have
+	QueryMerge = "merge"
+	// QueryOverride adds the parameters of visitors replacing the ones the destination has
+	QueryOverride = "override"
+)
+
+// UTM are the campaign parameters added to the destinations of a url when it's created
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// values returns the utm_ query parameters that are set
+func (u UTM) values() url.Values {
+	values := url.Values{}
+	for name, value := range map[string]string{
+		"utm_source":   u.Source,
+		"utm_medium":   u.Medium,
+		"utm_campaign": u.Campaign,
+		"utm_term":     u.Term,
+		"utm_content":  u.Content,
+	} {
+		if value = strings.TrimSpace(value); len(value) != 0 {
+			values.Set(name, value)
+		}
+	}
+	return values
+}
+
+func validQueryMode(mode string) bool {
+	return mode == QueryDrop || mode == QueryMerge || mode == QueryOverride
+}
+
+// AddQuery adds params to the query of rawURL, replacing the parameters it already has when override is set.
+// The parameters of rawURL keep their order and encoding, the new ones are added after them sorted by name.
+func AddQuery(rawURL string, params url.Values, override bool) (string, error) {
+	if len(params) == 0 {
+		return rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	var pairs []string
+	existing := map[string]bool{}
+	if len(u.RawQuery) != 0 {
+		for _, pair := range strings.Split(u.RawQuery, "&") {
+			name := pair
+			if i := strings.Index(pair, "="); i >= 0 {
+				name = pair[:i]
+			}
+			if decoded, err := url.QueryUnescape(name); err == nil {
+				name = decoded
+			}
+			if _, replaced := params[name]; override && replaced {
+				continue
+			}
+			existing[name] = true
+			pairs = append(pairs, pair)
+		}
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if existing[name] {
+			continue
+		}
+		for _, value := range params[name] {
+			pairs = append(pairs, url.QueryEscape(name)+"="+url.QueryEscape(value))
+		}
+	}
+	u.RawQuery = strings.Join(pairs, "&")
+	u.ForceQuery = false
+	return u.String(), nil
+}
+
+// forwardQuery adds the query of a visitor to a destination of the url following its QueryMode
+func (u URL) forwardQuery(destination string, query url.Values) string {
+	if u.QueryMode == QueryDrop || len(query) == 0 {
+		return destination
+	}
+	forwarded, err := AddQuery(destination, query, u.QueryMode == QueryOverride)
+	if err != nil {
+		return destination
+	}
+	return forwarded
+}
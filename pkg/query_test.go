@@ -0,0 +1,43 @@
+package urlshortener
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestAddQuery(t *testing.T) {
+	params := url.Values{"utm_source": {"news letter"}, "b": {"2"}, "a": {"x&y"}}
+	for _, test := range []struct {
+		url      string
+		override bool
+		expected string
+	}{
+		{"http://example.com/path", false, "http://example.com/path?a=x%26y&b=2&utm_source=news+letter"},
+		{"http://example.com/?z=1&b=1", false, "http://example.com/?z=1&b=1&a=x%26y&utm_source=news+letter"},
+		{"http://example.com/?z=1&b=1", true, "http://example.com/?z=1&a=x%26y&b=2&utm_source=news+letter"},
+		{"http://example.com/?utm%5Fsource=old&c=%2F", true, "http://example.com/?c=%2F&a=x%26y&b=2&utm_source=news+letter"},
+		{"http://example.com/?#top", false, "http://example.com/?a=x%26y&b=2&utm_source=news+letter#top"},
+	} {
+		actual, err := AddQuery(test.url, params, test.override)
+		if err != nil || actual != test.expected {
+			t.Fatal("Wrong query for", test.url, test.override, actual, err)
+		}
+	}
+	if actual, _ := AddQuery("http://example.com/?a", nil, true); actual != "http://example.com/?a" {
+		t.Fatal("Urls should not change without parameters", actual)
+	}
+}
+
+func TestDestinationForwardsQuery(t *testing.T) {
+	query := url.Values{"utm_source": {"x"}, "id": {"2"}}
+	for mode, expected := range map[string]string{
+		QueryDrop:     "http://example.com/?id=1",
+		QueryMerge:    "http://example.com/?id=1&utm_source=x",
+		QueryOverride: "http://example.com/?id=2&utm_source=x",
+	} {
+		url := URL{Full: "http://example.com/?id=1", QueryMode: mode}
+		if actual := url.Destination(Visitor{Query: query}); actual != expected {
+			t.Fatal("Wrong destination for", mode, actual)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package urlshortener
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit is a token bucket that refills Rate tokens per second up to Burst tokens.
+// The zero value doesn't limit anything
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited is true for rate limits that don't limit anything
+func (l RateLimit) Unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// ParseRateLimit parses limits like "60/m", that is 60 requests per minute with bursts of 60.
+// Periods are s, m and h. An empty string is no limit
+func ParseRateLimit(value string) (RateLimit, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return RateLimit{}, nil
+	}
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, it should look like 60/m", value)
+	}
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, it should look like 60/m", value)
+	}
+	periods := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	period, ok := periods[parts[1]]
+	if ok == false {
+		return RateLimit{}, fmt.Errorf("invalid rate limit period %q, use s, m or h", parts[1])
+	}
+	return RateLimit{Rate: float64(count) / period.Seconds(), Burst: count}, nil
+}
+
+// RateLimiter keeps token buckets
+type RateLimiter interface {
+	// Allow takes a token from the bucket identified by key.
+	// When the bucket is empty it returns false and how long until there is a token again
+	Allow(key string, limit RateLimit) (bool, time.Duration, error)
+}
@@ -0,0 +1,28 @@
+package urlshortener
+
+import (
+	"testing"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	cases := map[string]RateLimit{
+		"":       {},
+		"10/s":   {Rate: 10, Burst: 10},
+		"60/m":   {Rate: 1, Burst: 60},
+		"3600/h": {Rate: 1, Burst: 3600},
+	}
+	for value, expected := range cases {
+		limit, err := ParseRateLimit(value)
+		if err != nil || limit != expected {
+			t.Fatal("Rate limit was not parsed correctly", value, limit, err)
+		}
+	}
+	for _, value := range []string{"10", "10/d", "a/m", "-1/m", "10/m/s"} {
+		if _, err := ParseRateLimit(value); err == nil {
+			t.Fatal("Invalid rate limit should fail to parse", value)
+		}
+	}
+	if limit, _ := ParseRateLimit(""); limit.Unlimited() == false {
+		t.Fatal("Empty rate limit should be unlimited")
+	}
+}
@@ -0,0 +1,207 @@
+package redis
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/speps/go-hashids"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+const (
+	// dayBuckets and weekBuckets are the hourly buckets of the past day and week, the current hour included
+	dayBuckets  = 24
+	weekBuckets = 7 * 24
+	// viewBucketTTL keeps the buckets of the past week with a day to spare
+	viewBucketTTL = 8 * 24 * time.Hour
+)
+
+// redisAnalyticsRepository counts views in Redis instead of storing each of them.
+// Every url has a total counter, a counter for every hour expiring after a week, the views of its
+// variants and HyperLogLogs of all its visitors and of the visitors of every hour, so Stats reads
+// a few keys whatever the traffic of the url. Past day and week counts are as precise as the hour,
+// unique counts are estimates with an error under 1%.
+type redisAnalyticsRepository struct {
+	conn   *redis.Client
+	hasher *hashids.HashID
+	now    func() time.Time
+}
+
+func (r *redisAnalyticsRepository) CreateURLView(view domain.URLView) error {
+	if err := r.validHash(view.Hash); err != nil {
+		return err
+	}
+	hour := r.now().Unix() / int64(time.Hour/time.Second)
+	_, err := r.conn.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Incr(viewsKey(view.Hash))
+		pipe.Incr(viewBucketKey(view.Hash, hour))
+		pipe.Expire(viewBucketKey(view.Hash, hour), viewBucketTTL)
+		if view.Variant >= 0 {
+			pipe.HIncrBy(variantViewsKey(view.Hash), strconv.Itoa(view.Variant), 1)
+		}
+		if len(view.Visitor) != 0 {
+			pipe.PFAdd(visitorsKey(view.Hash), view.Visitor)
+			pipe.PFAdd(visitorBucketKey(view.Hash, hour), view.Visitor)
+			pipe.Expire(visitorBucketKey(view.Hash, hour), viewBucketTTL)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *redisAnalyticsRepository) Stats(urlHash string) (domain.URLViewStats, error) {
+	if err := r.validHash(urlHash); err != nil {
+		return domain.URLViewStats{}, err
+	}
+	hour := r.now().Unix() / int64(time.Hour/time.Second)
+	// the total first, then the buckets from the current hour back
+	keys := []string{viewsKey(urlHash)}
+	var visitorKeys []string
+	for i := int64(0); i < weekBuckets; i++ {
+		keys = append(keys, viewBucketKey(urlHash, hour-i))
+		visitorKeys = append(visitorKeys, visitorBucketKey(urlHash, hour-i))
+	}
+	var counts *redis.SliceCmd
+	var variants *redis.StringStringMapCmd
+	var visitors, pastDayVisitors, pastWeekVisitors *redis.IntCmd
+	_, err := r.conn.Pipelined(func(pipe redis.Pipeliner) error {
+		counts = pipe.MGet(keys...)
+		variants = pipe.HGetAll(variantViewsKey(urlHash))
+		// counting several HyperLogLogs counts their union, a visitor has another hash every day anyway
+		visitors = pipe.PFCount(visitorsKey(urlHash))
+		pastDayVisitors = pipe.PFCount(visitorKeys[:dayBuckets]...)
+		pastWeekVisitors = pipe.PFCount(visitorKeys...)
+		return nil
+	})
+	if err != nil {
+		return domain.URLViewStats{}, err
+	}
+	values := counts.Val()
+	stats := domain.URLViewStats{
+		Count:               redisInt(values[0]),
+		UniqueCount:         int(visitors.Val()),
+		PastDayUniqueCount:  int(pastDayVisitors.Val()),
+		PastWeekUniqueCount: int(pastWeekVisitors.Val()),
+	}
+	for i, value := range values[1:] {
+		count := redisInt(value)
+		if i < dayBuckets {
+			stats.PastDayCount += count
+		}
+		stats.PastWeekCount += count
+	}
+	for field, value := range variants.Val() {
+		variant, err := strconv.Atoi(field)
+		if err != nil || variant < 0 {
+			continue
+		}
+		for len(stats.Variants) <= variant {
+			stats.Variants = append(stats.Variants, 0)
+		}
+		stats.Variants[variant], _ = strconv.Atoi(value)
+	}
+	return stats, nil
+}
+
+// ViewCounts adds up the counters of the urls, reading them all with one round trip
+func (r *redisAnalyticsRepository) ViewCounts(urlHashes []string) (domain.ViewCounts, error) {
+	for _, urlHash := range urlHashes {
+		if err := r.validHash(urlHash); err != nil {
+			return domain.ViewCounts{}, err
+		}
+	}
+	if len(urlHashes) == 0 {
+		return domain.ViewCounts{}, nil
+	}
+	hour := r.now().Unix() / int64(time.Hour/time.Second)
+	counts := make([]*redis.SliceCmd, len(urlHashes))
+	_, err := r.conn.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, urlHash := range urlHashes {
+			keys := []string{viewsKey(urlHash)}
+			for j := int64(0); j < weekBuckets; j++ {
+				keys = append(keys, viewBucketKey(urlHash, hour-j))
+			}
+			counts[i] = pipe.MGet(keys...)
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.ViewCounts{}, err
+	}
+	total := domain.ViewCounts{}
+	for _, cmd := range counts {
+		values := cmd.Val()
+		total.Count += redisInt(values[0])
+		for i, value := range values[1:] {
+			count := redisInt(value)
+			if i < dayBuckets {
+				total.PastDayCount += count
+			}
+			total.PastWeekCount += count
+		}
+	}
+	return total, nil
+}
+
+// DeleteViews deletes the counters of the url, the buckets that didn't expire yet included
+func (r *redisAnalyticsRepository) DeleteViews(urlHash string) error {
+	if err := r.validHash(urlHash); err != nil {
+		return err
+	}
+	hour := r.now().Unix() / int64(time.Hour/time.Second)
+	keys := []string{viewsKey(urlHash), variantViewsKey(urlHash), visitorsKey(urlHash)}
+	for i := int64(0); i <= int64(viewBucketTTL/time.Hour); i++ {
+		keys = append(keys, viewBucketKey(urlHash, hour-i), visitorBucketKey(urlHash, hour-i))
+	}
+	return r.conn.Del(keys...).Err()
+}
+
+// validHash returns ErrorInvalidURL for hashes that can't be decoded, like the store does
+func (r *redisAnalyticsRepository) validHash(urlHash string) error {
+	if len(urlHash) == 0 {
+		return domain.ErrorInvalidURL
+	}
+	if _, err := r.hasher.DecodeInt64WithError(urlHash); err != nil {
+		return domain.ErrorInvalidURL
+	}
+	return nil
+}
+
+// redisInt reads a counter returned by MGET, missing keys are nil
+func redisInt(value interface{}) int {
+	s, _ := value.(string)
+	count, _ := strconv.Atoi(s)
+	return count
+}
+
+// the views of urls are counted by hash, ids are unique across domains
+func viewsKey(urlHash string) string {
+	return "views:" + urlHash
+}
+
+func viewBucketKey(urlHash string, hour int64) string {
+	return "views:" + urlHash + ":" + strconv.FormatInt(hour, 10)
+}
+
+func variantViewsKey(urlHash string) string {
+	return "views:" + urlHash + ":variants"
+}
+
+func visitorsKey(urlHash string) string {
+	return "visitors:" + urlHash
+}
+
+func visitorBucketKey(urlHash string, hour int64) string {
+	return "visitors:" + urlHash + ":" + strconv.FormatInt(hour, 10)
+}
+
+// NewRedisAnalyticsRepository creates an analytics repository that keeps counters in Redis,
+// views recorded by other repositories before are not counted
+func NewRedisAnalyticsRepository(redisURL string, timeout time.Duration, hasher *hashids.HashID) (*redisAnalyticsRepository, error) {
+	client, err := newRedisClient(redisURL, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &redisAnalyticsRepository{conn: client, hasher: hasher, now: time.Now}, nil
+}
@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestAnalyticsCountsViewsInBuckets(t *testing.T) {
+	if *testRedisCache == false {
+		return
+	}
+	hash, _ := testHasher.EncodeInt64([]int64{42})
+	now := time.Now()
+	repo := &redisAnalyticsRepository{conn: testConn, hasher: testHasher, now: func() time.Time { return now }}
+	defer func() {
+		keys, _ := testConn.Keys("views:" + hash + "*").Result()
+		visitors, _ := testConn.Keys("visitors:" + hash + "*").Result()
+		testConn.Del(append(keys, visitors...)...)
+	}()
+
+	views := []struct {
+		ago     time.Duration
+		variant int
+	}{
+		{10 * 24 * time.Hour, domain.NoVariant},
+		{3 * 24 * time.Hour, 1},
+		{2 * time.Hour, 1},
+		{0, 0},
+	}
+	current := now
+	for i, view := range views {
+		now = current.Add(-view.ago)
+		// the last two views are of the same visitor
+		visitor := strconv.Itoa(i)
+		if i == len(views)-1 {
+			visitor = strconv.Itoa(i - 1)
+		}
+		if err := repo.CreateURLView(domain.URLView{Hash: hash, Variant: view.variant, Visitor: visitor}); err != nil {
+			t.Fatal("Failed to record view", err)
+		}
+	}
+	now = current
+
+	stats, err := repo.Stats(hash)
+	if err != nil {
+		t.Fatal("Failed to get stats", err)
+	}
+	if stats.Count != 4 || stats.PastWeekCount != 3 || stats.PastDayCount != 2 {
+		t.Fatal("Views should be counted in the buckets of their hour", stats)
+	}
+	if stats.UniqueCount != 3 || stats.PastWeekUniqueCount != 2 || stats.PastDayUniqueCount != 1 {
+		t.Fatal("Visitors should be counted once", stats)
+	}
+	if len(stats.Variants) != 2 || stats.Variants[0] != 1 || stats.Variants[1] != 2 {
+		t.Fatal("Views of variants should be counted", stats.Variants)
+	}
+	other, _ := testHasher.EncodeInt64([]int64{43})
+	defer testConn.Del(viewsKey(other), viewBucketKey(other, now.Unix()/int64(time.Hour/time.Second)))
+	if err := repo.CreateURLView(domain.URLView{Hash: other, Variant: domain.NoVariant}); err != nil {
+		t.Fatal("Failed to record view", err)
+	}
+	expected := domain.ViewCounts{Count: 5, PastWeekCount: 4, PastDayCount: 3}
+	if counts, err := repo.ViewCounts([]string{hash, other}); err != nil || counts != expected {
+		t.Fatal("Views of the urls should be added up", counts, err)
+	}
+	if _, err := repo.Stats("invalid hash"); err != domain.ErrorInvalidURL {
+		t.Fatal("Invalid hashes should be rejected", err)
+	}
+
+	if err := repo.DeleteViews(hash); err != nil {
+		t.Fatal("Failed to delete views", err)
+	}
+	if keys, err := testConn.Keys("*:" + hash + "*").Result(); err != nil || len(keys) != 0 {
+		t.Fatal("Every key of the views of deleted urls should be deleted", keys, err)
+	}
+	if stats, err := repo.Stats(other); err != nil || stats.Count != 1 {
+		t.Fatal("Views of other urls should be kept", stats, err)
+	}
+}
@@ -0,0 +1,67 @@
+package redis
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// tokenBucketScript refills and takes a token from the bucket in KEYS[1] atomically.
+// ARGV is rate (tokens per second), burst and the current time in milliseconds.
+// It returns {1, 0} when a token was taken or {0, milliseconds until the next token}.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(bucket[1]) or burst
+local last = tonumber(bucket[2]) or now
+local elapsed = math.max(0, now - last) / 1000
+tokens = math.min(burst, tokens + elapsed * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "last", tostring(math.max(now, last)))
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, wait}
+`)
+
+type redisRateLimiter struct {
+	conn *redis.Client
+	now  func() time.Time
+}
+
+// NewRedisRateLimiter creates a rate limiter that keeps its buckets in Redis
+// so limits are shared by all server instances. Buckets expire once they are full again.
+func NewRedisRateLimiter(redisURL string, timeout time.Duration) (*redisRateLimiter, error) {
+	client, err := newRedisClient(redisURL, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &redisRateLimiter{conn: client, now: time.Now}, nil
+}
+
+func (r *redisRateLimiter) Allow(key string, limit domain.RateLimit) (bool, time.Duration, error) {
+	if limit.Unlimited() {
+		return true, 0, nil
+	}
+	now := r.now().UnixNano() / int64(time.Millisecond)
+	res, err := tokenBucketScript.Run(r.conn, []string{"ratelimit:" + key}, limit.Rate, limit.Burst, now).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	values, ok := res.([]interface{})
+	if ok == false || len(values) != 2 {
+		return false, 0, errors.New("unexpected rate limit script result")
+	}
+	allowed, _ := values[0].(int64)
+	wait, _ := values[1].(int64)
+	return allowed == 1, time.Duration(wait) * time.Millisecond, nil
+}
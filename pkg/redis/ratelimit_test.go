@@ -0,0 +1,33 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestRateLimiterTakesTokens(t *testing.T) {
+	if *testRedisCache == false {
+		return
+	}
+	now := time.Now()
+	limiter := &redisRateLimiter{conn: testConn, now: func() time.Time { return now }}
+	key := "test-bucket"
+	defer testConn.Del("ratelimit:" + key)
+	limit := domain.RateLimit{Rate: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if ok, _, err := limiter.Allow(key, limit); ok == false || err != nil {
+			t.Fatal("Bucket should allow a burst of 2", i, err)
+		}
+	}
+	ok, wait, err := limiter.Allow(key, limit)
+	if ok == true || err != nil || wait != time.Second {
+		t.Fatal("Empty bucket should wait a second for the next token", ok, wait, err)
+	}
+	now = now.Add(time.Second)
+	if ok, _, err := limiter.Allow(key, limit); ok == false || err != nil {
+		t.Fatal("Bucket should have refilled a token", err)
+	}
+}
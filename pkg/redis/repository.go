@@ -1,6 +1,9 @@
 package redis
 
 import (
+	"encoding/json"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v7"
@@ -13,7 +16,7 @@ type redisRepository struct {
 	hasher *hashids.HashID
 }
 
-func (r *redisRepository) Find(urlHash string) (domain.URL, error) {
+func (r *redisRepository) Find(urlDomain string, urlHash string) (domain.URL, error) {
 	if len(urlHash) == 0 {
 		return domain.URL{}, domain.ErrorInvalidURL
 	}
@@ -21,7 +24,7 @@ func (r *redisRepository) Find(urlHash string) (domain.URL, error) {
 	if err != nil {
 		return domain.URL{}, domain.ErrorInvalidURL
 	}
-	data, err := r.conn.HGetAll(urlHash).Result()
+	data, err := r.conn.HGetAll(urlKey(urlDomain, urlHash)).Result()
 	if err != nil {
 		return domain.URL{}, err
 	}
@@ -33,19 +36,100 @@ func (r *redisRepository) Find(urlHash string) (domain.URL, error) {
 		// log error, but ignore, it's not important enough to fail a return
 		createdAt = time.Now().UTC()
 	}
+	var rules []domain.RoutingRule
+	if len(data["rules"]) != 0 {
+		if err := json.Unmarshal([]byte(data["rules"]), &rules); err != nil {
+			return domain.URL{}, err
+		}
+	}
+	var variants []domain.Variant
+	if len(data["variants"]) != 0 {
+		if err := json.Unmarshal([]byte(data["variants"]), &variants); err != nil {
+			return domain.URL{}, err
+		}
+	}
+	var tags []string
+	if len(data["tags"]) != 0 {
+		tags = strings.Split(data["tags"], ",")
+	}
+	var metadata *domain.LinkMetadata
+	if len(data["metadata"]) != 0 {
+		metadata = &domain.LinkMetadata{}
+		if err := json.Unmarshal([]byte(data["metadata"]), metadata); err != nil {
+			return domain.URL{}, err
+		}
+	}
+	// clicks are counted by the store, the cache only needs to know there is a limit
+	maxClicks, _ := strconv.Atoi(data["max_clicks"])
 	return domain.URL{
+		Domain:    urlDomain,
 		Hash:      urlHash,
 		Full:      data["url"],
 		CreatedAt: createdAt,
+		Disabled:  data["disabled"] == "1",
+		Preview:   data["preview"] == "1",
+		// protected urls must stay protected when they are served from cache
+		PasswordHash: data["password_hash"],
+		MaxClicks:    maxClicks,
+		Rules:        rules,
+		Variants:     variants,
+		QueryMode:    data["query_mode"],
+		Prefix:       data["prefix"] == "1",
+		Tags:         tags,
+		Campaign:     data["campaign"],
+		Title:        data["title"],
+		Notes:        data["notes"],
+		Metadata:     metadata,
+		// events of cached urls go to the webhooks of their owner
+		Owner: data["owner"],
 	}, nil
 }
 
 func (r *redisRepository) Cache(url domain.URL) error {
+	rules := ""
+	if len(url.Rules) != 0 {
+		encoded, err := json.Marshal(url.Rules)
+		if err != nil {
+			return err
+		}
+		rules = string(encoded)
+	}
+	variants := ""
+	if len(url.Variants) != 0 {
+		encoded, err := json.Marshal(url.Variants)
+		if err != nil {
+			return err
+		}
+		variants = string(encoded)
+	}
+	metadata := ""
+	if url.Metadata != nil {
+		encoded, err := json.Marshal(url.Metadata)
+		if err != nil {
+			return err
+		}
+		metadata = string(encoded)
+	}
 	data := map[string]interface{}{
-		"url":        url.Full,
-		"created_at": url.CreatedAt.UTC(),
+		"url":           url.Full,
+		"created_at":    url.CreatedAt.UTC(),
+		"disabled":      url.Disabled,
+		"preview":       url.Preview,
+		"password_hash": url.PasswordHash,
+		"max_clicks":    url.MaxClicks,
+		"rules":         rules,
+		"variants":      variants,
+		"query_mode":    url.QueryMode,
+		"prefix":        url.Prefix,
+		// tags can't have commas
+		"tags":     strings.Join(url.Tags, ","),
+		"campaign": url.Campaign,
+		"title":    url.Title,
+		"notes":    url.Notes,
+		"metadata": metadata,
+		"owner":    url.Owner,
 	}
-	_, err := r.conn.HSet(url.Hash, data).Result()
+	_, err := r.conn.HSet(urlKey(url.Domain, url.Hash), data).Result()
 	if err != nil {
 		return err
 	}
@@ -53,6 +137,15 @@ func (r *redisRepository) Cache(url domain.URL) error {
 	return nil
 }
 
+func (r *redisRepository) Remove(urlDomain string, urlHash string) error {
+	return r.conn.Del(urlKey(urlDomain, urlHash)).Err()
+}
+
+// urlKey is the key of the hash holding a url, codes are unique per domain
+func urlKey(urlDomain string, urlHash string) string {
+	return "url:" + urlDomain + ":" + urlHash
+}
+
 func NewRedisRepository(redisURL string, timeout time.Duration, hasher *hashids.HashID) (*redisRepository, error) {
 	repo := &redisRepository{
 		hasher: hasher,
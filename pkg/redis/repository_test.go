@@ -63,7 +63,7 @@ func TestCacheShouldStoreCache(t *testing.T) {
 	if err := testRepo.Cache(expectedURL); err != nil {
 		t.Fatal("Failed inserting to cache", err)
 	}
-	data, err := testRepo.conn.HGetAll(expectedURL.Hash).Result()
+	data, err := testRepo.conn.HGetAll(urlKey(expectedURL.Domain, expectedURL.Hash)).Result()
 	if err != nil {
 		t.Fatal("Failed to get from cache", err)
 	}
@@ -88,7 +88,7 @@ func TestCacheShouldReplaceCache(t *testing.T) {
 	// clean up
 	defer func(conn *redis.Client, key string) {
 		conn.HDel(key, "created_at", "url")
-	}(testRepo.conn, hash)
+	}(testRepo.conn, urlKey("", hash))
 
 	// insert dummy
 	if err := testRepo.Cache(domain.URL{Hash: hash, Full: "dummy"}); err != nil {
@@ -99,7 +99,7 @@ func TestCacheShouldReplaceCache(t *testing.T) {
 		t.Fatal("Failed inserting to cache", err)
 	}
 
-	data, err := testRepo.conn.HGetAll(expectedURL.Hash).Result()
+	data, err := testRepo.conn.HGetAll(urlKey(expectedURL.Domain, expectedURL.Hash)).Result()
 	if err != nil {
 		t.Fatal("Failed to get from cache", err)
 	}
@@ -112,13 +112,13 @@ func TestCacheShouldReplaceCache(t *testing.T) {
 }
 
 func TestFindShouldReturnInvalidUrlError(t *testing.T) {
-	if _, err := testRepo.Find(""); err != domain.ErrorInvalidURL {
+	if _, err := testRepo.Find("", ""); err != domain.ErrorInvalidURL {
 		t.Fatal("Repo should return an URL invalid error but got:", err)
 	}
-	if _, err := testRepo.Find(" "); err != domain.ErrorInvalidURL {
+	if _, err := testRepo.Find("", " "); err != domain.ErrorInvalidURL {
 		t.Fatal("Repo should return an URL invalid error but got:", err)
 	}
-	if _, err := testRepo.Find("1"); err != domain.ErrorInvalidURL {
+	if _, err := testRepo.Find("", "1"); err != domain.ErrorInvalidURL {
 		t.Fatal("Repo should return an URL invalid error but got:", err)
 	}
 }
@@ -128,7 +128,7 @@ func TestFindShouldReturnUrlNotFound(t *testing.T) {
 		return
 	}
 	hash, _ := testHasher.EncodeInt64([]int64{8})
-	if _, err := testRepo.Find(hash); err != domain.ErrorURLNotFound {
+	if _, err := testRepo.Find("", hash); err != domain.ErrorURLNotFound {
 		t.Fatal("Repo should return an URL not found error but got:", err)
 	}
 }
@@ -149,13 +149,13 @@ func TestFindReturnsUrl(t *testing.T) {
 	// clean up
 	defer func(conn *redis.Client, key string) {
 		conn.HDel(key, "created_at", "url")
-	}(testRepo.conn, hash)
+	}(testRepo.conn, urlKey("", hash))
 
 	if err = testRepo.Cache(expected); err != nil {
 		t.Fatal("Failed to insert to cache", err)
 	}
 
-	actual, err := testRepo.Find(expected.Hash)
+	actual, err := testRepo.Find("", expected.Hash)
 	if err != nil {
 		t.Fatal("Failed to find from cache", err)
 	}
@@ -165,3 +165,85 @@ func TestFindReturnsUrl(t *testing.T) {
 		t.Fatal("Structs dont match", expected, actual)
 	}
 }
+
+func TestRemoveShouldDeleteCache(t *testing.T) {
+	if *testRedisCache == false {
+		return
+	}
+	hash, err := testHasher.EncodeInt64([]int64{9})
+	if err != nil {
+		t.Fatal("Failed to hash", err)
+	}
+	expected := domain.URL{
+		Hash:      hash,
+		Full:      "https://www.example.com",
+		CreatedAt: time.Now().UTC(),
+		Disabled:  true,
+	}
+	if err = testRepo.Cache(expected); err != nil {
+		t.Fatal("Failed to insert to cache", err)
+	}
+	actual, err := testRepo.Find("", hash)
+	if err != nil || actual.Disabled == false {
+		t.Fatal("Disabled flag should be cached", actual, err)
+	}
+	if err = testRepo.Remove("", hash); err != nil {
+		t.Fatal("Failed to remove from cache", err)
+	}
+	if _, err = testRepo.Find("", hash); err != domain.ErrorURLNotFound {
+		t.Fatal("Url should have been removed from cache", err)
+	}
+}
+
+func TestFindShouldOnlyReturnUrlsOfTheDomain(t *testing.T) {
+	if *testRedisCache == false {
+		return
+	}
+	hash, err := testHasher.EncodeInt64([]int64{10})
+	if err != nil {
+		t.Fatal("Failed to hash", err)
+	}
+	expected := domain.URL{
+		Domain:    "brand.co",
+		Hash:      hash,
+		Full:      "https://www.example.com",
+		CreatedAt: time.Now().UTC(),
+	}
+	defer testRepo.Remove(expected.Domain, hash)
+	if err = testRepo.Cache(expected); err != nil {
+		t.Fatal("Failed to insert to cache", err)
+	}
+	if actual, err := testRepo.Find("brand.co", hash); err != nil || actual.Domain != "brand.co" || actual.Full != expected.Full {
+		t.Fatal("Url should be found in its domain", actual, err)
+	}
+	if _, err := testRepo.Find("sho.rt", hash); err != domain.ErrorURLNotFound {
+		t.Fatal("Url should not be found in other domains", err)
+	}
+}
+
+func TestFindReturnsRoutingRules(t *testing.T) {
+	if *testRedisCache == false {
+		return
+	}
+	hash, err := testHasher.EncodeInt64([]int64{9})
+	if err != nil {
+		t.Fatal("Failed to hash", err)
+	}
+	expected := domain.URL{
+		Hash:  hash,
+		Full:  "https://www.example.com",
+		Rules: []domain.RoutingRule{{OS: "ios", URL: "https://apps.apple.com/app/example"}},
+	}
+	defer testRepo.Remove("", hash)
+
+	if err = testRepo.Cache(expected); err != nil {
+		t.Fatal("Failed to insert to cache", err)
+	}
+	actual, err := testRepo.Find("", expected.Hash)
+	if err != nil {
+		t.Fatal("Failed to find from cache", err)
+	}
+	if len(actual.Rules) != 1 || actual.Rules[0] != expected.Rules[0] {
+		t.Fatal("Cached urls should keep their routing rules", actual.Rules)
+	}
+}
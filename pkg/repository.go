@@ -1,16 +1,85 @@
 package urlshortener
 
+import "time"
+
 type URLCacheRepository interface {
-	Find(urlHash string) (URL, error)
+	Find(domain string, urlHash string) (URL, error)
 	Cache(url URL) error
+	Remove(domain string, urlHash string) error
 }
 
 type URLStoreRepository interface {
-	Find(urlHash string) (URL, error)
-	Create(url string) (URL, error)
+	Find(domain string, urlHash string) (URL, error)
+	Create(url string, opts CreateOptions) (URL, error)
+	Disable(domain string, urlHash string) error
+	// Delete removes the url of the owner and returns it, ErrorURLNotFound when the owner doesn't have it
+	Delete(domain string, urlHash string, owner string) (URL, error)
+	// CountClick atomically adds a click to a url and returns how many it has,
+	// it returns ErrorURLExpired when a click limited url already has all its clicks
+	CountClick(domain string, urlHash string) (int, error)
 }
 
 type URLAnalyticsRepository interface {
-	CreateURLView(urlHash string) error
+	CreateURLView(view URLView) error
 	Stats(urlHash string) (URLViewStats, error)
+	// ViewCounts adds up the views of the urls, see CampaignStats
+	ViewCounts(urlHashes []string) (ViewCounts, error)
+	// DeleteViews deletes the views of a deleted url, see AnalyticsSubscriber
+	DeleteViews(urlHash string) error
+}
+
+// ViewRollupRepository pre-aggregates the views of urls so their stats don't count every view, see ViewAggregator
+type ViewRollupRepository interface {
+	// RollUpViews aggregates the views recorded before until, Stats reads the aggregates and the views after them
+	RollUpViews(until time.Time) error
+	// PruneViews deletes the views recorded before, it keeps the ones that are still needed to roll up views.
+	// It returns how many were deleted
+	PruneViews(before time.Time) (int64, error)
+}
+
+// URLCatalogRepository lists the urls of an owner and keeps its campaigns, see URLCatalogService
+type URLCatalogRepository interface {
+	List(owner string, filter URLFilter) ([]URL, error)
+	CreateCampaign(owner string, name string) (Campaign, error)
+	// FindCampaign returns ErrorCampaignNotFound when the owner doesn't have the campaign
+	FindCampaign(owner string, campaignID string) (Campaign, error)
+	Campaigns(owner string) ([]Campaign, error)
+	// CampaignURLs returns the hashes of every url of the campaign of the owner,
+	// ErrorCampaignNotFound when the owner doesn't have the campaign
+	CampaignURLs(owner string, campaignID string) ([]string, error)
+}
+
+// URLMetadataRepository stores the metadata fetched for urls, see MetadataEnricher
+type URLMetadataRepository interface {
+	SaveMetadata(domain string, urlHash string, metadata LinkMetadata) error
+}
+
+// URLHealthRepository keeps the health of the destinations of urls, see HealthChecker
+type URLHealthRepository interface {
+	// HealthCheckCandidates returns up to limit urls that are served and were not checked since checkedBefore,
+	// the ones that were never checked first
+	HealthCheckCandidates(checkedBefore time.Time, limit int) ([]URL, error)
+	SaveHealth(domain string, urlHash string, health LinkHealth) error
+	// Health returns nil when the url wasn't checked yet
+	Health(domain string, urlHash string) (*LinkHealth, error)
+	BrokenURLs(owner string, limit int, offset int) ([]URLHealth, error)
+}
+
+// WebhookRepository keeps the webhooks of owners and the outbox of their deliveries, see WebhookDispatcher
+type WebhookRepository interface {
+	// CreateWebhook sets the ID and creation time of the webhook
+	CreateWebhook(owner string, webhook Webhook) (Webhook, error)
+	// Webhooks returns the webhooks of the owner with their secret, the oldest first
+	Webhooks(owner string) ([]Webhook, error)
+	// DeleteWebhook returns ErrorWebhookNotFound when the owner doesn't have the webhook, its pending deliveries are dropped
+	DeleteWebhook(owner string, webhookID string) error
+	// EnqueueDeliveries adds a delivery of the payload due at to the outbox for every webhook of the owner subscribed to the event
+	EnqueueDeliveries(owner string, event string, payload []byte, at time.Time) error
+	// DueDeliveries returns up to limit deliveries that are not done and due before now, the oldest first.
+	// They are not returned again for WebhookDeliveryLease
+	DueDeliveries(now time.Time, limit int) ([]WebhookDelivery, error)
+	// SaveDelivery saves the attempts, next attempt, error and state of a delivery
+	SaveDelivery(delivery WebhookDelivery) error
+	// PruneDeliveries deletes the done deliveries created before, returning how many
+	PruneDeliveries(before time.Time) (int64, error)
 }
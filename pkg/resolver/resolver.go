@@ -0,0 +1,48 @@
+package resolver
+
+import (
+	"net/http"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+	"github.com/yanisky/url-shortener/pkg/httpclient"
+)
+
+type httpResolver struct {
+	client *http.Client
+}
+
+// NewHTTPResolver creates a resolver that asks the other shortener where its link redirects to.
+// Redirects are not followed, the service follows the chain one link at a time.
+// Like other requests to destinations, private addresses are refused unless allowPrivateHosts is set.
+func NewHTTPResolver(timeout time.Duration, allowPrivateHosts bool) domain.ShortLinkResolver {
+	client := httpclient.New(timeout, allowPrivateHosts, 0)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &httpResolver{client: client}
+}
+
+func (r *httpResolver) Resolve(url string) (string, error) {
+	response, err := r.client.Head(url)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+	// some shorteners only answer GET requests
+	if response.StatusCode == http.StatusMethodNotAllowed {
+		response, err = r.client.Get(url)
+		if err != nil {
+			return "", err
+		}
+		response.Body.Close()
+	}
+	if response.StatusCode < 300 || response.StatusCode >= 400 {
+		return url, nil
+	}
+	location, err := response.Location()
+	if err != nil {
+		return "", err
+	}
+	return location.String(), nil
+}
@@ -0,0 +1,67 @@
+package resolver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yanisky/url-shortener/pkg/httpclient"
+)
+
+func TestResolveReturnsLocation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/abc":
+			http.Redirect(w, r, "https://www.example.com/destination", http.StatusMovedPermanently)
+		case "/relative":
+			http.Redirect(w, r, "/abc", http.StatusFound)
+		case "/get-only":
+			if r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			http.Redirect(w, r, "https://www.example.com/get", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	resolver := NewHTTPResolver(5*time.Second, true)
+	cases := map[string]string{
+		server.URL + "/abc":      "https://www.example.com/destination",
+		server.URL + "/relative": server.URL + "/abc",
+		server.URL + "/get-only": "https://www.example.com/get",
+		server.URL + "/page":     server.URL + "/page",
+	}
+	for url, expected := range cases {
+		actual, err := resolver.Resolve(url)
+		if err != nil {
+			t.Fatal("Failed to resolve", url, err)
+		}
+		if actual != expected {
+			t.Fatal("Resolved the wrong url", url, actual, expected)
+		}
+	}
+}
+
+func TestResolveFailsWhenUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+	if _, err := NewHTTPResolver(time.Second, true).Resolve(url); err == nil {
+		t.Fatal("Resolving an unreachable link should fail")
+	}
+}
+
+func TestResolveRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://www.example.com/destination", http.StatusMovedPermanently)
+	}))
+	defer server.Close()
+	if _, err := NewHTTPResolver(time.Second, false).Resolve(server.URL + "/abc"); err == nil || strings.Contains(err.Error(), httpclient.ErrPrivateAddress.Error()) == false {
+		t.Fatal("Links on private addresses should not be resolved", err)
+	}
+}
@@ -0,0 +1,66 @@
+package urlshortener
+
+import (
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// rollupLag is how long views have to be committed before the hour they were recorded in is rolled up
+const rollupLag = 5 * time.Minute
+
+// ViewRollupConfig holds the settings of a ViewAggregator
+type ViewRollupConfig struct {
+	// Interval is how often views are rolled up
+	Interval time.Duration
+	// Retention is how long views are kept once they are rolled up, they are kept forever when it's 0
+	Retention time.Duration
+	// Logger logs the roll ups Run fails, nothing is logged when it's nil
+	Logger log.Logger
+}
+
+// ViewAggregator rolls up the views of urls hour by hour in the background and prunes the old ones,
+// so stats read a few aggregates and the views of the current hour instead of every view
+type ViewAggregator struct {
+	repo   ViewRollupRepository
+	config ViewRollupConfig
+}
+
+// Aggregate rolls up the views of the hours that are over and prunes the views older than Retention
+func (a *ViewAggregator) Aggregate(now time.Time) error {
+	if err := a.repo.RollUpViews(now.Add(-rollupLag).Truncate(time.Hour)); err != nil {
+		return err
+	}
+	if a.config.Retention > 0 {
+		if _, err := a.repo.PruneViews(now.Add(-a.config.Retention)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run aggregates the views every Interval until stop is closed
+func (a *ViewAggregator) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := a.Aggregate(time.Now()); err != nil {
+			a.config.Logger.Log("msg", "failed to aggregate views", "err", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func NewViewAggregator(repo ViewRollupRepository, config ViewRollupConfig) *ViewAggregator {
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	return &ViewAggregator{
+		repo:   repo,
+		config: config,
+	}
+}
@@ -0,0 +1,62 @@
+package urlshortener
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAggregateRollsUpOverHours(t *testing.T) {
+	repo := &viewRollupRepoMock{}
+	aggregator := NewViewAggregator(repo, ViewRollupConfig{Retention: 30 * 24 * time.Hour})
+	now := time.Date(2020, 4, 3, 10, 3, 0, 0, time.UTC)
+	if err := aggregator.Aggregate(now); err != nil {
+		t.Fatal("Failed to aggregate", err)
+	}
+	// views of the last minutes of 9:00 may not be committed yet
+	if expected := time.Date(2020, 4, 3, 9, 0, 0, 0, time.UTC); repo.until.Equal(expected) == false {
+		t.Fatal("Only the hours that are over should be rolled up", repo.until)
+	}
+	if expected := now.AddDate(0, 0, -30); repo.before.Equal(expected) == false {
+		t.Fatal("Views older than the retention should be pruned", repo.before)
+	}
+
+	repo = &viewRollupRepoMock{}
+	if err := NewViewAggregator(repo, ViewRollupConfig{}).Aggregate(now); err != nil || repo.before.IsZero() == false {
+		t.Fatal("Views should be kept without retention", repo.before, err)
+	}
+}
+
+type viewRollupRepoMock struct {
+	until  time.Time
+	before time.Time
+	err    error
+}
+
+func (r *viewRollupRepoMock) RollUpViews(until time.Time) error {
+	r.until = until
+	return r.err
+}
+
+func (r *viewRollupRepoMock) PruneViews(before time.Time) (int64, error) {
+	r.before = before
+	return 0, nil
+}
+
+func TestRunLogsFailedAggregates(t *testing.T) {
+	logger := &loggerMock{}
+	repo := &viewRollupRepoMock{err: errors.New("timeout")}
+	aggregator := NewViewAggregator(repo, ViewRollupConfig{Interval: time.Hour, Logger: logger})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		aggregator.Run(stop)
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	<-done
+	if lines := logger.logged(); lines != 1 {
+		t.Fatal("Failed aggregates should be logged", lines)
+	}
+}
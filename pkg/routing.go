@@ -0,0 +1,141 @@
+package urlshortener
+
+import (
+	"net/url"
+	"strings"
+)
+
+// maxRoutingRules is how many rules a url can have, they are checked on every redirect
+const maxRoutingRules = 20
+
+// Values of the Device and OS conditions of routing rules
+var (
+	RoutingDevices = []string{"mobile", "tablet", "desktop"}
+	RoutingOSes    = []string{"ios", "android", "windows", "macos", "linux"}
+)
+
+// RoutingRule sends the visitors that match all of its conditions to URL instead of the url's destination.
+// Empty conditions match every visitor but a rule needs at least one.
+type RoutingRule struct {
+	// Device is one of RoutingDevices
+	Device string `json:"device,omitempty"`
+	// OS is one of RoutingOSes
+	OS string `json:"os,omitempty"`
+	// Country is an ISO 3166-1 alpha-2 code like "DE"
+	Country string `json:"country,omitempty"`
+	// Language is a language tag like "de" or "pt-br", "de" matches "de-at" too
+	Language string `json:"language,omitempty"`
+	URL      string `json:"url"`
+}
+
+// Visitor is what we know about whoever follows a url, see URL.Destination
+type Visitor struct {
+	Device  string
+	OS      string
+	Country string
+	// Languages are the accepted languages in order of preference
+	Languages []string
+	// Variant is the index of the variant the visitor was assigned for split urls, see URL.PickVariant
+	Variant int
+	// Query is the query string the visitor followed the url with
+	Query url.Values
+	// Path is the escaped path the visitor added after the code of a prefix url, see CleanPathSuffix
+	Path string
+	// ID identifies the visitor for unique visitor counts, see VisitorHash
+	ID string
+}
+
+// Destination returns where the visitor goes, the URL of the first rule it matches,
+// the URL of its variant for split urls or Full, with the path of the visitor for prefix urls
+// and its query following QueryMode
+func (u URL) Destination(visitor Visitor) string {
+	destination, _ := u.route(visitor)
+	if u.Prefix {
+		destination = joinPath(destination, visitor.Path)
+	}
+	return u.forwardQuery(destination, visitor.Query)
+}
+
+func (r RoutingRule) matches(visitor Visitor) bool {
+	if len(r.Device) != 0 && r.Device != visitor.Device {
+		return false
+	}
+	if len(r.OS) != 0 && r.OS != visitor.OS {
+		return false
+	}
+	if len(r.Country) != 0 && strings.EqualFold(r.Country, visitor.Country) == false {
+		return false
+	}
+	if len(r.Language) != 0 {
+		for _, language := range visitor.Languages {
+			language = strings.ToLower(language)
+			if language == r.Language || strings.HasPrefix(language, r.Language+"-") {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// normalizeRoutingRule checks the conditions of a rule and puts them in the form matches expects.
+// The URL is checked by the service like any other destination.
+func normalizeRoutingRule(rule RoutingRule) (RoutingRule, error) {
+	rule.Device = strings.ToLower(strings.TrimSpace(rule.Device))
+	rule.OS = strings.ToLower(strings.TrimSpace(rule.OS))
+	rule.Country = strings.ToUpper(strings.TrimSpace(rule.Country))
+	rule.Language = strings.ToLower(strings.TrimSpace(rule.Language))
+	if len(rule.Device)+len(rule.OS)+len(rule.Country)+len(rule.Language) == 0 {
+		return rule, newURLValidationError(RuleRoutingRule, "routing rules need at least one condition")
+	}
+	if len(rule.Device) != 0 && contains(RoutingDevices, rule.Device) == false {
+		return rule, newURLValidationError(RuleRoutingRule, "routing rule has an unknown device")
+	}
+	if len(rule.OS) != 0 && contains(RoutingOSes, rule.OS) == false {
+		return rule, newURLValidationError(RuleRoutingRule, "routing rule has an unknown os")
+	}
+	if len(rule.Country) != 0 && (len(rule.Country) != 2 || isLetters(rule.Country) == false) {
+		return rule, newURLValidationError(RuleRoutingRule, "routing rule country must be a two letter code")
+	}
+	if len(rule.Language) != 0 && validLanguageTag(rule.Language) == false {
+		return rule, newURLValidationError(RuleRoutingRule, "routing rule has an invalid language")
+	}
+	return rule, nil
+}
+
+// validLanguageTag checks the shape of a lowercased BCP 47 tag, a 2 or 3 letter language and optional subtags
+func validLanguageTag(tag string) bool {
+	subtags := strings.Split(tag, "-")
+	if len(subtags[0]) < 2 || len(subtags[0]) > 3 || isLetters(subtags[0]) == false {
+		return false
+	}
+	for _, subtag := range subtags[1:] {
+		if len(subtag) == 0 || len(subtag) > 8 {
+			return false
+		}
+		for _, c := range subtag {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isLetters(value string) bool {
+	for _, c := range strings.ToLower(value) {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package urlshortener
+
+import (
+	"testing"
+)
+
+func TestDestinationUsesFirstMatchingRule(t *testing.T) {
+	url := URL{
+		Full: "https://www.example.com/",
+		Rules: []RoutingRule{
+			{OS: "ios", URL: "https://apps.apple.com/app/example"},
+			{OS: "android", URL: "https://play.google.com/store/apps/details?id=com.example"},
+			{Language: "de", Country: "AT", URL: "https://www.example.com/at"},
+			{Language: "de", URL: "https://www.example.com/de"},
+			{Device: "desktop", URL: "https://www.example.com/desktop"},
+		},
+	}
+	for expected, visitor := range map[string]Visitor{
+		"https://apps.apple.com/app/example":                        {Device: "mobile", OS: "ios", Languages: []string{"de"}},
+		"https://play.google.com/store/apps/details?id=com.example": {Device: "tablet", OS: "android"},
+		"https://www.example.com/at":                                {Device: "desktop", Country: "at", Languages: []string{"de-AT", "en"}},
+		"https://www.example.com/de":                                {Device: "desktop", Country: "DE", Languages: []string{"en", "de-DE"}},
+		"https://www.example.com/desktop":                           {Device: "desktop", Languages: []string{"deu"}},
+		"https://www.example.com/":                                  {Device: "mobile", OS: "windows"},
+	} {
+		if actual := url.Destination(visitor); actual != expected {
+			t.Fatal("Visitor went to the wrong destination", visitor, actual, expected)
+		}
+	}
+	if (URL{Full: "https://www.example.com/"}).Destination(Visitor{OS: "ios"}) != "https://www.example.com/" {
+		t.Fatal("Urls without rules should go to their destination")
+	}
+}
+
+func TestNormalizeRoutingRule(t *testing.T) {
+	rule, err := normalizeRoutingRule(RoutingRule{Device: " Mobile", OS: "iOS", Country: "de", Language: "PT-br"})
+	if err != nil || rule.Device != "mobile" || rule.OS != "ios" || rule.Country != "DE" || rule.Language != "pt-br" {
+		t.Fatal("Rule conditions should be normalized", rule, err)
+	}
+	for _, invalid := range []RoutingRule{
+		{URL: "https://www.example.com"},
+		{Device: "watch"},
+		{OS: "beos"},
+		{Country: "DEU"},
+		{Country: "1A"},
+		{Language: "d"},
+		{Language: "de_AT"},
+		{Language: "de-"},
+	} {
+		_, err := normalizeRoutingRule(invalid)
+		if validationErr, ok := err.(*URLValidationError); ok == false || validationErr.Rule != RuleRoutingRule {
+			t.Fatal("Rule should be invalid", invalid, err)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package urlshortener
+
+// URLScreener checks destinations for abuse like phishing or malware.
+// Screen returns ErrorURLBlocked when the url must not be shortened or served
+// and any other error if the url couldn't be checked.
+type URLScreener interface {
+	Screen(url string) error
+}
@@ -0,0 +1,59 @@
+package screening
+
+import (
+	"net/url"
+	"strings"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+	"golang.org/x/net/idna"
+)
+
+type domainBlocklist struct {
+	domains map[string]struct{}
+}
+
+// NewDomainBlocklist loads a blocklist file with one domain per line,
+// urls to those domains or any of their subdomains are blocked.
+// Lines can also be in hosts file format ("0.0.0.0 evil.com") and # starts a comment.
+func NewDomainBlocklist(path string) (domain.URLScreener, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	blocklist := &domainBlocklist{domains: make(map[string]struct{}, len(lines))}
+	for _, line := range lines {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		host, err := idna.Lookup.ToASCII(strings.Trim(fields[len(fields)-1], "."))
+		if err != nil || len(host) == 0 {
+			continue
+		}
+		blocklist.domains[host] = struct{}{}
+	}
+	return blocklist, nil
+}
+
+func (b *domainBlocklist) Screen(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return domain.ErrorInvalidURL
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	// check the host and all its parent domains
+	for len(host) != 0 {
+		if _, ok := b.domains[host]; ok {
+			return domain.ErrorURLBlocked
+		}
+		i := strings.Index(host, ".")
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return nil
+}
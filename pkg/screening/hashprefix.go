@@ -0,0 +1,187 @@
+package screening
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+// safeBrowsingUpdate is the part of a Safe Browsing v4 threatListUpdates response we use
+type safeBrowsingUpdate struct {
+	Additions []struct {
+		CompressionType string `json:"compressionType"`
+		RawHashes       struct {
+			PrefixSize int    `json:"prefixSize"`
+			RawHashes  string `json:"rawHashes"`
+		} `json:"rawHashes"`
+	} `json:"additions"`
+}
+
+type hashPrefixScreener struct {
+	// prefixes by prefix size
+	prefixes map[int]map[string]struct{}
+}
+
+// NewHashPrefixScreener loads a list of SHA256 hash prefixes in the Safe Browsing v4 format,
+// a full update response saved from threatListUpdates:fetch with RAW compression.
+// Urls are blocked when any of their Safe Browsing url expressions match a prefix.
+// Full hashes are not verified against the API, a matching prefix is enough to block.
+func NewHashPrefixScreener(path string) (domain.URLScreener, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	update := safeBrowsingUpdate{}
+	if err := json.Unmarshal(data, &update); err != nil {
+		return nil, err
+	}
+	screener := &hashPrefixScreener{prefixes: map[int]map[string]struct{}{}}
+	for _, addition := range update.Additions {
+		if addition.CompressionType != "" && addition.CompressionType != "RAW" {
+			return nil, fmt.Errorf("unsupported compression type %s in %s", addition.CompressionType, path)
+		}
+		size := addition.RawHashes.PrefixSize
+		raw, err := base64.StdEncoding.DecodeString(addition.RawHashes.RawHashes)
+		if err != nil {
+			return nil, err
+		}
+		if size < 4 || size > sha256.Size || len(raw)%size != 0 {
+			return nil, fmt.Errorf("invalid prefix size %d in %s", size, path)
+		}
+		if screener.prefixes[size] == nil {
+			screener.prefixes[size] = map[string]struct{}{}
+		}
+		for i := 0; i < len(raw); i += size {
+			screener.prefixes[size][string(raw[i:i+size])] = struct{}{}
+		}
+	}
+	return screener, nil
+}
+
+func (s *hashPrefixScreener) Screen(rawURL string) error {
+	expressions, err := urlExpressions(rawURL)
+	if err != nil {
+		return domain.ErrorInvalidURL
+	}
+	for _, expression := range expressions {
+		hash := sha256.Sum256([]byte(expression))
+		for size, prefixes := range s.prefixes {
+			if _, ok := prefixes[string(hash[:size])]; ok {
+				return domain.ErrorURLBlocked
+			}
+		}
+	}
+	return nil
+}
+
+// urlExpressions returns the host suffix / path prefix combinations
+// that Safe Browsing looks up for a url
+func urlExpressions(rawURL string) ([]string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := canonicalHost(u.Hostname())
+	if len(host) == 0 {
+		return nil, domain.ErrorInvalidURL
+	}
+	path := canonicalPath(u.Path)
+
+	hosts := []string{host}
+	if net.ParseIP(host) == nil {
+		// up to 4 hosts made from the last 5 components, removing one component at a time
+		components := strings.Split(host, ".")
+		if len(components) > 5 {
+			components = components[len(components)-5:]
+		}
+		for i := 0; i < len(components)-1; i++ {
+			suffix := strings.Join(components[i:], ".")
+			if suffix != host {
+				hosts = append(hosts, suffix)
+			}
+		}
+	}
+
+	paths := []string{}
+	if len(u.RawQuery) != 0 {
+		paths = append(paths, path+"?"+u.RawQuery)
+	}
+	paths = append(paths, path)
+	// up to 4 paths from the root adding one component at a time
+	components := strings.Split(strings.Trim(path, "/"), "/")
+	prefix := "/"
+	for i := 0; i < len(components) && len(paths) < 6; i++ {
+		if prefix != path {
+			paths = append(paths, prefix)
+		}
+		if len(components[i]) == 0 {
+			break
+		}
+		prefix = prefix + components[i] + "/"
+	}
+
+	expressions := make([]string, 0, len(hosts)*len(paths))
+	for _, h := range hosts {
+		for _, p := range paths {
+			expressions = append(expressions, h+p)
+		}
+	}
+	return expressions, nil
+}
+
+func canonicalHost(host string) string {
+	host = strings.ToLower(strings.Trim(host, "."))
+	for strings.Contains(host, "..") {
+		host = strings.Replace(host, "..", ".", -1)
+	}
+	return host
+}
+
+// canonicalPath unescapes the path, resolves "." and ".." and removes repeated slashes
+func canonicalPath(path string) string {
+	for {
+		unescaped, err := url.PathUnescape(path)
+		if err != nil || unescaped == path {
+			break
+		}
+		path = unescaped
+	}
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(segments) != 0 {
+				segments = segments[:len(segments)-1]
+			}
+		default:
+			segments = append(segments, segment)
+		}
+	}
+	canonical := "/" + strings.Join(segments, "/")
+	if len(segments) != 0 && strings.HasSuffix(path, "/") {
+		canonical = canonical + "/"
+	}
+	return escapeExpression(canonical)
+}
+
+// escapeExpression escapes control characters, spaces, non ASCII, '#' and '%'
+func escapeExpression(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= 32 || c >= 127 || c == '#' || c == '%' {
+			fmt.Fprintf(&builder, "%%%02X", c)
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String()
+}
@@ -0,0 +1,95 @@
+package screening
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestURLExpressions(t *testing.T) {
+	// examples from the Safe Browsing v4 documentation
+	cases := map[string][]string{
+		"http://a.b.c/1/2.html?param=1": {
+			"a.b.c/1/2.html?param=1", "a.b.c/1/2.html", "a.b.c/", "a.b.c/1/",
+			"b.c/1/2.html?param=1", "b.c/1/2.html", "b.c/", "b.c/1/",
+		},
+		"http://a.b.c.d.e.f.g/1.html": {
+			"a.b.c.d.e.f.g/1.html", "a.b.c.d.e.f.g/",
+			"c.d.e.f.g/1.html", "c.d.e.f.g/",
+			"d.e.f.g/1.html", "d.e.f.g/",
+			"e.f.g/1.html", "e.f.g/",
+			"f.g/1.html", "f.g/",
+		},
+		"http://1.2.3.4/1/": {
+			"1.2.3.4/1/", "1.2.3.4/",
+		},
+		"http://www.example.com/a/./b/../c//d%2541": {
+			"www.example.com/a/c/dA", "www.example.com/", "www.example.com/a/", "www.example.com/a/c/",
+			"example.com/a/c/dA", "example.com/", "example.com/a/", "example.com/a/c/",
+		},
+	}
+	for url, expected := range cases {
+		actual, err := urlExpressions(url)
+		if err != nil {
+			t.Fatal("Failed to get url expressions", url, err)
+		}
+		if reflect.DeepEqual(actual, expected) == false {
+			t.Fatal("Url expressions don't match", url, actual, expected)
+		}
+	}
+}
+
+func TestHashPrefixScreener(t *testing.T) {
+	fullHash := sha256.Sum256([]byte("evil.example.com/phishing/"))
+	otherHash := sha256.Sum256([]byte("malware.test/"))
+	raw := append(fullHash[:4], otherHash[:4]...)
+	path := writeTestFile(t, "prefixes.json", fmt.Sprintf(`{
+		"listUpdateResponses": "ignored",
+		"additions": [{"compressionType": "RAW", "rawHashes": {"prefixSize": 4, "rawHashes": "%s"}}]
+	}`, base64.StdEncoding.EncodeToString(raw)))
+	defer os.Remove(path)
+
+	screener, err := NewHashPrefixScreener(path)
+	if err != nil {
+		t.Fatal("Failed to load hash prefixes", err)
+	}
+	blocked := []string{
+		"http://evil.example.com/phishing/login.html",
+		"https://www.evil.example.com/phishing/",
+		"http://malware.test/any/path?query=1",
+	}
+	for _, url := range blocked {
+		if err := screener.Screen(url); err != domain.ErrorURLBlocked {
+			t.Fatal("Url should have been blocked:", url, err)
+		}
+	}
+	allowed := []string{
+		"http://evil.example.com/",
+		"http://example.com/phishing/",
+	}
+	for _, url := range allowed {
+		if err := screener.Screen(url); err != nil {
+			t.Fatal("Url should have been allowed:", url, err)
+		}
+	}
+}
+
+func TestHashPrefixScreenerRejectsInvalidLists(t *testing.T) {
+	cases := []string{
+		`{"additions": [{"compressionType": "RICE", "riceHashes": {}}]}`,
+		`{"additions": [{"rawHashes": {"prefixSize": 4, "rawHashes": "AAAA"}}]}`,
+		`not json`,
+	}
+	for _, content := range cases {
+		path := writeTestFile(t, "prefixes.json", content)
+		if _, err := NewHashPrefixScreener(path); err == nil {
+			t.Fatal("Invalid list should fail to load", content)
+		}
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,39 @@
+package screening
+
+import (
+	"fmt"
+	"regexp"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+type regexScreener struct {
+	rules []*regexp.Regexp
+}
+
+// NewRegexScreener loads a file with one regular expression per line,
+// urls matching any of them are blocked. # starts a comment.
+func NewRegexScreener(path string) (domain.URLScreener, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	screener := &regexScreener{rules: make([]*regexp.Regexp, 0, len(lines))}
+	for _, line := range lines {
+		rule, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q in %s: %v", line, path, err)
+		}
+		screener.rules = append(screener.rules, rule)
+	}
+	return screener, nil
+}
+
+func (s *regexScreener) Screen(url string) error {
+	for _, rule := range s.rules {
+		if rule.MatchString(url) {
+			return domain.ErrorURLBlocked
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package screening
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+type combinedScreener struct {
+	screeners []domain.URLScreener
+}
+
+// Combine returns a screener that runs all the given screeners in order
+// and stops at the first one that fails
+func Combine(screeners ...domain.URLScreener) domain.URLScreener {
+	return &combinedScreener{screeners: screeners}
+}
+
+func (s *combinedScreener) Screen(url string) error {
+	for _, screener := range s.screeners {
+		if err := screener.Screen(url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FromFiles creates a screener from a domain blocklist, a regex rules file and
+// a Safe Browsing hash prefix list, empty paths are skipped.
+// It returns nil when all paths are empty.
+func FromFiles(blocklistPath string, rulesPath string, hashPrefixesPath string) (domain.URLScreener, error) {
+	screeners := []domain.URLScreener{}
+	loaders := []struct {
+		path string
+		load func(string) (domain.URLScreener, error)
+	}{
+		{blocklistPath, NewDomainBlocklist},
+		{rulesPath, NewRegexScreener},
+		{hashPrefixesPath, NewHashPrefixScreener},
+	}
+	for _, loader := range loaders {
+		if len(loader.path) == 0 {
+			continue
+		}
+		screener, err := loader.load(loader.path)
+		if err != nil {
+			return nil, err
+		}
+		screeners = append(screeners, screener)
+	}
+	if len(screeners) == 0 {
+		return nil, nil
+	}
+	return Combine(screeners...), nil
+}
+
+// readLines returns the trimmed lines of a file skipping empty lines and # comments
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
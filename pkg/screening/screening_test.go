@@ -0,0 +1,112 @@
+package screening
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+func TestDomainBlocklist(t *testing.T) {
+	path := writeTestFile(t, "blocklist.txt", `
+# phishing
+evil.com
+0.0.0.0 malware.example.org # hosts file format
+bücher.example
+`)
+	defer os.Remove(path)
+	screener, err := NewDomainBlocklist(path)
+	if err != nil {
+		t.Fatal("Failed to load blocklist", err)
+	}
+	blocked := []string{
+		"http://evil.com",
+		"https://login.evil.com/account",
+		"http://malware.example.org/",
+		"http://xn--bcher-kva.example/",
+	}
+	for _, url := range blocked {
+		if err := screener.Screen(url); err != domain.ErrorURLBlocked {
+			t.Fatal("Url should have been blocked:", url, err)
+		}
+	}
+	allowed := []string{
+		"http://notevil.com",
+		"http://example.org",
+		"https://www.example.com/evil.com",
+	}
+	for _, url := range allowed {
+		if err := screener.Screen(url); err != nil {
+			t.Fatal("Url should have been allowed:", url, err)
+		}
+	}
+}
+
+func TestRegexScreener(t *testing.T) {
+	path := writeTestFile(t, "rules.txt", `
+# paypal lookalikes
+^https?://[^/]*paypa1\.
+/wp-admin/.*\.php$
+`)
+	defer os.Remove(path)
+	screener, err := NewRegexScreener(path)
+	if err != nil {
+		t.Fatal("Failed to load rules", err)
+	}
+	if err := screener.Screen("https://secure.paypa1.com/login"); err != domain.ErrorURLBlocked {
+		t.Fatal("Url should have been blocked", err)
+	}
+	if err := screener.Screen("http://example.com/wp-admin/shell.php"); err != domain.ErrorURLBlocked {
+		t.Fatal("Url should have been blocked", err)
+	}
+	if err := screener.Screen("https://www.paypal.com/"); err != nil {
+		t.Fatal("Url should have been allowed", err)
+	}
+}
+
+func TestRegexScreenerRejectsInvalidRules(t *testing.T) {
+	path := writeTestFile(t, "rules.txt", "(unclosed\n")
+	defer os.Remove(path)
+	if _, err := NewRegexScreener(path); err == nil {
+		t.Fatal("Invalid rules should fail to load")
+	}
+}
+
+func TestCombineStopsAtFirstFailure(t *testing.T) {
+	first := &screenerMock{err: domain.ErrorURLBlocked}
+	second := &screenerMock{}
+	if err := Combine(first, second).Screen("http://example.com"); err != domain.ErrorURLBlocked {
+		t.Fatal("Combined screener should have failed", err)
+	}
+	if first.called == false || second.called == true {
+		t.Fatal("Combined screener should stop at the first failure", first, second)
+	}
+	first.err = nil
+	if err := Combine(first, second).Screen("http://example.com"); err != nil || second.called == false {
+		t.Fatal("Combined screener should run all screeners", err)
+	}
+}
+
+type screenerMock struct {
+	called bool
+	err    error
+}
+
+func (s *screenerMock) Screen(url string) error {
+	s.called = true
+	return s.err
+}
+
+func writeTestFile(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir("", "screening")
+	if err != nil {
+		t.Fatal("Failed to create temp dir", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal("Failed to write test file", err)
+	}
+	return path
+}
@@ -1,45 +1,235 @@
 package urlshortener
 
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type URLShortenerService interface {
-	Find(hashUrl string, shouldTrack bool) (URL, error)
-	Create(url string) (URL, error)
+	Find(domain string, hashUrl string, shouldTrack bool) (URL, error)
+	Create(url string, opts CreateOptions) (URL, error)
 	RecordURLView(urlHash string) error
 	Stats(hashUrl string) (URLViewStats, error)
+	Screen(url URL) error
+	Disable(domain string, urlHash string) error
+	Delete(domain string, urlHash string, owner string) error
+	Click(url URL, visitor Visitor) error
+}
+
+// ServiceConfig holds the settings of the url shortener service
+type ServiceConfig struct {
+	// Domains are the short domains links can be created on
+	Domains Domains
+	// Policy is applied to every url before it's created
+	Policy URLPolicy
+	// Screener when set checks every url before it's created
+	Screener URLScreener
+	// ScreenOnFind makes Find check urls with the Screener too,
+	// so urls that were added to a blocklist after they were created stop being served
+	ScreenOnFind bool
+	// OwnHosts are the hosts this shortener is served from besides Domains,
+	// urls to them and to Domains are rejected to avoid redirect loops unless FlattenOwnURLs is set
+	OwnHosts []string
+	// FlattenOwnURLs replaces urls to our own short urls, and to ShortenerHosts, with their destination
+	FlattenOwnURLs bool
+	// ShortenerHosts are other url shorteners, their links are followed with Resolver
+	// to find chains that loop back to us
+	ShortenerHosts []string
+	// Resolver resolves links of ShortenerHosts, they are not followed when it's nil
+	Resolver ShortLinkResolver
+	// Enricher fetches the metadata of new urls in the background, it's not fetched when it's nil
+	Enricher *MetadataEnricher
+	// Events receives the events of urls, see EventTypes. When it's nil the service uses a LocalEventBus
+	// with an AnalyticsSubscriber, otherwise views are only recorded by the subscribers of the bus
+	Events EventBus
+	// ClickEvents counts the clicks of every url with an owner so EventClickThreshold is published,
+	// only the clicks of click limited urls are counted otherwise. Counting costs a write to the store
+	// for every click of those urls, in the background
+	ClickEvents bool
 }
 
 type urlShortenerService struct {
 	store     URLStoreRepository
 	analytics URLAnalyticsRepository
+	events    EventBus
+	config    ServiceConfig
 }
 
-// Find will find the url that matches the short url hash
+// Find will find the url of the domain that matches the short url hash
 // if shouldTrack is set to true it add a view to the url
 // to get the stats use the Stats method
-func (s *urlShortenerService) Find(urlHash string, shouldTrack bool) (URL, error) {
-	url, err := s.store.Find(urlHash)
+func (s *urlShortenerService) Find(domain string, urlHash string, shouldTrack bool) (URL, error) {
+	url, err := s.findURL(domain, urlHash)
 	if err != nil {
 		return URL{}, err
 	}
+	if err := s.Screen(url); err != nil {
+		return URL{}, err
+	}
+	s.publish(EventLinkFound, url, 0, NoVariant)
 	if shouldTrack == true {
-		go func() {
-			s.RecordURLView(urlHash)
-		}()
+		s.publish(EventLinkVisited, url, 0, NoVariant)
 	}
 	return url, nil
 }
 
+// findURL finds the url of the domain in the store. Urls created before domains were configured
+// have the empty domain, the default domain serves them. They are returned with the default domain
+// so they are cached, disabled, deleted and clicked like its other urls.
+func (s *urlShortenerService) findURL(domain string, urlHash string) (URL, error) {
+	url, err := s.store.Find(domain, urlHash)
+	if err == ErrorURLNotFound && s.servesURLsWithoutDomain(domain) {
+		if url, err = s.store.Find("", urlHash); err == nil {
+			url.Domain = domain
+		}
+	}
+	return url, err
+}
+
+// servesURLsWithoutDomain is true for the default domain, see findURL
+func (s *urlShortenerService) servesURLsWithoutDomain(domain string) bool {
+	return len(domain) != 0 && domain == canonicalDomain(s.config.Domains.Default)
+}
+
 // Create creates a short url hash that can be used in the service
-func (s *urlShortenerService) Create(fullUrl string) (URL, error) {
-	url, err := s.store.Create(fullUrl)
+// the url is validated and normalized with the service's URLPolicy first
+// and links to this or other shorteners are followed, see ServiceConfig
+// see CreateOptions for the available settings, the domain has to be one of the configured Domains
+func (s *urlShortenerService) Create(fullUrl string, opts CreateOptions) (URL, error) {
+	domain, err := s.config.Domains.Validate(opts.Domain)
+	if err != nil {
+		return URL{}, err
+	}
+	opts.Domain = domain
+	opts.PasswordHash = ""
+	if len(opts.Password) != 0 {
+		if opts.PasswordHash, err = hashPassword(opts.Password); err != nil {
+			return URL{}, err
+		}
+		opts.Password = ""
+	}
+	if validQueryMode(opts.QueryMode) == false {
+		return URL{}, ErrorInvalidQueryMode
+	}
+	if opts.Tags, err = normalizeTags(opts.Tags); err != nil {
+		return URL{}, err
+	}
+	opts.Title = strings.TrimSpace(opts.Title)
+	if len(opts.Title) > maxTitleLength {
+		return URL{}, newURLValidationError(RuleTitle, "title can't be longer than "+strconv.Itoa(maxTitleLength)+" characters")
+	}
+	opts.Notes = strings.TrimSpace(opts.Notes)
+	if len(opts.Notes) > maxNotesLength {
+		return URL{}, newURLValidationError(RuleNotes, "notes can't be longer than "+strconv.Itoa(maxNotesLength)+" characters")
+	}
+	// an existing url might not have the same password, clicks left, rules, query mode, prefix setting,
+	// preview, tags, campaign, title or notes. Urls created with them aren't reused either
+	if opts.PasswordHash != "" || opts.MaxClicks > 0 || len(opts.Rules) != 0 || len(opts.Variants) != 0 ||
+		opts.QueryMode != QueryDrop || opts.Prefix || opts.Preview || len(opts.Tags) != 0 || len(opts.Campaign) != 0 ||
+		len(opts.Title) != 0 || len(opts.Notes) != 0 {
+		opts.ReuseExisting = false
+	}
+	utm := opts.UTM.values()
+	if len(opts.Variants) != 0 {
+		if len(fullUrl) != 0 {
+			return URL{}, newURLValidationError(RuleVariants, "split urls go to their variants, they don't have a url")
+		}
+		if opts.Variants, err = s.checkVariants(opts.Variants, utm); err != nil {
+			return URL{}, err
+		}
+		fullUrl = opts.Variants[0].URL
+	}
+	normalizedURL, err := s.checkDestination(fullUrl, utm)
+	if err != nil {
+		return URL{}, err
+	}
+	if opts.Rules, err = s.checkRoutingRules(opts.Rules, utm); err != nil {
+		return URL{}, err
+	}
+	url, err := s.store.Create(normalizedURL, opts)
 	if err != nil {
 		return URL{}, err
 	}
+	// reused urls may have it already
+	if s.config.Enricher != nil && url.Metadata == nil {
+		s.config.Enricher.Enqueue(url)
+	}
+	if url.Reused {
+		s.publish(EventLinkFound, url, 0, NoVariant)
+	} else {
+		s.publish(EventLinkCreated, url, 0, NoVariant)
+	}
 
 	return url, nil
 }
 
+// checkDestination normalizes a url visitors can be sent to, follows short links and screens it.
+// The utm parameters are added last so they are not lost when a short link is flattened.
+func (s *urlShortenerService) checkDestination(rawURL string, utm url.Values) (string, error) {
+	normalizedURL, err := s.config.Policy.Normalize(rawURL)
+	if err != nil {
+		return "", err
+	}
+	normalizedURL, err = s.followShortLinks(normalizedURL)
+	if err != nil {
+		return "", err
+	}
+	if s.config.Screener != nil {
+		if err := s.config.Screener.Screen(normalizedURL); err != nil {
+			return "", err
+		}
+	}
+	if len(utm) != 0 {
+		if normalizedURL, err = AddQuery(normalizedURL, utm, true); err != nil {
+			return "", newURLValidationError(RuleMalformed, "url is malformed")
+		}
+		// the parameters can make the url too long, the final url follows the policy too
+		return s.config.Policy.Normalize(normalizedURL)
+	}
+	return normalizedURL, nil
+}
+
+// checkRoutingRules validates the conditions of the rules, their urls go through checkDestination
+func (s *urlShortenerService) checkRoutingRules(rules []RoutingRule, utm url.Values) ([]RoutingRule, error) {
+	if len(rules) > maxRoutingRules {
+		return nil, newURLValidationError(RuleRoutingRule, "urls can't have more than "+strconv.Itoa(maxRoutingRules)+" routing rules")
+	}
+	var checked []RoutingRule
+	for _, rule := range rules {
+		rule, err := normalizeRoutingRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		if rule.URL, err = s.checkDestination(rule.URL, utm); err != nil {
+			return nil, err
+		}
+		checked = append(checked, rule)
+	}
+	return checked, nil
+}
+
+// checkVariants validates the variants of a split url, their urls go through checkDestination
+func (s *urlShortenerService) checkVariants(variants []Variant, utm url.Values) ([]Variant, error) {
+	if err := checkVariants(variants); err != nil {
+		return nil, err
+	}
+	checked := make([]Variant, len(variants))
+	for i, variant := range variants {
+		destination, err := s.checkDestination(variant.URL, utm)
+		if err != nil {
+			return nil, err
+		}
+		checked[i] = Variant{URL: destination, Weight: variant.Weight}
+	}
+	return checked, nil
+}
+
+// RecordURLView publishes a view of the url, the subscribers of EventLinkVisited record it
 func (s *urlShortenerService) RecordURLView(urlHash string) error {
-	return s.analytics.CreateURLView(urlHash)
+	s.publish(EventLinkVisited, URL{Hash: urlHash}, 0, NoVariant)
+	return nil
 }
 
 // Stats returns some basic stats
@@ -50,9 +240,122 @@ func (s *urlShortenerService) Stats(urlHash string) (URLViewStats, error) {
 	return s.analytics.Stats(urlHash)
 }
 
-func NewURLShortenerService(store URLStoreRepository, analytics URLAnalyticsRepository) URLShortenerService {
+// Screen returns ErrorURLBlocked if the url can't be served,
+// because it was disabled or because it fails the screener when ScreenOnFind is set.
+// Find calls it for every url, caches call it for the urls they find
+func (s *urlShortenerService) Screen(url URL) error {
+	if url.Disabled {
+		return ErrorURLBlocked
+	}
+	if s.config.ScreenOnFind && s.config.Screener != nil {
+		if err := s.config.Screener.Screen(url.Full); err != nil {
+			return err
+		}
+		for _, rule := range url.Rules {
+			if err := s.config.Screener.Screen(rule.URL); err != nil {
+				return err
+			}
+		}
+		// the first variant is Full
+		for i := 1; i < len(url.Variants); i++ {
+			if err := s.config.Screener.Screen(url.Variants[i].URL); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Click is called when a url is followed, before redirecting.
+// Clicks of click limited urls are counted right away so concurrent clicks can't go over the limit,
+// ErrorURLExpired is returned once there are no clicks left. The view is published with the visitor
+// and the variant it goes to for split urls, after the click events of the url.
+func (s *urlShortenerService) Click(url URL, visitor Visitor) error {
+	if url.MaxClicks > 0 {
+		clicks, err := s.countClick(url)
+		if err != nil {
+			return err
+		}
+		s.clicked(url, clicks)
+	} else if s.config.ClickEvents && len(url.Owner) != 0 {
+		// only the events need the clicks of urls without a limit
+		go func() {
+			if clicks, err := s.countClick(url); err == nil {
+				s.clicked(url, clicks)
+			}
+		}()
+	}
+	_, variant := url.route(visitor)
+	s.events.Publish(LinkEvent{Type: EventLinkVisited, URL: url, At: time.Now().UTC(), Variant: variant, Visitor: visitor.ID})
+	return nil
+}
+
+// countClick counts a click of the url in the store, with the empty domain for the urls findURL
+// serves on the default domain
+func (s *urlShortenerService) countClick(url URL) (int, error) {
+	clicks, err := s.store.CountClick(url.Domain, url.Hash)
+	if (err == ErrorURLExpired || err == ErrorURLNotFound) && s.servesURLsWithoutDomain(url.Domain) {
+		return s.store.CountClick("", url.Hash)
+	}
+	return clicks, err
+}
+
+// clicked publishes the events the url is due after being followed for the clicks time
+func (s *urlShortenerService) clicked(url URL, clicks int) {
+	for _, event := range clickEvents(url, clicks) {
+		s.publish(event, url, clicks, NoVariant)
+	}
+}
+
+// Disable stops a url from being served, for urls that turned out to be malicious.
+// Like Find and Delete, it falls back to the urls without a domain on the default domain
+func (s *urlShortenerService) Disable(domain string, urlHash string) error {
+	err := s.store.Disable(domain, urlHash)
+	if err == ErrorURLNotFound && s.servesURLsWithoutDomain(domain) {
+		err = s.store.Disable("", urlHash)
+	}
+	if err != nil {
+		return err
+	}
+	url, err := s.findURL(domain, urlHash)
+	if err != nil {
+		// caches still have to forget it
+		url = URL{Domain: domain, Hash: urlHash, Disabled: true}
+	}
+	s.publish(EventLinkUpdated, url, 0, NoVariant)
+	return nil
+}
+
+// Delete removes a url of the owner, returns ErrorURLNotFound when the owner doesn't have it
+func (s *urlShortenerService) Delete(domain string, urlHash string, owner string) error {
+	url, err := s.store.Delete(domain, urlHash, owner)
+	if err == ErrorURLNotFound && s.servesURLsWithoutDomain(domain) {
+		if url, err = s.store.Delete("", urlHash, owner); err == nil {
+			url.Domain = domain
+		}
+	}
+	if err != nil {
+		return err
+	}
+	s.publish(EventLinkDeleted, url, 0, NoVariant)
+	return nil
+}
+
+func (s *urlShortenerService) publish(eventType string, url URL, clicks int, variant int) {
+	s.events.Publish(LinkEvent{Type: eventType, URL: url, At: time.Now().UTC(), Clicks: clicks, Variant: variant})
+}
+
+func NewURLShortenerService(store URLStoreRepository, analytics URLAnalyticsRepository, config ServiceConfig) URLShortenerService {
+	events := config.Events
+	if events == nil {
+		bus := NewLocalEventBus()
+		bus.Subscribe("analytics", AnalyticsSubscriber(analytics), AnalyticsEvents...)
+		events = bus
+	}
 	return &urlShortenerService{
 		store:     store,
 		analytics: analytics,
+		events:    events,
+		config:    config,
 	}
 }
@@ -2,6 +2,7 @@ package urlshortener
 
 import (
 	"errors"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -11,9 +12,9 @@ func TestFindReturnsCorrectly(t *testing.T) {
 	mockUrl := &URL{Full: "Full"}
 	expectedHash := "HASH"
 	repoMock := &urlShortenerRepoMock{url: mockUrl}
-	service := NewURLShortenerService(repoMock, repoMock)
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
 
-	url, err := service.Find(expectedHash, false)
+	url, err := service.Find("", expectedHash, false)
 	if err != nil {
 		t.Fatal("Service should not fail to find the hash")
 	}
@@ -30,8 +31,8 @@ func TestFindTracksViews(t *testing.T) {
 	t.Parallel()
 	repoMock := &urlShortenerRepoMock{url: &URL{}}
 	expectedHash := "HASH"
-	service := NewURLShortenerService(repoMock, repoMock)
-	url, err := service.Find(expectedHash, true)
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	url, err := service.Find("", expectedHash, true)
 	if err != nil {
 		t.Fatal("Service should not fail to find the hash")
 	}
@@ -48,8 +49,8 @@ func TestFindTracksViews(t *testing.T) {
 func TestFindBubblesUpError(t *testing.T) {
 	expectedError := errors.New("Bubble up")
 	repoMock := &urlShortenerRepoMock{err: expectedError}
-	service := NewURLShortenerService(repoMock, repoMock)
-	_, err := service.Find("hash", false)
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	_, err := service.Find("", "hash", false)
 	if err != expectedError {
 		t.Fatal("Service should have failed with the expected error", err)
 	}
@@ -57,28 +58,90 @@ func TestFindBubblesUpError(t *testing.T) {
 
 func TestCreate(t *testing.T) {
 	expectedHash := "CreateHash"
-	expectedUrl := "CreateURL"
+	expectedUrl := "http://www.example.com/create"
 	repoMock := &urlShortenerRepoMock{url: &URL{Hash: expectedHash}}
-	service := NewURLShortenerService(repoMock, repoMock)
-	url, err := service.Create(expectedUrl)
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	expectedOpts := CreateOptions{Owner: "owner", ReuseExisting: true}
+	url, err := service.Create(expectedUrl, expectedOpts)
 	if err != nil {
 		t.Fatal("Service shouldn't have failed:", err)
 	}
 	if url.Full != expectedUrl || url.Hash != expectedHash {
 		t.Fatal("Service is not using the repository", url)
 	}
+	if reflect.DeepEqual(repoMock.opts, expectedOpts) == false {
+		t.Fatal("Service didn't pass the options to the repository", repoMock.opts)
+	}
 }
 
 func TestCreateBubblesError(t *testing.T) {
 	expectedError := errors.New("Bubble up")
 	repoMock := &urlShortenerRepoMock{err: expectedError}
-	service := NewURLShortenerService(repoMock, repoMock)
-	_, err := service.Create("hash")
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	_, err := service.Create("www.example.com", CreateOptions{})
 	if err != expectedError {
 		t.Fatal("Service should have failed with the expected error", err)
 	}
 }
 
+func TestCreateAppliesPolicy(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{
+		Policy: URLPolicy{StripFragment: true, DeniedDomains: []string{"evil.com"}},
+	})
+	url, err := service.Create("WWW.Example.com/path#fragment", CreateOptions{})
+	if err != nil {
+		t.Fatal("Service shouldn't have failed:", err)
+	}
+	if url.Full != "http://www.example.com/path" {
+		t.Fatal("Service didn't normalize the url before creating it", url.Full)
+	}
+	_, err = service.Create("https://login.evil.com", CreateOptions{})
+	if validationErr, ok := err.(*URLValidationError); ok == false || validationErr.Rule != RuleDeniedDomain {
+		t.Fatal("Service should have rejected a denied domain", err)
+	}
+}
+
+func TestCreateScreensUrls(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{}}
+	screener := &urlScreenerMock{blocked: "http://evil.com"}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{Screener: screener})
+	if _, err := service.Create("evil.com", CreateOptions{}); err != ErrorURLBlocked {
+		t.Fatal("Service should have blocked the url", err)
+	}
+	if _, err := service.Create("example.com", CreateOptions{}); err != nil {
+		t.Fatal("Service shouldn't have blocked the url", err)
+	}
+}
+
+func TestFindScreensOnlyWhenConfigured(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{Full: "http://evil.com"}}
+	screener := &urlScreenerMock{blocked: "http://evil.com"}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{Screener: screener})
+	if _, err := service.Find("", "hash", false); err != nil || screener.calls != 0 {
+		t.Fatal("Find shouldn't screen urls by default", err)
+	}
+	service = NewURLShortenerService(repoMock, repoMock, ServiceConfig{Screener: screener, ScreenOnFind: true})
+	if _, err := service.Find("", "hash", true); err != ErrorURLBlocked {
+		t.Fatal("Find should have screened the url", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if repoMock.createURLViewCalled == true {
+		t.Fatal("Views of blocked urls should not be recorded")
+	}
+}
+
+func TestFindDoesntServeDisabledUrls(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{Full: "http://example.com", Disabled: true}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	if _, err := service.Find("", "hash", false); err != ErrorURLBlocked {
+		t.Fatal("Disabled urls should be blocked", err)
+	}
+	if err := service.Disable("", "hash"); err != nil || repoMock.disableCalled == false {
+		t.Fatal("Service should disable urls in the store", err)
+	}
+}
+
 func TestStats(t *testing.T) {
 	expectedStats := &URLViewStats{
 		Count:         99,
@@ -88,7 +151,7 @@ func TestStats(t *testing.T) {
 	expectedHash := "expected-hash"
 	url := &URL{}
 	repoMock := &urlShortenerRepoMock{stats: expectedStats, url: url}
-	service := NewURLShortenerService(repoMock, repoMock)
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
 	stats, err := service.Stats(expectedHash)
 	if err != nil {
 		t.Fatal("Service should have not fail on stats", err)
@@ -102,7 +165,7 @@ func TestStats(t *testing.T) {
 func TestStatsBubblesUpError(t *testing.T) {
 	expectedError := errors.New("Bubble up")
 	repoMock := &urlShortenerRepoMock{err: expectedError}
-	service := NewURLShortenerService(repoMock, repoMock)
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
 	_, err := service.Stats("hash")
 	if err != expectedError {
 		t.Fatal("Service should have failed with the expected error", err)
@@ -115,30 +178,47 @@ type urlShortenerRepoMock struct {
 	err                 error
 	createURLViewCalled bool
 	memorizeCalled      bool
+	disableCalled       bool
+	countClickCalled    bool
+	deleteCalled        bool
+	deleteViewsCalled   bool
+	viewCountsCalls     int
+	clicks              int
+	view                URLView
+	opts                CreateOptions
+	domain              string
 }
 
-func (r *urlShortenerRepoMock) Find(urlHash string) (URL, error) {
+func (r *urlShortenerRepoMock) Find(domain string, urlHash string) (URL, error) {
+	r.domain = domain
 	if r.url != nil {
+		r.url.Domain = domain
 		r.url.Hash = urlHash
 		return *r.url, nil
 	}
 	return URL{}, r.err
 }
-func (r *urlShortenerRepoMock) Create(url string) (URL, error) {
+func (r *urlShortenerRepoMock) Create(url string, opts CreateOptions) (URL, error) {
+	r.opts = opts
 	if r.url != nil {
 		r.url.Full = url
 		return *r.url, nil
 	}
 	return URL{}, r.err
 }
-func (r *urlShortenerRepoMock) CreateURLView(urlHash string) error {
+func (r *urlShortenerRepoMock) CreateURLView(view URLView) error {
 	r.createURLViewCalled = true
+	r.view = view
 	if r.url != nil {
-		r.url.Hash = urlHash
+		r.url.Hash = view.Hash
 		return nil
 	}
 	return r.err
 }
+func (r *urlShortenerRepoMock) DeleteViews(urlHash string) error {
+	r.deleteViewsCalled = true
+	return r.err
+}
 func (r *urlShortenerRepoMock) Stats(urlHash string) (URLViewStats, error) {
 	if r.stats != nil {
 		r.url = &URL{Hash: urlHash}
@@ -146,3 +226,263 @@ func (r *urlShortenerRepoMock) Stats(urlHash string) (URLViewStats, error) {
 	}
 	return URLViewStats{}, r.err
 }
+func (r *urlShortenerRepoMock) ViewCounts(urlHashes []string) (ViewCounts, error) {
+	r.viewCountsCalls++
+	if r.stats != nil {
+		urls := len(urlHashes)
+		return ViewCounts{Count: r.stats.Count * urls, PastWeekCount: r.stats.PastWeekCount * urls, PastDayCount: r.stats.PastDayCount * urls}, nil
+	}
+	return ViewCounts{}, r.err
+}
+func (r *urlShortenerRepoMock) CountClick(domain string, urlHash string) (int, error) {
+	r.countClickCalled = true
+	return r.clicks, r.err
+}
+func (r *urlShortenerRepoMock) Delete(domain string, urlHash string, owner string) (URL, error) {
+	r.deleteCalled = true
+	if r.url != nil && r.url.Owner == owner {
+		r.url.Hash = urlHash
+		return *r.url, nil
+	}
+	return URL{}, ErrorURLNotFound
+}
+func (r *urlShortenerRepoMock) Disable(domain string, urlHash string) error {
+	r.disableCalled = true
+	r.domain = domain
+	return r.err
+}
+
+type urlScreenerMock struct {
+	blocked string
+	calls   int
+}
+
+func (s *urlScreenerMock) Screen(url string) error {
+	s.calls++
+	if url == s.blocked {
+		return ErrorURLBlocked
+	}
+	return nil
+}
+
+func TestCreateValidatesDomain(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{
+		Domains: Domains{Default: "sho.rt", Registered: []string{"brand.co"}},
+	})
+	if _, err := service.Create("example.com", CreateOptions{}); err != nil || repoMock.opts.Domain != "sho.rt" {
+		t.Fatal("Urls without domain should get the default one", repoMock.opts, err)
+	}
+	if _, err := service.Create("example.com", CreateOptions{Domain: "BRAND.co"}); err != nil || repoMock.opts.Domain != "brand.co" {
+		t.Fatal("Urls should get the requested domain", repoMock.opts, err)
+	}
+	if _, err := service.Create("example.com", CreateOptions{Domain: "other.com"}); err != ErrorUnknownDomain {
+		t.Fatal("Unknown domains should be rejected", err)
+	}
+}
+
+func TestFindUsesDomain(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	if url, err := service.Find("brand.co", "hash", false); err != nil || repoMock.domain != "brand.co" || url.Domain != "brand.co" {
+		t.Fatal("Service should find urls of the domain", url, err)
+	}
+}
+
+func TestFindServesUrlsWithoutDomainOnTheDefaultDomain(t *testing.T) {
+	repoMock := &urlsWithoutDomainRepoMock{&urlShortenerRepoMock{url: &URL{Full: "http://www.example.com/"}}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{Domains: Domains{Default: "Sho.rt.", Registered: []string{"brand.co"}}})
+	url, err := service.Find("sho.rt", "hash", false)
+	if err != nil || url.Full != "http://www.example.com/" {
+		t.Fatal("Urls created before domains were configured should be found on the default domain", url, err)
+	}
+	// caches keep them under the domain they are found on
+	if url.Domain != "sho.rt" {
+		t.Fatal("Urls found on the default domain should have it", url.Domain)
+	}
+	if _, err := service.Find("brand.co", "hash", false); err != ErrorURLNotFound {
+		t.Fatal("Urls created before domains were configured should only be found on the default domain", err)
+	}
+	url.MaxClicks = 1
+	if err := service.Click(url, Visitor{}); err != nil || repoMock.countClickCalled == false {
+		t.Fatal("Clicks of urls without a domain should be counted on the default domain", err)
+	}
+	if err := service.Disable("sho.rt", "hash"); err != nil || repoMock.disableCalled == false {
+		t.Fatal("Urls without a domain should be disabled on the default domain", err)
+	}
+	if err := service.Disable("brand.co", "hash"); err != ErrorURLNotFound {
+		t.Fatal("Urls without a domain should only be disabled on the default domain", err)
+	}
+	if err := service.Delete("sho.rt", "hash", ""); err != nil || repoMock.deleteCalled == false {
+		t.Fatal("Urls without a domain should be deleted on the default domain", err)
+	}
+}
+
+// urlsWithoutDomainRepoMock only has urls of the empty domain
+type urlsWithoutDomainRepoMock struct {
+	*urlShortenerRepoMock
+}
+
+func (r *urlsWithoutDomainRepoMock) Find(domain string, urlHash string) (URL, error) {
+	if len(domain) != 0 {
+		return URL{}, ErrorURLNotFound
+	}
+	return r.urlShortenerRepoMock.Find(domain, urlHash)
+}
+
+func (r *urlsWithoutDomainRepoMock) CountClick(domain string, urlHash string) (int, error) {
+	if len(domain) != 0 {
+		return 0, ErrorURLExpired
+	}
+	return r.urlShortenerRepoMock.CountClick(domain, urlHash)
+}
+
+func (r *urlsWithoutDomainRepoMock) Disable(domain string, urlHash string) error {
+	if len(domain) != 0 {
+		return ErrorURLNotFound
+	}
+	return r.urlShortenerRepoMock.Disable(domain, urlHash)
+}
+
+func (r *urlsWithoutDomainRepoMock) Delete(domain string, urlHash string, owner string) (URL, error) {
+	if len(domain) != 0 {
+		return URL{}, ErrorURLNotFound
+	}
+	return r.urlShortenerRepoMock.Delete(domain, urlHash, owner)
+}
+
+func TestClickCountsLimitedUrls(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	if err := service.Click(URL{Hash: "hash"}, Visitor{}); err != nil || repoMock.countClickCalled {
+		t.Fatal("Clicks of urls without a limit should not be counted", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if repoMock.createURLViewCalled == false {
+		t.Fatal("Clicks should be recorded as views")
+	}
+
+	repoMock = &urlShortenerRepoMock{err: ErrorURLExpired}
+	service = NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	if err := service.Click(URL{Hash: "hash", MaxClicks: 1}, Visitor{}); err != ErrorURLExpired || repoMock.countClickCalled == false {
+		t.Fatal("Clicks of expired urls should fail", err)
+	}
+}
+
+func TestCreateDoesntReuseLimitedUrls(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	if _, err := service.Create("http://www.example.com/", CreateOptions{ReuseExisting: true, MaxClicks: 1}); err != nil {
+		t.Fatal("Service should not fail to create url", err)
+	}
+	if repoMock.opts.ReuseExisting {
+		t.Fatal("Click limited urls should not be shared", repoMock.opts)
+	}
+}
+
+func TestCreateDoesntReusePreviewUrls(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	if _, err := service.Create("http://www.example.com/", CreateOptions{ReuseExisting: true, Preview: true}); err != nil {
+		t.Fatal("Service should not fail to create url", err)
+	}
+	if repoMock.opts.ReuseExisting || repoMock.opts.Preview == false {
+		t.Fatal("Preview urls should not be shared", repoMock.opts)
+	}
+}
+
+func TestCreateChecksRoutingRules(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{}}
+	screener := &urlScreenerMock{blocked: "http://evil.com"}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{Screener: screener})
+	_, err := service.Create("example.com", CreateOptions{ReuseExisting: true, Rules: []RoutingRule{{OS: "IOS", URL: "apps.apple.com/app/example"}}})
+	if err != nil {
+		t.Fatal("Service should not fail to create url", err)
+	}
+	rules := repoMock.opts.Rules
+	if len(rules) != 1 || rules[0].OS != "ios" || rules[0].URL != "http://apps.apple.com/app/example" {
+		t.Fatal("Rules should be normalized", rules)
+	}
+	if repoMock.opts.ReuseExisting {
+		t.Fatal("Urls with routing rules should not be shared", repoMock.opts)
+	}
+	if _, err := service.Create("example.com", CreateOptions{Rules: []RoutingRule{{OS: "ios", URL: "ftp://example.com"}}}); errors.Is(err, ErrorInvalidURL) == false {
+		t.Fatal("Rule urls should be validated", err)
+	}
+	if _, err := service.Create("example.com", CreateOptions{Rules: []RoutingRule{{OS: "ios", URL: "evil.com"}}}); err != ErrorURLBlocked {
+		t.Fatal("Rule urls should be screened", err)
+	}
+	tooMany := make([]RoutingRule, maxRoutingRules+1)
+	for i := range tooMany {
+		tooMany[i] = RoutingRule{OS: "ios", URL: "example.com"}
+	}
+	if _, err := service.Create("example.com", CreateOptions{Rules: tooMany}); errors.Is(err, ErrorInvalidURL) == false {
+		t.Fatal("Urls should have a limited number of rules", err)
+	}
+}
+
+func TestFindScreensRoutingRules(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{Full: "http://example.com", Rules: []RoutingRule{{OS: "ios", URL: "http://evil.com"}}}}
+	screener := &urlScreenerMock{blocked: "http://evil.com"}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{Screener: screener, ScreenOnFind: true})
+	if _, err := service.Find("", "hash", false); err != ErrorURLBlocked {
+		t.Fatal("Find should have screened the rule urls", err)
+	}
+}
+
+func TestCreateSplitsUrls(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	variants := []Variant{{URL: "a.com", Weight: 70}, {URL: "b.com", Weight: 30}}
+	url, err := service.Create("", CreateOptions{ReuseExisting: true, Variants: variants})
+	if err != nil {
+		t.Fatal("Service should not fail to create split url", err)
+	}
+	if url.Full != "http://a.com" || repoMock.opts.Variants[1].URL != "http://b.com" || repoMock.opts.ReuseExisting {
+		t.Fatal("Split urls should go to their normalized first variant and not be shared", url, repoMock.opts)
+	}
+	if _, err := service.Create("c.com", CreateOptions{Variants: variants}); errors.Is(err, ErrorInvalidURL) == false {
+		t.Fatal("Split urls should not have a url", err)
+	}
+	if _, err := service.Create("", CreateOptions{Variants: []Variant{{URL: "a.com", Weight: 1}, {URL: "ftp://b.com", Weight: 1}}}); errors.Is(err, ErrorInvalidURL) == false {
+		t.Fatal("Variant urls should be validated", err)
+	}
+}
+
+func TestClickRecordsVariant(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	url := URL{Hash: "hash", Variants: []Variant{{URL: "http://a.com", Weight: 1}, {URL: "http://b.com", Weight: 1}}}
+	if err := service.Click(url, Visitor{Variant: 1}); err != nil {
+		t.Fatal("Service should not fail to click", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if repoMock.view.Hash != "hash" || repoMock.view.Variant != 1 {
+		t.Fatal("Views of split urls should have the variant", repoMock.view)
+	}
+}
+
+func TestCreateAddsUTMParameters(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{})
+	opts := CreateOptions{
+		ReuseExisting: true,
+		QueryMode:     QueryMerge,
+		UTM:           UTM{Source: "newsletter", Campaign: "spring sale"},
+		Rules:         []RoutingRule{{OS: "ios", URL: "apps.apple.com/app?utm_source=old"}},
+	}
+	url, err := service.Create("example.com/?id=1", opts)
+	if err != nil {
+		t.Fatal("Service should not fail to create url", err)
+	}
+	if url.Full != "http://example.com/?id=1&utm_campaign=spring+sale&utm_source=newsletter" ||
+		repoMock.opts.Rules[0].URL != "http://apps.apple.com/app?utm_campaign=spring+sale&utm_source=newsletter" {
+		t.Fatal("UTM parameters should be added to every destination", url.Full, repoMock.opts.Rules)
+	}
+	if repoMock.opts.ReuseExisting {
+		t.Fatal("Urls forwarding their query should not be shared", repoMock.opts)
+	}
+	if _, err := service.Create("example.com", CreateOptions{QueryMode: "append"}); err != ErrorInvalidQueryMode {
+		t.Fatal("Unknown query modes should be rejected", err)
+	}
+}
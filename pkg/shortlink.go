@@ -0,0 +1,109 @@
+package urlshortener
+
+import (
+	"net/url"
+	"strings"
+)
+
+// maxShortLinkHops is how many short links are followed before giving up on a chain
+const maxShortLinkHops = 5
+
+// ShortLinkResolver resolves a link of another url shortener.
+// Resolve returns where the link redirects to, or the same url if it doesn't redirect.
+type ShortLinkResolver interface {
+	Resolve(url string) (string, error)
+}
+
+// followShortLinks follows the chain of short links starting at normalizedURL,
+// both our own (ServiceConfig.Domains and OwnHosts) and other shorteners' (ServiceConfig.ShortenerHosts).
+// Our own hosts match exactly like in Domains.ForHost, the subdomains of a branded domain can serve
+// its website. Subdomains of other shorteners are followed too.
+// Links to our own hosts are rejected unless FlattenOwnURLs is set,
+// in that case, and for chains ending outside our hosts, the url at the end of the chain is returned.
+func (s *urlShortenerService) followShortLinks(normalizedURL string) (string, error) {
+	current := normalizedURL
+	ownHosts := append(s.config.Domains.All(), s.config.OwnHosts...)
+	seen := map[string]bool{}
+	for hop := 0; ; hop++ {
+		if seen[current] || hop > maxShortLinkHops {
+			return "", newURLValidationError(RuleRedirectLoop, "url leads to a redirect loop")
+		}
+		seen[current] = true
+
+		u, err := url.Parse(current)
+		if err != nil {
+			return "", newURLValidationError(RuleMalformed, "url is malformed")
+		}
+		host := u.Hostname()
+		switch {
+		case matchesHost(host, ownHosts):
+			if s.config.FlattenOwnURLs == false {
+				return "", newURLValidationError(RuleSelfReference, "urls to this shortener are not allowed")
+			}
+			segments := strings.SplitN(strings.TrimPrefix(u.EscapedPath(), "/"), "/", 2)
+			next, err := s.findURL(s.config.Domains.ForHost(host), segments[0])
+			if err != nil {
+				return "", newURLValidationError(RuleSelfReference, "url points to a short url that doesn't exist")
+			}
+			if err := s.Screen(next); err != nil {
+				return "", err
+			}
+			if next.Protected() || next.MaxClicks > 0 || len(next.Rules) != 0 || len(next.Variants) != 0 {
+				return "", newURLValidationError(RuleSelfReference, "url points to a protected, click limited, routed or split short url")
+			}
+			current = next.Full
+			if next.Prefix && len(segments) == 2 {
+				suffix, err := CleanPathSuffix(segments[1])
+				if err != nil {
+					return "", newURLValidationError(RuleSelfReference, "url points to a short url that doesn't exist")
+				}
+				current = joinPath(next.Full, suffix)
+			}
+		case s.config.Resolver != nil && matchesHostOrSubdomain(host, s.config.ShortenerHosts):
+			next, err := s.config.Resolver.Resolve(current)
+			if err != nil {
+				return "", newURLValidationError(RuleShortLink, "short link couldn't be resolved")
+			}
+			if next == current {
+				return s.flattenedOr(normalizedURL, current), nil
+			}
+			current, err = s.config.Policy.Normalize(next)
+			if err != nil {
+				return "", err
+			}
+		default:
+			return s.flattenedOr(normalizedURL, current), nil
+		}
+	}
+}
+
+// flattenedOr returns the end of the chain when flattening, the original url otherwise
+func (s *urlShortenerService) flattenedOr(original string, end string) string {
+	if s.config.FlattenOwnURLs {
+		return end
+	}
+	return original
+}
+
+// matchesHost is true when host is one of hosts
+func matchesHost(host string, hosts []string) bool {
+	for _, h := range hosts {
+		if strings.EqualFold(host, strings.TrimSuffix(h, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesHostOrSubdomain is true when host is one of hosts or a subdomain of one,
+// so www.bit.ly matches bit.ly but notbit.ly doesn't
+func matchesHostOrSubdomain(host string, hosts []string) bool {
+	host = strings.ToLower(host)
+	for _, h := range hosts {
+		h = strings.ToLower(strings.TrimSuffix(h, "."))
+		if len(h) != 0 && (host == h || strings.HasSuffix(host, "."+h)) {
+			return true
+		}
+	}
+	return false
+}
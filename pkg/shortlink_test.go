@@ -0,0 +1,183 @@
+package urlshortener
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateRejectsOwnHosts(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{Full: "http://www.example.com/"}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{OwnHosts: []string{"sho.rt"}})
+	_, err := service.Create("https://SHO.RT/abcdefg", CreateOptions{})
+	if validationErr, ok := err.(*URLValidationError); ok == false || validationErr.Rule != RuleSelfReference {
+		t.Fatal("Urls to our own hosts should be rejected", err)
+	}
+}
+
+func TestCreateFlattensOwnUrls(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{Full: "http://www.example.com/"}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{OwnHosts: []string{"sho.rt"}, FlattenOwnURLs: true})
+	url, err := service.Create("https://sho.rt/abcdefg", CreateOptions{})
+	if err != nil {
+		t.Fatal("Own urls should be flattened", err)
+	}
+	if url.Full != "http://www.example.com/" {
+		t.Fatal("Url should point to the destination of the short url", url.Full)
+	}
+	repoMock = &urlShortenerRepoMock{err: ErrorURLNotFound}
+	service = NewURLShortenerService(repoMock, repoMock, ServiceConfig{OwnHosts: []string{"sho.rt"}, FlattenOwnURLs: true})
+	_, err = service.Create("https://sho.rt/missing", CreateOptions{})
+	if validationErr, ok := err.(*URLValidationError); ok == false || validationErr.Rule != RuleSelfReference {
+		t.Fatal("Urls to missing short urls should be rejected", err)
+	}
+}
+
+func TestCreateTreatsDomainsAsOwnHosts(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{Full: "http://www.example.com/"}}
+	domains := Domains{Default: "sho.rt", Registered: []string{"brand.co"}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{Domains: domains})
+	_, err := service.Create("https://brand.co/abcdefg", CreateOptions{})
+	if validationErr, ok := err.(*URLValidationError); ok == false || validationErr.Rule != RuleSelfReference {
+		t.Fatal("Urls to our domains should be rejected", err)
+	}
+	service = NewURLShortenerService(repoMock, repoMock, ServiceConfig{Domains: domains, FlattenOwnURLs: true})
+	if _, err := service.Create("https://brand.co/abcdefg", CreateOptions{}); err != nil || repoMock.domain != "brand.co" {
+		t.Fatal("Urls to our domains should be flattened with the url of that domain", repoMock.domain, err)
+	}
+}
+
+func TestCreateDoesntFlattenProtectedUrls(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{Full: "http://www.example.com/", PasswordHash: "hash"}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{OwnHosts: []string{"sho.rt"}, FlattenOwnURLs: true})
+	_, err := service.Create("https://sho.rt/abcdefg", CreateOptions{})
+	if validationErr, ok := err.(*URLValidationError); ok == false || validationErr.Rule != RuleSelfReference {
+		t.Fatal("Flattening protected urls would reveal where they go", err)
+	}
+}
+
+func TestCreateFollowsOtherShorteners(t *testing.T) {
+	resolver := &shortLinkResolverMock{links: map[string]string{
+		"http://bit.ly/toUs":   "https://sho.rt/abcdefg",
+		"http://bit.ly/away":   "https://t.co/away",
+		"https://t.co/away":    "https://www.example.org/",
+		"http://bit.ly/loop":   "https://t.co/loop",
+		"https://t.co/loop":    "http://bit.ly/loop",
+		"http://bit.ly/broken": "",
+	}}
+	config := ServiceConfig{
+		OwnHosts:       []string{"sho.rt"},
+		ShortenerHosts: []string{"bit.ly", "t.co"},
+		Resolver:       resolver,
+	}
+	repoMock := &urlShortenerRepoMock{url: &URL{Full: "http://www.example.com/"}}
+	service := NewURLShortenerService(repoMock, repoMock, config)
+
+	rules := map[string]string{
+		"bit.ly/toUs":   RuleSelfReference,
+		"bit.ly/loop":   RuleRedirectLoop,
+		"bit.ly/broken": RuleShortLink,
+	}
+	for url, rule := range rules {
+		_, err := service.Create(url, CreateOptions{})
+		if validationErr, ok := err.(*URLValidationError); ok == false || validationErr.Rule != rule {
+			t.Fatal("Url should have failed with rule:", url, rule, err)
+		}
+	}
+
+	url, err := service.Create("bit.ly/away", CreateOptions{})
+	if err != nil || url.Full != "http://bit.ly/away" {
+		t.Fatal("Short links that don't lead to us should be kept as they are", url.Full, err)
+	}
+
+	config.FlattenOwnURLs = true
+	service = NewURLShortenerService(repoMock, repoMock, config)
+	url, err = service.Create("bit.ly/away", CreateOptions{})
+	if err != nil || url.Full != "https://www.example.org/" {
+		t.Fatal("Short links should be flattened", url.Full, err)
+	}
+	// the mock repo returns the last created url
+	repoMock = &urlShortenerRepoMock{url: &URL{Full: "http://www.example.com/"}}
+	service = NewURLShortenerService(repoMock, repoMock, config)
+	url, err = service.Create("bit.ly/toUs", CreateOptions{})
+	if err != nil || url.Full != "http://www.example.com/" {
+		t.Fatal("Short links to us should be flattened", url.Full, err)
+	}
+}
+
+type shortLinkResolverMock struct {
+	links map[string]string
+}
+
+func (r *shortLinkResolverMock) Resolve(url string) (string, error) {
+	next, ok := r.links[url]
+	if ok == false {
+		return url, nil
+	}
+	if len(next) == 0 {
+		return "", errors.New("unreachable")
+	}
+	return next, nil
+}
+
+func TestCreateFollowsSubdomainsOfShorteners(t *testing.T) {
+	otherShortener := http.NewServeMux()
+	otherShortener.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://www.example.org/", http.StatusMovedPermanently)
+	})
+	config := ServiceConfig{
+		OwnHosts:       []string{"sho.rt"},
+		ShortenerHosts: []string{"bit.ly"},
+		Resolver:       &handlerResolver{handler: otherShortener},
+		FlattenOwnURLs: true,
+	}
+	cases := map[string]string{
+		"http://WWW.bit.ly/away":         "https://www.example.org/",
+		"http://notbit.ly/away":          "http://notbit.ly/away",
+		"http://bit.ly.example.com/away": "http://bit.ly.example.com/away",
+		"https://sho.rt/abcdefg":         "http://www.example.com/",
+		"https://www.sho.rt/pricing":     "https://www.sho.rt/pricing",
+	}
+	for url, expected := range cases {
+		// the mock repo returns the last created url
+		repoMock := &urlShortenerRepoMock{url: &URL{Full: "http://www.example.com/"}}
+		service := NewURLShortenerService(repoMock, repoMock, config)
+		created, err := service.Create(url, CreateOptions{})
+		if err != nil || created.Full != expected {
+			t.Fatal("Only our hosts and other shorteners with their subdomains should be followed", url, created, err)
+		}
+	}
+}
+
+// handlerResolver resolves links with the redirects of a stand-in shortener
+type handlerResolver struct {
+	handler http.Handler
+}
+
+func (r *handlerResolver) Resolve(url string) (string, error) {
+	recorder := httptest.NewRecorder()
+	r.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, url, nil))
+	if location := recorder.Header().Get("Location"); len(location) != 0 {
+		return location, nil
+	}
+	return url, nil
+}
+
+func TestCreateDoesntFlattenLimitedUrls(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{Full: "http://www.example.com/", MaxClicks: 1}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{OwnHosts: []string{"sho.rt"}, FlattenOwnURLs: true})
+	_, err := service.Create("https://sho.rt/abcdefg", CreateOptions{})
+	if validationErr, ok := err.(*URLValidationError); ok == false || validationErr.Rule != RuleSelfReference {
+		t.Fatal("Flattening click limited urls would go around their limit", err)
+	}
+}
+
+func TestCreateFlattensPrefixUrlsWithTheirPath(t *testing.T) {
+	repoMock := &urlShortenerRepoMock{url: &URL{Full: "http://docs.example.com/v2", Prefix: true}}
+	service := NewURLShortenerService(repoMock, repoMock, ServiceConfig{OwnHosts: []string{"sho.rt"}, FlattenOwnURLs: true})
+	url, err := service.Create("https://sho.rt/abcdefg/guide/intro", CreateOptions{})
+	if err != nil || url.Full != "http://docs.example.com/v2/guide/intro" {
+		t.Fatal("Flattened prefix urls should keep the path", url, err)
+	}
+}
@@ -1,17 +1,93 @@
 package urlshortener
 
 import (
+	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/asaskevich/govalidator"
+	"golang.org/x/net/idna"
 )
 
 // URL struct
 type URL struct {
+	// Domain is the short domain the url is served from, see Domains
+	Domain    string    `json:"domain,omitempty"`
 	Hash      string    `json:"hash"`
 	Full      string    `json:"url"`
 	CreatedAt time.Time `json:"created_at"`
+	// Disabled urls are never served, see URLShortenerService.Disable
+	Disabled bool `json:"disabled,omitempty"`
+	// Preview urls show where they go instead of redirecting
+	Preview bool `json:"preview,omitempty"`
+	// PasswordHash is the bcrypt hash of the password of protected urls, see Protected
+	PasswordHash string `json:"-"`
+	// MaxClicks is how many times the url can be followed, 0 for no limit. See URLShortenerService.Click
+	MaxClicks int `json:"max_clicks,omitempty"`
+	// Rules send some visitors somewhere else than Full, see Destination
+	Rules []RoutingRule `json:"rules,omitempty"`
+	// Variants split the visitors of the url across several destinations, Full is the first one
+	Variants []Variant `json:"variants,omitempty"`
+	// QueryMode tells if the query of visitors is forwarded to the destination, QueryDrop, QueryMerge or QueryOverride
+	QueryMode string `json:"query_mode,omitempty"`
+	// Prefix urls forward the path visitors add after their code, /{code}/docs/intro goes to Full + "/docs/intro"
+	Prefix bool `json:"prefix,omitempty"`
+	// Tags label the url for its owner, see URLFilter
+	Tags []string `json:"tags,omitempty"`
+	// Campaign is the ID of the campaign the url belongs to, see Campaign
+	Campaign string `json:"campaign,omitempty"`
+	// Title and Notes describe the url for its owner, they are set when it's created
+	Title string `json:"title,omitempty"`
+	Notes string `json:"notes,omitempty"`
+	// Metadata is fetched from the destination after the url is created, nil until then
+	Metadata *LinkMetadata `json:"metadata,omitempty"`
+	// Owner identifies who created the url, empty for anonymous urls. See CreateOptions.Owner
+	Owner string `json:"-"`
+	// Reused is set by Create when an existing url was returned instead of creating one, see CreateOptions.ReuseExisting
+	Reused bool `json:"-"`
+}
+
+// CreateOptions are the optional settings for a new short url
+type CreateOptions struct {
+	// Owner identifies who is creating the url, empty for anonymous requests
+	Owner string
+	// Domain is the short domain of the url, the default domain when empty, see Domains
+	Domain string
+	// Preview makes the url show a page with its destination instead of redirecting
+	Preview bool
+	// Password protects the url, visitors have to enter it before they are redirected.
+	// Protected urls are never reused. The service replaces it with PasswordHash.
+	Password     string
+	PasswordHash string
+	// MaxClicks limits how many times the url can be followed, 0 for no limit.
+	// Click limited urls are never reused
+	MaxClicks int
+	// Rules are checked in order on every redirect, the first one the visitor matches
+	// decides where it goes. Urls with rules are never reused
+	Rules []RoutingRule
+	// Variants split the visitors across several destinations by weight, the url
+	// is created without a destination of its own then. Split urls are never reused
+	Variants []Variant
+	// QueryMode forwards the query of visitors to the destination, see URL.QueryMode.
+	// Urls that forward their query are never reused
+	QueryMode string
+	// UTM parameters are added to every destination of the url, replacing the ones they have
+	UTM UTM
+	// Prefix makes the url forward the path visitors add after its code, see URL.Prefix
+	Prefix bool
+	// Tags label the url, they are lowercased and can have letters, digits, "-" and "_"
+	Tags []string
+	// Campaign is the ID of a campaign of the owner the url is added to. Urls with tags
+	// or a campaign are never reused
+	Campaign string
+	// Title and Notes describe the url, urls with them are never reused
+	Title string
+	Notes string
+	// ReuseExisting returns the url previously created by the same owner in this mode
+	// for the same destination instead of creating a new one
+	ReuseExisting bool
 }
 
 // URLViewStats struct
@@ -19,29 +95,117 @@ type URLViewStats struct {
 	PastDayCount  int `json:"past_day_count"`
 	PastWeekCount int `json:"past_week_count"`
 	Count         int `json:"count"`
+	// Unique counts are the visitors of the url, a visitor is counted once a day, see VisitorHash.
+	// Views without a visitor, like the ones recorded before visitors were, are not counted.
+	// The salt of the hash changes every day, so PastWeekUniqueCount and UniqueCount are sums of
+	// the daily unique visitors, someone visiting on two days counts twice
+	PastDayUniqueCount  int `json:"past_day_unique_count"`
+	PastWeekUniqueCount int `json:"past_week_unique_count"`
+	UniqueCount         int `json:"unique_count"`
+	// Variants has the views of each variant of split urls, in the order of URL.Variants
+	Variants []int `json:"variants,omitempty"`
 }
 
 // NormalizeURL checks if the given string is a valid url
-// and prepends http:// if it doesn't start with 'http://' or 'https://'
-func NormalizeURL(url string) (string, error) {
-	if ok := govalidator.MinStringLength(url, "1"); ok == false {
-		return "", ErrorInvalidURL
+// and prepends http:// if it doesn't start with 'http://' or 'https://'.
+// Only http and https urls with a valid host are accepted, international domain names
+// are converted to punycode, the host is lowercased and default ports are removed.
+// The returned error is a *URLValidationError telling which rule failed.
+// NormalizeURL doesn't decide which hosts are allowed, see URLPolicy for that.
+func NormalizeURL(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if ok := govalidator.MinStringLength(rawURL, "1"); ok == false {
+		return "", newURLValidationError(RuleLength, "url can't be empty")
 	}
 	// Internet explorer has a limit of 2048 characters in the address bar
 	// this might no longer be the case but we should have a limit and 2048 seems reasonable.
-	if ok := govalidator.MaxStringLength(url, "2048"); ok == false {
-		return "", ErrorInvalidURL
+	if ok := govalidator.MaxStringLength(rawURL, "2048"); ok == false {
+		return "", newURLValidationError(RuleLength, "url can't be longer than 2048 characters")
 	}
 
-	loweredURL := strings.ToLower(url)
-	if !strings.HasPrefix(loweredURL, "http://") && !strings.HasPrefix(loweredURL, "https://") {
-		url = "http://" + url
+	if i := strings.Index(rawURL, "://"); i >= 0 {
+		scheme := strings.ToLower(rawURL[:i])
+		if scheme != "http" && scheme != "https" {
+			return "", newURLValidationError(RuleScheme, "only http and https urls are allowed")
+		}
+	} else {
+		rawURL = "http://" + rawURL
 	}
 
-	// This is a very loose URL validation. It should be replaced by a custom one.
-	if ok := govalidator.IsRequestURL(url); ok == false {
-		return "", ErrorInvalidURL
+	u, err := url.Parse(rawURL)
+	if err != nil || len(u.Opaque) != 0 {
+		return "", newURLValidationError(RuleMalformed, "url is malformed")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	// http://trusted.com@evil.com is a common phishing trick
+	if u.User != nil {
+		return "", newURLValidationError(RuleUserInfo, "urls with credentials are not allowed")
 	}
 
-	return url, nil
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if len(port) != 0 {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", newURLValidationError(RuleMalformed, "url has an invalid port")
+		}
+	}
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if len(port) != 0 {
+		host = host + ":" + port
+	}
+	u.Host = host
+
+	normalized := u.String()
+	// punycode can make the url longer
+	if ok := govalidator.MaxStringLength(normalized, "2048"); ok == false {
+		return "", newURLValidationError(RuleLength, "url can't be longer than 2048 characters")
+	}
+	return normalized, nil
+}
+
+// normalizeHost returns the lowercased ASCII form of a host name or IP address
+func normalizeHost(host string) (string, error) {
+	if len(host) == 0 {
+		return "", newURLValidationError(RuleHost, "url must have a host")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	asciiHost, err := idna.Lookup.ToASCII(strings.TrimSuffix(host, "."))
+	if err != nil || len(asciiHost) == 0 {
+		return "", newURLValidationError(RuleHost, "url has an invalid host")
+	}
+	// a numeric top level label is either a malformed IP like 127.1 or 0x7f.1 or not a domain at all,
+	// browsers can read those as IPs so they are not allowed
+	labels := strings.Split(asciiHost, ".")
+	last := labels[len(labels)-1]
+	if _, err := strconv.ParseUint(last, 0, 64); err == nil {
+		return "", newURLValidationError(RuleHost, "url has an invalid host")
+	}
+	return asciiHost, nil
+}
+
+// CanonicalURL returns the form of a url used to compare destinations,
+// that is the normalized url with "/" as path when it doesn't have one.
+func CanonicalURL(rawURL string) string {
+	normalized, err := NormalizeURL(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u, err := url.Parse(normalized)
+	if err != nil {
+		return normalized
+	}
+	if len(u.Path) == 0 {
+		u.Path = "/"
+	}
+	return u.String()
 }
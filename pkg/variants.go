@@ -0,0 +1,80 @@
+package urlshortener
+
+import (
+	"strconv"
+)
+
+const (
+	// maxVariants is how many destinations a split url can have
+	maxVariants = 10
+	// maxVariantWeight keeps the total weight small enough to pick variants with an int
+	maxVariantWeight = 1000
+	// NoVariant is the variant of views of urls that are not split or that went to a routing rule
+	NoVariant = -1
+)
+
+// Variant is one of the destinations of a split url, visitors are spread across them by Weight
+type Variant struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// URLView is a view of a url recorded by the analytics repository
+type URLView struct {
+	Hash string
+	// Variant is the index of the variant the visitor went to, NoVariant otherwise
+	Variant int
+	// Visitor identifies the visitor for unique visitor counts, the view isn't counted as a visitor when it's empty
+	Visitor string
+}
+
+// PickVariant chooses the variant of a new visitor by weight, roll returns a number in [0, n) like rand.Intn.
+// It returns NoVariant when the url isn't split.
+func (u URL) PickVariant(roll func(n int) int) int {
+	total := 0
+	for _, variant := range u.Variants {
+		total += variant.Weight
+	}
+	if total <= 0 {
+		return NoVariant
+	}
+	point := roll(total)
+	for i, variant := range u.Variants {
+		if point < variant.Weight {
+			return i
+		}
+		point -= variant.Weight
+	}
+	return len(u.Variants) - 1
+}
+
+// route returns where the visitor goes and the variant that is, NoVariant when a routing rule matched
+// or the url isn't split. Visitors without a valid variant get the first one.
+func (u URL) route(visitor Visitor) (string, int) {
+	for _, rule := range u.Rules {
+		if rule.matches(visitor) {
+			return rule.URL, NoVariant
+		}
+	}
+	if len(u.Variants) == 0 {
+		return u.Full, NoVariant
+	}
+	variant := visitor.Variant
+	if variant < 0 || variant >= len(u.Variants) {
+		variant = 0
+	}
+	return u.Variants[variant].URL, variant
+}
+
+// checkVariants validates the weights of the variants of a new url, their urls are checked by the service
+func checkVariants(variants []Variant) error {
+	if len(variants) == 1 || len(variants) > maxVariants {
+		return newURLValidationError(RuleVariants, "split urls need between 2 and "+strconv.Itoa(maxVariants)+" variants")
+	}
+	for _, variant := range variants {
+		if variant.Weight < 1 || variant.Weight > maxVariantWeight {
+			return newURLValidationError(RuleVariants, "variant weights must be between 1 and "+strconv.Itoa(maxVariantWeight))
+		}
+	}
+	return nil
+}
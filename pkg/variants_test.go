@@ -0,0 +1,57 @@
+package urlshortener
+
+import (
+	"testing"
+)
+
+func TestPickVariantByWeight(t *testing.T) {
+	url := URL{Variants: []Variant{{URL: "http://a.com", Weight: 70}, {URL: "http://b.com", Weight: 30}}}
+	picked := make([]int, 2)
+	for point := 0; point < 100; point++ {
+		picked[url.PickVariant(func(n int) int {
+			if n != 100 {
+				t.Fatal("Variants should be rolled with the total weight", n)
+			}
+			return point
+		})]++
+	}
+	if picked[0] != 70 || picked[1] != 30 {
+		t.Fatal("Variants should be picked by weight", picked)
+	}
+	if (URL{}).PickVariant(func(n int) int { return 0 }) != NoVariant {
+		t.Fatal("Urls that are not split don't have variants")
+	}
+}
+
+func TestRouteCountsVariants(t *testing.T) {
+	url := URL{
+		Full:     "http://a.com",
+		Rules:    []RoutingRule{{OS: "ios", URL: "http://apps.apple.com"}},
+		Variants: []Variant{{URL: "http://a.com", Weight: 1}, {URL: "http://b.com", Weight: 1}},
+	}
+	if destination, variant := url.route(Visitor{Variant: 1}); destination != "http://b.com" || variant != 1 {
+		t.Fatal("Visitor should go to its variant", destination, variant)
+	}
+	if destination, variant := url.route(Visitor{Variant: 5}); destination != "http://a.com" || variant != 0 {
+		t.Fatal("Visitors with an unknown variant should go to the first one", destination, variant)
+	}
+	if destination, variant := url.route(Visitor{OS: "ios", Variant: 1}); destination != "http://apps.apple.com" || variant != NoVariant {
+		t.Fatal("Routing rules should win over variants", destination, variant)
+	}
+	if _, variant := (URL{Full: "http://a.com"}).route(Visitor{}); variant != NoVariant {
+		t.Fatal("Urls that are not split don't have variants", variant)
+	}
+}
+
+func TestCheckVariants(t *testing.T) {
+	for _, invalid := range [][]Variant{
+		{{URL: "http://a.com", Weight: 1}},
+		{{URL: "http://a.com", Weight: 1}, {URL: "http://b.com", Weight: 0}},
+		{{URL: "http://a.com", Weight: 1}, {URL: "http://b.com", Weight: maxVariantWeight + 1}},
+		make([]Variant, maxVariants+1),
+	} {
+		if validationErr, ok := checkVariants(invalid).(*URLValidationError); ok == false || validationErr.Rule != RuleVariants {
+			t.Fatal("Variants should be invalid", invalid)
+		}
+	}
+}
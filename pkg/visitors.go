@@ -0,0 +1,22 @@
+package urlshortener
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// VisitorHash identifies a visitor of a url for unique visitor counts without keeping who it is.
+// The IP and user agent of the visitor are hashed with a salt of the day derived from secret,
+// so the same visitor gets another hash every day and on every url: visitors can't be followed
+// across days or urls, and unique visitors are counted once a day.
+// Instances share the counts when they share the secret.
+func VisitorHash(secret []byte, at time.Time, urlHash string, ip string, userAgent string) string {
+	salt := hmac.New(sha256.New, secret)
+	salt.Write([]byte("visitor\n" + at.UTC().Format("2006-01-02")))
+	mac := hmac.New(sha256.New, salt.Sum(nil))
+	mac.Write([]byte(urlHash + "\n" + ip + "\n" + userAgent))
+	// 128 bits are plenty to tell the visitors of a url apart
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
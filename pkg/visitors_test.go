@@ -0,0 +1,27 @@
+package urlshortener
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVisitorHashChangesEveryDay(t *testing.T) {
+	secret := []byte("secret")
+	morning := time.Date(2020, 4, 3, 8, 0, 0, 0, time.UTC)
+	hash := VisitorHash(secret, morning, "h1", "192.0.2.1", "Firefox")
+	if len(hash) != 32 || VisitorHash(secret, morning.Add(12*time.Hour), "h1", "192.0.2.1", "Firefox") != hash {
+		t.Fatal("Visitors should have the same hash all day", hash)
+	}
+	others := []string{
+		VisitorHash(secret, morning.AddDate(0, 0, 1), "h1", "192.0.2.1", "Firefox"),
+		VisitorHash(secret, morning, "h2", "192.0.2.1", "Firefox"),
+		VisitorHash(secret, morning, "h1", "192.0.2.2", "Firefox"),
+		VisitorHash(secret, morning, "h1", "192.0.2.1", "Chrome"),
+		VisitorHash([]byte("other"), morning, "h1", "192.0.2.1", "Firefox"),
+	}
+	for i, other := range others {
+		if other == hash {
+			t.Fatal("Visitors should have another hash on other days, urls, clients and secrets", i)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package urlshortener
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// WebhookEvents are the events webhooks can subscribe to
+var WebhookEvents = []string{EventLinkCreated, EventLinkUpdated, EventLinkDeleted, EventClickThreshold, EventLinkExpired}
+
+// ClickThresholds are the click counts EventClickThreshold is sent at
+var ClickThresholds = []int{10, 100, 1000, 10000, 100000, 1000000}
+
+const (
+	// maxWebhooks is how many webhooks an owner can register
+	maxWebhooks = 10
+	// webhookRetryDelay is waited after the first failed delivery, it doubles after every failed attempt
+	webhookRetryDelay    = 30 * time.Second
+	maxWebhookRetryDelay = 6 * time.Hour
+	// defaultWebhookAttempts is how many times a delivery is tried before giving up
+	defaultWebhookAttempts    = 10
+	defaultWebhookSendTimeout = 10 * time.Second
+	// defaultWebhookRetention is how long done deliveries are kept, webhookPruneInterval how often they are pruned
+	defaultWebhookRetention = 7 * 24 * time.Hour
+	webhookPruneInterval    = time.Hour
+)
+
+// WebhookDeliveryLease is how long the deliveries returned by DueDeliveries are skipped by other
+// dispatchers, deliveries of a dispatcher that stopped before saving them are tried again after it
+const WebhookDeliveryLease = 5 * time.Minute
+
+// Webhook is an endpoint of an owner that receives the events of its urls
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events are the events sent to the endpoint, see WebhookEvents
+	Events []string `json:"events"`
+	// Secret signs the payloads, see SignWebhookPayload. It's only shown when the webhook is registered
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is an event waiting in the outbox to be sent to a webhook
+type WebhookDelivery struct {
+	ID      int64
+	Webhook Webhook
+	Event   string
+	Payload []byte
+	// Attempts is how many times the delivery failed
+	Attempts    int
+	NextAttempt time.Time
+	// Done deliveries were sent or given up on
+	Done      bool
+	LastError string
+}
+
+// WebhookSender posts a payload to an endpoint, it fails unless the endpoint answers with a 2xx status
+type WebhookSender interface {
+	Send(endpoint string, headers map[string]string, payload []byte) error
+}
+
+// WebhookService lets owners manage the webhooks their events are sent to
+type WebhookService interface {
+	Register(owner string, endpoint string, events []string) (Webhook, error)
+	Webhooks(owner string) ([]Webhook, error)
+	// Remove returns ErrorWebhookNotFound when the owner doesn't have the webhook
+	Remove(owner string, webhookID string) error
+}
+
+// WebhookConfig holds the settings of a WebhookDispatcher
+type WebhookConfig struct {
+	// Policy validates the endpoints of webhooks like urls are validated
+	Policy URLPolicy
+	// PollInterval is how often the outbox is checked for due deliveries
+	PollInterval time.Duration
+	// BatchSize is how many deliveries are sent at most every time the dispatcher wakes up
+	BatchSize int
+	// MaxAttempts is how many times a delivery is tried before giving up
+	MaxAttempts int
+	// SendTimeout is how long the sender waits for an endpoint at most, 10s when it's 0. Deliveries are
+	// sent one after the other, BatchSize is lowered so a batch of endpoints that time out takes half
+	// of WebhookDeliveryLease at most and isn't claimed again by other dispatchers while it's sent
+	SendTimeout time.Duration
+	// Retention is how long deliveries that were sent or given up on are kept, a week when it's 0
+	Retention time.Duration
+	// Logger logs the events Notify fails to store and the batches Run fails to send,
+	// nothing is logged when it's nil
+	Logger log.Logger
+}
+
+// WebhookDispatcher stores the events of urls in an outbox and sends them to the webhooks of
+// their owner, failed deliveries are retried with a growing delay.
+// Notify is subscribed to the WebhookEvents of the EventBus, it's the WebhookService of the API.
+type WebhookDispatcher struct {
+	repo   WebhookRepository
+	sender WebhookSender
+	config WebhookConfig
+}
+
+type webhookPayload struct {
+	Event     string           `json:"event"`
+	CreatedAt time.Time        `json:"created_at"`
+	Data      webhookEventData `json:"data"`
+}
+
+type webhookEventData struct {
+	URL    URL `json:"url"`
+	Clicks int `json:"clicks,omitempty"`
+}
+
+// Register validates the endpoint with the Policy and creates a webhook with a new secret.
+// No events means all of them.
+func (d *WebhookDispatcher) Register(owner string, endpoint string, events []string) (Webhook, error) {
+	endpoint, err := d.config.Policy.Normalize(endpoint)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if len(events) == 0 {
+		events = WebhookEvents
+	}
+	for _, event := range events {
+		if validWebhookEvent(event) == false {
+			return Webhook{}, ErrorInvalidWebhookEvent
+		}
+	}
+	webhooks, err := d.repo.Webhooks(owner)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if len(webhooks) >= maxWebhooks {
+		return Webhook{}, ErrorTooManyWebhooks
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Webhook{}, err
+	}
+	return d.repo.CreateWebhook(owner, Webhook{URL: endpoint, Events: events, Secret: hex.EncodeToString(secret)})
+}
+
+func (d *WebhookDispatcher) Webhooks(owner string) ([]Webhook, error) {
+	webhooks, err := d.repo.Webhooks(owner)
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+func (d *WebhookDispatcher) Remove(owner string, webhookID string) error {
+	return d.repo.DeleteWebhook(owner, webhookID)
+}
+
+// Notify adds a delivery of the event to the outbox for every webhook of the owner of the url
+// subscribed to it, anonymous urls have no webhooks.
+// The event bus calls it in the background, requests don't wait for it, but every event of a url
+// with an owner costs a write to the outbox whether the owner has webhooks or not. Clicks only
+// publish WebhookEvents when they reach a threshold or the limit of the url.
+func (d *WebhookDispatcher) Notify(event LinkEvent) {
+	if len(event.URL.Owner) == 0 {
+		return
+	}
+	payload, err := json.Marshal(webhookPayload{
+		Event:     event.Type,
+		CreatedAt: event.At,
+		Data:      webhookEventData{URL: event.URL, Clicks: event.Clicks},
+	})
+	if err != nil {
+		return
+	}
+	if err := d.repo.EnqueueDeliveries(event.URL.Owner, event.Type, payload, event.At); err != nil {
+		d.config.Logger.Log("msg", "failed to store event", "event", event.Type, "hash", event.URL.Hash, "err", err)
+	}
+}
+
+// DeliverDue sends the deliveries whose next attempt is due, up to BatchSize of them.
+// It returns how many were tried.
+func (d *WebhookDispatcher) DeliverDue(now time.Time) (int, error) {
+	deliveries, err := d.repo.DueDeliveries(now, d.config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, delivery := range deliveries {
+		err := d.sender.Send(delivery.Webhook.URL, map[string]string{
+			"Content-Type":        "application/json",
+			"X-Webhook-Event":     delivery.Event,
+			"X-Webhook-Delivery":  strconv.FormatInt(delivery.ID, 10),
+			"X-Webhook-Signature": SignWebhookPayload(delivery.Webhook.Secret, delivery.Payload),
+		}, delivery.Payload)
+		if err == nil {
+			delivery.Done = true
+			delivery.LastError = ""
+		} else {
+			delivery.Attempts++
+			delivery.LastError = err.Error()
+			delivery.Done = delivery.Attempts >= d.config.MaxAttempts
+			delivery.NextAttempt = now.Add(webhookBackoff(delivery.Attempts))
+		}
+		if err := d.repo.SaveDelivery(delivery); err != nil {
+			return 0, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+// PruneDeliveries deletes the deliveries that are done and older than Retention
+func (d *WebhookDispatcher) PruneDeliveries(now time.Time) (int64, error) {
+	return d.repo.PruneDeliveries(now.Add(-d.config.Retention))
+}
+
+// Run sends the due deliveries every PollInterval and prunes the done ones every hour until stop is closed,
+// see runBatches
+func (d *WebhookDispatcher) Run(stop <-chan struct{}) {
+	go runBatches(stop, webhookPruneInterval, 1, d.config.Logger, func(now time.Time) (int, error) {
+		_, err := d.PruneDeliveries(now)
+		return 0, err
+	})
+	runBatches(stop, d.config.PollInterval, d.config.BatchSize, d.config.Logger, d.DeliverDue)
+}
+
+// SignWebhookPayload returns the X-Webhook-Signature header of a payload,
+// "sha256=" followed by the hex encoded HMAC-SHA256 of the payload with the secret of the webhook
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns how long to wait after the failed attempt
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookRetryDelay
+	for i := 1; i < attempts && delay < maxWebhookRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxWebhookRetryDelay {
+		return maxWebhookRetryDelay
+	}
+	return delay
+}
+
+func validWebhookEvent(event string) bool {
+	for _, known := range WebhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// clickEvents returns the events a url is due after being followed for the clicks time
+func clickEvents(url URL, clicks int) []string {
+	var events []string
+	for _, threshold := range ClickThresholds {
+		if clicks == threshold {
+			events = append(events, EventClickThreshold)
+		}
+	}
+	if url.MaxClicks > 0 && clicks == url.MaxClicks {
+		events = append(events, EventLinkExpired)
+	}
+	return events
+}
+
+func NewWebhookDispatcher(repo WebhookRepository, sender WebhookSender, config WebhookConfig) *WebhookDispatcher {
+	if config.BatchSize < 1 {
+		config.BatchSize = MaxListLimit
+	}
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = defaultWebhookAttempts
+	}
+	if config.SendTimeout <= 0 {
+		config.SendTimeout = defaultWebhookSendTimeout
+	}
+	if maxBatch := int(WebhookDeliveryLease / 2 / config.SendTimeout); config.BatchSize > maxBatch {
+		config.BatchSize = maxBatch
+		if config.BatchSize < 1 {
+			config.BatchSize = 1
+		}
+	}
+	if config.Retention <= 0 {
+		config.Retention = defaultWebhookRetention
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	return &WebhookDispatcher{
+		repo:   repo,
+		sender: sender,
+		config: config,
+	}
+}
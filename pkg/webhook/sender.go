@@ -0,0 +1,42 @@
+package webhook
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+)
+
+type httpSender struct {
+	client *http.Client
+}
+
+// NewHTTPSender creates a sender that posts payloads with a client like httpclient.New,
+// the client shouldn't follow redirects so endpoints can't send the payloads somewhere else
+func NewHTTPSender(client *http.Client) domain.WebhookSender {
+	return &httpSender{client: client}
+}
+
+func (s *httpSender) Send(endpoint string, headers map[string]string, payload []byte) error {
+	request, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	// drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64*1024))
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.New("endpoint answered " + response.Status)
+	}
+	return nil
+}
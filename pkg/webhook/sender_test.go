@@ -0,0 +1,42 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	domain "github.com/yanisky/url-shortener/pkg"
+	"github.com/yanisky/url-shortener/pkg/httpclient"
+)
+
+func TestSendPostsSignedPayloads(t *testing.T) {
+	var signature, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			data, _ := ioutil.ReadAll(r.Body)
+			body = string(data)
+			signature = r.Header.Get("X-Webhook-Signature")
+			w.WriteHeader(http.StatusNoContent)
+		case "/moved":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	sender := NewHTTPSender(httpclient.New(5*time.Second, true, 0))
+	payload := []byte(`{"event":"link.created"}`)
+
+	err := sender.Send(server.URL+"/ok", map[string]string{"X-Webhook-Signature": domain.SignWebhookPayload("secret", payload)}, payload)
+	if err != nil || body != string(payload) || signature != domain.SignWebhookPayload("secret", payload) {
+		t.Fatal("Payloads should be posted with their headers", body, signature, err)
+	}
+	for _, path := range []string{"/moved", "/error"} {
+		if err := sender.Send(server.URL+path, nil, payload); err == nil {
+			t.Fatal("Only 2xx answers should be deliveries", path)
+		}
+	}
+}
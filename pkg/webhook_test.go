@@ -0,0 +1,177 @@
+package urlshortener
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRegisterValidatesWebhooks(t *testing.T) {
+	repo := &webhookRepoMock{}
+	dispatcher := NewWebhookDispatcher(repo, &webhookSenderMock{}, WebhookConfig{})
+
+	webhook, err := dispatcher.Register("owner", "hooks.example.com/events", nil)
+	if err != nil || webhook.URL != "http://hooks.example.com/events" || len(webhook.Secret) != 64 || len(webhook.Events) != len(WebhookEvents) {
+		t.Fatal("Webhooks should be created with a secret and every event by default", webhook, err)
+	}
+	if _, err := dispatcher.Register("owner", "http://127.0.0.1/events", nil); errors.Is(err, ErrorInvalidURL) == false {
+		t.Fatal("Endpoints should be validated with the policy", err)
+	}
+	if _, err := dispatcher.Register("owner", "https://hooks.example.com", []string{"link.visited"}); err != ErrorInvalidWebhookEvent {
+		t.Fatal("Unknown events should be rejected", err)
+	}
+	for i := 1; i < maxWebhooks; i++ {
+		if _, err := dispatcher.Register("owner", "https://hooks.example.com", []string{EventLinkCreated}); err != nil {
+			t.Fatal("Failed to register webhook", err)
+		}
+	}
+	if _, err := dispatcher.Register("owner", "https://hooks.example.com", nil); err != ErrorTooManyWebhooks {
+		t.Fatal("Owners should have a limited number of webhooks", err)
+	}
+	webhooks, err := dispatcher.Webhooks("owner")
+	if err != nil || len(webhooks) != maxWebhooks || len(webhooks[0].Secret) != 0 {
+		t.Fatal("Webhooks should be listed without their secret", webhooks, err)
+	}
+}
+
+func TestNotifyQueuesTheEventForTheOwner(t *testing.T) {
+	repo := &webhookRepoMock{}
+	dispatcher := NewWebhookDispatcher(repo, &webhookSenderMock{}, WebhookConfig{})
+	at := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	dispatcher.Notify(LinkEvent{Type: EventLinkCreated, URL: URL{Hash: "anonymous"}, At: at})
+	dispatcher.Notify(LinkEvent{Type: EventClickThreshold, URL: URL{Hash: "hash", Owner: "owner", PasswordHash: "secret"}, Clicks: 100, At: at})
+	if len(repo.deliveries) != 1 || repo.deliveries[0].Event != EventClickThreshold || repo.deliveries[0].NextAttempt.Equal(at) == false {
+		t.Fatal("Only the events of urls with an owner should be queued", repo.deliveries)
+	}
+	payload := map[string]interface{}{}
+	if err := json.Unmarshal(repo.deliveries[0].Payload, &payload); err != nil {
+		t.Fatal("Payloads should be JSON", err)
+	}
+	data := payload["data"].(map[string]interface{})
+	url := data["url"].(map[string]interface{})
+	if payload["event"] != EventClickThreshold || data["clicks"] != 100.0 || url["hash"] != "hash" || url["PasswordHash"] != nil {
+		t.Fatal("Payloads should have the event and the url", string(repo.deliveries[0].Payload))
+	}
+}
+
+func TestDeliverDueRetriesFailedDeliveries(t *testing.T) {
+	repo := &webhookRepoMock{deliveries: []WebhookDelivery{
+		{ID: 1, Webhook: Webhook{URL: "https://ok.example.com", Secret: "s1"}, Event: EventLinkCreated, Payload: []byte(`{"n":1}`)},
+		{ID: 2, Webhook: Webhook{URL: "https://down.example.com", Secret: "s2"}, Event: EventLinkDeleted, Payload: []byte(`{"n":2}`)},
+		{ID: 3, Webhook: Webhook{URL: "https://down.example.com"}, Event: EventLinkDeleted, Attempts: 2},
+	}}
+	sender := &webhookSenderMock{up: "https://ok.example.com"}
+	dispatcher := NewWebhookDispatcher(repo, sender, WebhookConfig{BatchSize: 10, MaxAttempts: 3})
+
+	now := time.Now()
+	sent, err := dispatcher.DeliverDue(now)
+	if err != nil || sent != 3 {
+		t.Fatal("Every due delivery should be sent", sent, err)
+	}
+	if headers := sender.headers[0]; headers["X-Webhook-Event"] != EventLinkCreated || headers["X-Webhook-Delivery"] != "1" ||
+		headers["X-Webhook-Signature"] != SignWebhookPayload("s1", []byte(`{"n":1}`)) {
+		t.Fatal("Deliveries should be signed with the secret of their webhook", headers)
+	}
+	if delivered := repo.saved[1]; delivered.Done == false || delivered.Attempts != 0 {
+		t.Fatal("Sent deliveries should be done", delivered)
+	}
+	if failed := repo.saved[2]; failed.Done || failed.Attempts != 1 || failed.NextAttempt.Equal(now.Add(webhookRetryDelay)) == false || len(failed.LastError) == 0 {
+		t.Fatal("Failed deliveries should be retried later", failed)
+	}
+	if failed := repo.saved[3]; failed.Done == false || failed.Attempts != 3 {
+		t.Fatal("Deliveries should be given up after MaxAttempts", failed)
+	}
+}
+
+func TestBatchesAreSentWithinTheLease(t *testing.T) {
+	dispatcher := NewWebhookDispatcher(&webhookRepoMock{}, &webhookSenderMock{}, WebhookConfig{BatchSize: 100, SendTimeout: time.Minute})
+	// endpoints that time out one after the other
+	if batch := time.Duration(dispatcher.config.BatchSize) * dispatcher.config.SendTimeout; batch > WebhookDeliveryLease/2 {
+		t.Fatal("Batches of slow endpoints should be sent before other dispatchers claim them", dispatcher.config.BatchSize)
+	}
+	if dispatcher := NewWebhookDispatcher(&webhookRepoMock{}, &webhookSenderMock{}, WebhookConfig{SendTimeout: time.Hour}); dispatcher.config.BatchSize != 1 {
+		t.Fatal("Deliveries should still be sent with long timeouts", dispatcher.config.BatchSize)
+	}
+}
+
+func TestPruneDeliveriesKeepsThemForTheRetention(t *testing.T) {
+	repo := &webhookRepoMock{}
+	dispatcher := NewWebhookDispatcher(repo, &webhookSenderMock{}, WebhookConfig{})
+	now := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	if _, err := dispatcher.PruneDeliveries(now); err != nil || repo.prunedBefore.Equal(now.AddDate(0, 0, -7)) == false {
+		t.Fatal("Done deliveries should be kept for a week by default", repo.prunedBefore, err)
+	}
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	// echo -n '{"event":"link.created"}' | openssl dgst -sha256 -hmac secret
+	signature := SignWebhookPayload("secret", []byte(`{"event":"link.created"}`))
+	if signature != "sha256=ef9e62a387ae842088e27b00e86d1c8de7822ee328d63e20b620706d6f558ea6" {
+		t.Fatal("Payloads should be signed with HMAC-SHA256", signature)
+	}
+}
+
+func TestWebhookBackoffIsCapped(t *testing.T) {
+	if webhookBackoff(1) != webhookRetryDelay || webhookBackoff(3) != 4*webhookRetryDelay || webhookBackoff(30) != maxWebhookRetryDelay {
+		t.Fatal("Retries should wait twice as long every time up to a limit", webhookBackoff(1), webhookBackoff(3), webhookBackoff(30))
+	}
+}
+
+type webhookRepoMock struct {
+	webhooks     []Webhook
+	deliveries   []WebhookDelivery
+	saved        map[int64]WebhookDelivery
+	prunedBefore time.Time
+}
+
+func (r *webhookRepoMock) CreateWebhook(owner string, webhook Webhook) (Webhook, error) {
+	webhook.ID = strconv.Itoa(len(r.webhooks) + 1)
+	r.webhooks = append(r.webhooks, webhook)
+	return webhook, nil
+}
+
+func (r *webhookRepoMock) Webhooks(owner string) ([]Webhook, error) {
+	return append([]Webhook{}, r.webhooks...), nil
+}
+
+func (r *webhookRepoMock) DeleteWebhook(owner string, webhookID string) error {
+	return ErrorWebhookNotFound
+}
+
+func (r *webhookRepoMock) EnqueueDeliveries(owner string, event string, payload []byte, at time.Time) error {
+	r.deliveries = append(r.deliveries, WebhookDelivery{Event: event, Payload: payload, NextAttempt: at})
+	return nil
+}
+
+func (r *webhookRepoMock) DueDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	return r.deliveries, nil
+}
+
+func (r *webhookRepoMock) SaveDelivery(delivery WebhookDelivery) error {
+	if r.saved == nil {
+		r.saved = map[int64]WebhookDelivery{}
+	}
+	r.saved[delivery.ID] = delivery
+	return nil
+}
+
+func (r *webhookRepoMock) PruneDeliveries(before time.Time) (int64, error) {
+	r.prunedBefore = before
+	return 0, nil
+}
+
+type webhookSenderMock struct {
+	up      string
+	headers []map[string]string
+}
+
+func (s *webhookSenderMock) Send(endpoint string, headers map[string]string, payload []byte) error {
+	s.headers = append(s.headers, headers)
+	if endpoint != s.up {
+		return errors.New("503 Service Unavailable")
+	}
+	return nil
+}